@@ -16,6 +16,7 @@ package buffer
 
 import (
 	"bytes"
+	"fmt"
 	"math/rand"
 	"testing"
 
@@ -61,6 +62,49 @@ func TestNewView(t *testing.T) {
 	}
 }
 
+func TestNewViewReusedChunkCleared(t *testing.T) {
+	for sz := baseChunkSize; sz <= MaxChunkSize; sz <<= 1 {
+		v := NewViewWithData(bytes.Repeat([]byte{0xff}, sz))
+		v.Release()
+
+		// The chunk released above is likely to be reused, but it must not
+		// carry over any data.
+		v = NewViewSize(sz)
+		if got := v.AsSlice(); !bytes.Equal(got, make([]byte, sz)) {
+			t.Errorf("NewViewSize(%d) has non-zero data", sz)
+		}
+		v.Release()
+	}
+}
+
+func TestNewViewNoAlias(t *testing.T) {
+	v1 := NewViewSize(baseChunkSize)
+	defer v1.Release()
+	v2 := NewViewSize(baseChunkSize)
+	defer v2.Release()
+
+	if v1.chunk == v2.chunk {
+		t.Fatalf("got v1.chunk = v2.chunk = %p, want distinct chunks", v1.chunk)
+	}
+	for i := range v1.AsSlice() {
+		v1.AsSlice()[i] = 0xff
+	}
+	if got := v2.AsSlice(); !bytes.Equal(got, make([]byte, baseChunkSize)) {
+		t.Errorf("got v2.AsSlice() = %x after writing to v1, want all zeros", got)
+	}
+}
+
+func BenchmarkNewViewRelease(b *testing.B) {
+	for _, sz := range []int{baseChunkSize, 1500, MaxChunkSize} {
+		b.Run(fmt.Sprintf("%d", sz), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				NewViewSize(sz).Release()
+			}
+		})
+	}
+}
+
 func TestClone(t *testing.T) {
 	orig := NewView(100)
 	clone := orig.Clone()
@@ -225,6 +225,10 @@ var Metrics = tcpip.Stats{
 		PacketsSent:                         mustCreateMetric("/netstack/ip/packets_sent", "Number of IP packets sent via WritePacket."),
 		OutgoingPacketErrors:                mustCreateMetric("/netstack/ip/outgoing_packet_errors", "Number of IP packets which failed to write to a link-layer endpoint."),
 		MalformedPacketsReceived:            mustCreateMetric("/netstack/ip/malformed_packets_received", "Number of IP packets which failed IP header validation checks."),
+		TruncatedPacketsReceived:            mustCreateMetric("/netstack/ip/truncated_packets_received", "Number of IP packets which were shorter than their IP header or the length it specifies."),
+		BadVersionPacketsReceived:           mustCreateMetric("/netstack/ip/bad_version_packets_received", "Number of IP packets whose version field did not match the network protocol."),
+		BadLengthPacketsReceived:            mustCreateMetric("/netstack/ip/bad_length_packets_received", "Number of IPv4 packets whose header length was invalid."),
+		BadChecksumPacketsReceived:          mustCreateMetric("/netstack/ip/bad_checksum_packets_received", "Number of IPv4 packets with bad header checksums."),
 		MalformedFragmentsReceived:          mustCreateMetric("/netstack/ip/malformed_fragments_received", "Number of IP fragments which failed IP fragment validation checks."),
 		IPTablesPreroutingDropped:           mustCreateMetric("/netstack/ip/iptables/prerouting_dropped", "Number of IP packets dropped in the Prerouting chain."),
 		IPTablesInputDropped:                mustCreateMetric("/netstack/ip/iptables/input_dropped", "Number of IP packets dropped in the Input chain."),
@@ -291,6 +295,8 @@ var Metrics = tcpip.Stats{
 		SpuriousRecovery:                   mustCreateMetric("/netstack/tcp/spurious_recovery", "Number of times the connection entered loss recovery spuriously."),
 		SpuriousRTORecovery:                mustCreateMetric("/netstack/tcp/spurious_rto_recovery", "Number of times the connection entered RTO spuriously."),
 		ForwardMaxInFlightDrop:             mustCreateMetric("/netstack/tcp/forward_max_in_flight_drop", "Number of connection requests dropped due to exceeding in-flight limit."),
+		PAWSRejected:                       mustCreateMetric("/netstack/tcp/paws_rejected", "Number of segments dropped due to an old timestamp."),
+		MD5Failures:                        mustCreateMetric("/netstack/tcp/md5_failures", "Number of segments dropped due to a missing, unexpected or invalid MD5 signature."),
 	},
 	UDP: tcpip.UDPStats{
 		PacketsReceived:          mustCreateMetric("/netstack/udp/packets_received", "Number of UDP datagrams received via HandlePacket."),
@@ -1204,12 +1210,13 @@ func getSockOptTCP(t *kernel.Task, s socket.Socket, ep commonEndpoint, name, out
 		// TODO(b/64800844): Translate fields once they are added to
 		// tcpip.TCPInfoOption.
 		info := linux.TCPInfo{
-			State:       uint8(v.State),
-			RTO:         uint32(v.RTO / time.Microsecond),
-			RTT:         uint32(v.RTT / time.Microsecond),
-			RTTVar:      uint32(v.RTTVar / time.Microsecond),
-			SndSsthresh: v.SndSsthresh,
-			SndCwnd:     v.SndCwnd,
+			State:        uint8(v.State),
+			RTO:          uint32(v.RTO / time.Microsecond),
+			RTT:          uint32(v.RTT / time.Microsecond),
+			RTTVar:       uint32(v.RTTVar / time.Microsecond),
+			SndSsthresh:  v.SndSsthresh,
+			SndCwnd:      v.SndCwnd,
+			TotalRetrans: uint32(v.TotalRetransmits),
 		}
 		switch v.CcState {
 		case tcpip.RTORecovery:
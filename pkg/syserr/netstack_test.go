@@ -0,0 +1,84 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package syserr_test
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/abi/linux/errno"
+	"gvisor.dev/gvisor/pkg/syserr"
+	"gvisor.dev/gvisor/pkg/tcpip"
+)
+
+func TestTranslateNetstackError(t *testing.T) {
+	for _, test := range []struct {
+		err  tcpip.Error
+		want errno.Errno
+	}{
+		{&tcpip.ErrAborted{}, errno.EPIPE},
+		{&tcpip.ErrAddressFamilyNotSupported{}, errno.EAFNOSUPPORT},
+		{&tcpip.ErrAlreadyBound{}, errno.EINVAL},
+		{&tcpip.ErrAlreadyConnected{}, errno.EISCONN},
+		{&tcpip.ErrAlreadyConnecting{}, errno.EALREADY},
+		{&tcpip.ErrBadAddress{}, errno.EFAULT},
+		{&tcpip.ErrBadBuffer{}, errno.EFAULT},
+		{&tcpip.ErrBadLocalAddress{}, errno.EADDRNOTAVAIL},
+		{&tcpip.ErrBroadcastDisabled{}, errno.EACCES},
+		{&tcpip.ErrClosedForReceive{}, errno.NOERRNO},
+		{&tcpip.ErrClosedForSend{}, errno.EPIPE},
+		{&tcpip.ErrConnectStarted{}, errno.EINPROGRESS},
+		{&tcpip.ErrConnectionAborted{}, errno.ECONNABORTED},
+		{&tcpip.ErrConnectionRefused{}, errno.ECONNREFUSED},
+		{&tcpip.ErrConnectionReset{}, errno.ECONNRESET},
+		{&tcpip.ErrDestinationRequired{}, errno.EDESTADDRREQ},
+		{&tcpip.ErrDuplicateAddress{}, errno.EEXIST},
+		{&tcpip.ErrDuplicateNICID{}, errno.EEXIST},
+		{&tcpip.ErrInvalidEndpointState{}, errno.EINVAL},
+		{&tcpip.ErrInvalidOptionValue{}, errno.EINVAL},
+		{&tcpip.ErrInvalidPortRange{}, errno.EINVAL},
+		{&tcpip.ErrMalformedHeader{}, errno.EINVAL},
+		{&tcpip.ErrMessageTooLong{}, errno.EMSGSIZE},
+		{&tcpip.ErrNetworkUnreachable{}, errno.ENETUNREACH},
+		{&tcpip.ErrNoBufferSpace{}, errno.ENOBUFS},
+		{&tcpip.ErrNoPortAvailable{}, errno.EAGAIN},
+		{&tcpip.ErrHostUnreachable{}, errno.EHOSTUNREACH},
+		{&tcpip.ErrHostDown{}, errno.EHOSTDOWN},
+		{&tcpip.ErrNoNet{}, errno.ENONET},
+		{&tcpip.ErrNoSuchFile{}, errno.ENOENT},
+		{&tcpip.ErrNotConnected{}, errno.ENOTCONN},
+		{&tcpip.ErrNotPermitted{}, errno.EPERM},
+		{&tcpip.ErrNotSupported{}, errno.EOPNOTSUPP},
+		{&tcpip.ErrPortInUse{}, errno.EADDRINUSE},
+		{&tcpip.ErrQueueSizeNotSupported{}, errno.ENOTTY},
+		{&tcpip.ErrTimeout{}, errno.ETIMEDOUT},
+		{&tcpip.ErrUnknownDevice{}, errno.ENODEV},
+		{&tcpip.ErrUnknownNICID{}, errno.ENODEV},
+		{&tcpip.ErrUnknownProtocol{}, errno.EINVAL},
+		{&tcpip.ErrUnknownProtocolOption{}, errno.ENOPROTOOPT},
+		{&tcpip.ErrWouldBlock{}, errno.EWOULDBLOCK},
+		{&tcpip.ErrMissingRequiredFields{}, errno.EINVAL},
+		{&tcpip.ErrMulticastInputCannotBeOutput{}, errno.EINVAL},
+	} {
+		t.Run(test.err.String(), func(t *testing.T) {
+			if got := syserr.TranslateNetstackError(test.err).ToLinux(); got != test.want {
+				t.Errorf("got TranslateNetstackError(%T).ToLinux() = %d, want = %d", test.err, got, test.want)
+			}
+		})
+	}
+
+	if got := syserr.TranslateNetstackError(nil); got != nil {
+		t.Errorf("got TranslateNetstackError(nil) = %s, want = nil", got)
+	}
+}
@@ -656,6 +656,52 @@ func TestPacketConnTransfer(t *testing.T) {
 	}
 }
 
+// TestPacketConnReadDeadline tests that a read on an idle UDPConn times out
+// once its read deadline passes.
+func TestPacketConnReadDeadline(t *testing.T) {
+	s, e := newLoopbackStack()
+	if e != nil {
+		t.Fatalf("newLoopbackStack() = %v", e)
+	}
+	defer func() {
+		s.Close()
+		s.Wait()
+	}()
+
+	ip := tcpip.AddrFromSlice(net.IPv4(169, 254, 10, 1).To4())
+	addr := tcpip.FullAddress{NIC: NICID, Addr: ip, Port: 11211}
+	protocolAddr := tcpip.ProtocolAddress{
+		Protocol:          ipv4.ProtocolNumber,
+		AddressWithPrefix: ip.WithPrefix(),
+	}
+	if err := s.AddProtocolAddress(NICID, protocolAddr, stack.AddressProperties{}); err != nil {
+		t.Fatalf("AddProtocolAddress(%d, %+v, {}): %s", NICID, protocolAddr, err)
+	}
+
+	c, err := DialUDP(s, &addr, nil, ipv4.ProtocolNumber)
+	if err != nil {
+		t.Fatal("DialUDP(bind port 11211):", err)
+	}
+	defer c.Close()
+
+	const timeout = 50 * time.Millisecond
+	start := time.Now()
+	c.SetReadDeadline(start.Add(timeout))
+	n, _, err := c.ReadFrom(make([]byte, 16))
+	if netErr, ok := err.(net.Error); n != 0 || !ok || !netErr.Timeout() {
+		t.Fatalf("got c.ReadFrom(_) = (%d, _, %v), want = (0, _, timeout error)", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < timeout {
+		t.Errorf("c.ReadFrom(_) returned after %s, want >= %s", elapsed, timeout)
+	}
+
+	// A deadline in the past fails the read immediately.
+	c.SetReadDeadline(time.Unix(1, 0))
+	if _, _, err := c.ReadFrom(make([]byte, 16)); err == nil {
+		t.Fatal("got c.ReadFrom(_) = nil error with an expired deadline")
+	}
+}
+
 func TestConnectedPacketConnTransfer(t *testing.T) {
 	s, e := newLoopbackStack()
 	if e != nil {
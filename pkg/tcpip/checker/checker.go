@@ -675,6 +675,22 @@ func TCPWindowLessThanEq(window uint16) TransportChecker {
 	}
 }
 
+// TCPUrgentPointer creates a checker that checks the tcp urgent pointer.
+func TCPUrgentPointer(urgentPointer uint16) TransportChecker {
+	return func(t *testing.T, h header.Transport) {
+		t.Helper()
+
+		tcp, ok := h.(header.TCP)
+		if !ok {
+			t.Fatalf("TCP header not found in h: %T", h)
+		}
+
+		if got := tcp.UrgentPointer(); got != urgentPointer {
+			t.Errorf("got tcp.UrgentPointer() = %d, want = %d", got, urgentPointer)
+		}
+	}
+}
+
 // TCPFlags creates a checker that checks the tcp flags.
 func TCPFlags(flags header.TCPFlags) TransportChecker {
 	return func(t *testing.T, h header.Transport) {
@@ -595,6 +595,51 @@ const (
 	IPv4OptionLengthOffset = 1
 )
 
+const (
+	// ipv4OptionCopiedFlag is the bit of an option type indicating that the
+	// option must be copied into all fragments of a datagram.
+	ipv4OptionCopiedFlag IPv4OptionType = 0x80
+
+	// ipv4OptionClassMask and ipv4OptionClassShift extract the option class
+	// from an option type.
+	ipv4OptionClassMask  IPv4OptionType = 0x60
+	ipv4OptionClassShift                = 5
+
+	// ipv4OptionNumberMask extracts the option number from an option type.
+	ipv4OptionNumberMask IPv4OptionType = 0x1f
+)
+
+// IPv4OptionClass is the class of an IPv4 option, as defined in RFC 791
+// page 15.
+type IPv4OptionClass uint8
+
+const (
+	// IPv4OptionClassControl is the class of control options.
+	IPv4OptionClassControl IPv4OptionClass = 0
+
+	// IPv4OptionClassDebugging is the class of debugging and measurement
+	// options.
+	IPv4OptionClassDebugging IPv4OptionClass = 2
+)
+
+// Copied returns true if the option must be copied into all fragments of a
+// datagram when it is fragmented. Options without the flag are only carried
+// by the first fragment.
+func (t IPv4OptionType) Copied() bool {
+	return t&ipv4OptionCopiedFlag != 0
+}
+
+// Class returns the class of the option.
+func (t IPv4OptionType) Class() IPv4OptionClass {
+	return IPv4OptionClass((t & ipv4OptionClassMask) >> ipv4OptionClassShift)
+}
+
+// Number returns the option number, which identifies the option within its
+// class.
+func (t IPv4OptionType) Number() uint8 {
+	return uint8(t & ipv4OptionNumberMask)
+}
+
 // IPv4OptParameterProblem indicates that a Parameter Problem message
 // should be generated, and gives the offset in the current entity
 // that should be used in that packet.
@@ -634,7 +679,6 @@ func (o *IPv4OptionGeneric) Contents() []byte { return *o }
 // IPv4OptionIterator is an iterator pointing to a specific IP option
 // at any point of time. It also holds information as to a new options buffer
 // that we are building up to hand back to the caller.
-// TODO(https://gvisor.dev/issues/5513): Add unit tests for IPv4OptionIterator.
 type IPv4OptionIterator struct {
 	options IPv4Options
 	// ErrCursor is where we are while parsing options. It is exported as any
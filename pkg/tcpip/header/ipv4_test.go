@@ -179,6 +179,208 @@ func TestIPv4EncodeOptions(t *testing.T) {
 	}
 }
 
+func TestIPv4EncodeTOS(t *testing.T) {
+	tests := []struct {
+		name string
+		tos  uint8
+	}{
+		{name: "zero", tos: 0},
+		{name: "ECT(0)", tos: 0x02},
+		{name: "CE", tos: 0x03},
+		{name: "DSCP EF", tos: 46 << 2},
+		{name: "DSCP EF with ECT(1)", tos: 46<<2 | 0x01},
+		{name: "all bits", tos: 0xff},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ip := header.IPv4(make([]byte, header.IPv4MinimumSize))
+			ip.Encode(&header.IPv4Fields{
+				TOS:         test.tos,
+				TotalLength: header.IPv4MinimumSize,
+			})
+
+			// The TOS byte immediately follows the version and IHL byte.
+			want := []byte{0x45, test.tos, 0, header.IPv4MinimumSize}
+			if diff := cmp.Diff(want, []byte(ip[:4])); diff != "" {
+				t.Errorf("first header word mismatch (-want +got):\n%s", diff)
+			}
+			if got, _ := ip.TOS(); got != test.tos {
+				t.Errorf("got ip.TOS() = %#x, want = %#x", got, test.tos)
+			}
+		})
+	}
+}
+
+func TestIPv4OptionType(t *testing.T) {
+	tests := []struct {
+		name       string
+		optType    header.IPv4OptionType
+		wantCopied bool
+		wantClass  header.IPv4OptionClass
+		wantNumber uint8
+	}{
+		{
+			name:       "End of Option List",
+			optType:    header.IPv4OptionListEndType,
+			wantCopied: false,
+			wantClass:  header.IPv4OptionClassControl,
+			wantNumber: 0,
+		},
+		{
+			name:       "NOP",
+			optType:    header.IPv4OptionNOPType,
+			wantCopied: false,
+			wantClass:  header.IPv4OptionClassControl,
+			wantNumber: 1,
+		},
+		{
+			name:       "Record Route",
+			optType:    header.IPv4OptionRecordRouteType,
+			wantCopied: false,
+			wantClass:  header.IPv4OptionClassControl,
+			wantNumber: 7,
+		},
+		{
+			name:       "Timestamp",
+			optType:    header.IPv4OptionTimestampType,
+			wantCopied: false,
+			wantClass:  header.IPv4OptionClassDebugging,
+			wantNumber: 4,
+		},
+		{
+			name:       "Router Alert",
+			optType:    header.IPv4OptionRouterAlertType,
+			wantCopied: true,
+			wantClass:  header.IPv4OptionClassControl,
+			wantNumber: 20,
+		},
+		{
+			name:       "Loose Source Route",
+			optType:    131,
+			wantCopied: true,
+			wantClass:  header.IPv4OptionClassControl,
+			wantNumber: 3,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := test.optType.Copied(); got != test.wantCopied {
+				t.Errorf("got %d.Copied() = %t, want = %t", test.optType, got, test.wantCopied)
+			}
+			if got := test.optType.Class(); got != test.wantClass {
+				t.Errorf("got %d.Class() = %d, want = %d", test.optType, got, test.wantClass)
+			}
+			if got := test.optType.Number(); got != test.wantNumber {
+				t.Errorf("got %d.Number() = %d, want = %d", test.optType, got, test.wantNumber)
+			}
+		})
+	}
+}
+
+func TestIPv4OptionIterator(t *testing.T) {
+	tests := []struct {
+		name        string
+		options     header.IPv4Options
+		wantTypes   []header.IPv4OptionType
+		wantProblem *header.IPv4OptParameterProblem
+	}{
+		{
+			name: "no options",
+		},
+		{
+			name:      "NOPs and End of Option List",
+			options:   header.IPv4Options{1, 1, 0, 0},
+			wantTypes: []header.IPv4OptionType{1, 1, 0, 0},
+		},
+		{
+			name:      "Record Route",
+			options:   header.IPv4Options{7, 7, 4, 0, 0, 0, 0, 0},
+			wantTypes: []header.IPv4OptionType{header.IPv4OptionRecordRouteType, 0},
+		},
+		{
+			name:      "Timestamp",
+			options:   header.IPv4Options{68, 8, 5, 0, 0, 0, 0, 0},
+			wantTypes: []header.IPv4OptionType{header.IPv4OptionTimestampType},
+		},
+		{
+			name:      "Router Alert",
+			options:   header.IPv4Options{148, 4, 0, 0},
+			wantTypes: []header.IPv4OptionType{header.IPv4OptionRouterAlertType},
+		},
+		{
+			name:      "unknown option",
+			options:   header.IPv4Options{158, 3, 0, 0},
+			wantTypes: []header.IPv4OptionType{158, 0},
+		},
+		{
+			name:      "multiple options",
+			options:   header.IPv4Options{1, 148, 4, 0, 0, 7, 3, 4},
+			wantTypes: []header.IPv4OptionType{1, header.IPv4OptionRouterAlertType, header.IPv4OptionRecordRouteType},
+		},
+		{
+			name:        "missing length",
+			options:     header.IPv4Options{7},
+			wantProblem: &header.IPv4OptParameterProblem{Pointer: header.IPv4MinimumSize, NeedICMP: true},
+		},
+		{
+			name:        "length too small",
+			options:     header.IPv4Options{7, 1, 0, 0},
+			wantProblem: &header.IPv4OptParameterProblem{Pointer: header.IPv4MinimumSize, NeedICMP: true},
+		},
+		{
+			name:        "length beyond options",
+			options:     header.IPv4Options{7, 10, 4, 0},
+			wantProblem: &header.IPv4OptParameterProblem{Pointer: header.IPv4MinimumSize, NeedICMP: true},
+		},
+		{
+			name:        "Timestamp too short",
+			options:     header.IPv4Options{68, 3, 5, 0},
+			wantProblem: &header.IPv4OptParameterProblem{Pointer: header.IPv4MinimumSize + 1, NeedICMP: true},
+		},
+		{
+			name:        "Record Route too short",
+			options:     header.IPv4Options{7, 2, 0, 0},
+			wantProblem: &header.IPv4OptParameterProblem{Pointer: header.IPv4MinimumSize + 1, NeedICMP: true},
+		},
+		{
+			name:        "Router Alert bad length",
+			options:     header.IPv4Options{148, 3, 0, 0},
+			wantProblem: &header.IPv4OptParameterProblem{Pointer: header.IPv4MinimumSize + 1, NeedICMP: true},
+		},
+		{
+			name:        "truncated option after NOP",
+			options:     header.IPv4Options{1, 1, 1, 7},
+			wantProblem: &header.IPv4OptParameterProblem{Pointer: header.IPv4MinimumSize + 3, NeedICMP: true},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			iter := test.options.MakeIterator()
+			var gotTypes []header.IPv4OptionType
+			for {
+				opt, done, problem := iter.Next()
+				if problem != nil {
+					if diff := cmp.Diff(test.wantProblem, problem); diff != "" {
+						t.Errorf("parameter problem mismatch (-want +got):\n%s", diff)
+					}
+					return
+				}
+				if done {
+					break
+				}
+				gotTypes = append(gotTypes, opt.Type())
+			}
+			if test.wantProblem != nil {
+				t.Errorf("got no parameter problem, want = %#v", test.wantProblem)
+			}
+			if diff := cmp.Diff(test.wantTypes, gotTypes); diff != "" {
+				t.Errorf("option types mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestIsV4LinkLocalUnicastAddress(t *testing.T) {
 	tests := []struct {
 		name     string
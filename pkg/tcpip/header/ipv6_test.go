@@ -456,3 +456,62 @@ func TestV6MulticastScope(t *testing.T) {
 		})
 	}
 }
+
+func TestIPv6EncodeTrafficClass(t *testing.T) {
+	tests := []struct {
+		name         string
+		trafficClass uint8
+		flowLabel    uint32
+		want         []byte
+	}{
+		{
+			name: "zero",
+			want: []byte{0x60, 0x00, 0x00, 0x00},
+		},
+		{
+			name:         "ECT(0)",
+			trafficClass: 0x02,
+			want:         []byte{0x60, 0x20, 0x00, 0x00},
+		},
+		{
+			name:         "DSCP EF with CE",
+			trafficClass: 46<<2 | 0x03,
+			want:         []byte{0x6b, 0xb0, 0x00, 0x00},
+		},
+		{
+			name:         "all bits with flow label",
+			trafficClass: 0xff,
+			flowLabel:    0xabcde,
+			want:         []byte{0x6f, 0xfa, 0xbc, 0xde},
+		},
+		{
+			name:         "flow label is truncated to 20 bits",
+			trafficClass: 0x01,
+			flowLabel:    0xfffabcde,
+			want:         []byte{0x60, 0x1a, 0xbc, 0xde},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ip := header.IPv6(make([]byte, header.IPv6MinimumSize))
+			ip.Encode(&header.IPv6Fields{
+				TrafficClass: test.trafficClass,
+				FlowLabel:    test.flowLabel,
+			})
+
+			// The traffic class straddles the version and flow label fields in the
+			// first word of the header.
+			if diff := cmp.Diff(test.want, []byte(ip[:4])); diff != "" {
+				t.Errorf("first header word mismatch (-want +got):\n%s", diff)
+			}
+			gotTrafficClass, gotFlowLabel := ip.TOS()
+			if gotTrafficClass != test.trafficClass {
+				t.Errorf("got traffic class = %#x, want = %#x", gotTrafficClass, test.trafficClass)
+			}
+			if want := test.flowLabel & 0xfffff; gotFlowLabel != want {
+				t.Errorf("got flow label = %#x, want = %#x", gotFlowLabel, want)
+			}
+		})
+	}
+}
@@ -91,6 +91,7 @@ const (
 	TCPOptionTS            = 8
 	TCPOptionSACKPermitted = 4
 	TCPOptionSACK          = 5
+	TCPOptionMD5           = 19
 )
 
 // Option Lengths.
@@ -99,8 +100,13 @@ const (
 	TCPOptionTSLength            = 10
 	TCPOptionWSLength            = 3
 	TCPOptionSackPermittedLength = 2
+	TCPOptionMD5Length           = 18
 )
 
+// TCPMD5DigestSize is the size of the digest carried by the MD5 signature
+// option, as described in RFC 2385.
+const TCPMD5DigestSize = TCPOptionMD5Length - 2
+
 // TCPFields contains the fields of a TCP packet. It is used to describe the
 // fields of a packet that needs to be encoded.
 type TCPFields struct {
@@ -620,6 +626,51 @@ func EncodeTSOption(tsVal, tsEcr uint32, b []byte) int {
 	return int(b[1])
 }
 
+// EncodeMD5Option encodes an MD5 signature option with a zeroed digest into
+// the provided buffer. The digest is filled in once the rest of the segment is
+// known. If the buffer is smaller than required it just returns without
+// encoding anything. It returns the number of bytes written to the provided
+// buffer.
+func EncodeMD5Option(b []byte) int {
+	if len(b) < TCPOptionMD5Length {
+		return 0
+	}
+	b[0], b[1] = TCPOptionMD5, TCPOptionMD5Length
+	clear(b[2:TCPOptionMD5Length])
+	return int(b[1])
+}
+
+// TCPMD5Digest returns the digest carried by the MD5 signature option in the
+// provided options, or nil if there is no such option. The returned slice
+// aliases b.
+func TCPMD5Digest(b []byte) []byte {
+	limit := len(b)
+	for i := 0; i < limit; {
+		switch b[i] {
+		case TCPOptionEOL:
+			return nil
+		case TCPOptionNOP:
+			i++
+		default:
+			if i+2 > limit {
+				return nil
+			}
+			l := int(b[i+1])
+			if l < 2 || i+l > limit {
+				return nil
+			}
+			if b[i] == TCPOptionMD5 {
+				if l != TCPOptionMD5Length {
+					return nil
+				}
+				return b[i+2 : i+l]
+			}
+			i += l
+		}
+	}
+	return nil
+}
+
 // EncodeSACKPermittedOption encodes a SACKPermitted option into the provided
 // buffer. If the buffer is smaller than required it just returns without
 // encoding anything. It returns the number of bytes written to the provided
@@ -147,6 +147,40 @@ func TestTCPParseOptions(t *testing.T) {
 	}
 }
 
+func TestTCPMD5Digest(t *testing.T) {
+	digest := []byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16}
+	md5Opt := append([]byte{header.TCPOptionMD5, header.TCPOptionMD5Length}, digest...)
+	testCases := []struct {
+		name string
+		b    []byte
+		want []byte
+	}{
+		{"no options", nil, nil},
+		{"MD5 only", md5Opt, digest},
+		{"after NOPs", append([]byte{header.TCPOptionNOP, header.TCPOptionNOP}, md5Opt...), digest},
+		{"after timestamp", append([]byte{header.TCPOptionTS, 10, 0, 0, 0, 1, 0, 0, 0, 1}, md5Opt...), digest},
+		{"after EOL", append([]byte{header.TCPOptionEOL}, md5Opt...), nil},
+		{"bad length", append([]byte{header.TCPOptionMD5, 10}, digest[:8]...), nil},
+		{"truncated", md5Opt[:10], nil},
+	}
+	for _, tc := range testCases {
+		if got := header.TCPMD5Digest(tc.b); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("%s: TCPMD5Digest(%v) = %v, want: %v", tc.name, tc.b, got, tc.want)
+		}
+	}
+
+	b := make([]byte, header.TCPOptionMD5Length)
+	for i := range b {
+		b[i] = 0xff
+	}
+	if got := header.EncodeMD5Option(b); got != header.TCPOptionMD5Length {
+		t.Fatalf("EncodeMD5Option(_) = %d, want: %d", got, header.TCPOptionMD5Length)
+	}
+	if got, want := header.TCPMD5Digest(b), make([]byte, header.TCPMD5DigestSize); !reflect.DeepEqual(got, want) {
+		t.Errorf("TCPMD5Digest(EncodeMD5Option(_)) = %v, want: %v", got, want)
+	}
+}
+
 func TestTCPFlags(t *testing.T) {
 	for _, tt := range []struct {
 		flags header.TCPFlags
@@ -63,6 +63,10 @@ const (
 
 	// UDPProtocolNumber is UDP's transport protocol number.
 	UDPProtocolNumber tcpip.TransportProtocolNumber = 17
+
+	// UDPLiteProtocolNumber is UDP-Lite's transport protocol number, as
+	// assigned in RFC 3828.
+	UDPLiteProtocolNumber tcpip.TransportProtocolNumber = 136
 )
 
 // SourcePort returns the "source port" field of the UDP header.
@@ -193,3 +197,41 @@ func UDPValid(hdr UDP, payloadChecksum func() uint16, payloadSize uint16, netPro
 
 	return true, hdr.IsChecksumValid(srcAddr, dstAddr, payloadChecksum())
 }
+
+// UDPLiteCoverage returns the number of bytes, including the header, covered
+// by the checksum of a UDP-Lite datagram of the given size. The "length" field
+// of UDP-Lite holds the checksum coverage, with zero meaning the whole
+// datagram, as per RFC 3828 section 3.1.
+func UDPLiteCoverage(hdr UDP, size uint16) uint16 {
+	if coverage := hdr.Length(); coverage != 0 {
+		return coverage
+	}
+	return size
+}
+
+// UDPLiteValid returns true if the checksum coverage of the UDP-Lite header is
+// valid for a payload of payloadSize bytes, and whether the checksum is valid
+// over the covered bytes. payloadChecksum is called with the number of payload
+// bytes covered by the checksum.
+//
+// The checksum is mandatory and a zero value is never valid, as per RFC 3828
+// section 3.1.
+func UDPLiteValid(hdr UDP, payloadChecksum func(coverage int) uint16, payloadSize uint16, srcAddr, dstAddr tcpip.Address, skipChecksumValidation bool) (lengthValid, csumValid bool) {
+	size := payloadSize + UDPMinimumSize
+	coverage := UDPLiteCoverage(hdr, size)
+	if coverage < UDPMinimumSize || coverage > size {
+		return false, false
+	}
+
+	if skipChecksumValidation {
+		return true, true
+	}
+
+	if hdr.Checksum() == 0 {
+		return true, false
+	}
+
+	xsum := PseudoHeaderChecksum(UDPLiteProtocolNumber, dstAddr, srcAddr, size)
+	xsum = checksum.Combine(xsum, payloadChecksum(int(coverage-UDPMinimumSize)))
+	return true, hdr.CalculateChecksum(xsum) == 0xffff
+}
@@ -131,6 +131,7 @@ func (q *queue) RemoveNotify(handle *NotificationHandle) {
 
 var _ stack.LinkEndpoint = (*Endpoint)(nil)
 var _ stack.GSOEndpoint = (*Endpoint)(nil)
+var _ stack.LinkStateEndpoint = (*Endpoint)(nil)
 
 // Endpoint is link layer endpoint that stores outbound packets in a channel
 // and allows injection of inbound packets.
@@ -143,6 +144,8 @@ type Endpoint struct {
 	mu sync.RWMutex
 	// +checklocks:mu
 	dispatcher stack.NetworkDispatcher
+	// +checklocks:mu
+	linkDown bool
 
 	// Outbound packet queue.
 	q *queue
@@ -218,6 +221,31 @@ func (e *Endpoint) IsAttached() bool {
 	return e.dispatcher != nil
 }
 
+// LinkUp implements stack.LinkStateEndpoint.LinkUp. The link is up until
+// SetLinkUp is called.
+func (e *Endpoint) LinkUp() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return !e.linkDown
+}
+
+// SetLinkUp sets the state of the link and notifies the attached dispatcher
+// of the change. The stack only observes the state if LinkEPCapabilities
+// includes stack.CapabilityLinkState.
+func (e *Endpoint) SetLinkUp(up bool) {
+	e.mu.Lock()
+	changed := e.linkDown == up
+	e.linkDown = !up
+	d := e.dispatcher
+	e.mu.Unlock()
+	if !changed || e.LinkEPCapabilities&stack.CapabilityLinkState == 0 {
+		return
+	}
+	if d, ok := d.(stack.LinkStateDispatcher); ok {
+		d.DeliverLinkState(up)
+	}
+}
+
 // MTU implements stack.LinkEndpoint.MTU. It returns the value initialized
 // during construction.
 func (e *Endpoint) MTU() uint32 {
@@ -0,0 +1,174 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channel_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/refs"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	linkAddrA = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x06")
+	linkAddrB = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x07")
+)
+
+type countingNotification struct {
+	count int
+}
+
+var _ channel.Notification = (*countingNotification)(nil)
+
+func (n *countingNotification) WriteNotify() {
+	n.count++
+}
+
+func isWouldBlock(err tcpip.Error) bool {
+	_, ok := err.(*tcpip.ErrWouldBlock)
+	return ok
+}
+
+func TestWritePacketsQueueFull(t *testing.T) {
+	const size = 2
+
+	ep := channel.New(size, header.IPv4MinimumMTU, linkAddrA)
+	defer ep.Close()
+	var notify countingNotification
+	ep.AddNotify(&notify)
+
+	var pkts stack.PacketBufferList
+	for i := 0; i < size+1; i++ {
+		pkts.PushBack(stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Payload: buffer.MakeWithData([]byte{byte(i)}),
+		}))
+	}
+	n, err := ep.WritePackets(pkts)
+	pkts.DecRef()
+	if err != nil || n != size {
+		t.Fatalf("ep.WritePackets(_) = (%d, %s), want = (%d, nil)", n, err, size)
+	}
+	if got := ep.NumQueued(); got != size {
+		t.Errorf("got ep.NumQueued() = %d, want = %d", got, size)
+	}
+	if notify.count != size {
+		t.Errorf("got notify.count = %d, want = %d", notify.count, size)
+	}
+
+	// Draining the queue makes room for more packets.
+	if got := ep.Drain(); got != size {
+		t.Errorf("got ep.Drain() = %d, want = %d", got, size)
+	}
+	var more stack.PacketBufferList
+	more.PushBack(stack.NewPacketBuffer(stack.PacketBufferOptions{}))
+	n, err = ep.WritePackets(more)
+	more.DecRef()
+	if err != nil || n != 1 {
+		t.Fatalf("ep.WritePackets(_) = (%d, %s), want = (1, nil)", n, err)
+	}
+}
+
+func TestPairUDP(t *testing.T) {
+	var (
+		addrA = tcpip.AddrFrom4([4]byte{10, 0, 0, 1})
+		addrB = tcpip.AddrFrom4([4]byte{10, 0, 0, 2})
+	)
+	const (
+		nicID = 1
+		port  = 1234
+	)
+
+	pair := channel.NewPair(1, header.IPv4MinimumMTU, linkAddrA, linkAddrB)
+	defer pair.Close()
+
+	newStack := func(ep stack.LinkEndpoint, addr tcpip.Address) *stack.Stack {
+		t.Helper()
+
+		s := stack.New(stack.Options{
+			NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+			TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+		})
+		if err := s.CreateNIC(nicID, ep); err != nil {
+			t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+		}
+		protocolAddr := tcpip.ProtocolAddress{
+			Protocol:          ipv4.ProtocolNumber,
+			AddressWithPrefix: addr.WithPrefix(),
+		}
+		if err := s.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+			t.Fatalf("AddProtocolAddress(%d, %+v, {}): %s", nicID, protocolAddr, err)
+		}
+		s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicID}})
+		return s
+	}
+	sA := newStack(pair.A, addrA)
+	defer sA.Destroy()
+	sB := newStack(pair.B, addrB)
+	defer sB.Destroy()
+
+	var wq waiter.Queue
+	epB, err := sB.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if err != nil {
+		t.Fatalf("NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	defer epB.Close()
+	if err := epB.Bind(tcpip.FullAddress{Port: port}); err != nil {
+		t.Fatalf("epB.Bind(_): %s", err)
+	}
+	epA, err := sA.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if err != nil {
+		t.Fatalf("NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	defer epA.Close()
+
+	data := []byte{1, 2, 3, 4}
+	var r bytes.Reader
+	r.Reset(data)
+	to := tcpip.FullAddress{Addr: addrB, Port: port}
+	if _, err := epA.Write(&r, tcpip.WriteOptions{To: &to}); err != nil {
+		t.Fatalf("epA.Write(_, _): %s", err)
+	}
+
+	// Nothing is delivered until the pair is flushed.
+	var buf bytes.Buffer
+	if _, err := epB.Read(&buf, tcpip.ReadOptions{}); !isWouldBlock(err) {
+		t.Fatalf("got epB.Read(_, _) = %v, want = %s", err, &tcpip.ErrWouldBlock{})
+	}
+	if got := pair.Flush(); got != 1 {
+		t.Errorf("got pair.Flush() = %d, want = 1", got)
+	}
+	if _, err := epB.Read(&buf, tcpip.ReadOptions{}); err != nil {
+		t.Fatalf("epB.Read(_, _): %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Errorf("got epB.Read(_, _) data = %x, want = %x", buf.Bytes(), data)
+	}
+}
+
+func TestMain(m *testing.M) {
+	refs.SetLeakMode(refs.LeaksPanic)
+	code := m.Run()
+	refs.DoLeakCheck()
+	os.Exit(code)
+}
@@ -0,0 +1,79 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package channel
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// Forward moves every packet currently queued for outbound on from to to as an
+// inbound packet, and returns the number of packets moved.
+//
+// Packets that from writes while they are being delivered, e.g. replies
+// generated synchronously by to's stack, are moved as well.
+func Forward(from, to *Endpoint) int {
+	n := 0
+	for pkt := from.Read(); pkt != nil; pkt = from.Read() {
+		// Create a fresh packet with pkt's payload but without struct fields
+		// or headers set so the receiving stack can parse it from scratch.
+		newPkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Payload: pkt.ToBuffer(),
+		})
+		to.InjectInbound(pkt.NetworkProtocolNumber, newPkt)
+		newPkt.DecRef()
+		pkt.DecRef()
+		n++
+	}
+	return n
+}
+
+// Pair is a bidirectional link made of two channel endpoints.
+//
+// Packets are only moved between the endpoints when Flush is called, which
+// lets tests control exactly when each side observes the other's traffic.
+type Pair struct {
+	A *Endpoint
+	B *Endpoint
+}
+
+// NewPair creates two channel endpoints with outbound queues holding up to
+// size packets each, linked to each other.
+func NewPair(size int, mtu uint32, linkAddrA, linkAddrB tcpip.LinkAddress) *Pair {
+	return &Pair{
+		A: New(size, mtu, linkAddrA),
+		B: New(size, mtu, linkAddrB),
+	}
+}
+
+// Flush moves queued packets between the endpoints, in both directions, until
+// neither endpoint has any outbound packets left. It returns the total number
+// of packets moved.
+func (p *Pair) Flush() int {
+	total := 0
+	for {
+		n := Forward(p.A, p.B) + Forward(p.B, p.A)
+		if n == 0 {
+			return total
+		}
+		total += n
+	}
+}
+
+// Close closes both endpoints.
+func (p *Pair) Close() {
+	p.A.Close()
+	p.B.Close()
+}
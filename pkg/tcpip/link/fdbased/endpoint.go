@@ -144,6 +144,9 @@ type endpoint struct {
 	// endpoint.
 	packetDispatchMode PacketDispatchMode
 
+	// recvMMsgBatchSize has the same meaning as Options.RecvMMsgBatchSize.
+	recvMMsgBatchSize int
+
 	// gsoMaxSize is the maximum GSO packet size. It is zero if GSO is
 	// disabled.
 	gsoMaxSize uint32
@@ -208,6 +211,11 @@ type Options struct {
 	// used for this endpoint.
 	PacketDispatchMode PacketDispatchMode
 
+	// RecvMMsgBatchSize is the maximum number of packets read by each
+	// recvmmsg() call when PacketDispatchMode is RecvMMsg. If zero,
+	// MaxMsgsPerRecv is used.
+	RecvMMsgBatchSize int
+
 	// TXChecksumOffload if true, indicates that this endpoints capability
 	// set should include CapabilityTXChecksumOffload.
 	TXChecksumOffload bool
@@ -279,6 +287,10 @@ func New(opts *Options) (stack.LinkEndpoint, error) {
 		return nil, fmt.Errorf("opts.MaxSyscallHeaderBytes is negative")
 	}
 
+	if opts.RecvMMsgBatchSize < 0 {
+		return nil, fmt.Errorf("opts.RecvMMsgBatchSize is negative")
+	}
+
 	e := &endpoint{
 		mtu:                   opts.MTU,
 		caps:                  caps,
@@ -286,6 +298,7 @@ func New(opts *Options) (stack.LinkEndpoint, error) {
 		addr:                  opts.Address,
 		hdrSize:               hdrSize,
 		packetDispatchMode:    opts.PacketDispatchMode,
+		recvMMsgBatchSize:     opts.RecvMMsgBatchSize,
 		maxSyscallHeaderBytes: uintptr(opts.MaxSyscallHeaderBytes),
 		writevMaxIovs:         rawfile.MaxIovs,
 	}
@@ -78,7 +78,7 @@ func checkPacketInfoEqual(t *testing.T, got, want packetInfo) {
 }
 
 type context struct {
-	t        *testing.T
+	t        testing.TB
 	readFDs  []int
 	writeFDs []int
 	ep       stack.LinkEndpoint
@@ -86,7 +86,7 @@ type context struct {
 	done     chan struct{}
 }
 
-func newContext(t *testing.T, opt *Options) *context {
+func newContext(t testing.TB, opt *Options) *context {
 	firstFDPair, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET, 0)
 	if err != nil {
 		t.Fatalf("Socketpair failed: %v", err)
@@ -325,6 +325,162 @@ func TestHashedWritePacket(t *testing.T) {
 	}
 }
 
+// newBatch returns n packets with distinct payloads that are ready to be
+// written to c.ep. The i-th packet has hash i.
+func (c *context) newBatch(n int) stack.PacketBufferList {
+	var pkts stack.PacketBufferList
+	for i := 0; i < n; i++ {
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			ReserveHeaderBytes: int(c.ep.MaxHeaderLength()),
+			Payload:            buffer.MakeWithData([]byte{byte(i), 1, 2, 3}),
+		})
+		pkt.Hash = uint32(i)
+		pkt.EgressRoute.LocalLinkAddress = laddr
+		pkt.EgressRoute.RemoteLinkAddress = raddr
+		pkt.NetworkProtocolNumber = proto
+		c.ep.AddHeader(pkt)
+		pkts.PushBack(pkt)
+	}
+	return pkts
+}
+
+func TestWritePacketsBatch(t *testing.T) {
+	c := newContext(t, &Options{Address: laddr, MTU: mtu, EthernetHeader: true})
+	defer c.cleanup()
+
+	// Packets alternate between the two FDs, so the batch is split on every
+	// packet; packets with the same hash are written in order.
+	const numPackets = 10
+	pkts := c.newBatch(numPackets)
+	defer pkts.DecRef()
+	if n, err := c.ep.WritePackets(pkts); err != nil || n != numPackets {
+		t.Fatalf("c.ep.WritePackets(_) = (%d, %s), want = (%d, nil)", n, err, numPackets)
+	}
+
+	b := make([]byte, mtu)
+	for i := 0; i < numPackets; i++ {
+		fd := c.readFDs[i%len(c.readFDs)]
+		n, err := unix.Read(fd, b)
+		if err != nil {
+			t.Fatalf("unix.Read(%d, _): %s", fd, err)
+		}
+		want := []byte{byte(i), 1, 2, 3}
+		if got := b[header.EthernetMinimumSize:n]; !bytes.Equal(got, want) {
+			t.Errorf("got packet %d = %x, want = %x", i, got, want)
+		}
+	}
+}
+
+// BenchmarkWritePackets compares writing packets to a socket FD one at a time
+// with writing them in a single batch, which uses sendmmsg.
+func BenchmarkWritePackets(b *testing.B) {
+	const batchSize = 32
+	for _, batched := range []bool{false, true} {
+		b.Run(fmt.Sprintf("Batched=%t", batched), func(b *testing.B) {
+			c := newContext(b, &Options{Address: laddr, MTU: mtu, EthernetHeader: true})
+			defer c.cleanup()
+
+			// Use a single hash so that every packet goes to the same FD.
+			pkts := c.newBatch(batchSize)
+			defer pkts.DecRef()
+			for _, pkt := range pkts.AsSlice() {
+				pkt.Hash = 0
+			}
+
+			buf := make([]byte, mtu)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if batched {
+					if n, err := c.ep.WritePackets(pkts); err != nil || n != batchSize {
+						b.Fatalf("c.ep.WritePackets(_) = (%d, %s), want = (%d, nil)", n, err, batchSize)
+					}
+				} else {
+					for _, pkt := range pkts.AsSlice() {
+						var single stack.PacketBufferList
+						single.PushBack(pkt)
+						if _, err := c.ep.WritePackets(single); err != nil {
+							b.Fatalf("c.ep.WritePackets(_): %s", err)
+						}
+					}
+				}
+
+				// Drain the peer so that the next iteration does not block.
+				b.StopTimer()
+				for j := 0; j < batchSize; j++ {
+					if _, err := unix.Read(c.readFDs[0], buf); err != nil {
+						b.Fatalf("unix.Read(%d, _): %s", c.readFDs[0], err)
+					}
+				}
+				b.StartTimer()
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*batchSize), "ns/pkt")
+		})
+	}
+}
+
+// discardingNetworkDispatcher is a stack.NetworkDispatcher that drops every
+// packet it is given.
+type discardingNetworkDispatcher struct{}
+
+func (discardingNetworkDispatcher) DeliverNetworkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {
+}
+
+func (discardingNetworkDispatcher) DeliverLinkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {
+}
+
+// BenchmarkRecvMMsgDispatch compares reading packets one at a time with
+// reading them in batches of MaxMsgsPerRecv with recvmmsg.
+//
+// The packets are read from a socket pair rather than a tap device so that
+// the benchmark can run without privileges.
+func BenchmarkRecvMMsgDispatch(b *testing.B) {
+	const numPackets = 32
+	for _, batchSize := range []int{1, MaxMsgsPerRecv} {
+		b.Run(fmt.Sprintf("BatchSize=%d", batchSize), func(b *testing.B) {
+			fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_SEQPACKET, 0)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer unix.Close(fds[0])
+			defer unix.Close(fds[1])
+
+			d, err := newRecvMMsgDispatcher(fds[0], &endpoint{
+				hdrSize:           header.EthernetMinimumSize,
+				dispatcher:        discardingNetworkDispatcher{},
+				recvMMsgBatchSize: batchSize,
+			})
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer d.release()
+
+			data := make([]byte, header.EthernetMinimumSize+header.IPv4MinimumSize)
+			header.Ethernet(data).Encode(&header.EthernetFields{
+				SrcAddr: raddr,
+				DstAddr: laddr,
+				Type:    header.IPv4ProtocolNumber,
+			})
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				for j := 0; j < numPackets; j++ {
+					if err := unix.Sendmsg(fds[1], data, nil, nil, unix.MSG_DONTWAIT); err != nil {
+						b.Fatalf("unix.Sendmsg(%d, ...): %s", fds[1], err)
+					}
+				}
+				b.StartTimer()
+
+				for j := 0; j < numPackets/batchSize; j++ {
+					if ok, err := d.dispatch(); !ok || err != nil {
+						b.Fatalf("d.dispatch() = %v, %v", ok, err)
+					}
+				}
+			}
+			b.ReportMetric(float64(b.Elapsed().Nanoseconds())/float64(b.N*numPackets), "ns/pkt")
+		})
+	}
+}
+
 func TestPreserveSrcAddress(t *testing.T) {
 	baddr := tcpip.LinkAddress("\xcc\xbb\xaa\x77\x88\x99")
 
@@ -424,6 +580,44 @@ func TestDeliverPacket(t *testing.T) {
 	}
 }
 
+func TestDeliverPacketEthertypes(t *testing.T) {
+	for _, proto := range []tcpip.NetworkProtocolNumber{
+		header.ARPProtocolNumber,
+		header.IPv4ProtocolNumber,
+		header.IPv6ProtocolNumber,
+	} {
+		t.Run(fmt.Sprintf("Proto=%#04x", proto), func(t *testing.T) {
+			c := newContext(t, &Options{Address: laddr, MTU: mtu, EthernetHeader: true})
+			defer c.cleanup()
+
+			payload := []byte{1, 2, 3, 4}
+			frame := make([]byte, header.EthernetMinimumSize, header.EthernetMinimumSize+len(payload))
+			header.Ethernet(frame).Encode(&header.EthernetFields{
+				SrcAddr: raddr,
+				DstAddr: laddr,
+				Type:    proto,
+			})
+			frame = append(frame, payload...)
+			if _, err := unix.Write(c.readFDs[0], frame); err != nil {
+				t.Fatalf("Write failed: %v", err)
+			}
+
+			select {
+			case pi := <-c.ch:
+				defer pi.Contents.DecRef()
+				if pi.Proto != proto {
+					t.Errorf("got pi.Proto = %#04x, want %#04x", pi.Proto, proto)
+				}
+				if got := pi.Contents.Data().AsRange().ToSlice(); !bytes.Equal(got, payload) {
+					t.Errorf("got pi.Contents.Data() = %v, want %v", got, payload)
+				}
+			case <-time.After(10 * time.Second):
+				t.Fatalf("Timed out waiting for packet")
+			}
+		})
+	}
+}
+
 func TestBufConfigMaxLength(t *testing.T) {
 	got := 0
 	for _, i := range BufConfig {
@@ -643,6 +837,66 @@ func TestDispatchPacketFormat(t *testing.T) {
 	}
 }
 
+func TestRecvMMsgDispatcherBatchSize(t *testing.T) {
+	const (
+		batchSize  = 2
+		numPackets = 3
+	)
+
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_DGRAM, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer unix.Close(fds[0])
+	defer unix.Close(fds[1])
+
+	for i := 0; i < numPackets; i++ {
+		data := []byte{
+			// Ethernet header.
+			1, 2, 3, 4, 5, 60,
+			1, 2, 3, 4, 5, 61,
+			8, 0,
+			// Mock network header.
+			byte(i),
+		}
+		if err := unix.Sendmsg(fds[1], data, nil, nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sink := &fakeNetworkDispatcher{}
+	d, err := newRecvMMsgDispatcher(fds[0], &endpoint{
+		hdrSize:           header.EthernetMinimumSize,
+		dispatcher:        sink,
+		recvMMsgBatchSize: batchSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.release()
+	defer func() {
+		for _, pkt := range sink.pkts {
+			pkt.DecRef()
+		}
+	}()
+
+	// The first read is limited to batchSize packets and the second read
+	// picks up the remainder as a partial batch.
+	for _, want := range []int{batchSize, numPackets} {
+		if ok, err := d.dispatch(); !ok || err != nil {
+			t.Fatalf("d.dispatch() = %v, %v", ok, err)
+		}
+		if got := len(sink.pkts); got != want {
+			t.Fatalf("len(sink.pkts) = %d, want %d", got, want)
+		}
+	}
+	for i, pkt := range sink.pkts {
+		if got := pkt.Data().AsRange().ToSlice(); !bytes.Equal(got, []byte{byte(i)}) {
+			t.Errorf("sink.pkts[%d] data = %v, want %v", i, got, []byte{byte(i)})
+		}
+	}
+}
+
 func TestMain(m *testing.M) {
 	refs.SetLeakMode(refs.LeaksPanic)
 	code := m.Run()
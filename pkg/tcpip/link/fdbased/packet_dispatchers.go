@@ -243,8 +243,8 @@ type recvMMsgDispatcher struct {
 }
 
 const (
-	// MaxMsgsPerRecv is the maximum number of packets we want to retrieve
-	// in a single RecvMMsg call.
+	// MaxMsgsPerRecv is the default maximum number of packets we want to
+	// retrieve in a single RecvMMsg call.
 	MaxMsgsPerRecv = 8
 )
 
@@ -253,12 +253,16 @@ func newRecvMMsgDispatcher(fd int, e *endpoint) (linkDispatcher, error) {
 	if err != nil {
 		return nil, err
 	}
+	batchSize := e.recvMMsgBatchSize
+	if batchSize == 0 {
+		batchSize = MaxMsgsPerRecv
+	}
 	d := &recvMMsgDispatcher{
 		StopFD:  stopFD,
 		fd:      fd,
 		e:       e,
-		bufs:    make([]*iovecBuffer, MaxMsgsPerRecv),
-		msgHdrs: make([]rawfile.MMsgHdr, MaxMsgsPerRecv),
+		bufs:    make([]*iovecBuffer, batchSize),
+		msgHdrs: make([]rawfile.MMsgHdr, batchSize),
 	}
 	skipsVnetHdr := d.e.gsoKind == stack.HostGSOSupported
 	for i := range d.bufs {
@@ -0,0 +1,155 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loopback
+
+import (
+	"sync"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// delayedPacket is a packet waiting to be delivered by a DelayedEndpoint.
+type delayedPacket struct {
+	deadline tcpip.MonotonicTime
+	protocol tcpip.NetworkProtocolNumber
+	pkt      *stack.PacketBuffer
+}
+
+// DelayedEndpoint is a loopback endpoint that turns outbound packets into
+// inbound ones after a fixed delay. Packets are delivered in the order they
+// were written, from timers scheduled on the clock the endpoint was created
+// with.
+type DelayedEndpoint struct {
+	endpoint
+
+	// clock and delay are immutable after construction.
+	clock tcpip.Clock
+	delay time.Duration
+
+	queueMu sync.Mutex
+	// queue holds the packets waiting to be delivered, ordered by deadline.
+	//
+	// +checklocks:queueMu
+	queue []delayedPacket
+	// timer is set while a delivery of the queue is scheduled.
+	//
+	// +checklocks:queueMu
+	timer tcpip.Timer
+	// +checklocks:queueMu
+	closed bool
+}
+
+var _ stack.LinkEndpoint = (*DelayedEndpoint)(nil)
+
+// NewDelayed creates a new loopback endpoint that delivers every packet
+// written to it once delay has elapsed on clock.
+//
+// The endpoint does not own any goroutine, so the stack can be destroyed
+// without closing it first. Close drops and releases the packets still
+// waiting to be delivered.
+func NewDelayed(clock tcpip.Clock, delay time.Duration) *DelayedEndpoint {
+	return &DelayedEndpoint{
+		endpoint: endpoint{mtu: defaultMTU},
+		clock:    clock,
+		delay:    delay,
+	}
+}
+
+// WritePackets implements stack.LinkEndpoint.WritePackets. Packets written
+// while the endpoint is not attached or after it is closed are dropped.
+func (e *DelayedEndpoint) WritePackets(pkts stack.PacketBufferList) (int, tcpip.Error) {
+	e.recordWrite(pkts)
+	if !e.IsAttached() {
+		return pkts.Len(), nil
+	}
+
+	deadline := e.clock.NowMonotonic().Add(e.delay)
+	e.queueMu.Lock()
+	defer e.queueMu.Unlock()
+	if e.closed {
+		return pkts.Len(), nil
+	}
+	for _, pkt := range pkts.AsSlice() {
+		// See deliverPacket for why a fresh packet is needed.
+		e.queue = append(e.queue, delayedPacket{
+			deadline: deadline,
+			protocol: pkt.NetworkProtocolNumber,
+			pkt: stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Payload: pkt.ToBuffer(),
+			}),
+		})
+	}
+	if e.timer == nil {
+		e.timer = e.clock.AfterFunc(e.delay, e.deliver)
+	}
+	return pkts.Len(), nil
+}
+
+// deliver delivers the queued packets whose deadline is reached and schedules
+// the delivery of the remaining ones. Only one delivery is scheduled at a
+// time so packets are never reordered.
+func (e *DelayedEndpoint) deliver() {
+	e.queueMu.Lock()
+	if e.closed {
+		e.queueMu.Unlock()
+		return
+	}
+	now := e.clock.NowMonotonic()
+	i := 0
+	for i < len(e.queue) && !e.queue[i].deadline.After(now) {
+		i++
+	}
+	ready := append([]delayedPacket(nil), e.queue[:i]...)
+	e.queue = e.queue[i:]
+	e.queueMu.Unlock()
+
+	e.mu.RLock()
+	d := e.dispatcher
+	e.mu.RUnlock()
+	for _, p := range ready {
+		if d != nil {
+			d.DeliverNetworkPacket(p.protocol, p.pkt)
+		}
+		p.pkt.DecRef()
+	}
+
+	e.queueMu.Lock()
+	defer e.queueMu.Unlock()
+	if e.closed || len(e.queue) == 0 {
+		e.timer = nil
+		return
+	}
+	e.timer = e.clock.AfterFunc(e.queue[0].deadline.Sub(e.clock.NowMonotonic()), e.deliver)
+}
+
+// Close drops all packets that have not been delivered yet. Packets written
+// afterwards are dropped too. It is safe to call Close more than once.
+func (e *DelayedEndpoint) Close() {
+	e.queueMu.Lock()
+	e.closed = true
+	queue := e.queue
+	e.queue = nil
+	if e.timer != nil {
+		e.timer.Stop()
+		e.timer = nil
+	}
+	e.queueMu.Unlock()
+
+	for _, p := range queue {
+		p.pkt.DecRef()
+	}
+}
@@ -0,0 +1,128 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loopback
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// EthernetEndpoint is a loopback endpoint that frames packets in an Ethernet
+// header before turning them into inbound ones. Unlike the plain loopback
+// endpoint it has a link address and requires link resolution, so it can be
+// used by tests exercising ARP, NDP and Ethernet framing without a peer.
+type EthernetEndpoint struct {
+	endpoint
+
+	// linkAddr is immutable after construction.
+	linkAddr tcpip.LinkAddress
+}
+
+var _ stack.LinkEndpoint = (*EthernetEndpoint)(nil)
+
+// NewEthernet creates a new loopback endpoint with the link address linkAddr
+// that adds an Ethernet header to outbound packets and strips it from the
+// packets it loops back.
+func NewEthernet(linkAddr tcpip.LinkAddress) *EthernetEndpoint {
+	return &EthernetEndpoint{
+		endpoint: endpoint{mtu: defaultMTU},
+		linkAddr: linkAddr,
+	}
+}
+
+// Capabilities implements stack.LinkEndpoint.Capabilities. The endpoint is
+// not reported as a loopback device so that the stack resolves link
+// addresses over it.
+func (*EthernetEndpoint) Capabilities() stack.LinkEndpointCapabilities {
+	return stack.CapabilityRXChecksumOffload | stack.CapabilityTXChecksumOffload | stack.CapabilitySaveRestore | stack.CapabilityResolutionRequired | stack.CapabilityLinkState
+}
+
+// MaxHeaderLength implements stack.LinkEndpoint.MaxHeaderLength.
+func (*EthernetEndpoint) MaxHeaderLength() uint16 {
+	return header.EthernetMinimumSize
+}
+
+// LinkAddress implements stack.LinkEndpoint.LinkAddress.
+func (e *EthernetEndpoint) LinkAddress() tcpip.LinkAddress {
+	return e.linkAddr
+}
+
+// ARPHardwareType implements stack.LinkEndpoint.ARPHardwareType.
+func (*EthernetEndpoint) ARPHardwareType() header.ARPHardwareType {
+	return header.ARPHardwareEther
+}
+
+// AddHeader implements stack.LinkEndpoint.AddHeader.
+func (e *EthernetEndpoint) AddHeader(pkt *stack.PacketBuffer) {
+	src := pkt.EgressRoute.LocalLinkAddress
+	if len(src) == 0 {
+		src = e.linkAddr
+	}
+	eth := header.Ethernet(pkt.LinkHeader().Push(header.EthernetMinimumSize))
+	eth.Encode(&header.EthernetFields{
+		SrcAddr: src,
+		DstAddr: pkt.EgressRoute.RemoteLinkAddress,
+		Type:    pkt.NetworkProtocolNumber,
+	})
+}
+
+// ParseHeader implements stack.LinkEndpoint.ParseHeader.
+func (*EthernetEndpoint) ParseHeader(pkt *stack.PacketBuffer) bool {
+	_, ok := pkt.LinkHeader().Consume(header.EthernetMinimumSize)
+	return ok
+}
+
+// WritePackets implements stack.LinkEndpoint.WritePackets. If the endpoint is
+// not attached, the packets are not delivered.
+func (e *EthernetEndpoint) WritePackets(pkts stack.PacketBufferList) (int, tcpip.Error) {
+	e.recordWrite(pkts)
+	e.mu.RLock()
+	d := e.dispatcher
+	e.mu.RUnlock()
+	for _, pkt := range pkts.AsSlice() {
+		e.deliverFrame(d, pkt)
+	}
+	return pkts.Len(), nil
+}
+
+// deliverFrame loops the Ethernet frame in pkt back to the inbound side of d.
+// If d is nil, the frame is not delivered.
+//
+// Frames sent to a unicast address are delivered as if they were addressed to
+// this endpoint, since every frame written to a loopback device is received by
+// it.
+func (e *EthernetEndpoint) deliverFrame(d stack.NetworkDispatcher, pkt *stack.PacketBuffer) {
+	if d == nil {
+		return
+	}
+	newPkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: pkt.ToBuffer(),
+	})
+	defer newPkt.DecRef()
+	if !e.ParseHeader(newPkt) {
+		return
+	}
+	eth := header.Ethernet(newPkt.LinkHeader().Slice())
+	switch dst := eth.DestinationAddress(); {
+	case dst == header.EthernetBroadcastAddress:
+		newPkt.PktType = tcpip.PacketBroadcast
+	case header.IsMulticastEthernetAddress(dst):
+		newPkt.PktType = tcpip.PacketMulticast
+	default:
+		newPkt.PktType = tcpip.PacketHost
+	}
+	d.DeliverNetworkPacket(eth.Type(), newPkt)
+}
@@ -28,16 +28,70 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
+// defaultMTU is the MTU of loopback endpoints. It matches the linux loopback
+// interface.
+const defaultMTU = 65536
+
+// Stats holds the statistics of a loopback endpoint.
+type Stats struct {
+	// Packets is the number of packets written to the endpoint.
+	Packets tcpip.StatCounter
+
+	// Bytes is the number of bytes written to the endpoint.
+	Bytes tcpip.StatCounter
+}
+
+// StatsOf returns the statistics of ep if it is an endpoint created by this
+// package.
+func StatsOf(ep stack.LinkEndpoint) (*Stats, bool) {
+	e, ok := ep.(interface{ Stats() *Stats })
+	if !ok {
+		return nil, false
+	}
+	return e.Stats(), true
+}
+
+// SetLinkUp sets the state of the link of ep if it is an endpoint created by
+// this package, and notifies the attached dispatcher of the change. It returns
+// false if ep was not created by this package.
+//
+// Loopback links are up until SetLinkUp is called; this is meant to let tests
+// exercise link state changes.
+func SetLinkUp(ep stack.LinkEndpoint, up bool) bool {
+	e, ok := ep.(interface{ setLinkUp(bool) })
+	if !ok {
+		return false
+	}
+	e.setLinkUp(up)
+	return true
+}
+
 type endpoint struct {
+	// mtu is immutable after construction.
+	mtu uint32
+
+	stats Stats
+
 	mu sync.RWMutex
 	// +checklocks:mu
 	dispatcher stack.NetworkDispatcher
+	// +checklocks:mu
+	linkDown bool
 }
 
 // New creates a new loopback endpoint. This link-layer endpoint just turns
 // outbound packets into inbound packets.
 func New() stack.LinkEndpoint {
-	return &endpoint{}
+	return NewWithMTU(defaultMTU)
+}
+
+// NewWithMTU creates a new loopback endpoint that reports mtu as its MTU.
+//
+// The MTU is only advertised to the network layer; packets larger than mtu
+// are still delivered in their entirety as loopback does not fragment at the
+// link layer.
+func NewWithMTU(mtu uint32) stack.LinkEndpoint {
+	return &endpoint{mtu: mtu}
 }
 
 // Attach implements stack.LinkEndpoint.Attach. It just saves the stack network-
@@ -55,16 +109,38 @@ func (e *endpoint) IsAttached() bool {
 	return e.dispatcher != nil
 }
 
-// MTU implements stack.LinkEndpoint.MTU. It returns a constant that matches the
-// linux loopback interface.
-func (*endpoint) MTU() uint32 {
-	return 65536
+// LinkUp implements stack.LinkStateEndpoint.LinkUp.
+func (e *endpoint) LinkUp() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return !e.linkDown
+}
+
+// setLinkUp sets the state of the link and notifies the attached dispatcher if
+// it changed.
+func (e *endpoint) setLinkUp(up bool) {
+	e.mu.Lock()
+	changed := e.linkDown == up
+	e.linkDown = !up
+	d := e.dispatcher
+	e.mu.Unlock()
+	if !changed {
+		return
+	}
+	if d, ok := d.(stack.LinkStateDispatcher); ok {
+		d.DeliverLinkState(up)
+	}
+}
+
+// MTU implements stack.LinkEndpoint.MTU.
+func (e *endpoint) MTU() uint32 {
+	return e.mtu
 }
 
 // Capabilities implements stack.LinkEndpoint.Capabilities. Loopback advertises
 // itself as supporting checksum offload, but in reality it's just omitted.
 func (*endpoint) Capabilities() stack.LinkEndpointCapabilities {
-	return stack.CapabilityRXChecksumOffload | stack.CapabilityTXChecksumOffload | stack.CapabilitySaveRestore | stack.CapabilityLoopback
+	return stack.CapabilityRXChecksumOffload | stack.CapabilityTXChecksumOffload | stack.CapabilitySaveRestore | stack.CapabilityLoopback | stack.CapabilityLinkState
 }
 
 // MaxHeaderLength implements stack.LinkEndpoint.MaxHeaderLength. Given that the
@@ -81,27 +157,49 @@ func (*endpoint) LinkAddress() tcpip.LinkAddress {
 // Wait implements stack.LinkEndpoint.Wait.
 func (*endpoint) Wait() {}
 
+// Stats returns the statistics of the endpoint.
+func (e *endpoint) Stats() *Stats {
+	return &e.stats
+}
+
+// recordWrite updates the statistics of the endpoint for the packets in pkts.
+func (e *endpoint) recordWrite(pkts stack.PacketBufferList) {
+	var bytes uint64
+	for _, pkt := range pkts.AsSlice() {
+		bytes += uint64(pkt.Size())
+	}
+	e.stats.Packets.IncrementBy(uint64(pkts.Len()))
+	e.stats.Bytes.IncrementBy(bytes)
+}
+
 // WritePackets implements stack.LinkEndpoint.WritePackets. If the endpoint is
 // not attached, the packets are not delivered.
 func (e *endpoint) WritePackets(pkts stack.PacketBufferList) (int, tcpip.Error) {
+	e.recordWrite(pkts)
 	e.mu.RLock()
 	d := e.dispatcher
 	e.mu.RUnlock()
 	for _, pkt := range pkts.AsSlice() {
-		// In order to properly loop back to the inbound side we must create a
-		// fresh packet that only contains the underlying payload with no headers
-		// or struct fields set.
-		newPkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
-			Payload: pkt.ToBuffer(),
-		})
-		if d != nil {
-			d.DeliverNetworkPacket(pkt.NetworkProtocolNumber, newPkt)
-		}
-		newPkt.DecRef()
+		deliverPacket(d, pkt)
 	}
 	return pkts.Len(), nil
 }
 
+// deliverPacket loops pkt back to the inbound side of d. If d is nil, the
+// packet is not delivered.
+func deliverPacket(d stack.NetworkDispatcher, pkt *stack.PacketBuffer) {
+	// In order to properly loop back to the inbound side we must create a
+	// fresh packet that only contains the underlying payload with no headers
+	// or struct fields set.
+	newPkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: pkt.ToBuffer(),
+	})
+	if d != nil {
+		d.DeliverNetworkPacket(pkt.NetworkProtocolNumber, newPkt)
+	}
+	newPkt.DecRef()
+}
+
 // ARPHardwareType implements stack.LinkEndpoint.ARPHardwareType.
 func (*endpoint) ARPHardwareType() header.ARPHardwareType {
 	return header.ARPHardwareLoopback
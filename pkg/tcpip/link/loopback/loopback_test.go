@@ -0,0 +1,426 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loopback_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/refs"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/loopback"
+	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+type countingDispatcher struct {
+	count int
+}
+
+var _ stack.NetworkDispatcher = (*countingDispatcher)(nil)
+
+func (d *countingDispatcher) DeliverNetworkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {
+	d.count++
+}
+
+func (*countingDispatcher) DeliverLinkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {
+	panic("not implemented")
+}
+
+// writePackets writes n packets with a size byte payload to ep.
+func writePackets(t *testing.T, ep stack.LinkEndpoint, n, size int) {
+	t.Helper()
+
+	var pkts stack.PacketBufferList
+	for i := 0; i < n; i++ {
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Payload: buffer.MakeWithData(make([]byte, size)),
+		})
+		pkt.NetworkProtocolNumber = header.IPv4ProtocolNumber
+		pkts.PushBack(pkt)
+	}
+	defer pkts.DecRef()
+	if got, err := ep.WritePackets(pkts); err != nil || got != n {
+		t.Fatalf("ep.WritePackets(_) = (%d, %s), want = (%d, nil)", got, err, n)
+	}
+}
+
+func TestStats(t *testing.T) {
+	const (
+		numPackets = 5
+		size       = 100
+	)
+
+	tests := []struct {
+		name string
+		ep   stack.LinkEndpoint
+	}{
+		{
+			name: "loopback",
+			ep:   loopback.New(),
+		},
+		{
+			name: "lossy",
+			ep:   loopback.NewLossy(0.5, 1),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var disp countingDispatcher
+			test.ep.Attach(&disp)
+
+			stats, ok := loopback.StatsOf(test.ep)
+			if !ok {
+				t.Fatalf("loopback.StatsOf(_) = (_, false), want = (_, true)")
+			}
+			writePackets(t, test.ep, numPackets, size)
+			if got := stats.Packets.Value(); got != numPackets {
+				t.Errorf("got stats.Packets.Value() = %d, want = %d", got, numPackets)
+			}
+			if got, want := stats.Bytes.Value(), uint64(numPackets*size); got != want {
+				t.Errorf("got stats.Bytes.Value() = %d, want = %d", got, want)
+			}
+		})
+	}
+}
+
+func TestLossyDropRate(t *testing.T) {
+	const numPackets = 1000
+
+	tests := []struct {
+		name        string
+		dropRate    float64
+		wantDropped func(uint64) bool
+	}{
+		{
+			name:        "no loss",
+			dropRate:    0,
+			wantDropped: func(v uint64) bool { return v == 0 },
+		},
+		{
+			name:        "half",
+			dropRate:    0.5,
+			wantDropped: func(v uint64) bool { return v > numPackets/4 && v < numPackets*3/4 },
+		},
+		{
+			name:        "full loss",
+			dropRate:    1,
+			wantDropped: func(v uint64) bool { return v == numPackets },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var disp countingDispatcher
+			ep := loopback.NewLossy(test.dropRate, 1)
+			ep.Attach(&disp)
+
+			writePackets(t, ep, numPackets, 1)
+
+			if got := ep.Stats().Packets.Value(); got != numPackets {
+				t.Errorf("got ep.Stats().Packets.Value() = %d, want = %d", got, numPackets)
+			}
+			dropped := ep.Dropped.Value()
+			if !test.wantDropped(dropped) {
+				t.Errorf("got ep.Dropped.Value() = %d, not expected for drop rate %f", dropped, test.dropRate)
+			}
+			if got, want := uint64(disp.count), numPackets-dropped; got != want {
+				t.Errorf("got delivered packets = %d, want = %d", got, want)
+			}
+		})
+	}
+}
+
+func TestLossyDeterministic(t *testing.T) {
+	const numPackets = 100
+
+	var dropped [2]uint64
+	for i := range dropped {
+		var disp countingDispatcher
+		ep := loopback.NewLossy(0.3, 42)
+		ep.Attach(&disp)
+		writePackets(t, ep, numPackets, 1)
+		dropped[i] = ep.Dropped.Value()
+	}
+	if dropped[0] != dropped[1] {
+		t.Errorf("got dropped packets = %d and %d with the same seed, want equal", dropped[0], dropped[1])
+	}
+}
+
+func TestLossySetDropRate(t *testing.T) {
+	const numPackets = 10
+
+	var disp countingDispatcher
+	ep := loopback.NewLossy(0, 1)
+	ep.Attach(&disp)
+
+	writePackets(t, ep, numPackets, 1)
+	if got := disp.count; got != numPackets {
+		t.Errorf("got delivered packets = %d, want = %d", got, numPackets)
+	}
+
+	ep.SetDropRate(1)
+	if got := ep.DropRate(); got != 1 {
+		t.Errorf("got ep.DropRate() = %f, want = 1", got)
+	}
+	writePackets(t, ep, numPackets, 1)
+	if got := disp.count; got != numPackets {
+		t.Errorf("got delivered packets = %d after drop rate change, want = %d", got, numPackets)
+	}
+	if got := ep.Dropped.Value(); got != numPackets {
+		t.Errorf("got ep.Dropped.Value() = %d, want = %d", got, numPackets)
+	}
+}
+
+// chanDispatcher sends the payloads of delivered packets to a channel.
+type chanDispatcher struct {
+	c chan []byte
+}
+
+var _ stack.NetworkDispatcher = (*chanDispatcher)(nil)
+
+func (d *chanDispatcher) DeliverNetworkPacket(_ tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	d.c <- pkt.Data().AsRange().ToSlice()
+}
+
+func (*chanDispatcher) DeliverLinkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {
+	panic("not implemented")
+}
+
+func writePayloads(t *testing.T, ep stack.LinkEndpoint, payloads ...[]byte) {
+	t.Helper()
+
+	var pkts stack.PacketBufferList
+	for _, p := range payloads {
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Payload: buffer.MakeWithData(p),
+		})
+		pkt.NetworkProtocolNumber = header.IPv4ProtocolNumber
+		pkts.PushBack(pkt)
+	}
+	defer pkts.DecRef()
+	if got, err := ep.WritePackets(pkts); err != nil || got != len(payloads) {
+		t.Fatalf("ep.WritePackets(_) = (%d, %s), want = (%d, nil)", got, err, len(payloads))
+	}
+}
+
+// checkDelivered checks that the packets delivered to d so far carry the
+// one-byte payloads in want, in order.
+func checkDelivered(t *testing.T, d *chanDispatcher, want ...byte) {
+	t.Helper()
+	var got []byte
+	for len(d.c) != 0 {
+		p := <-d.c
+		if len(p) != 1 {
+			t.Fatalf("got packet payload = %x, want a single byte", p)
+		}
+		got = append(got, p[0])
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("got delivered payloads = %x, want = %x", got, want)
+	}
+}
+
+func TestDelayedDelivery(t *testing.T) {
+	const delay = 50 * time.Millisecond
+
+	clock := faketime.NewManualClock()
+	disp := chanDispatcher{c: make(chan []byte, 10)}
+	ep := loopback.NewDelayed(clock, delay)
+	defer ep.Close()
+	ep.Attach(&disp)
+
+	writePayloads(t, ep, []byte{0}, []byte{1})
+	clock.Advance(delay / 2)
+	writePayloads(t, ep, []byte{2})
+	checkDelivered(t, &disp)
+
+	clock.Advance(delay/2 - time.Nanosecond)
+	checkDelivered(t, &disp)
+	clock.Advance(time.Nanosecond)
+	checkDelivered(t, &disp, 0, 1)
+
+	clock.Advance(delay / 2)
+	checkDelivered(t, &disp, 2)
+}
+
+func TestDelayedDetached(t *testing.T) {
+	clock := faketime.NewManualClock()
+	disp := chanDispatcher{c: make(chan []byte, 1)}
+	ep := loopback.NewDelayed(clock, time.Millisecond)
+	defer ep.Close()
+
+	writePayloads(t, ep, []byte{1})
+	ep.Attach(&disp)
+	clock.Advance(time.Second)
+	checkDelivered(t, &disp)
+}
+
+func TestDelayedClose(t *testing.T) {
+	clock := faketime.NewManualClock()
+	disp := chanDispatcher{c: make(chan []byte, 3)}
+	ep := loopback.NewDelayed(clock, time.Hour)
+	ep.Attach(&disp)
+
+	writePayloads(t, ep, []byte{1}, []byte{2})
+	ep.Close()
+
+	// Packets written after Close are dropped.
+	writePayloads(t, ep, []byte{3})
+	clock.Advance(2 * time.Hour)
+	checkDelivered(t, &disp)
+
+	// Closing an already closed endpoint is a no-op.
+	ep.Close()
+}
+
+func TestDelayedStackDestroy(t *testing.T) {
+	clock := faketime.NewManualClock()
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		Clock:            clock,
+	})
+	ep := loopback.NewDelayed(clock, time.Hour)
+	if err := s.CreateNIC(1, ep); err != nil {
+		t.Fatalf("s.CreateNIC(1, _): %s", err)
+	}
+	writePayloads(t, ep, []byte{1})
+
+	// Destroying the stack must not wait for the endpoint to be closed.
+	done := make(chan struct{})
+	go func() {
+		s.Destroy()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for s.Destroy()")
+	}
+	ep.Close()
+}
+
+func TestEthernetLinkResolution(t *testing.T) {
+	const (
+		nicID    = 1
+		linkAddr = tcpip.LinkAddress("\x02\x03\x04\x05\x06\x07")
+	)
+	var (
+		localAddr  = tcpip.AddrFrom4([4]byte{10, 0, 0, 1})
+		remoteAddr = tcpip.AddrFrom4([4]byte{10, 0, 0, 2})
+	)
+
+	ep := loopback.NewEthernet(linkAddr)
+	if got, want := ep.MaxHeaderLength(), uint16(header.EthernetMinimumSize); got != want {
+		t.Errorf("got ep.MaxHeaderLength() = %d, want = %d", got, want)
+	}
+	if got := ep.LinkAddress(); got != linkAddr {
+		t.Errorf("got ep.LinkAddress() = %s, want = %s", got, linkAddr)
+	}
+
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{arp.NewProtocol, ipv4.NewProtocol},
+	})
+	defer s.Destroy()
+	if err := s.CreateNIC(nicID, ep); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+	for _, addr := range []tcpip.Address{localAddr, remoteAddr} {
+		protocolAddr := tcpip.ProtocolAddress{
+			Protocol:          ipv4.ProtocolNumber,
+			AddressWithPrefix: addr.WithPrefix(),
+		}
+		if err := s.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+			t.Fatalf("s.AddProtocolAddress(%d, %+v, {}): %s", nicID, protocolAddr, err)
+		}
+	}
+
+	// The ARP request is looped back and answered by the NIC itself, so the
+	// address resolves to the endpoint's own link address.
+	ch := make(chan stack.LinkResolutionResult, 1)
+	err := s.GetLinkAddress(nicID, remoteAddr, localAddr, ipv4.ProtocolNumber, func(r stack.LinkResolutionResult) {
+		ch <- r
+	})
+	if _, ok := err.(*tcpip.ErrWouldBlock); !ok {
+		t.Fatalf("got s.GetLinkAddress(%d, %s, %s, %d, _) = %v, want = %s", nicID, remoteAddr, localAddr, ipv4.ProtocolNumber, err, &tcpip.ErrWouldBlock{})
+	}
+	select {
+	case r := <-ch:
+		if r.Err != nil || r.LinkAddress != linkAddr {
+			t.Errorf("got link resolution result = (%s, %v), want = (%s, nil)", r.LinkAddress, r.Err, linkAddr)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("timed out waiting for link resolution")
+	}
+
+	if got := s.Stats().ARP.RequestsReceived.Value(); got != 1 {
+		t.Errorf("got s.Stats().ARP.RequestsReceived.Value() = %d, want = 1", got)
+	}
+	if got := s.Stats().ARP.RepliesReceived.Value(); got != 1 {
+		t.Errorf("got s.Stats().ARP.RepliesReceived.Value() = %d, want = 1", got)
+	}
+}
+
+func TestLinkState(t *testing.T) {
+	const nicID = 1
+	localAddr := tcpip.AddrFrom4([4]byte{127, 0, 0, 1})
+
+	ep := loopback.New()
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+	})
+	defer s.Destroy()
+	if err := s.CreateNIC(nicID, ep); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+	protocolAddr := tcpip.ProtocolAddress{
+		Protocol:          ipv4.ProtocolNumber,
+		AddressWithPrefix: localAddr.WithPrefix(),
+	}
+	if err := s.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+		t.Fatalf("s.AddProtocolAddress(%d, %+v, {}): %s", nicID, protocolAddr, err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicID}})
+
+	for _, up := range []bool{false, true} {
+		if !loopback.SetLinkUp(ep, up) {
+			t.Fatalf("got loopback.SetLinkUp(_, %t) = false, want = true", up)
+		}
+		if got := s.NICInfo()[nicID].Flags.Running; got != up {
+			t.Errorf("got s.NICInfo()[%d].Flags.Running = %t, want = %t", nicID, got, up)
+		}
+		r, err := s.FindRoute(nicID, localAddr, localAddr, ipv4.ProtocolNumber, false /* multicastLoop */)
+		if got := err == nil; got != up {
+			t.Errorf("got s.FindRoute(%d, %s, %s, %d, false) = (_, %v), want route found = %t", nicID, localAddr, localAddr, ipv4.ProtocolNumber, err, up)
+		}
+		if r != nil {
+			r.Release()
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	refs.SetLeakMode(refs.LeaksPanic)
+	code := m.Run()
+	refs.DoLeakCheck()
+	os.Exit(code)
+}
@@ -0,0 +1,103 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package loopback
+
+import (
+	"math/rand"
+	"sync"
+
+	"gvisor.dev/gvisor/pkg/atomicbitops"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// LossyEndpoint is a loopback endpoint that drops a fraction of the packets
+// written to it before they are turned into inbound packets. It is meant to be
+// used by tests exercising loss recovery.
+//
+// The packets written to the endpoint, including the dropped ones, are counted
+// in its Stats.
+type LossyEndpoint struct {
+	endpoint
+
+	// Dropped is the number of packets dropped by the endpoint.
+	Dropped tcpip.StatCounter
+
+	// dropRate is the probability, in the range [0, 1], of a packet being
+	// dropped.
+	dropRate atomicbitops.Float64
+
+	rngMu sync.Mutex
+	// +checklocks:rngMu
+	rng *rand.Rand
+}
+
+var _ stack.LinkEndpoint = (*LossyEndpoint)(nil)
+
+// NewLossy creates a new loopback endpoint that drops packets with probability
+// dropRate. The drop decisions are made using a random number generator
+// seeded with seed so that tests are deterministic.
+func NewLossy(dropRate float64, seed int64) *LossyEndpoint {
+	e := &LossyEndpoint{
+		endpoint: endpoint{mtu: defaultMTU},
+		rng:      rand.New(rand.NewSource(seed)),
+	}
+	e.SetDropRate(dropRate)
+	return e
+}
+
+// SetDropRate sets the probability of a packet being dropped. Values outside
+// of the range [0, 1] are clamped.
+func (e *LossyEndpoint) SetDropRate(dropRate float64) {
+	if dropRate < 0 {
+		dropRate = 0
+	} else if dropRate > 1 {
+		dropRate = 1
+	}
+	e.dropRate.Store(dropRate)
+}
+
+// DropRate returns the probability of a packet being dropped.
+func (e *LossyEndpoint) DropRate() float64 {
+	return e.dropRate.Load()
+}
+
+// WritePackets implements stack.LinkEndpoint.WritePackets. Dropped packets are
+// reported as written, as if they were lost on the wire.
+func (e *LossyEndpoint) WritePackets(pkts stack.PacketBufferList) (int, tcpip.Error) {
+	dropRate := e.dropRate.Load()
+	if dropRate == 0 {
+		return e.endpoint.WritePackets(pkts)
+	}
+
+	e.recordWrite(pkts)
+	e.mu.RLock()
+	d := e.dispatcher
+	e.mu.RUnlock()
+	for _, pkt := range pkts.AsSlice() {
+		if e.shouldDrop(dropRate) {
+			e.Dropped.Increment()
+			continue
+		}
+		deliverPacket(d, pkt)
+	}
+	return pkts.Len(), nil
+}
+
+func (e *LossyEndpoint) shouldDrop(dropRate float64) bool {
+	e.rngMu.Lock()
+	defer e.rngMu.Unlock()
+	return e.rng.Float64() < dropRate
+}
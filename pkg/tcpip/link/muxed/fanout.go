@@ -0,0 +1,326 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package muxed
+
+import (
+	"sync"
+
+	"gvisor.dev/gvisor/pkg/atomicbitops"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// Flood is returned by a FanOutPolicy to write a packet to every lower
+// endpoint.
+const Flood = -1
+
+// FanOutPolicy selects the lower endpoint an outbound packet is written to.
+// It returns an index into the endpoint's lower endpoints, or Flood to write
+// the packet to all of them.
+//
+// It is called synchronously from the packet path and must not block.
+type FanOutPolicy func(pkt *stack.PacketBuffer, numLowers int) int
+
+// FanOutEndpoint is a link endpoint backed by several lower link endpoints.
+// Outbound packets are written to the lower endpoints selected by its
+// FanOutPolicy and inbound packets from any lower endpoint are delivered to
+// the single dispatcher attached to the FanOutEndpoint.
+//
+// All lower endpoints must use the same link-layer framing; headers are added
+// and parsed by the first lower endpoint.
+//
+// The link of a FanOutEndpoint is up while the link of any lower endpoint is
+// up.
+type FanOutEndpoint struct {
+	// lowers, lowerDispatchers and policy are immutable after construction.
+	lowers           []stack.LinkEndpoint
+	lowerDispatchers []fanOutDispatcher
+	policy           FanOutPolicy
+
+	mu sync.RWMutex
+	// +checklocks:mu
+	dispatcher stack.NetworkDispatcher
+
+	// linkMu serializes link state changes of the lower endpoints.
+	linkMu sync.Mutex
+	// lowerUp holds the link state of each lower endpoint.
+	// +checklocks:linkMu
+	lowerUp []bool
+	// up is true if the link of any lower endpoint is up. It is only written
+	// with linkMu held.
+	up atomicbitops.Bool
+}
+
+var _ stack.LinkEndpoint = (*FanOutEndpoint)(nil)
+var _ stack.LinkStateEndpoint = (*FanOutEndpoint)(nil)
+
+// NewFanOutEndpoint creates a new FanOutEndpoint that floods outbound packets
+// to all of lowers.
+//
+// NewFanOutEndpoint panics if lowers is empty.
+func NewFanOutEndpoint(lowers []stack.LinkEndpoint) *FanOutEndpoint {
+	return NewFanOutEndpointWithPolicy(lowers, func(*stack.PacketBuffer, int) int { return Flood })
+}
+
+// NewFanOutEndpointWithPolicy creates a new FanOutEndpoint that writes
+// outbound packets to the lower endpoints selected by policy.
+//
+// NewFanOutEndpointWithPolicy panics if lowers is empty.
+func NewFanOutEndpointWithPolicy(lowers []stack.LinkEndpoint, policy FanOutPolicy) *FanOutEndpoint {
+	if len(lowers) == 0 {
+		panic("muxed: no lower endpoints")
+	}
+	lowerUp := make([]bool, len(lowers))
+	up := false
+	for i, ep := range lowers {
+		lowerUp[i] = lowerLinkUp(ep)
+		up = up || lowerUp[i]
+	}
+	e := &FanOutEndpoint{
+		lowers:           append([]stack.LinkEndpoint(nil), lowers...),
+		lowerDispatchers: make([]fanOutDispatcher, len(lowers)),
+		policy:           policy,
+		lowerUp:          lowerUp,
+	}
+	for i := range e.lowerDispatchers {
+		e.lowerDispatchers[i] = fanOutDispatcher{e: e, lower: i}
+	}
+	e.up.Store(up)
+	return e
+}
+
+// lowerLinkUp returns the link state of ep. Links of endpoints without
+// stack.CapabilityLinkState are always up.
+func lowerLinkUp(ep stack.LinkEndpoint) bool {
+	if ep.Capabilities()&stack.CapabilityLinkState == 0 {
+		return true
+	}
+	lse, ok := ep.(stack.LinkStateEndpoint)
+	return !ok || lse.LinkUp()
+}
+
+// fanOutDispatcher is attached to a lower endpoint of a FanOutEndpoint. It
+// forwards inbound packets to the dispatcher of the FanOutEndpoint and
+// records the link state of the lower endpoint.
+type fanOutDispatcher struct {
+	e     *FanOutEndpoint
+	lower int
+}
+
+var _ stack.NetworkDispatcher = (*fanOutDispatcher)(nil)
+var _ stack.LinkStateDispatcher = (*fanOutDispatcher)(nil)
+
+func (d *fanOutDispatcher) upper() stack.NetworkDispatcher {
+	d.e.mu.RLock()
+	defer d.e.mu.RUnlock()
+	return d.e.dispatcher
+}
+
+// DeliverNetworkPacket implements stack.NetworkDispatcher.
+func (d *fanOutDispatcher) DeliverNetworkPacket(protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	if upper := d.upper(); upper != nil {
+		upper.DeliverNetworkPacket(protocol, pkt)
+	}
+}
+
+// DeliverLinkPacket implements stack.NetworkDispatcher.
+func (d *fanOutDispatcher) DeliverLinkPacket(protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	if upper := d.upper(); upper != nil {
+		upper.DeliverLinkPacket(protocol, pkt)
+	}
+}
+
+// DeliverLinkState implements stack.LinkStateDispatcher.
+func (d *fanOutDispatcher) DeliverLinkState(up bool) {
+	d.e.setLowerLinkUp(d.lower, up)
+}
+
+// setLowerLinkUp records the link state of the lower endpoint at index i and
+// notifies the attached dispatcher if the link of e went up or down as a
+// result.
+func (e *FanOutEndpoint) setLowerLinkUp(i int, up bool) {
+	e.linkMu.Lock()
+	defer e.linkMu.Unlock()
+	e.lowerUp[i] = up
+	anyUp := false
+	for _, lowerUp := range e.lowerUp {
+		anyUp = anyUp || lowerUp
+	}
+	if e.up.Swap(anyUp) == anyUp {
+		return
+	}
+	e.mu.RLock()
+	d := e.dispatcher
+	e.mu.RUnlock()
+	if d, ok := d.(stack.LinkStateDispatcher); ok {
+		d.DeliverLinkState(anyUp)
+	}
+}
+
+// LinkUp implements stack.LinkStateEndpoint. The link is up while the link of
+// any lower endpoint is up.
+func (e *FanOutEndpoint) LinkUp() bool {
+	return e.up.Load()
+}
+
+// MTU implements stack.LinkEndpoint. It is the minimum of the lower
+// endpoints' MTUs.
+func (e *FanOutEndpoint) MTU() uint32 {
+	minMTU := ^uint32(0)
+	for _, ep := range e.lowers {
+		if mtu := ep.MTU(); mtu < minMTU {
+			minMTU = mtu
+		}
+	}
+	return minMTU
+}
+
+// Capabilities implements stack.LinkEndpoint. Only the capabilities shared by
+// all lower endpoints are reported, except for stack.CapabilityLinkState
+// which is reported if any lower endpoint has it.
+func (e *FanOutEndpoint) Capabilities() stack.LinkEndpointCapabilities {
+	caps := stack.LinkEndpointCapabilities(^uint(0))
+	var linkState stack.LinkEndpointCapabilities
+	for _, ep := range e.lowers {
+		caps &= ep.Capabilities()
+		linkState |= ep.Capabilities() & stack.CapabilityLinkState
+	}
+	return caps | linkState
+}
+
+// MaxHeaderLength implements stack.LinkEndpoint.
+func (e *FanOutEndpoint) MaxHeaderLength() uint16 {
+	var maxHeaderLen uint16
+	for _, ep := range e.lowers {
+		if headerLen := ep.MaxHeaderLength(); headerLen > maxHeaderLen {
+			maxHeaderLen = headerLen
+		}
+	}
+	return maxHeaderLen
+}
+
+// LinkAddress implements stack.LinkEndpoint. It is the link address of the
+// first lower endpoint.
+func (e *FanOutEndpoint) LinkAddress() tcpip.LinkAddress {
+	return e.lowers[0].LinkAddress()
+}
+
+// Attach implements stack.LinkEndpoint. Every lower endpoint is attached to
+// a dispatcher that forwards its packets to dispatcher.
+func (e *FanOutEndpoint) Attach(dispatcher stack.NetworkDispatcher) {
+	e.mu.Lock()
+	e.dispatcher = dispatcher
+	e.mu.Unlock()
+	for i, ep := range e.lowers {
+		if dispatcher == nil {
+			ep.Attach(nil)
+			continue
+		}
+		ep.Attach(&e.lowerDispatchers[i])
+		// Read the link state only once attached so that no change is
+		// missed.
+		e.setLowerLinkUp(i, lowerLinkUp(ep))
+	}
+}
+
+// IsAttached implements stack.LinkEndpoint.
+func (e *FanOutEndpoint) IsAttached() bool {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.dispatcher != nil
+}
+
+// WritePackets implements stack.LinkEndpoint. A flooded packet is considered
+// written if at least one lower endpoint wrote it.
+func (e *FanOutEndpoint) WritePackets(pkts stack.PacketBufferList) (int, tcpip.Error) {
+	n := 0
+	for _, pkt := range pkts.AsSlice() {
+		var err tcpip.Error
+		if i := e.policy(pkt, len(e.lowers)); i == Flood {
+			err = e.flood(pkt)
+		} else {
+			err = writePacket(e.lowers[i], pkt)
+		}
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// flood writes pkt to every lower endpoint. Each lower endpoint but the last
+// is given its own clone of pkt so that they do not share packet state.
+func (e *FanOutEndpoint) flood(pkt *stack.PacketBuffer) tcpip.Error {
+	var firstErr tcpip.Error
+	written := false
+	last := len(e.lowers) - 1
+	for i, ep := range e.lowers {
+		p := pkt
+		if i != last {
+			p = pkt.Clone()
+		}
+		err := writePacket(ep, p)
+		if i != last {
+			p.DecRef()
+		}
+		if err == nil {
+			written = true
+		} else if firstErr == nil {
+			firstErr = err
+		}
+	}
+	if written {
+		return nil
+	}
+	return firstErr
+}
+
+// writePacket writes pkt to ep.
+func writePacket(ep stack.LinkEndpoint, pkt *stack.PacketBuffer) tcpip.Error {
+	var pkts stack.PacketBufferList
+	pkts.PushBack(pkt)
+	n, err := ep.WritePackets(pkts)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return &tcpip.ErrNoBufferSpace{}
+	}
+	return nil
+}
+
+// Wait implements stack.LinkEndpoint.
+func (e *FanOutEndpoint) Wait() {
+	for _, ep := range e.lowers {
+		ep.Wait()
+	}
+}
+
+// ARPHardwareType implements stack.LinkEndpoint.
+func (e *FanOutEndpoint) ARPHardwareType() header.ARPHardwareType {
+	return e.lowers[0].ARPHardwareType()
+}
+
+// AddHeader implements stack.LinkEndpoint.
+func (e *FanOutEndpoint) AddHeader(pkt *stack.PacketBuffer) {
+	e.lowers[0].AddHeader(pkt)
+}
+
+// ParseHeader implements stack.LinkEndpoint.
+func (e *FanOutEndpoint) ParseHeader(pkt *stack.PacketBuffer) bool {
+	return e.lowers[0].ParseHeader(pkt)
+}
@@ -0,0 +1,210 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package muxed
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+type countingDispatcher struct {
+	count      int
+	linkStates []bool
+}
+
+var _ stack.NetworkDispatcher = (*countingDispatcher)(nil)
+var _ stack.LinkStateDispatcher = (*countingDispatcher)(nil)
+
+func (d *countingDispatcher) DeliverNetworkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {
+	d.count++
+}
+
+func (*countingDispatcher) DeliverLinkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {
+	panic("not implemented")
+}
+
+func (d *countingDispatcher) DeliverLinkState(up bool) {
+	d.linkStates = append(d.linkStates, up)
+}
+
+func newLowers(t *testing.T, mtus ...uint32) ([]*channel.Endpoint, []stack.LinkEndpoint) {
+	t.Helper()
+
+	var chans []*channel.Endpoint
+	var lowers []stack.LinkEndpoint
+	for _, mtu := range mtus {
+		ch := channel.New(1, mtu, "")
+		t.Cleanup(ch.Close)
+		chans = append(chans, ch)
+		lowers = append(lowers, ch)
+	}
+	return chans, lowers
+}
+
+func writePayload(t *testing.T, ep stack.LinkEndpoint, payload []byte) {
+	t.Helper()
+
+	var pkts stack.PacketBufferList
+	pkts.PushBack(stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(payload),
+	}))
+	defer pkts.DecRef()
+	if n, err := ep.WritePackets(pkts); err != nil || n != 1 {
+		t.Fatalf("ep.WritePackets(_) = (%d, %s), want = (1, nil)", n, err)
+	}
+}
+
+// checkRead checks that ch has exactly one queued packet carrying payload.
+func checkRead(t *testing.T, ch *channel.Endpoint, payload []byte) {
+	t.Helper()
+
+	pkt := ch.Read()
+	if pkt == nil {
+		t.Fatal("expected a packet to be written")
+	}
+	defer pkt.DecRef()
+	v := pkt.ToView()
+	defer v.Release()
+	if got := v.AsSlice(); !bytes.Equal(got, payload) {
+		t.Errorf("got packet payload = %v, want = %v", got, payload)
+	}
+	if n := ch.NumQueued(); n != 0 {
+		t.Errorf("got ch.NumQueued() = %d after reading, want = 0", n)
+	}
+}
+
+func TestFanOutEndpointMTU(t *testing.T) {
+	_, lowers := newLowers(t, 1500, header.IPv4MinimumMTU, 9000)
+	ep := NewFanOutEndpoint(lowers)
+	if got, want := ep.MTU(), uint32(header.IPv4MinimumMTU); got != want {
+		t.Errorf("got ep.MTU() = %d, want = %d", got, want)
+	}
+}
+
+func TestFanOutEndpointFanIn(t *testing.T) {
+	chans, lowers := newLowers(t, 1500, 1500)
+	ep := NewFanOutEndpoint(lowers)
+	var d countingDispatcher
+	ep.Attach(&d)
+	if !ep.IsAttached() {
+		t.Fatal("got ep.IsAttached() = false, want = true")
+	}
+
+	for i, ch := range chans {
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{})
+		ch.InjectInbound(header.IPv4ProtocolNumber, pkt)
+		pkt.DecRef()
+		if got, want := d.count, i+1; got != want {
+			t.Errorf("got %d delivered packets after injecting into lower %d, want = %d", got, i, want)
+		}
+	}
+
+	// Detaching the endpoint detaches all lower endpoints.
+	ep.Attach(nil)
+	for i, ch := range chans {
+		if ch.IsAttached() {
+			t.Errorf("got lower %d attached after detaching, want detached", i)
+		}
+	}
+}
+
+func TestFanOutEndpointFanOut(t *testing.T) {
+	chans, lowers := newLowers(t, 1500, 1500)
+	ep := NewFanOutEndpoint(lowers)
+
+	payload := []byte{1, 2, 3, 4}
+	writePayload(t, ep, payload)
+	for _, ch := range chans {
+		checkRead(t, ch, payload)
+	}
+}
+
+func TestFanOutEndpointFanOutPartialFailure(t *testing.T) {
+	chans, lowers := newLowers(t, 1500, 1500)
+	ep := NewFanOutEndpoint(lowers)
+
+	// Fill the first lower endpoint's queue. The packet is still written to
+	// the second.
+	writePayload(t, lowers[0], []byte{0})
+	payload := []byte{1, 2, 3, 4}
+	writePayload(t, ep, payload)
+	checkRead(t, chans[0], []byte{0})
+	checkRead(t, chans[1], payload)
+}
+
+func TestFanOutEndpointPolicy(t *testing.T) {
+	chans, lowers := newLowers(t, 1500, 1500, 1500)
+	ep := NewFanOutEndpointWithPolicy(lowers, func(pkt *stack.PacketBuffer, numLowers int) int {
+		if numLowers != 3 {
+			t.Errorf("got numLowers = %d, want = 3", numLowers)
+		}
+		// Select the lower endpoint based on the first payload byte.
+		return int(pkt.Data().AsRange().ToSlice()[0]) % numLowers
+	})
+
+	for i := range chans {
+		payload := []byte{byte(i), 5, 6}
+		writePayload(t, ep, payload)
+		for j, ch := range chans {
+			if j == i {
+				checkRead(t, ch, payload)
+			} else if n := ch.NumQueued(); n != 0 {
+				t.Errorf("got chans[%d].NumQueued() = %d after writing to lower %d, want = 0", j, n, i)
+			}
+		}
+	}
+}
+
+func TestFanOutEndpointLinkState(t *testing.T) {
+	chans, lowers := newLowers(t, 1500, 1500)
+	for _, ch := range chans {
+		ch.LinkEPCapabilities |= stack.CapabilityLinkState
+	}
+	chans[1].SetLinkUp(false)
+	ep := NewFanOutEndpoint(lowers)
+	if got := ep.Capabilities() & stack.CapabilityLinkState; got == 0 {
+		t.Fatal("got ep.Capabilities() without CapabilityLinkState, want with")
+	}
+	var d countingDispatcher
+	ep.Attach(&d)
+
+	for _, test := range []struct {
+		name       string
+		lower      int
+		up         bool
+		wantUp     bool
+		wantStates []bool
+	}{
+		{name: "first lower down", lower: 0, up: false, wantUp: false, wantStates: []bool{false}},
+		{name: "second lower up", lower: 1, up: true, wantUp: true, wantStates: []bool{false, true}},
+		{name: "first lower up", lower: 0, up: true, wantUp: true, wantStates: []bool{false, true}},
+		{name: "second lower down", lower: 1, up: false, wantUp: true, wantStates: []bool{false, true}},
+	} {
+		chans[test.lower].SetLinkUp(test.up)
+		if got := ep.LinkUp(); got != test.wantUp {
+			t.Errorf("%s: got ep.LinkUp() = %t, want = %t", test.name, got, test.wantUp)
+		}
+		if diff := cmp.Diff(test.wantStates, d.linkStates); diff != "" {
+			t.Errorf("%s: delivered link states mismatch (-want +got):\n%s", test.name, diff)
+		}
+	}
+}
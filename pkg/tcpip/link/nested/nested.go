@@ -40,6 +40,8 @@ type Endpoint struct {
 var _ stack.GSOEndpoint = (*Endpoint)(nil)
 var _ stack.LinkEndpoint = (*Endpoint)(nil)
 var _ stack.NetworkDispatcher = (*Endpoint)(nil)
+var _ stack.LinkStateEndpoint = (*Endpoint)(nil)
+var _ stack.LinkStateDispatcher = (*Endpoint)(nil)
 
 // Init initializes a nested.Endpoint that uses embedder as the dispatcher for
 // child on Attach.
@@ -70,6 +72,16 @@ func (e *Endpoint) DeliverLinkPacket(protocol tcpip.NetworkProtocolNumber, pkt *
 	}
 }
 
+// DeliverLinkState implements stack.LinkStateDispatcher.
+func (e *Endpoint) DeliverLinkState(up bool) {
+	e.mu.RLock()
+	d := e.dispatcher
+	e.mu.RUnlock()
+	if d, ok := d.(stack.LinkStateDispatcher); ok {
+		d.DeliverLinkState(up)
+	}
+}
+
 // Attach implements stack.LinkEndpoint.
 func (e *Endpoint) Attach(dispatcher stack.NetworkDispatcher) {
 	e.mu.Lock()
@@ -102,6 +114,14 @@ func (e *Endpoint) Capabilities() stack.LinkEndpointCapabilities {
 	return e.child.Capabilities()
 }
 
+// LinkUp implements stack.LinkStateEndpoint.
+func (e *Endpoint) LinkUp() bool {
+	if lse, ok := e.child.(stack.LinkStateEndpoint); ok {
+		return lse.LinkUp()
+	}
+	return true
+}
+
 // MaxHeaderLength implements stack.LinkEndpoint.
 func (e *Endpoint) MaxHeaderLength() uint16 {
 	return e.child.MaxHeaderLength()
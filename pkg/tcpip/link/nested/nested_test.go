@@ -48,10 +48,12 @@ func (c *childEndpoint) IsAttached() bool {
 }
 
 type counterDispatcher struct {
-	count int
+	count      int
+	linkStates []bool
 }
 
 var _ stack.NetworkDispatcher = (*counterDispatcher)(nil)
+var _ stack.LinkStateDispatcher = (*counterDispatcher)(nil)
 
 func (d *counterDispatcher) DeliverNetworkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {
 	d.count++
@@ -61,6 +63,19 @@ func (*counterDispatcher) DeliverLinkPacket(tcpip.NetworkProtocolNumber, *stack.
 	panic("not implemented")
 }
 
+func (d *counterDispatcher) DeliverLinkState(up bool) {
+	d.linkStates = append(d.linkStates, up)
+}
+
+type linkStateChildEndpoint struct {
+	childEndpoint
+	up bool
+}
+
+func (c *linkStateChildEndpoint) LinkUp() bool {
+	return c.up
+}
+
 func TestNestedLinkEndpoint(t *testing.T) {
 	var (
 		childEP  childEndpoint
@@ -115,6 +130,36 @@ func TestNestedLinkEndpoint(t *testing.T) {
 	}
 }
 
+func TestNestedLinkState(t *testing.T) {
+	var (
+		childEP  linkStateChildEndpoint
+		nestedEP parentEndpoint
+		disp     counterDispatcher
+	)
+	nestedEP.Endpoint.Init(&childEP, &nestedEP)
+	nestedEP.Attach(&disp)
+
+	childEP.up = true
+	if !nestedEP.LinkUp() {
+		t.Error("With child link up, nestedEP.LinkUp() = false, want = true")
+	}
+
+	childEP.up = false
+	childEP.dispatcher.(stack.LinkStateDispatcher).DeliverLinkState(false)
+	if nestedEP.LinkUp() {
+		t.Error("With child link down, nestedEP.LinkUp() = true, want = false")
+	}
+	if len(disp.linkStates) != 1 || disp.linkStates[0] {
+		t.Errorf("After child link went down, got disp.linkStates = %v, want = [false]", disp.linkStates)
+	}
+
+	nestedEP.Attach(nil)
+	nestedEP.DeliverLinkState(true)
+	if len(disp.linkStates) != 1 {
+		t.Errorf("After detach, got disp.linkStates = %v, want = [false]", disp.linkStates)
+	}
+}
+
 func TestMain(m *testing.M) {
 	refs.SetLeakMode(refs.LeaksPanic)
 	code := m.Run()
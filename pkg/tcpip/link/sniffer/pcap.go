@@ -23,6 +23,21 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
+// LinkType is the data link type of the packets written to a pcap writer.
+//
+// See https://www.tcpdump.org/linktypes.html.
+type LinkType uint32
+
+const (
+	// LinkTypeEthernet indicates that captured packets start with an Ethernet
+	// header.
+	LinkTypeEthernet LinkType = 1
+
+	// LinkTypeRaw indicates that captured packets start with an IPv4 or IPv6
+	// header.
+	LinkTypeRaw LinkType = 101
+)
+
 type pcapHeader struct {
 	// MagicNumber is the file magic number.
 	MagicNumber uint32
@@ -52,10 +67,16 @@ type pcapPacket struct {
 	timestamp     time.Time
 	packet        *stack.PacketBuffer
 	maxCaptureLen int
+	linkType      LinkType
 }
 
 func (p *pcapPacket) MarshalBinary() ([]byte, error) {
-	pkt := trimmedClone(p.packet)
+	var pkt *stack.PacketBuffer
+	if p.linkType == LinkTypeEthernet {
+		pkt = linkClone(p.packet)
+	} else {
+		pkt = trimmedClone(p.packet)
+	}
 	defer pkt.DecRef()
 	packetSize := pkt.Size()
 	captureLen := p.maxCaptureLen
@@ -26,12 +26,14 @@ import (
 	"io"
 	"time"
 
+	"golang.org/x/time/rate"
 	"gvisor.dev/gvisor/pkg/atomicbitops"
 	"gvisor.dev/gvisor/pkg/log"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/header/parse"
 	"gvisor.dev/gvisor/pkg/tcpip/link/nested"
+	"gvisor.dev/gvisor/pkg/tcpip/packetfilter"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
@@ -48,7 +50,20 @@ type endpoint struct {
 	nested.Endpoint
 	writer     io.Writer
 	maxPCAPLen uint32
+	linkType   LinkType
 	logPrefix  string
+
+	// limiter limits the rate at which packets are logged. It is nil if
+	// logging is not rate limited.
+	limiter *rate.Limiter
+
+	// suppressed is the number of packets that were not logged since the
+	// last suppression report because of limiter.
+	suppressed atomicbitops.Uint64
+
+	// filter selects the packets that are logged. It is nil if all packets
+	// are logged.
+	filter *packetfilter.Filter
 }
 
 var _ stack.GSOEndpoint = (*endpoint)(nil)
@@ -84,13 +99,43 @@ func NewWithPrefix(lower stack.LinkEndpoint, logPrefix string) stack.LinkEndpoin
 	return sniffer
 }
 
+// NewWithPrefixAndRate creates a new sniffer link-layer endpoint like
+// NewWithPrefix, but logs at most maxPerSecond packets per second, with bursts
+// of up to maxPerSecond packets. A maxPerSecond of 0 means that logging is not
+// rate limited.
+//
+// Packets that are not logged are still forwarded. The number of packets that
+// were not logged is reported when logging resumes.
+func NewWithPrefixAndRate(lower stack.LinkEndpoint, logPrefix string, maxPerSecond int) stack.LinkEndpoint {
+	sniffer := &endpoint{logPrefix: logPrefix}
+	if maxPerSecond > 0 {
+		sniffer.limiter = rate.NewLimiter(rate.Limit(maxPerSecond), maxPerSecond)
+	}
+	sniffer.Endpoint.Init(lower, sniffer)
+	return sniffer
+}
+
+// NewWithFilter creates a new sniffer link-layer endpoint like NewWithPrefix,
+// but only logs packets accepted by filter. The filter sees packets starting
+// at the network header, i.e. as they are written in LINKTYPE_RAW captures.
+//
+// Packets rejected by the filter are still forwarded.
+func NewWithFilter(lower stack.LinkEndpoint, logPrefix string, filter *packetfilter.Filter) stack.LinkEndpoint {
+	sniffer := &endpoint{
+		logPrefix: logPrefix,
+		filter:    filter,
+	}
+	sniffer.Endpoint.Init(lower, sniffer)
+	return sniffer
+}
+
 func zoneOffset() (int32, error) {
 	date := time.Date(0, 0, 0, 0, 0, 0, 0, time.Local)
 	_, offset := date.Zone()
 	return int32(offset), nil
 }
 
-func writePCAPHeader(w io.Writer, maxLen uint32) error {
+func writePCAPHeader(w io.Writer, maxLen uint32, linkType LinkType) error {
 	offset, err := zoneOffset()
 	if err != nil {
 		return err
@@ -104,7 +149,7 @@ func writePCAPHeader(w io.Writer, maxLen uint32) error {
 		Thiszone:     offset,
 		Sigfigs:      0,
 		Snaplen:      maxLen,
-		Network:      101, // LINKTYPE_RAW
+		Network:      uint32(linkType),
 	})
 }
 
@@ -119,12 +164,33 @@ func writePCAPHeader(w io.Writer, maxLen uint32) error {
 // less than or equal to snapLen will be saved in their entirety. Longer
 // packets will be truncated to snapLen.
 func NewWithWriter(lower stack.LinkEndpoint, writer io.Writer, snapLen uint32) (stack.LinkEndpoint, error) {
-	if err := writePCAPHeader(writer, snapLen); err != nil {
+	return NewWithWriterAndFilter(lower, writer, snapLen, nil)
+}
+
+// NewWithWriterAndFilter creates a new sniffer link-layer endpoint like
+// NewWithWriter, but only writes packets accepted by filter. A nil filter
+// accepts all packets. See NewWithFilter.
+func NewWithWriterAndFilter(lower stack.LinkEndpoint, writer io.Writer, snapLen uint32, filter *packetfilter.Filter) (stack.LinkEndpoint, error) {
+	return newWithWriter(lower, writer, snapLen, LinkTypeRaw, filter)
+}
+
+// NewWithWriterAndLinkType creates a new sniffer link-layer endpoint like
+// NewWithWriter, but writes packets with the given pcap link type. Link-layer
+// headers are only written for LinkTypeEthernet; with LinkTypeRaw, packets
+// start at the network header.
+func NewWithWriterAndLinkType(lower stack.LinkEndpoint, writer io.Writer, snapLen uint32, linkType LinkType) (stack.LinkEndpoint, error) {
+	return newWithWriter(lower, writer, snapLen, linkType, nil)
+}
+
+func newWithWriter(lower stack.LinkEndpoint, writer io.Writer, snapLen uint32, linkType LinkType, filter *packetfilter.Filter) (stack.LinkEndpoint, error) {
+	if err := writePCAPHeader(writer, snapLen, linkType); err != nil {
 		return nil, err
 	}
 	sniffer := &endpoint{
 		writer:     writer,
 		maxPCAPLen: snapLen,
+		linkType:   linkType,
+		filter:     filter,
 	}
 	sniffer.Endpoint.Init(lower, sniffer)
 	return sniffer, nil
@@ -140,14 +206,15 @@ func (e *endpoint) DeliverNetworkPacket(protocol tcpip.NetworkProtocolNumber, pk
 
 func (e *endpoint) dumpPacket(dir Direction, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
 	writer := e.writer
-	if writer == nil && LogPackets.Load() == 1 {
-		LogPacket(e.logPrefix, dir, protocol, pkt)
+	if writer == nil && LogPackets.Load() == 1 && e.match(pkt) {
+		e.logPacket(dir, protocol, pkt)
 	}
-	if writer != nil && LogPacketsToPCAP.Load() == 1 {
+	if writer != nil && LogPacketsToPCAP.Load() == 1 && e.match(pkt) {
 		packet := pcapPacket{
 			timestamp:     time.Now(),
 			packet:        pkt,
 			maxCaptureLen: int(e.maxPCAPLen),
+			linkType:      e.linkType,
 		}
 		b, err := packet.MarshalBinary()
 		if err != nil {
@@ -159,6 +226,30 @@ func (e *endpoint) dumpPacket(dir Direction, protocol tcpip.NetworkProtocolNumbe
 	}
 }
 
+func (e *endpoint) logPacket(dir Direction, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	if e.limiter != nil {
+		if !e.limiter.Allow() {
+			e.suppressed.Add(1)
+			return
+		}
+		if n := e.suppressed.Swap(0); n != 0 {
+			log.Infof("%ssuppressed logging of %d packets", e.logPrefix, n)
+		}
+	}
+	LogPacket(e.logPrefix, dir, protocol, pkt)
+}
+
+// match returns true if pkt is accepted by the endpoint's filter.
+func (e *endpoint) match(pkt *stack.PacketBuffer) bool {
+	if e.filter == nil {
+		return true
+	}
+	buf := pkt.ToBuffer()
+	defer buf.Release()
+	buf.TrimFront(int64(len(pkt.VirtioNetHeader().Slice()) + len(pkt.LinkHeader().Slice())))
+	return e.filter.Match(buf.Flatten())
+}
+
 // WritePackets implements the stack.LinkEndpoint interface. It is called by
 // higher-level protocols to write packets; it just logs the packet and
 // forwards the request to the lower endpoint.
@@ -391,3 +482,10 @@ func trimmedClone(pkt *stack.PacketBuffer) *stack.PacketBuffer {
 	buf.TrimFront(int64(len(pkt.LinkHeader().Slice())))
 	return stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buf})
 }
+
+// linkClone is like trimmedClone, but keeps the link header.
+func linkClone(pkt *stack.PacketBuffer) *stack.PacketBuffer {
+	buf := pkt.ToBuffer()
+	buf.TrimFront(int64(len(pkt.VirtioNetHeader().Slice())))
+	return stack.NewPacketBuffer(stack.PacketBufferOptions{Payload: buf})
+}
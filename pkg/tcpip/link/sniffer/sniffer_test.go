@@ -0,0 +1,307 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sniffer_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/bpf"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/log"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/link/ethernet"
+	"gvisor.dev/gvisor/pkg/tcpip/link/sniffer"
+	"gvisor.dev/gvisor/pkg/tcpip/packetfilter"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// recordingEmitter is a log.Emitter that records formatted log lines.
+type recordingEmitter struct {
+	lines []string
+}
+
+var _ log.Emitter = (*recordingEmitter)(nil)
+
+// Emit implements log.Emitter.
+func (r *recordingEmitter) Emit(_ int, _ log.Level, _ time.Time, format string, v ...any) {
+	r.lines = append(r.lines, fmt.Sprintf(format, v...))
+}
+
+func (r *recordingEmitter) count(substr string) int {
+	n := 0
+	for _, l := range r.lines {
+		if strings.Contains(l, substr) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestRateLimitedLogging(t *testing.T) {
+	const (
+		prefix     = "test/"
+		numPackets = 20
+	)
+
+	tests := []struct {
+		name         string
+		maxPerSecond int
+		wantLogged   int
+	}{
+		{
+			name:         "unlimited",
+			maxPerSecond: 0,
+			wantLogged:   numPackets,
+		},
+		{
+			name:         "limited",
+			maxPerSecond: 5,
+			wantLogged:   5,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var emitter recordingEmitter
+			oldEmitter := log.Log().Emitter
+			log.SetTarget(&emitter)
+			defer log.SetTarget(oldEmitter)
+
+			lower := channel.New(numPackets+1, header.IPv4MinimumMTU, "")
+			defer lower.Close()
+			ep := sniffer.NewWithPrefixAndRate(lower, prefix, test.maxPerSecond)
+
+			write := func() {
+				t.Helper()
+
+				var pkts stack.PacketBufferList
+				pkts.PushBack(stack.NewPacketBuffer(stack.PacketBufferOptions{}))
+				n, err := ep.WritePackets(pkts)
+				pkts.DecRef()
+				if err != nil || n != 1 {
+					t.Fatalf("ep.WritePackets(_) = (%d, %s), want = (1, nil)", n, err)
+				}
+			}
+			for i := 0; i < numPackets; i++ {
+				write()
+			}
+
+			if got := lower.NumQueued(); got != numPackets {
+				t.Errorf("got lower.NumQueued() = %d, want = %d", got, numPackets)
+			}
+			if got := emitter.count(prefix + "send"); got != test.wantLogged {
+				t.Errorf("got %d logged packets, want = %d", got, test.wantLogged)
+			}
+			if test.maxPerSecond == 0 {
+				return
+			}
+
+			// Once the limiter replenishes, logging resumes and reports the
+			// packets that were not logged.
+			time.Sleep(time.Second / time.Duration(test.maxPerSecond))
+			write()
+			if got := emitter.count(prefix + "send"); got != test.wantLogged+1 {
+				t.Errorf("got %d logged packets, want = %d", got, test.wantLogged+1)
+			}
+			want := fmt.Sprintf("%ssuppressed logging of %d packets", prefix, numPackets-test.wantLogged)
+			if got := emitter.count(want); got != 1 {
+				t.Errorf("got %d log lines containing %q, want = 1; all lines: %q", got, want, emitter.lines)
+			}
+			lower.Drain()
+		})
+	}
+}
+
+func TestFilter(t *testing.T) {
+	const (
+		prefix = "test/"
+
+		pcapHeaderSize       = 24
+		pcapRecordHeaderSize = 16
+	)
+
+	// The filter accepts packets starting with 1. Programs are tested in
+	// package packetfilter; this only checks that the sniffer applies them.
+	f, err := packetfilter.New([]bpf.Instruction{
+		bpf.Stmt(bpf.Ld|bpf.Abs|bpf.B, 0),
+		bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 1, 0, 1),
+		bpf.Stmt(bpf.Ret|bpf.K, 0xffff),
+		bpf.Stmt(bpf.Ret|bpf.K, 0),
+	})
+	if err != nil {
+		t.Fatalf("packetfilter.New(_): %s", err)
+	}
+
+	pkts := []struct {
+		b     []byte
+		match bool
+	}{
+		{b: []byte{1, 2, 3, 4}, match: true},
+		{b: []byte{2, 2, 3, 4}, match: false},
+	}
+
+	tests := []struct {
+		name string
+		pcap bool
+	}{
+		{
+			name: "log",
+			pcap: false,
+		},
+		{
+			name: "pcap",
+			pcap: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var emitter recordingEmitter
+			oldEmitter := log.Log().Emitter
+			log.SetTarget(&emitter)
+			defer log.SetTarget(oldEmitter)
+
+			lower := channel.New(len(pkts), header.IPv4MinimumMTU, "")
+			defer lower.Close()
+			var w bytes.Buffer
+			var ep stack.LinkEndpoint
+			if test.pcap {
+				var err error
+				ep, err = sniffer.NewWithWriterAndFilter(lower, &w, header.IPv4MinimumMTU, f)
+				if err != nil {
+					t.Fatalf("sniffer.NewWithWriterAndFilter(...): %s", err)
+				}
+			} else {
+				ep = sniffer.NewWithFilter(lower, prefix, f)
+			}
+
+			wantLen := pcapHeaderSize
+			for _, p := range pkts {
+				var pkts stack.PacketBufferList
+				pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+					Payload: buffer.MakeWithData(p.b),
+				})
+				pkts.PushBack(pkt)
+				n, err := ep.WritePackets(pkts)
+				pkts.DecRef()
+				if err != nil || n != 1 {
+					t.Fatalf("ep.WritePackets(_) = (%d, %s), want = (1, nil)", n, err)
+				}
+				if p.match {
+					wantLen += pcapRecordHeaderSize + len(p.b)
+				}
+			}
+
+			// Packets rejected by the filter are still forwarded.
+			if got := lower.NumQueued(); got != len(pkts) {
+				t.Errorf("got lower.NumQueued() = %d, want = %d", got, len(pkts))
+			}
+			if test.pcap {
+				if got := w.Len(); got != wantLen {
+					t.Errorf("got w.Len() = %d, want = %d", got, wantLen)
+				}
+			} else {
+				if got := emitter.count(prefix + "send"); got != 1 {
+					t.Errorf("got %d logged packets, want = 1; all lines: %q", got, emitter.lines)
+				}
+			}
+			lower.Drain()
+		})
+	}
+}
+
+type nopDispatcher struct{}
+
+var _ stack.NetworkDispatcher = (*nopDispatcher)(nil)
+
+func (*nopDispatcher) DeliverNetworkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {}
+
+func (*nopDispatcher) DeliverLinkPacket(tcpip.NetworkProtocolNumber, *stack.PacketBuffer) {}
+
+func TestWriterLinkType(t *testing.T) {
+	const (
+		linkAddr      = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x06")
+		otherLinkAddr = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x07")
+
+		pcapHeaderSize       = 24
+		pcapRecordHeaderSize = 16
+	)
+
+	payload := []byte{1, 2, 3, 4}
+	frame := make([]byte, header.EthernetMinimumSize+len(payload))
+	header.Ethernet(frame).Encode(&header.EthernetFields{
+		SrcAddr: otherLinkAddr,
+		DstAddr: linkAddr,
+		Type:    header.IPv4ProtocolNumber,
+	})
+	copy(frame[header.EthernetMinimumSize:], payload)
+
+	tests := []struct {
+		name     string
+		linkType sniffer.LinkType
+		want     []byte
+	}{
+		{
+			name:     "raw",
+			linkType: sniffer.LinkTypeRaw,
+			want:     payload,
+		},
+		{
+			name:     "ethernet",
+			linkType: sniffer.LinkTypeEthernet,
+			want:     frame,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			lower := channel.New(1, header.IPv4MinimumMTU, linkAddr)
+			defer lower.Close()
+			var w bytes.Buffer
+			ep, err := sniffer.NewWithWriterAndLinkType(ethernet.New(lower), &w, header.IPv4MinimumMTU, test.linkType)
+			if err != nil {
+				t.Fatalf("sniffer.NewWithWriterAndLinkType(...): %s", err)
+			}
+			ep.Attach(&nopDispatcher{})
+
+			pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Payload: buffer.MakeWithData(frame),
+			})
+			lower.InjectInbound(0, pkt)
+			pkt.DecRef()
+
+			b := w.Bytes()
+			if got, want := len(b), pcapHeaderSize+pcapRecordHeaderSize+len(test.want); got != want {
+				t.Fatalf("got len(w.Bytes()) = %d, want = %d", got, want)
+			}
+			if got := sniffer.LinkType(binary.LittleEndian.Uint32(b[20:24])); got != test.linkType {
+				t.Errorf("got link type = %d, want = %d", got, test.linkType)
+			}
+			if got := binary.LittleEndian.Uint32(b[pcapHeaderSize+12:]); got != uint32(len(test.want)) {
+				t.Errorf("got packet length = %d, want = %d", got, len(test.want))
+			}
+			if got := b[pcapHeaderSize+pcapRecordHeaderSize:]; !bytes.Equal(got, test.want) {
+				t.Errorf("got packet = %x, want = %x", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,204 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sniffer
+
+import (
+	"sync/atomic"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/header/parse"
+	"gvisor.dev/gvisor/pkg/tcpip/link/nested"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// PacketSummary is a decoded summary of a packet that traversed a
+// SummaryEndpoint.
+type PacketSummary struct {
+	// Timestamp is the time at which the packet was observed.
+	Timestamp time.Time
+
+	// Direction is the direction the packet was travelling in.
+	Direction Direction
+
+	// NetworkProtocol is the network protocol of the packet.
+	NetworkProtocol tcpip.NetworkProtocolNumber
+
+	// TransportProtocol is the transport protocol of the packet. It is zero
+	// if the packet does not carry a transport protocol (e.g. ARP).
+	TransportProtocol tcpip.TransportProtocolNumber
+
+	// Src and Dst are the network-layer source and destination addresses. For
+	// ARP packets, they are the sender and target protocol addresses.
+	Src tcpip.Address
+	Dst tcpip.Address
+
+	// SrcPort and DstPort are the transport-layer ports. They are zero for
+	// transport protocols without ports and for non-initial fragments.
+	SrcPort uint16
+	DstPort uint16
+
+	// Size is the size of the packet, starting at the network header.
+	Size int
+
+	// TCPFlags holds the flags of a TCP segment.
+	TCPFlags header.TCPFlags
+}
+
+// SummaryFunc is called with the summary of each packet that traverses a
+// SummaryEndpoint.
+//
+// It is called synchronously from the packet path and must not block.
+type SummaryFunc func(PacketSummary)
+
+// SummaryEndpoint is a sniffer link-layer endpoint that reports decoded
+// packet summaries to a subscriber instead of logging them.
+type SummaryEndpoint struct {
+	nested.Endpoint
+
+	fn atomic.Value // SummaryFunc
+}
+
+var _ stack.GSOEndpoint = (*SummaryEndpoint)(nil)
+var _ stack.LinkEndpoint = (*SummaryEndpoint)(nil)
+var _ stack.NetworkDispatcher = (*SummaryEndpoint)(nil)
+
+// NewWithSummaries creates a new sniffer link-layer endpoint. It wraps around
+// another endpoint and reports a summary of each packet that traverses the
+// endpoint to the function installed with Subscribe.
+//
+// Packets are not decoded while there is no subscriber.
+func NewWithSummaries(lower stack.LinkEndpoint) *SummaryEndpoint {
+	e := &SummaryEndpoint{}
+	e.Endpoint.Init(lower, e)
+	return e
+}
+
+// Subscribe installs fn as the receiver of packet summaries, replacing any
+// previously installed function. A nil fn removes the subscriber.
+func (e *SummaryEndpoint) Subscribe(fn SummaryFunc) {
+	// This must be a SummaryFunc because atomic.Value.Store(nil) panics.
+	e.fn.Store(fn)
+}
+
+func (e *SummaryEndpoint) subscriber() SummaryFunc {
+	fn := e.fn.Load()
+	if fn == nil {
+		return nil
+	}
+	return fn.(SummaryFunc)
+}
+
+// DeliverNetworkPacket implements the stack.NetworkDispatcher interface. It is
+// called by the link-layer endpoint being wrapped when a packet arrives, and
+// reports the packet before forwarding to the actual dispatcher.
+func (e *SummaryEndpoint) DeliverNetworkPacket(protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) {
+	if fn := e.subscriber(); fn != nil {
+		if s, ok := summarize(DirectionRecv, protocol, pkt); ok {
+			fn(s)
+		}
+	}
+	e.Endpoint.DeliverNetworkPacket(protocol, pkt)
+}
+
+// WritePackets implements the stack.LinkEndpoint interface. It is called by
+// higher-level protocols to write packets; it reports the packets and
+// forwards the request to the lower endpoint.
+func (e *SummaryEndpoint) WritePackets(pkts stack.PacketBufferList) (int, tcpip.Error) {
+	if fn := e.subscriber(); fn != nil {
+		for _, pkt := range pkts.AsSlice() {
+			if s, ok := summarize(DirectionSend, pkt.NetworkProtocolNumber, pkt); ok {
+				fn(s)
+			}
+		}
+	}
+	return e.Endpoint.WritePackets(pkts)
+}
+
+// summarize decodes pkt into a PacketSummary. It returns false if the network
+// header could not be parsed.
+func summarize(dir Direction, protocol tcpip.NetworkProtocolNumber, pkt *stack.PacketBuffer) (PacketSummary, bool) {
+	s := PacketSummary{
+		Timestamp:       time.Now(),
+		Direction:       dir,
+		NetworkProtocol: protocol,
+	}
+
+	clone := trimmedClone(pkt)
+	defer clone.DecRef()
+	s.Size = clone.Size()
+
+	var fragmentOffset uint16
+	switch protocol {
+	case header.IPv4ProtocolNumber:
+		if ok := parse.IPv4(clone); !ok {
+			return PacketSummary{}, false
+		}
+		ipv4 := header.IPv4(clone.NetworkHeader().Slice())
+		s.Src = ipv4.SourceAddress()
+		s.Dst = ipv4.DestinationAddress()
+		s.TransportProtocol = tcpip.TransportProtocolNumber(ipv4.Protocol())
+		fragmentOffset = ipv4.FragmentOffset()
+
+	case header.IPv6ProtocolNumber:
+		proto, _, fragOffset, _, ok := parse.IPv6(clone)
+		if !ok {
+			return PacketSummary{}, false
+		}
+		ipv6 := header.IPv6(clone.NetworkHeader().Slice())
+		s.Src = ipv6.SourceAddress()
+		s.Dst = ipv6.DestinationAddress()
+		s.TransportProtocol = proto
+		fragmentOffset = fragOffset
+
+	case header.ARPProtocolNumber:
+		if !parse.ARP(clone) {
+			return PacketSummary{}, false
+		}
+		arp := header.ARP(clone.NetworkHeader().Slice())
+		s.Src = tcpip.AddrFromSlice(arp.ProtocolAddressSender())
+		s.Dst = tcpip.AddrFromSlice(arp.ProtocolAddressTarget())
+		return s, true
+
+	default:
+		return s, true
+	}
+
+	if fragmentOffset != 0 {
+		return s, true
+	}
+
+	switch s.TransportProtocol {
+	case header.UDPProtocolNumber:
+		if ok := parse.UDP(clone); !ok {
+			break
+		}
+		udp := header.UDP(clone.TransportHeader().Slice())
+		s.SrcPort = udp.SourcePort()
+		s.DstPort = udp.DestinationPort()
+
+	case header.TCPProtocolNumber:
+		if ok := parse.TCP(clone); !ok {
+			break
+		}
+		tcp := header.TCP(clone.TransportHeader().Slice())
+		s.SrcPort = tcp.SourcePort()
+		s.DstPort = tcp.DestinationPort()
+		s.TCPFlags = tcp.Flags()
+	}
+
+	return s, true
+}
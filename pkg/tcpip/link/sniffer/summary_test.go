@@ -0,0 +1,143 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package sniffer_test
+
+import (
+	"os"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/refs"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/link/sniffer"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	nicID      = 1
+	remotePort = 80
+)
+
+var (
+	localAddr  = tcpip.AddrFrom4([4]byte{10, 0, 0, 1})
+	remoteAddr = tcpip.AddrFrom4([4]byte{10, 0, 0, 2})
+)
+
+func TestSummaryTCPSyn(t *testing.T) {
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol},
+	})
+	defer s.Destroy()
+	e := channel.New(1, header.IPv4MinimumMTU, "")
+	defer e.Close()
+	sniff := sniffer.NewWithSummaries(e)
+	if err := s.CreateNIC(nicID, sniff); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+	protocolAddr := tcpip.ProtocolAddress{
+		Protocol:          ipv4.ProtocolNumber,
+		AddressWithPrefix: localAddr.WithPrefix(),
+	}
+	if err := s.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+		t.Fatalf("s.AddProtocolAddress(%d, %+v, {}): %s", nicID, protocolAddr, err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicID}})
+
+	var summaries []sniffer.PacketSummary
+	sniff.Subscribe(func(s sniffer.PacketSummary) {
+		summaries = append(summaries, s)
+	})
+
+	var wq waiter.Queue
+	ep, err := s.NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if err != nil {
+		t.Fatalf("s.NewEndpoint(%d, %d, _): %s", tcp.ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	defer ep.Close()
+	to := tcpip.FullAddress{Addr: remoteAddr, Port: remotePort}
+	if err := ep.Connect(to); err != nil {
+		if _, ok := err.(*tcpip.ErrConnectStarted); !ok {
+			t.Fatalf("got ep.Connect(%+v) = %s, want = %s", to, err, &tcpip.ErrConnectStarted{})
+		}
+	}
+	pkt := e.Read()
+	if pkt == nil {
+		t.Fatal("expected a SYN to be written")
+	}
+	size := pkt.Size()
+	pkt.DecRef()
+
+	if got := len(summaries); got != 1 {
+		t.Fatalf("got len(summaries) = %d, want = 1", got)
+	}
+	got := summaries[0]
+	if got.Timestamp.IsZero() {
+		t.Error("got summary with zero timestamp")
+	}
+	if got.Direction != sniffer.DirectionSend {
+		t.Errorf("got Direction = %d, want = %d", got.Direction, sniffer.DirectionSend)
+	}
+	if got.NetworkProtocol != ipv4.ProtocolNumber {
+		t.Errorf("got NetworkProtocol = %d, want = %d", got.NetworkProtocol, ipv4.ProtocolNumber)
+	}
+	if got.TransportProtocol != tcp.ProtocolNumber {
+		t.Errorf("got TransportProtocol = %d, want = %d", got.TransportProtocol, tcp.ProtocolNumber)
+	}
+	if got.Src != localAddr || got.Dst != remoteAddr {
+		t.Errorf("got (Src, Dst) = (%s, %s), want = (%s, %s)", got.Src, got.Dst, localAddr, remoteAddr)
+	}
+	if got.SrcPort == 0 || got.DstPort != remotePort {
+		t.Errorf("got (SrcPort, DstPort) = (%d, %d), want = (non-zero, %d)", got.SrcPort, got.DstPort, remotePort)
+	}
+	if got.Size != size {
+		t.Errorf("got Size = %d, want = %d", got.Size, size)
+	}
+	if got.TCPFlags != header.TCPFlagSyn {
+		t.Errorf("got TCPFlags = %s, want = %s", got.TCPFlags, header.TCPFlagSyn)
+	}
+
+	// No summaries are reported once the subscriber is removed.
+	sniff.Subscribe(nil)
+	ep2, err := s.NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if err != nil {
+		t.Fatalf("s.NewEndpoint(%d, %d, _): %s", tcp.ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	defer ep2.Close()
+	if err := ep2.Connect(to); err != nil {
+		if _, ok := err.(*tcpip.ErrConnectStarted); !ok {
+			t.Fatalf("got ep2.Connect(%+v) = %s, want = %s", to, err, &tcpip.ErrConnectStarted{})
+		}
+	}
+	pkt = e.Read()
+	if pkt == nil {
+		t.Fatal("expected a SYN to be written")
+	}
+	pkt.DecRef()
+	if got := len(summaries); got != 1 {
+		t.Errorf("got len(summaries) = %d after unsubscribing, want = 1", got)
+	}
+}
+
+func TestMain(m *testing.M) {
+	refs.SetLeakMode(refs.LeaksPanic)
+	code := m.Run()
+	refs.DoLeakCheck()
+	os.Exit(code)
+}
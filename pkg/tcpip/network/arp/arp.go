@@ -36,6 +36,7 @@ const (
 )
 
 var _ stack.DuplicateAddressDetector = (*endpoint)(nil)
+var _ stack.AddressAnnouncer = (*endpoint)(nil)
 var _ stack.LinkAddressResolver = (*endpoint)(nil)
 var _ ip.DADProtocol = (*endpoint)(nil)
 
@@ -85,6 +86,14 @@ func (e *endpoint) SendDADMessage(addr tcpip.Address, _ []byte) tcpip.Error {
 	return e.sendARPRequest(header.IPv4Any, addr, header.EthernetBroadcastAddress)
 }
 
+// AnnounceAddress implements stack.AddressAnnouncer.
+//
+// The announcement is a gratuitous ARP request where both the sender and
+// target protocol addresses are addr, as per RFC 5227 section 2.3.
+func (e *endpoint) AnnounceAddress(addr tcpip.Address) tcpip.Error {
+	return e.sendARPRequest(addr, addr, header.EthernetBroadcastAddress)
+}
+
 func (e *endpoint) Enable() tcpip.Error {
 	if !e.nic.Enabled() {
 		return &tcpip.ErrNotPermitted{}
@@ -18,6 +18,7 @@ import (
 	"fmt"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"github.com/google/go-cmp/cmp/cmpopts"
@@ -804,6 +805,223 @@ func TestDADARPRequestPacket(t *testing.T) {
 	}
 }
 
+// addressConflictEvent is an address conflict detection result reported to an
+// addressConflictDispatcher.
+type addressConflictEvent struct {
+	nicID tcpip.NICID
+	addr  tcpip.Address
+	res   stack.DADResult
+}
+
+type addressConflictDispatcher struct {
+	events []addressConflictEvent
+}
+
+var _ stack.AddressConflictDispatcher = (*addressConflictDispatcher)(nil)
+
+// OnAddressConflictDetectionResult implements
+// stack.AddressConflictDispatcher.
+func (d *addressConflictDispatcher) OnAddressConflictDetectionResult(nicID tcpip.NICID, addr tcpip.Address, res stack.DADResult) {
+	d.events = append(d.events, addressConflictEvent{nicID: nicID, addr: addr, res: res})
+}
+
+func TestIPv4AddressConflictDetection(t *testing.T) {
+	const retransmitTimer = time.Second
+
+	checkARPRequest := func(t *testing.T, e *channel.Endpoint, senderAddr, targetAddr tcpip.Address) {
+		t.Helper()
+
+		pkt := e.Read()
+		if pkt == nil {
+			t.Fatal("expected to send an ARP request")
+		}
+		if pkt.EgressRoute.RemoteLinkAddress != header.EthernetBroadcastAddress {
+			t.Errorf("got pkt.EgressRoute.RemoteLinkAddress = %s, want = %s", pkt.EgressRoute.RemoteLinkAddress, header.EthernetBroadcastAddress)
+		}
+		payload := stack.PayloadSince(pkt.NetworkHeader())
+		defer payload.Release()
+		req := header.ARP(payload.AsSlice())
+		pkt.DecRef()
+		if !req.IsValid() {
+			t.Fatalf("got req.IsValid() = false, want = true")
+		}
+		if got := req.Op(); got != header.ARPRequest {
+			t.Errorf("got req.Op() = %d, want = %d", got, header.ARPRequest)
+		}
+		if got := tcpip.LinkAddress(req.HardwareAddressSender()); got != stackLinkAddr {
+			t.Errorf("got req.HardwareAddressSender() = %s, want = %s", got, stackLinkAddr)
+		}
+		if got := tcpip.AddrFromSlice(req.ProtocolAddressSender()); got != senderAddr {
+			t.Errorf("got req.ProtocolAddressSender() = %s, want = %s", got, senderAddr)
+		}
+		if got := tcpip.AddrFromSlice(req.ProtocolAddressTarget()); got != targetAddr {
+			t.Errorf("got req.ProtocolAddressTarget() = %s, want = %s", got, targetAddr)
+		}
+	}
+
+	checkAssigned := func(t *testing.T, s *stack.Stack, want bool) {
+		t.Helper()
+
+		addr, err := s.GetMainNICAddress(nicID, ipv4.ProtocolNumber)
+		if err != nil {
+			t.Fatalf("s.GetMainNICAddress(%d, %d): %s", nicID, ipv4.ProtocolNumber, err)
+		}
+		if got := addr.Address == stackAddr; got != want {
+			t.Errorf("got (s.GetMainNICAddress(%d, %d) == %s) = %t, want = %t", nicID, ipv4.ProtocolNumber, stackAddr, got, want)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		enabled      bool
+		addDisabled  bool
+		conflict     bool
+		wantProbe    bool
+		wantAnnounce bool
+		wantAssigned bool
+		wantEvents   []addressConflictEvent
+	}{
+		{
+			name:         "disabled",
+			enabled:      false,
+			wantAssigned: true,
+		},
+		{
+			name:         "no conflict",
+			enabled:      true,
+			wantProbe:    true,
+			wantAnnounce: true,
+			wantAssigned: true,
+			wantEvents: []addressConflictEvent{
+				{nicID: nicID, addr: stackAddr, res: &stack.DADSucceeded{}},
+			},
+		},
+		{
+			name:         "conflict",
+			enabled:      true,
+			conflict:     true,
+			wantProbe:    true,
+			wantAssigned: false,
+			wantEvents: []addressConflictEvent{
+				{nicID: nicID, addr: stackAddr, res: &stack.DADDupAddrDetected{HolderLinkAddress: remoteLinkAddr}},
+			},
+		},
+		{
+			name:         "added while NIC disabled",
+			enabled:      true,
+			addDisabled:  true,
+			wantProbe:    true,
+			wantAnnounce: true,
+			wantAssigned: true,
+			wantEvents: []addressConflictEvent{
+				{nicID: nicID, addr: stackAddr, res: &stack.DADSucceeded{}},
+			},
+		},
+		{
+			name:         "conflict when added while NIC disabled",
+			enabled:      true,
+			addDisabled:  true,
+			conflict:     true,
+			wantProbe:    true,
+			wantAssigned: false,
+			wantEvents: []addressConflictEvent{
+				{nicID: nicID, addr: stackAddr, res: &stack.DADDupAddrDetected{HolderLinkAddress: remoteLinkAddr}},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := faketime.NewManualClock()
+			var disp addressConflictDispatcher
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{arp.NewProtocolWithOptions(arp.Options{
+					DADConfigs: stack.DADConfigurations{
+						DupAddrDetectTransmits: 1,
+						RetransmitTimer:        retransmitTimer,
+					},
+				}), ipv4.NewProtocol},
+				Clock:                        clock,
+				IPv4AddressConflictDetection: test.enabled,
+				AddressConflictDisp:          &disp,
+			})
+			defer func() {
+				s.Close()
+				s.Wait()
+			}()
+			e := channel.New(2, header.IPv4MinimumMTU, stackLinkAddr)
+			defer e.Close()
+			e.LinkEPCapabilities |= stack.CapabilityResolutionRequired
+			if err := s.CreateNICWithOptions(nicID, e, stack.NICOptions{Disabled: test.addDisabled}); err != nil {
+				t.Fatalf("s.CreateNICWithOptions(%d, _, _): %s", nicID, err)
+			}
+
+			// Ignore any packets sent when the NIC was enabled.
+			e.Drain()
+
+			protocolAddr := tcpip.ProtocolAddress{
+				Protocol:          ipv4.ProtocolNumber,
+				AddressWithPrefix: stackAddr.WithPrefix(),
+			}
+			if err := s.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+				t.Fatalf("s.AddProtocolAddress(%d, %+v, {}): %s", nicID, protocolAddr, err)
+			}
+
+			if test.addDisabled {
+				// Nothing is probed for while the NIC is disabled.
+				clock.Advance(retransmitTimer)
+				if got := e.Drain(); got != 0 {
+					t.Errorf("got e.Drain() = %d, want = 0", got)
+				}
+				if err := s.EnableNIC(nicID); err != nil {
+					t.Fatalf("s.EnableNIC(%d): %s", nicID, err)
+				}
+			}
+
+			clock.RunImmediatelyScheduledJobs()
+			if test.wantProbe {
+				checkARPRequest(t, e, header.IPv4Any, stackAddr)
+			}
+			// Drop any other packets sent when the NIC was enabled.
+			e.Drain()
+
+			if test.enabled {
+				// The address must not be used while it is tentative.
+				checkAssigned(t, s, false)
+			}
+
+			if test.conflict {
+				v := make([]byte, header.ARPSize)
+				h := header.ARP(v)
+				h.SetIPv4OverEthernet()
+				h.SetOp(header.ARPReply)
+				copy(h.HardwareAddressSender(), remoteLinkAddr)
+				copy(h.ProtocolAddressSender(), stackAddr.AsSlice())
+				copy(h.HardwareAddressTarget(), stackLinkAddr)
+				copy(h.ProtocolAddressTarget(), header.IPv4Any.AsSlice())
+				pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+					Payload: buffer.MakeWithData(v),
+				})
+				e.InjectInbound(arp.ProtocolNumber, pkt)
+				pkt.DecRef()
+			}
+
+			clock.Advance(retransmitTimer)
+			if test.wantAnnounce {
+				checkARPRequest(t, e, stackAddr, stackAddr)
+			}
+			if got := e.Drain(); got != 0 {
+				t.Errorf("got e.Drain() = %d, want = 0", got)
+			}
+
+			checkAssigned(t, s, test.wantAssigned)
+			if diff := cmp.Diff(test.wantEvents, disp.events, cmp.AllowUnexported(addressConflictEvent{})); diff != "" {
+				t.Errorf("address conflict events mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
 func TestMain(m *testing.M) {
 	refs.SetLeakMode(refs.LeaksPanic)
 	code := m.Run()
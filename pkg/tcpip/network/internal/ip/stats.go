@@ -138,6 +138,25 @@ type MultiCounterIPStats struct {
 	// dropped due to the IP packet header failing validation checks.
 	MalformedPacketsReceived tcpip.MultiCounterStat
 
+	// TruncatedPacketsReceived is the number of IP packets that were
+	// dropped because they were shorter than the IP header or than the
+	// length the header specifies.
+	TruncatedPacketsReceived tcpip.MultiCounterStat
+
+	// BadVersionPacketsReceived is the number of IP packets that were
+	// dropped because their version field did not match the network
+	// protocol.
+	BadVersionPacketsReceived tcpip.MultiCounterStat
+
+	// BadLengthPacketsReceived is the number of IPv4 packets that were
+	// dropped because their header length was less than the minimum or
+	// greater than their total length.
+	BadLengthPacketsReceived tcpip.MultiCounterStat
+
+	// BadChecksumPacketsReceived is the number of IPv4 packets that were
+	// dropped because their header checksum was invalid.
+	BadChecksumPacketsReceived tcpip.MultiCounterStat
+
 	// MalformedFragmentsReceived is the number of IP Fragments that were
 	// dropped due to the fragment failing validation checks.
 	MalformedFragmentsReceived tcpip.MultiCounterStat
@@ -194,6 +213,10 @@ func (m *MultiCounterIPStats) Init(a, b *tcpip.IPStats) {
 	m.PacketsSent.Init(a.PacketsSent, b.PacketsSent)
 	m.OutgoingPacketErrors.Init(a.OutgoingPacketErrors, b.OutgoingPacketErrors)
 	m.MalformedPacketsReceived.Init(a.MalformedPacketsReceived, b.MalformedPacketsReceived)
+	m.TruncatedPacketsReceived.Init(a.TruncatedPacketsReceived, b.TruncatedPacketsReceived)
+	m.BadVersionPacketsReceived.Init(a.BadVersionPacketsReceived, b.BadVersionPacketsReceived)
+	m.BadLengthPacketsReceived.Init(a.BadLengthPacketsReceived, b.BadLengthPacketsReceived)
+	m.BadChecksumPacketsReceived.Init(a.BadChecksumPacketsReceived, b.BadChecksumPacketsReceived)
 	m.MalformedFragmentsReceived.Init(a.MalformedFragmentsReceived, b.MalformedFragmentsReceived)
 	m.IPTablesPreroutingDropped.Init(a.IPTablesPreroutingDropped, b.IPTablesPreroutingDropped)
 	m.IPTablesInputDropped.Init(a.IPTablesInputDropped, b.IPTablesInputDropped)
@@ -227,6 +227,31 @@ func (*icmpv4FragmentationNeededSockError) Kind() stack.TransportErrorKind {
 	return stack.PacketTooBigTransportError
 }
 
+// mtuPlateaus holds the common MTU values along Internet paths, as listed in
+// RFC 1191 section 7, in decreasing order.
+var mtuPlateaus = [...]uint16{32000, 17914, 8166, 4352, 2002, 1492, 1006, 508, 296, header.IPv4MinimumMTU}
+
+// estimatePathMTU estimates the next-hop MTU for a Fragmentation Needed
+// message sent by a router that predates RFC 1191 and leaves the Next-Hop MTU
+// field zero.
+//
+// As suggested by RFC 1191 section 7, the estimate is the largest plateau that
+// is smaller than the Total Length of the original datagram, which is held in
+// pkt's payload.
+func estimatePathMTU(pkt *stack.PacketBuffer) uint16 {
+	h, ok := pkt.Data().PullUp(header.IPv4MinimumSize)
+	if !ok {
+		return 0
+	}
+	totalLen := header.IPv4(h).TotalLength()
+	for _, plateau := range mtuPlateaus {
+		if plateau < totalLen {
+			return plateau
+		}
+	}
+	return header.IPv4MinimumMTU
+}
+
 func (e *endpoint) checkLocalAddress(addr tcpip.Address) bool {
 	if e.nic.Spoofing() {
 		return true
@@ -481,6 +506,9 @@ func (e *endpoint) handleICMP(pkt *stack.PacketBuffer) {
 		case header.ICMPv4PortUnreachable:
 			e.handleControl(&icmpv4DestinationPortUnreachableSockError{}, pkt)
 		case header.ICMPv4FragmentationNeeded:
+			if mtu == 0 {
+				mtu = estimatePathMTU(pkt)
+			}
 			networkMTU, err := calculateNetworkMTU(uint32(mtu), header.IPv4MinimumSize)
 			if err != nil {
 				networkMTU = 0
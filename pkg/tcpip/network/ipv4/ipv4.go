@@ -489,12 +489,13 @@ func (e *endpoint) handleFragments(_ *stack.Route, networkMTU uint32, pkt *stack
 	// Round the MTU down to align to 8 bytes.
 	fragmentPayloadSize := networkMTU &^ 7
 	networkHeader := header.IPv4(pkt.NetworkHeader().Slice())
+	nonFirstHeader := nonFirstFragmentHeader(networkHeader)
 	pf := fragmentation.MakePacketFragmenter(pkt, fragmentPayloadSize, pkt.AvailableHeaderBytes()+len(networkHeader))
 	defer pf.Release()
 
 	var n int
 	for {
-		fragPkt, more := buildNextFragment(&pf, networkHeader)
+		fragPkt, more := buildNextFragment(&pf, networkHeader, nonFirstHeader)
 		err := handler(fragPkt)
 		fragPkt.DecRef()
 		if err != nil {
@@ -578,9 +579,10 @@ func (e *endpoint) writePacketPostRouting(r *stack.Route, pkt *stack.PacketBuffe
 
 	if packetMustBeFragmented(pkt, networkMTU) {
 		h := header.IPv4(pkt.NetworkHeader().Slice())
-		if h.Flags()&header.IPv4FlagDontFragment != 0 && pkt.NetworkPacketInfo.IsForwardedPacket {
-			// TODO(gvisor.dev/issue/5919): Handle error condition in which DontFragment
-			// is set but the packet must be fragmented for the non-forwarding case.
+		if h.Flags()&header.IPv4FlagDontFragment != 0 {
+			// Forwarded packets are answered with an ICMP Fragmentation Needed
+			// error by the caller, locally generated ones are reported to the
+			// sender.
 			return &tcpip.ErrMessageTooLong{}
 		}
 		sent, remain, err := e.handleFragments(r, networkMTU, pkt, func(fragPkt *stack.PacketBuffer) tcpip.Error {
@@ -824,7 +826,7 @@ func (e *endpoint) HandlePacket(pkt *stack.PacketBuffer) {
 		return
 	}
 
-	hView, ok := e.protocol.parseAndValidate(pkt)
+	hView, ok := e.protocol.parseAndValidate(pkt, stats)
 	if !ok {
 		stats.MalformedPacketsReceived.Increment()
 		return
@@ -881,7 +883,7 @@ func (e *endpoint) handleLocalPacket(pkt *stack.PacketBuffer, canSkipRXChecksum
 	defer pkt.DecRef()
 	pkt.RXChecksumValidated = canSkipRXChecksum
 
-	hView, ok := e.protocol.parseAndValidate(pkt)
+	hView, ok := e.protocol.parseAndValidate(pkt, stats)
 	if !ok {
 		stats.MalformedPacketsReceived.Increment()
 		return
@@ -1365,7 +1367,13 @@ func (e *endpoint) AddAndAcquirePermanentAddress(addr tcpip.AddressWithPrefix, p
 	e.mu.Lock()
 	defer e.mu.Unlock()
 
-	ep, err := e.addressableEndpointState.AddAndAcquireAddress(addr, properties, stack.Permanent)
+	kind := stack.Permanent
+	if e.protocol.stack.IPv4AddressConflictDetection() && e.nic.Capabilities()&stack.CapabilityResolutionRequired != 0 {
+		// The NIC probes for the address before it is assigned, as per RFC
+		// 5227.
+		kind = stack.PermanentTentative
+	}
+	ep, err := e.addressableEndpointState.AddAndAcquireAddress(addr, properties, kind)
 	if err == nil {
 		e.sendQueuedReports()
 	}
@@ -1772,21 +1780,48 @@ func (p *protocol) isSubnetLocalBroadcastAddress(addr tcpip.Address) bool {
 // parseAndValidate parses the packet (including its transport layer header) and
 // returns the parsed IP header.
 //
-// Returns true if the IP header was successfully parsed.
-func (p *protocol) parseAndValidate(pkt *stack.PacketBuffer) (*buffer.View, bool) {
+// Returns true if the IP header was successfully parsed. Otherwise, the counter
+// in stats for the first validation check the header failed is incremented.
+func (p *protocol) parseAndValidate(pkt *stack.PacketBuffer, stats ip.MultiCounterIPStats) (*buffer.View, bool) {
+	// Do not include the link header's size when calculating the size of the IP
+	// packet.
+	pktSize := pkt.Size() - len(pkt.LinkHeader().Slice())
+
+	// Check the fields Parse relies on before parsing so that each reason for
+	// dropping the packet is counted.
+	hdr, ok := pkt.Data().PullUp(header.IPv4MinimumSize)
+	if !ok {
+		stats.TruncatedPacketsReceived.Increment()
+		return nil, false
+	}
+	h := header.IPv4(hdr)
+	if header.IPVersion(h) != header.IPv4Version {
+		stats.BadVersionPacketsReceived.Increment()
+		return nil, false
+	}
+	hlen := int(h.HeaderLength())
+	tlen := int(h.TotalLength())
+	if hlen < header.IPv4MinimumSize || hlen > tlen {
+		stats.BadLengthPacketsReceived.Increment()
+		return nil, false
+	}
+	if tlen > pktSize {
+		stats.TruncatedPacketsReceived.Increment()
+		return nil, false
+	}
+
 	transProtoNum, hasTransportHdr, ok := p.Parse(pkt)
 	if !ok {
 		return nil, false
 	}
 
-	h := header.IPv4(pkt.NetworkHeader().Slice())
-	// Do not include the link header's size when calculating the size of the IP
-	// packet.
-	if !h.IsValid(pkt.Size() - len(pkt.LinkHeader().Slice())) {
+	h = header.IPv4(pkt.NetworkHeader().Slice())
+	if !h.IsValid(pktSize) {
 		return nil, false
 	}
 
 	if !pkt.RXChecksumValidated && !h.IsChecksumValid() {
+		stats.BadChecksumPacketsReceived.Increment()
 		return nil, false
 	}
 
@@ -1918,6 +1953,21 @@ type Options struct {
 	// AllowExternalLoopbackTraffic indicates that inbound loopback packets (i.e.
 	// martian loopback packets) should be accepted.
 	AllowExternalLoopbackTraffic bool
+
+	// ReassembleTimeout is the maximum time allowed to reassemble a fragmented
+	// packet. If zero, ReassembleTimeout is used.
+	ReassembleTimeout time.Duration
+
+	// FragmentHighThreshold is the amount of memory, in bytes, held by
+	// incomplete packets at which the oldest ones start being evicted. If
+	// zero, 4MB is used (the Linux default, see net.ipv4.ipfrag_high_thresh).
+	FragmentHighThreshold int
+
+	// FragmentLowThreshold is the amount of memory, in bytes, the evictions
+	// triggered by FragmentHighThreshold bring usage down to. It is capped at
+	// FragmentHighThreshold. If zero, 3MB is used (the Linux default, see
+	// net.ipv4.ipfrag_low_thresh).
+	FragmentLowThreshold int
 }
 
 // NewProtocolWithOptions returns an IPv4 network protocol.
@@ -1939,7 +1989,19 @@ func NewProtocolWithOptions(opts Options) stack.NetworkProtocolFactory {
 			defaultTTL: atomicbitops.FromUint32(DefaultTTL),
 			options:    opts,
 		}
-		p.fragmentation = fragmentation.NewFragmentation(fragmentblockSize, fragmentation.HighFragThreshold, fragmentation.LowFragThreshold, ReassembleTimeout, s.Clock(), p)
+		reassembleTimeout := opts.ReassembleTimeout
+		if reassembleTimeout == 0 {
+			reassembleTimeout = ReassembleTimeout
+		}
+		highThreshold := opts.FragmentHighThreshold
+		if highThreshold == 0 {
+			highThreshold = fragmentation.HighFragThreshold
+		}
+		lowThreshold := opts.FragmentLowThreshold
+		if lowThreshold == 0 {
+			lowThreshold = fragmentation.LowFragThreshold
+		}
+		p.fragmentation = fragmentation.NewFragmentation(fragmentblockSize, highThreshold, lowThreshold, reassembleTimeout, s.Clock(), p)
 		p.eps = make(map[tcpip.NICID]*endpoint)
 		// Set ICMP rate limiting to Linux defaults.
 		// See https://man7.org/linux/man-pages/man7/icmp.7.html.
@@ -1961,13 +2023,55 @@ func NewProtocol(s *stack.Stack) stack.NetworkProtocol {
 	return NewProtocolWithOptions(Options{})(s)
 }
 
-func buildNextFragment(pf *fragmentation.PacketFragmenter, originalIPHeader header.IPv4) (*stack.PacketBuffer, bool) {
+// nonFirstFragmentHeader returns the IP header to be used by all but the first
+// fragment of a packet with the IP header originalIPHeader.
+//
+// As per RFC 791 section 3.1, only the options with the copied flag set are
+// carried by every fragment, the other ones are only carried by the first
+// fragment.
+func nonFirstFragmentHeader(originalIPHeader header.IPv4) header.IPv4 {
+	options := originalIPHeader.Options()
+	if len(options) == 0 {
+		return originalIPHeader
+	}
+
+	iter := options.MakeIterator()
+	for {
+		option, done, optProblem := iter.Next()
+		if optProblem != nil {
+			// The options were already validated, keep them untouched if they still
+			// somehow fail to parse.
+			return originalIPHeader
+		}
+		if done || option.Type() == header.IPv4OptionListEndType {
+			break
+		}
+		if !option.Type().Copied() {
+			continue
+		}
+		iter.ConsumeBuffer(copy(iter.RemainingBuffer(), option.Contents()))
+	}
+	newOptions := iter.Finalize()
+
+	h := header.IPv4(make([]byte, header.IPv4MinimumSize+len(newOptions)))
+	copy(h, originalIPHeader[:header.IPv4MinimumSize])
+	copy(h[header.IPv4MinimumSize:], newOptions)
+	h.SetHeaderLength(uint8(len(h)))
+	return h
+}
+
+// buildNextFragment builds the next fragment from pf. The first fragment
+// carries firstIPHeader, the following ones carry nonFirstIPHeader.
+func buildNextFragment(pf *fragmentation.PacketFragmenter, firstIPHeader, nonFirstIPHeader header.IPv4) (*stack.PacketBuffer, bool) {
 	fragPkt, offset, copied, more := pf.BuildNextFragment()
 	fragPkt.NetworkProtocolNumber = ProtocolNumber
 
+	originalIPHeader := firstIPHeader
+	if offset != 0 {
+		originalIPHeader = nonFirstIPHeader
+	}
 	originalIPHeaderLength := len(originalIPHeader)
 	nextFragIPHeader := header.IPv4(fragPkt.NetworkHeader().Push(originalIPHeaderLength))
-	fragPkt.NetworkProtocolNumber = ProtocolNumber
 
 	if copied := copy(nextFragIPHeader, originalIPHeader); copied != len(originalIPHeader) {
 		panic(fmt.Sprintf("wrong number of bytes copied into fragmentIPHeaders: got = %d, want = %d", copied, originalIPHeaderLength))
@@ -2263,6 +2263,324 @@ func TestFragmentationErrors(t *testing.T) {
 	}
 }
 
+// TestFragmentationWithOptions checks that only the options with the copied
+// flag set are carried by fragments other than the first one, and that the
+// fragments are reassembled back into the original datagram by the receiver.
+func TestFragmentationWithOptions(t *testing.T) {
+	const (
+		ttl         = 42
+		remoteNICID = 1
+		dataSize    = 92
+	)
+
+	var (
+		// The Record Route option does not have the copied flag set.
+		recordRoute = []byte{byte(header.IPv4OptionRecordRouteType), 7, 4, 0, 0, 0, 0}
+		// The Stream Identifier option (type 136) has the copied flag set.
+		streamID = []byte{136, 4, 0x12, 0x34}
+		options  = append(append(append([]byte{}, recordRoute...), byte(header.IPv4OptionNOPType)), streamID...)
+	)
+
+	ctx := newTestContext()
+	defer ctx.cleanup()
+	ep := iptestutil.NewMockLinkEndpoint(header.IPv4MinimumMTU, nil, math.MaxInt32)
+	defer ep.Close()
+	r := buildRoute(t, ctx, ep)
+	defer r.Release()
+
+	data := make([]byte, dataSize)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	hdrLen := header.IPv4MinimumSize + len(options)
+	b := make([]byte, hdrLen+header.UDPMinimumSize+len(data))
+	ip := header.IPv4(b)
+	ip.Encode(&header.IPv4Fields{
+		TTL:      ttl,
+		Protocol: uint8(udp.ProtocolNumber),
+		SrcAddr:  r.LocalAddress(),
+		DstAddr:  r.RemoteAddress(),
+	})
+	ip.SetHeaderLength(uint8(hdrLen))
+	copy(ip.Options(), options)
+	u := header.UDP(b[hdrLen:])
+	u.Encode(&header.UDPFields{
+		SrcPort: 1234,
+		DstPort: 5678,
+		Length:  uint16(header.UDPMinimumSize + len(data)),
+	})
+	copy(u.Payload(), data)
+
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: extraHeaderReserve,
+		Payload:            buffer.MakeWithData(b),
+	})
+	err := r.WriteHeaderIncludedPacket(pkt)
+	pkt.DecRef()
+	if err != nil {
+		t.Fatalf("r.WriteHeaderIncludedPacket(_): %s", err)
+	}
+
+	// The first fragment carries 32 bytes of payload after its 32 byte header.
+	// The following fragments have a 24 byte header but still carry 32 bytes
+	// of payload as all fragments but the last are sized identically.
+	wantFragments := []struct {
+		options []byte
+		offset  uint16
+		size    int
+	}{
+		{options: options, offset: 0, size: 32},
+		{options: streamID, offset: 32, size: 32},
+		{options: streamID, offset: 64, size: 32},
+		{options: streamID, offset: 96, size: 4},
+	}
+	if got, want := len(ep.WrittenPackets), len(wantFragments); got != want {
+		t.Fatalf("got len(ep.WrittenPackets) = %d, want = %d", got, want)
+	}
+	var fragments [][]byte
+	for i, want := range wantFragments {
+		buf := ep.WrittenPackets[i].ToBuffer()
+		frag := header.IPv4(buf.Flatten())
+		buf.Release()
+		if !frag.IsValid(len(frag)) {
+			t.Fatalf("fragment #%d: IP packet is invalid:\n%s", i, hex.Dump(frag))
+		}
+		if got := frag.CalculateChecksum(); got != 0xffff {
+			t.Errorf("fragment #%d: got frag.CalculateChecksum() = %#x, want = 0xffff", i, got)
+		}
+		if diff := cmp.Diff(want.options, []byte(frag.Options())); diff != "" {
+			t.Errorf("fragment #%d: options mismatch (-want +got):\n%s", i, diff)
+		}
+		if got := frag.FragmentOffset(); got != want.offset {
+			t.Errorf("fragment #%d: got frag.FragmentOffset() = %d, want = %d", i, got, want.offset)
+		}
+		wantMore := i != len(wantFragments)-1
+		if got := frag.Flags()&header.IPv4FlagMoreFragments != 0; got != wantMore {
+			t.Errorf("fragment #%d: got more fragments flag = %t, want = %t", i, got, wantMore)
+		}
+		if got := len(frag.Payload()); got != want.size {
+			t.Errorf("fragment #%d: got len(frag.Payload()) = %d, want = %d", i, got, want.size)
+		}
+		fragments = append(fragments, frag)
+	}
+
+	// Feed the fragments in reverse order to a receiving stack and check that
+	// the original datagram is delivered.
+	remote := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+	})
+	defer func() {
+		remote.Close()
+		remote.Wait()
+	}()
+	e := channel.New(0, defaultMTU, "")
+	defer e.Close()
+	if err := remote.CreateNIC(remoteNICID, e); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", remoteNICID, err)
+	}
+	protocolAddr := tcpip.ProtocolAddress{
+		Protocol:          ipv4.ProtocolNumber,
+		AddressWithPrefix: r.RemoteAddress().WithPrefix(),
+	}
+	if err := remote.AddProtocolAddress(remoteNICID, protocolAddr, stack.AddressProperties{}); err != nil {
+		t.Fatalf("AddProtocolAddress(%d, %+v, {}): %s", remoteNICID, protocolAddr, err)
+	}
+	var wq waiter.Queue
+	udpEP, err := remote.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+	if err != nil {
+		t.Fatalf("NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+	}
+	defer udpEP.Close()
+	bindAddr := tcpip.FullAddress{Addr: r.RemoteAddress(), Port: 5678}
+	if err := udpEP.Bind(bindAddr); err != nil {
+		t.Fatalf("Bind(%+v): %s", bindAddr, err)
+	}
+
+	for i := len(fragments) - 1; i >= 0; i-- {
+		pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+			Payload: buffer.MakeWithData(fragments[i]),
+		})
+		e.InjectInbound(header.IPv4ProtocolNumber, pkt)
+		pkt.DecRef()
+	}
+
+	var got bytes.Buffer
+	if _, err := udpEP.Read(&got, tcpip.ReadOptions{}); err != nil {
+		t.Fatalf("udpEP.Read(_, {}): %s", err)
+	}
+	if diff := cmp.Diff(data, got.Bytes()); diff != "" {
+		t.Errorf("reassembled data mismatch (-want +got):\n%s", diff)
+	}
+}
+
+// TestFragmentationDontFragment checks that packets with the Don't Fragment
+// flag set are not fragmented.
+func TestFragmentationDontFragment(t *testing.T) {
+	ctx := newTestContext()
+	defer ctx.cleanup()
+	ep := iptestutil.NewMockLinkEndpoint(header.IPv4MinimumMTU, nil, math.MaxInt32)
+	defer ep.Close()
+	r := buildRoute(t, ctx, ep)
+	defer r.Release()
+
+	b := make([]byte, header.IPv4MinimumMTU+1)
+	header.IPv4(b).Encode(&header.IPv4Fields{
+		Flags:    header.IPv4FlagDontFragment,
+		TTL:      64,
+		Protocol: uint8(udp.ProtocolNumber),
+		SrcAddr:  r.LocalAddress(),
+		DstAddr:  r.RemoteAddress(),
+	})
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		ReserveHeaderBytes: extraHeaderReserve,
+		Payload:            buffer.MakeWithData(b),
+	})
+	defer pkt.DecRef()
+	if diff := cmp.Diff(&tcpip.ErrMessageTooLong{}, r.WriteHeaderIncludedPacket(pkt)); diff != "" {
+		t.Fatalf("unexpected error from r.WriteHeaderIncludedPacket(_), (-want, +got):\n%s", diff)
+	}
+	if got := len(ep.WrittenPackets); got != 0 {
+		t.Errorf("got len(ep.WrittenPackets) = %d, want = 0", got)
+	}
+}
+
+// TestMalformedHeaderStats sends packets failing each IPv4 header validation
+// check and verifies that they are dropped and counted under their reason.
+func TestMalformedHeaderStats(t *testing.T) {
+	const (
+		nicID      = 1
+		payloadLen = 8
+		pktLen     = header.IPv4MinimumSize + payloadLen
+	)
+
+	var (
+		addr1 = tcpip.AddrFromSlice([]byte("\x0a\x00\x00\x01"))
+		addr2 = tcpip.AddrFromSlice([]byte("\x0a\x00\x00\x02"))
+	)
+
+	tests := []struct {
+		name string
+		// mutate is applied to the header before its checksum is computed.
+		mutate func(header.IPv4)
+		// badChecksum corrupts the header checksum.
+		badChecksum bool
+		// size is the number of bytes of the packet to inject.
+		size       int
+		wantReason func(*tcpip.IPStats) *tcpip.StatCounter
+	}{
+		{
+			name:       "truncated header",
+			size:       header.IPv4MinimumSize - 1,
+			wantReason: func(s *tcpip.IPStats) *tcpip.StatCounter { return s.TruncatedPacketsReceived },
+		},
+		{
+			name:       "truncated payload",
+			size:       pktLen - 1,
+			wantReason: func(s *tcpip.IPStats) *tcpip.StatCounter { return s.TruncatedPacketsReceived },
+		},
+		{
+			name: "bad version",
+			mutate: func(h header.IPv4) {
+				h[0] = header.IPv6Version<<4 | h[0]&0xf
+			},
+			size:       pktLen,
+			wantReason: func(s *tcpip.IPStats) *tcpip.StatCounter { return s.BadVersionPacketsReceived },
+		},
+		{
+			name: "header length below minimum",
+			mutate: func(h header.IPv4) {
+				h.SetHeaderLength(header.IPv4MinimumSize - 4)
+			},
+			size:       pktLen,
+			wantReason: func(s *tcpip.IPStats) *tcpip.StatCounter { return s.BadLengthPacketsReceived },
+		},
+		{
+			name: "header length above total length",
+			mutate: func(h header.IPv4) {
+				h.SetHeaderLength(header.IPv4MinimumSize + 4)
+				h.SetTotalLength(header.IPv4MinimumSize)
+			},
+			size:       pktLen,
+			wantReason: func(s *tcpip.IPStats) *tcpip.StatCounter { return s.BadLengthPacketsReceived },
+		},
+		{
+			name:        "bad checksum",
+			badChecksum: true,
+			size:        pktLen,
+			wantReason:  func(s *tcpip.IPStats) *tcpip.StatCounter { return s.BadChecksumPacketsReceived },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := newTestContext()
+			defer ctx.cleanup()
+			s := ctx.s
+
+			e := channel.New(0, defaultMTU, "")
+			defer e.Close()
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+			}
+			protocolAddr := tcpip.ProtocolAddress{
+				Protocol:          ipv4.ProtocolNumber,
+				AddressWithPrefix: addr2.WithPrefix(),
+			}
+			if err := s.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+				t.Fatalf("AddProtocolAddress(%d, %+v, {}): %s", nicID, protocolAddr, err)
+			}
+
+			b := make([]byte, pktLen)
+			ip := header.IPv4(b)
+			ip.Encode(&header.IPv4Fields{
+				TotalLength: pktLen,
+				TTL:         ipv4.DefaultTTL,
+				Protocol:    uint8(header.UDPProtocolNumber),
+				SrcAddr:     addr1,
+				DstAddr:     addr2,
+			})
+			if test.mutate != nil {
+				test.mutate(ip)
+			}
+			ip.SetChecksum(^ip.CalculateChecksum())
+			if test.badChecksum {
+				ip.SetChecksum(^ip.Checksum())
+			}
+
+			pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Payload: buffer.MakeWithData(b[:test.size]),
+			})
+			e.InjectInbound(header.IPv4ProtocolNumber, pkt)
+			pkt.DecRef()
+
+			stats := s.Stats().IP
+			if got := stats.MalformedPacketsReceived.Value(); got != 1 {
+				t.Errorf("got stats.MalformedPacketsReceived.Value() = %d, want = 1", got)
+			}
+			if got := stats.PacketsDelivered.Value(); got != 0 {
+				t.Errorf("got stats.PacketsDelivered.Value() = %d, want = 0", got)
+			}
+			reasons := map[string]*tcpip.StatCounter{
+				"TruncatedPacketsReceived":   stats.TruncatedPacketsReceived,
+				"BadVersionPacketsReceived":  stats.BadVersionPacketsReceived,
+				"BadLengthPacketsReceived":   stats.BadLengthPacketsReceived,
+				"BadChecksumPacketsReceived": stats.BadChecksumPacketsReceived,
+			}
+			wantReason := test.wantReason(&stats)
+			for name, counter := range reasons {
+				var want uint64
+				if counter == wantReason {
+					want = 1
+				}
+				if got := counter.Value(); got != want {
+					t.Errorf("got stats.%s.Value() = %d, want = %d", name, got, want)
+				}
+			}
+		})
+	}
+}
+
 func TestInvalidFragments(t *testing.T) {
 	const (
 		nicID    = 1
@@ -2843,6 +3161,134 @@ func TestFragmentReassemblyTimeout(t *testing.T) {
 	}
 }
 
+func TestFragmentReassemblyOptions(t *testing.T) {
+	const (
+		nicID    = 1
+		linkAddr = tcpip.LinkAddress("\x0a\x0b\x0c\x0d\x0e\x0e")
+		ident    = 1
+		ttl      = 48
+		protocol = 99
+		timeout  = 5 * time.Second
+	)
+
+	var (
+		addr1 = tcpip.AddrFromSlice([]byte("\x0a\x00\x00\x01"))
+		addr2 = tcpip.AddrFromSlice([]byte("\x0a\x00\x00\x02"))
+	)
+
+	tests := []struct {
+		name       string
+		opts       ipv4.Options
+		advance    time.Duration
+		expectICMP bool
+	}{
+		{
+			name:       "default timeout not reached",
+			advance:    ipv4.ReassembleTimeout - time.Nanosecond,
+			expectICMP: false,
+		},
+		{
+			name:       "custom timeout not reached",
+			opts:       ipv4.Options{ReassembleTimeout: timeout},
+			advance:    timeout - time.Nanosecond,
+			expectICMP: false,
+		},
+		{
+			name:       "custom timeout reached",
+			opts:       ipv4.Options{ReassembleTimeout: timeout},
+			advance:    timeout,
+			expectICMP: true,
+		},
+		{
+			name: "memory limit exceeded",
+			opts: ipv4.Options{
+				ReassembleTimeout:     timeout,
+				FragmentHighThreshold: 1,
+			},
+			advance:    timeout,
+			expectICMP: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := faketime.NewManualClock()
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocolWithOptions(test.opts)},
+				Clock:            clock,
+			})
+			defer func() {
+				s.Close()
+				s.Wait()
+				refs.DoRepeatedLeakCheck()
+			}()
+
+			e := channel.New(1, 1500, linkAddr)
+			defer e.Close()
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+			}
+			protocolAddr := tcpip.ProtocolAddress{
+				Protocol:          ipv4.ProtocolNumber,
+				AddressWithPrefix: addr2.WithPrefix(),
+			}
+			if err := s.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+				t.Fatalf("AddProtocolAddress(%d, %+v, {}): %s", nicID, protocolAddr, err)
+			}
+			s.SetRouteTable([]tcpip.Route{{
+				Destination: header.IPv4EmptySubnet,
+				NIC:         nicID,
+			}})
+
+			// Only send the first fragment so that reassembly never completes.
+			payload := []byte("TEST_FRAGMENT_RE")
+			hdr := prependable.New(header.IPv4MinimumSize)
+			ip := header.IPv4(hdr.Prepend(header.IPv4MinimumSize))
+			ip.Encode(&header.IPv4Fields{
+				TotalLength: uint16(header.IPv4MinimumSize + len(payload)),
+				ID:          ident,
+				Flags:       header.IPv4FlagMoreFragments,
+				TTL:         ttl,
+				Protocol:    protocol,
+				SrcAddr:     addr1,
+				DstAddr:     addr2,
+			})
+			ip.SetChecksum(^ip.CalculateChecksum())
+			buf := buffer.MakeWithData(hdr.View())
+			buf.Append(buffer.NewViewWithData(payload))
+			pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Payload: buf,
+			})
+			e.InjectInbound(header.IPv4ProtocolNumber, pkt)
+			pkt.DecRef()
+
+			clock.Advance(test.advance)
+
+			reply := e.Read()
+			if !test.expectICMP {
+				if reply != nil {
+					t.Fatalf("unexpected ICMP error message received: %#v", reply)
+				}
+				return
+			}
+			if reply == nil {
+				t.Fatal("expected ICMP error message missing")
+			}
+			defer reply.DecRef()
+			replyPayload := stack.PayloadSince(reply.NetworkHeader())
+			defer replyPayload.Release()
+			checker.IPv4(t, replyPayload,
+				checker.SrcAddr(addr2),
+				checker.DstAddr(addr1),
+				checker.ICMPv4(
+					checker.ICMPv4Type(header.ICMPv4TimeExceeded),
+					checker.ICMPv4Code(header.ICMPv4ReassemblyTimeout),
+				),
+			)
+		})
+	}
+}
+
 // TestReceiveFragments feeds fragments in through the incoming packet path to
 // test reassembly
 func TestReceiveFragments(t *testing.T) {
@@ -1079,7 +1079,7 @@ func (e *endpoint) HandlePacket(pkt *stack.PacketBuffer) {
 		return
 	}
 
-	hView, ok := e.protocol.parseAndValidate(pkt)
+	hView, ok := e.protocol.parseAndValidate(pkt, stats)
 	if !ok {
 		stats.MalformedPacketsReceived.Increment()
 		return
@@ -1140,7 +1140,7 @@ func (e *endpoint) handleLocalPacket(pkt *stack.PacketBuffer, canSkipRXChecksum
 	defer pkt.DecRef()
 	pkt.RXChecksumValidated = canSkipRXChecksum
 
-	hView, ok := e.protocol.parseAndValidate(pkt)
+	hView, ok := e.protocol.parseAndValidate(pkt, stats)
 	if !ok {
 		stats.MalformedPacketsReceived.Increment()
 		return
@@ -2587,17 +2587,37 @@ func (*protocol) Wait() {}
 // returns a view containing the parsed IP header. The caller is responsible
 // for releasing the returned View.
 //
-// Returns true if the IP header was successfully parsed.
-func (p *protocol) parseAndValidate(pkt *stack.PacketBuffer) (*buffer.View, bool) {
+// Returns true if the IP header was successfully parsed. Otherwise, the counter
+// in stats for the first validation check the header failed is incremented.
+func (p *protocol) parseAndValidate(pkt *stack.PacketBuffer, stats ip.MultiCounterIPStats) (*buffer.View, bool) {
+	// Do not include the link header's size when calculating the size of the IP
+	// packet.
+	pktSize := pkt.Size() - len(pkt.LinkHeader().Slice())
+
+	// Check the fixed header before parsing so that each reason for dropping
+	// the packet is counted.
+	hdr, ok := pkt.Data().PullUp(header.IPv6MinimumSize)
+	if !ok {
+		stats.TruncatedPacketsReceived.Increment()
+		return nil, false
+	}
+	h := header.IPv6(hdr)
+	if header.IPVersion(h) != header.IPv6Version {
+		stats.BadVersionPacketsReceived.Increment()
+		return nil, false
+	}
+	if int(h.PayloadLength()) > pktSize-header.IPv6MinimumSize {
+		stats.TruncatedPacketsReceived.Increment()
+		return nil, false
+	}
+
 	transProtoNum, hasTransportHdr, ok := p.Parse(pkt)
 	if !ok {
 		return nil, false
 	}
 
-	h := header.IPv6(pkt.NetworkHeader().Slice())
-	// Do not include the link header's size when calculating the size of the IP
-	// packet.
-	if !h.IsValid(pkt.Size() - len(pkt.LinkHeader().Slice())) {
+	h = header.IPv6(pkt.NetworkHeader().Slice())
+	if !h.IsValid(pktSize) {
 		return nil, false
 	}
 
@@ -2739,6 +2759,21 @@ type Options struct {
 	// AllowExternalLoopbackTraffic indicates that inbound loopback packets (i.e.
 	// martian loopback packets) should be accepted.
 	AllowExternalLoopbackTraffic bool
+
+	// ReassembleTimeout is the maximum time allowed to reassemble a fragmented
+	// packet. If zero, ReassembleTimeout is used.
+	ReassembleTimeout time.Duration
+
+	// FragmentHighThreshold is the amount of memory, in bytes, held by
+	// incomplete packets at which the oldest ones start being evicted. If
+	// zero, 4MB is used (the Linux default, see net.ipv6.ip6frag_high_thresh).
+	FragmentHighThreshold int
+
+	// FragmentLowThreshold is the amount of memory, in bytes, the evictions
+	// triggered by FragmentHighThreshold bring usage down to. It is capped at
+	// FragmentHighThreshold. If zero, 3MB is used (the Linux default, see
+	// net.ipv6.ip6frag_low_thresh).
+	FragmentLowThreshold int
 }
 
 // NewProtocolWithOptions returns an IPv6 network protocol.
@@ -2750,7 +2785,19 @@ func NewProtocolWithOptions(opts Options) stack.NetworkProtocolFactory {
 			stack:   s,
 			options: opts,
 		}
-		p.fragmentation = fragmentation.NewFragmentation(header.IPv6FragmentExtHdrFragmentOffsetBytesPerUnit, fragmentation.HighFragThreshold, fragmentation.LowFragThreshold, ReassembleTimeout, s.Clock(), p)
+		reassembleTimeout := opts.ReassembleTimeout
+		if reassembleTimeout == 0 {
+			reassembleTimeout = ReassembleTimeout
+		}
+		highThreshold := opts.FragmentHighThreshold
+		if highThreshold == 0 {
+			highThreshold = fragmentation.HighFragThreshold
+		}
+		lowThreshold := opts.FragmentLowThreshold
+		if lowThreshold == 0 {
+			lowThreshold = fragmentation.LowFragThreshold
+		}
+		p.fragmentation = fragmentation.NewFragmentation(header.IPv6FragmentExtHdrFragmentOffsetBytesPerUnit, highThreshold, lowThreshold, reassembleTimeout, s.Clock(), p)
 		p.mu.eps = make(map[tcpip.NICID]*endpoint)
 		p.SetDefaultTTL(DefaultTTL)
 		// Set default ICMP rate limiting to Linux defaults.
@@ -23,6 +23,7 @@ import (
 	"net"
 	"reflect"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"gvisor.dev/gvisor/pkg/buffer"
@@ -30,6 +31,7 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/checker"
 	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
 	iptestutil "gvisor.dev/gvisor/pkg/tcpip/network/internal/testutil"
@@ -1249,6 +1251,50 @@ func TestReceiveIPv6Fragments(t *testing.T) {
 			},
 			expectedPayloads: [][]byte{udpPayload1Addr1ToAddr2},
 		},
+		// As per RFC 8200 section 4.5, a packet with overlapping fragments must be
+		// discarded. Fragments arriving afterwards are not enough to complete it.
+		{
+			name: "Two overlapping fragments",
+			fragments: []fragmentData{
+				{
+					srcAddr: addr1,
+					dstAddr: addr2,
+					nextHdr: fragmentExtHdrID,
+					data: append(
+						// Fragment extension header.
+						//
+						// Fragment offset = 0, More = true, ID = 1
+						[]byte{uint8(header.UDPProtocolNumber), 0, 0, 1, 0, 0, 0, 1},
+						ipv6Payload1Addr1ToAddr2[:64]...,
+					),
+				},
+				{
+					srcAddr: addr1,
+					dstAddr: addr2,
+					nextHdr: fragmentExtHdrID,
+					data: append(
+						// Fragment extension header.
+						//
+						// Fragment offset = 7, More = false, ID = 1
+						[]byte{uint8(header.UDPProtocolNumber), 0, 0, 56, 0, 0, 0, 1},
+						ipv6Payload1Addr1ToAddr2[56:]...,
+					),
+				},
+				{
+					srcAddr: addr1,
+					dstAddr: addr2,
+					nextHdr: fragmentExtHdrID,
+					data: append(
+						// Fragment extension header.
+						//
+						// Fragment offset = 8, More = false, ID = 1
+						[]byte{uint8(header.UDPProtocolNumber), 0, 0, 64, 0, 0, 0, 1},
+						ipv6Payload1Addr1ToAddr2[64:]...,
+					),
+				},
+			},
+			expectedPayloads: nil,
+		},
 		{
 			name: "Two fragments out of order",
 			fragments: []fragmentData{
@@ -2057,6 +2103,107 @@ func TestConcurrentFragmentWrites(t *testing.T) {
 	}
 }
 
+// TestMalformedHeaderStats sends packets failing each IPv6 header validation
+// check and verifies that they are dropped and counted under their reason.
+func TestMalformedHeaderStats(t *testing.T) {
+	const (
+		nicID      = 1
+		payloadLen = 8
+		pktLen     = header.IPv6MinimumSize + payloadLen
+	)
+
+	tests := []struct {
+		name   string
+		mutate func(header.IPv6)
+		// size is the number of bytes of the packet to inject.
+		size       int
+		wantReason func(*tcpip.IPStats) *tcpip.StatCounter
+	}{
+		{
+			name:       "truncated header",
+			size:       header.IPv6MinimumSize - 1,
+			wantReason: func(s *tcpip.IPStats) *tcpip.StatCounter { return s.TruncatedPacketsReceived },
+		},
+		{
+			name:       "truncated payload",
+			size:       pktLen - 1,
+			wantReason: func(s *tcpip.IPStats) *tcpip.StatCounter { return s.TruncatedPacketsReceived },
+		},
+		{
+			name: "bad version",
+			mutate: func(h header.IPv6) {
+				h[0] = header.IPv4Version<<4 | h[0]&0xf
+			},
+			size:       pktLen,
+			wantReason: func(s *tcpip.IPStats) *tcpip.StatCounter { return s.BadVersionPacketsReceived },
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := newTestContext()
+			defer ctx.cleanup()
+			s := ctx.s
+
+			e := channel.New(0, header.IPv6MinimumMTU, "")
+			defer e.Close()
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+			}
+			protocolAddr := tcpip.ProtocolAddress{
+				Protocol:          ProtocolNumber,
+				AddressWithPrefix: addr2.WithPrefix(),
+			}
+			if err := s.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+				t.Fatalf("AddProtocolAddress(%d, %+v, {}): %s", nicID, protocolAddr, err)
+			}
+
+			b := make([]byte, pktLen)
+			ip := header.IPv6(b)
+			ip.Encode(&header.IPv6Fields{
+				PayloadLength:     payloadLen,
+				TransportProtocol: udp.ProtocolNumber,
+				HopLimit:          DefaultTTL,
+				SrcAddr:           addr1,
+				DstAddr:           addr2,
+			})
+			if test.mutate != nil {
+				test.mutate(ip)
+			}
+
+			pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Payload: buffer.MakeWithData(b[:test.size]),
+			})
+			e.InjectInbound(ProtocolNumber, pkt)
+			pkt.DecRef()
+
+			stats := s.Stats().IP
+			if got := stats.MalformedPacketsReceived.Value(); got != 1 {
+				t.Errorf("got stats.MalformedPacketsReceived.Value() = %d, want = 1", got)
+			}
+			if got := stats.PacketsDelivered.Value(); got != 0 {
+				t.Errorf("got stats.PacketsDelivered.Value() = %d, want = 0", got)
+			}
+			reasons := map[string]*tcpip.StatCounter{
+				"TruncatedPacketsReceived":   stats.TruncatedPacketsReceived,
+				"BadVersionPacketsReceived":  stats.BadVersionPacketsReceived,
+				"BadLengthPacketsReceived":   stats.BadLengthPacketsReceived,
+				"BadChecksumPacketsReceived": stats.BadChecksumPacketsReceived,
+			}
+			wantReason := test.wantReason(&stats)
+			for name, counter := range reasons {
+				var want uint64
+				if counter == wantReason {
+					want = 1
+				}
+				if got := counter.Value(); got != want {
+					t.Errorf("got stats.%s.Value() = %d, want = %d", name, got, want)
+				}
+			}
+		})
+	}
+}
+
 func TestInvalidIPv6Fragments(t *testing.T) {
 	const (
 		linkAddr1 = tcpip.LinkAddress("\x0a\x0b\x0c\x0d\x0e\x0e")
@@ -2482,6 +2629,130 @@ func TestFragmentReassemblyTimeout(t *testing.T) {
 	}
 }
 
+func TestFragmentReassemblyOptions(t *testing.T) {
+	const (
+		linkAddr1 = tcpip.LinkAddress("\x0a\x0b\x0c\x0d\x0e\x0e")
+		nicID     = 1
+		hoplimit  = 255
+		ident     = 1
+		timeout   = 5 * time.Second
+	)
+	var (
+		addr1 = tcpip.AddrFromSlice([]byte("\x0a\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x01"))
+		addr2 = tcpip.AddrFromSlice([]byte("\x0a\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x00\x02"))
+	)
+
+	tests := []struct {
+		name       string
+		opts       Options
+		advance    time.Duration
+		expectICMP bool
+	}{
+		{
+			name:       "default timeout not reached",
+			advance:    ReassembleTimeout - time.Nanosecond,
+			expectICMP: false,
+		},
+		{
+			name:       "custom timeout not reached",
+			opts:       Options{ReassembleTimeout: timeout},
+			advance:    timeout - time.Nanosecond,
+			expectICMP: false,
+		},
+		{
+			name:       "custom timeout reached",
+			opts:       Options{ReassembleTimeout: timeout},
+			advance:    timeout,
+			expectICMP: true,
+		},
+		{
+			name: "memory limit exceeded",
+			opts: Options{
+				ReassembleTimeout:     timeout,
+				FragmentHighThreshold: 1,
+			},
+			advance:    timeout,
+			expectICMP: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := faketime.NewManualClock()
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{NewProtocolWithOptions(test.opts)},
+				Clock:            clock,
+			})
+			c := testContext{s: s, clock: clock}
+			defer c.cleanup()
+
+			e := channel.New(1, 1500, linkAddr1)
+			defer e.Close()
+			if err := s.CreateNIC(nicID, e); err != nil {
+				t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+			}
+			protocolAddr := tcpip.ProtocolAddress{
+				Protocol:          ProtocolNumber,
+				AddressWithPrefix: addr2.WithPrefix(),
+			}
+			if err := s.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+				t.Fatalf("AddProtocolAddress(%d, %+v, {}): %s", nicID, protocolAddr, err)
+			}
+			s.SetRouteTable([]tcpip.Route{{
+				Destination: header.IPv6EmptySubnet,
+				NIC:         nicID,
+			}})
+
+			// Only send the first fragment so that reassembly never completes.
+			payload := []byte("TEST_FRAGMENT_RE")
+			hdr := prependable.New(header.IPv6MinimumSize + header.IPv6FragmentHeaderSize)
+			ip := header.IPv6(hdr.Prepend(header.IPv6MinimumSize + header.IPv6FragmentHeaderSize))
+			ip.Encode(&header.IPv6Fields{
+				PayloadLength:     uint16(header.IPv6FragmentHeaderSize + len(payload)),
+				TransportProtocol: header.UDPProtocolNumber,
+				HopLimit:          hoplimit,
+				SrcAddr:           addr1,
+				DstAddr:           addr2,
+				ExtensionHeaders: header.IPv6ExtHdrSerializer{
+					&header.IPv6SerializableFragmentExtHdr{
+						M:              true,
+						Identification: ident,
+					},
+				},
+			})
+			pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Payload: buffer.MakeWithData(append(hdr.View(), payload...)),
+			})
+			e.InjectInbound(ProtocolNumber, pkt)
+			pkt.DecRef()
+
+			clock.Advance(test.advance)
+
+			reply := e.Read()
+			if !test.expectICMP {
+				if reply != nil {
+					t.Fatalf("unexpected ICMP error message received: %#v", reply)
+				}
+				return
+			}
+			if reply == nil {
+				t.Fatal("expected ICMP error message missing")
+			}
+			defer reply.DecRef()
+			replyPayload := stack.PayloadSince(reply.NetworkHeader())
+			defer replyPayload.Release()
+			checker.IPv6(t, replyPayload,
+				checker.SrcAddr(addr2),
+				checker.DstAddr(addr1),
+				checker.ICMPv6(
+					checker.ICMPv6Type(header.ICMPv6TimeExceeded),
+					checker.ICMPv6Code(header.ICMPv6ReassemblyTimeout),
+				),
+			)
+		})
+	}
+}
+
 func TestWriteStats(t *testing.T) {
 	const nPackets = 3
 	tests := []struct {
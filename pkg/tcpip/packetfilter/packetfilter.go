@@ -0,0 +1,52 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package packetfilter provides classic BPF packet filters, such as the
+// programs generated by "tcpdump -dd", that select which packets are
+// captured.
+package packetfilter
+
+import (
+	"fmt"
+
+	"gvisor.dev/gvisor/pkg/bpf"
+)
+
+// Filter is a validated classic BPF program that selects packets.
+type Filter struct {
+	program bpf.Program
+}
+
+// New returns a filter that runs insns. It returns an error if insns is not a
+// valid program.
+//
+// Programs run on raw IP packets: the link-layer header is stripped, so
+// offsets are relative to the start of the network header. Generate programs
+// for the raw IP link type, e.g. with "tcpdump -y RAW -dd '<expression>'",
+// rather than for Ethernet.
+func New(insns []bpf.Instruction) (*Filter, error) {
+	program, err := bpf.Compile(insns, true /* optimize */)
+	if err != nil {
+		return nil, fmt.Errorf("invalid packet filter: %w", err)
+	}
+	return &Filter{program: program}, nil
+}
+
+// Match returns true if the filter accepts the packet in b. Loads are in
+// network byte order. As in Linux, the packet is rejected if the program
+// returns zero or fails, e.g. by reading past the end of the packet.
+func (f *Filter) Match(b []byte) bool {
+	ret, err := bpf.Exec[bpf.BigEndian](f.program, bpf.Input(b))
+	return err == nil && ret != 0
+}
@@ -0,0 +1,179 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package packetfilter_test
+
+import (
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/bpf"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/packetfilter"
+)
+
+// tcpPort80 selects unfragmented IPv4 TCP segments to or from port 80. It is
+// the program "tcpdump -y RAW -dd 'ip and tcp port 80'" generates.
+var tcpPort80 = []bpf.Instruction{
+	bpf.Stmt(bpf.Ld|bpf.Abs|bpf.B, 0),
+	bpf.Stmt(bpf.Alu|bpf.And|bpf.K, 0xf0),
+	bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 0x40, 0, 10),
+	bpf.Stmt(bpf.Ld|bpf.Abs|bpf.B, 9),
+	bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, uint32(header.TCPProtocolNumber), 0, 8),
+	bpf.Stmt(bpf.Ld|bpf.Abs|bpf.H, 6),
+	bpf.Jump(bpf.Jmp|bpf.Jset|bpf.K, 0x1fff, 6, 0),
+	bpf.Stmt(bpf.Ldx|bpf.Msh|bpf.B, 0),
+	bpf.Stmt(bpf.Ld|bpf.Ind|bpf.H, 0),
+	bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 80, 2, 0),
+	bpf.Stmt(bpf.Ld|bpf.Ind|bpf.H, 2),
+	bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 80, 0, 1),
+	bpf.Stmt(bpf.Ret|bpf.K, 262144),
+	bpf.Stmt(bpf.Ret|bpf.K, 0),
+}
+
+var (
+	srcAddr = tcpip.AddrFrom4([4]byte{10, 0, 0, 1})
+	dstAddr = tcpip.AddrFrom4([4]byte{10, 0, 0, 2})
+)
+
+// buildPacket returns an IPv4 packet carrying a transport header with the
+// given ports. The transport header is truncated to transportSize bytes.
+func buildPacket(proto tcpip.TransportProtocolNumber, srcPort, dstPort uint16, fragmentOffset uint16, transportSize int) []byte {
+	b := make([]byte, header.IPv4MinimumSize+header.TCPMinimumSize)
+	header.IPv4(b).Encode(&header.IPv4Fields{
+		TotalLength:    uint16(header.IPv4MinimumSize + transportSize),
+		TTL:            64,
+		Protocol:       uint8(proto),
+		FragmentOffset: fragmentOffset,
+		SrcAddr:        srcAddr,
+		DstAddr:        dstAddr,
+	})
+	switch proto {
+	case header.TCPProtocolNumber:
+		header.TCP(b[header.IPv4MinimumSize:]).Encode(&header.TCPFields{
+			SrcPort:    srcPort,
+			DstPort:    dstPort,
+			DataOffset: header.TCPMinimumSize,
+		})
+	case header.UDPProtocolNumber:
+		header.UDP(b[header.IPv4MinimumSize:]).Encode(&header.UDPFields{
+			SrcPort: srcPort,
+			DstPort: dstPort,
+			Length:  header.UDPMinimumSize,
+		})
+	}
+	return b[:header.IPv4MinimumSize+transportSize]
+}
+
+func TestMatch(t *testing.T) {
+	f, err := packetfilter.New(tcpPort80)
+	if err != nil {
+		t.Fatalf("packetfilter.New(_): %s", err)
+	}
+
+	tests := []struct {
+		name string
+		pkt  []byte
+		want bool
+	}{
+		{
+			name: "TCP to port 80",
+			pkt:  buildPacket(header.TCPProtocolNumber, 1234, 80, 0, header.TCPMinimumSize),
+			want: true,
+		},
+		{
+			name: "TCP from port 80",
+			pkt:  buildPacket(header.TCPProtocolNumber, 80, 1234, 0, header.TCPMinimumSize),
+			want: true,
+		},
+		{
+			name: "TCP other port",
+			pkt:  buildPacket(header.TCPProtocolNumber, 1234, 81, 0, header.TCPMinimumSize),
+			want: false,
+		},
+		{
+			name: "UDP to port 80",
+			pkt:  buildPacket(header.UDPProtocolNumber, 1234, 80, 0, header.UDPMinimumSize),
+			want: false,
+		},
+		{
+			name: "TCP to port 80 non-first fragment",
+			pkt:  buildPacket(header.TCPProtocolNumber, 1234, 80, 8, header.TCPMinimumSize),
+			want: false,
+		},
+		{
+			name: "truncated TCP header",
+			pkt:  buildPacket(header.TCPProtocolNumber, 1234, 80, 0, 2),
+			want: false,
+		},
+		{
+			name: "empty",
+			want: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if got := f.Match(test.pkt); got != test.want {
+				t.Errorf("got f.Match(_) = %t, want = %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	tests := []struct {
+		name  string
+		insns []bpf.Instruction
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name: "no return",
+			insns: []bpf.Instruction{
+				bpf.Stmt(bpf.Ld|bpf.Abs|bpf.B, 0),
+			},
+		},
+		{
+			name: "jump past end",
+			insns: []bpf.Instruction{
+				bpf.Jump(bpf.Jmp|bpf.Jeq|bpf.K, 0, 0, 2),
+				bpf.Stmt(bpf.Ret|bpf.K, 0),
+			},
+		},
+		{
+			name: "invalid opcode",
+			insns: []bpf.Instruction{
+				bpf.Stmt(0xffff, 0),
+				bpf.Stmt(bpf.Ret|bpf.K, 0),
+			},
+		},
+		{
+			name: "invalid scratch register",
+			insns: []bpf.Instruction{
+				bpf.Stmt(bpf.Ld|bpf.Mem|bpf.W, bpf.ScratchMemRegisters),
+				bpf.Stmt(bpf.Ret|bpf.A, 0),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if f, err := packetfilter.New(test.insns); err == nil {
+				t.Errorf("got packetfilter.New(_) = (%p, nil), want = (_, non-nil)", f)
+			}
+		})
+	}
+}
@@ -481,9 +481,10 @@ func (pm *PortManager) PortRange() (uint16, uint16) {
 }
 
 // SetPortRange sets the UDP and TCP IPv4 and IPv6 ephemeral port range
-// (inclusive).
+// (inclusive). Port 0 can't be part of the range as it requests that a port be
+// picked.
 func (pm *PortManager) SetPortRange(start uint16, end uint16) tcpip.Error {
-	if start > end {
+	if start == 0 || start > end {
 		return &tcpip.ErrInvalidPortRange{}
 	}
 	pm.ephemeralMu.Lock()
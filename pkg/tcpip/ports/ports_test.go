@@ -433,6 +433,71 @@ func TestPickEphemeralPort(t *testing.T) {
 	}
 }
 
+func TestSetPortRange(t *testing.T) {
+	for _, test := range []struct {
+		name       string
+		start, end uint16
+		wantErr    tcpip.Error
+	}{
+		{name: "single port", start: 1000, end: 1000},
+		{name: "full range", start: 1, end: math.MaxUint16},
+		{name: "start after end", start: 1001, end: 1000, wantErr: &tcpip.ErrInvalidPortRange{}},
+		{name: "zero start", start: 0, end: 1000, wantErr: &tcpip.ErrInvalidPortRange{}},
+		{name: "zero range", start: 0, end: 0, wantErr: &tcpip.ErrInvalidPortRange{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			pm := NewPortManager()
+			wantStart, wantEnd := pm.PortRange()
+			err := pm.SetPortRange(test.start, test.end)
+			if diff := cmp.Diff(test.wantErr, err); diff != "" {
+				t.Fatalf("unexpected error from SetPortRange(%d, %d), (-want, +got):\n%s", test.start, test.end, diff)
+			}
+			if test.wantErr == nil {
+				wantStart, wantEnd = test.start, test.end
+			}
+			if start, end := pm.PortRange(); start != wantStart || end != wantEnd {
+				t.Errorf("got PortRange() = (%d, %d), want = (%d, %d)", start, end, wantStart, wantEnd)
+			}
+		})
+	}
+}
+
+func TestEphemeralPortRangeExhaustion(t *testing.T) {
+	const (
+		start = 5000
+		end   = 5002
+	)
+	pm := NewPortManager()
+	if err := pm.SetPortRange(start, end); err != nil {
+		t.Fatalf("SetPortRange(%d, %d): %s", start, end, err)
+	}
+	rng := cryptorand.RNGFrom(cryptorand.Reader)
+	res := Reservation{
+		Networks:  []tcpip.NetworkProtocolNumber{fakeNetworkNumber},
+		Transport: fakeTransNumber,
+		Addr:      fakeIPAddress,
+	}
+
+	picked := make(map[uint16]struct{})
+	for i := 0; i < end-start+1; i++ {
+		port, err := pm.ReservePort(rng, res, nil /* testPort */)
+		if err != nil {
+			t.Fatalf("ReservePort(%+v, _) at iteration %d: %s", res, i, err)
+		}
+		if port < start || port > end {
+			t.Fatalf("got ReservePort(%+v, _) = %d, want port in range [%d, %d]", res, port, start, end)
+		}
+		if _, ok := picked[port]; ok {
+			t.Fatalf("got ReservePort(%+v, _) = %d, which was already picked", res, port)
+		}
+		picked[port] = struct{}{}
+	}
+
+	if _, err := pm.ReservePort(rng, res, nil /* testPort */); !cmp.Equal(err, &tcpip.ErrNoPortAvailable{}) {
+		t.Fatalf("got ReservePort(%+v, _) = %v, want = %s", res, err, &tcpip.ErrNoPortAvailable{})
+	}
+}
+
 // TestOverflow addresses b/183593432, wherein an overflowing uint16 causes a
 // port allocation failure.
 func TestOverflow(t *testing.T) {
@@ -58,7 +58,7 @@ func (p Prependable) UsedLength() int {
 	return len(p.buf) - p.usedIdx
 }
 
-// AvailableLength returns the number of bytes used so far.
+// AvailableLength returns the number of bytes that can still be prepended.
 func (p Prependable) AvailableLength() int {
 	return p.usedIdx
 }
@@ -70,6 +70,9 @@ func (p *Prependable) TrimBack(size int) {
 
 // Prepend reserves the requested space in front of the buffer, returning a
 // slice that represents the reserved space.
+//
+// If fewer than size bytes are available, Prepend reserves nothing and
+// returns nil.
 func (p *Prependable) Prepend(size int) []byte {
 	if size > p.usedIdx {
 		return nil
@@ -79,6 +82,19 @@ func (p *Prependable) Prepend(size int) []byte {
 	return p.View()[:size:size]
 }
 
+// Reset empties p and resizes it to size bytes, reusing the backing buffer
+// if it is large enough. The buffer is zeroed so that it can be reused as if
+// it were returned by New.
+func (p *Prependable) Reset(size int) {
+	if cap(p.buf) < size {
+		*p = New(size)
+		return
+	}
+	p.buf = p.buf[:size]
+	clear(p.buf)
+	p.usedIdx = size
+}
+
 // DeepCopy copies p and the bytes backing it.
 func (p Prependable) DeepCopy() Prependable {
 	p.buf = append([]byte{}, p.buf...)
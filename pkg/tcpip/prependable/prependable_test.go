@@ -0,0 +1,93 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package prependable
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrependExhausted(t *testing.T) {
+	p := New(4)
+	if got := p.Prepend(3); len(got) != 3 {
+		t.Fatalf("got len(p.Prepend(3)) = %d, want = 3", len(got))
+	}
+	if got := p.Prepend(2); got != nil {
+		t.Errorf("got p.Prepend(2) = %x, want = nil", got)
+	}
+	if got := p.UsedLength(); got != 3 {
+		t.Errorf("got p.UsedLength() = %d, want = 3", got)
+	}
+	if got := p.AvailableLength(); got != 1 {
+		t.Errorf("got p.AvailableLength() = %d, want = 1", got)
+	}
+	if got := p.Prepend(1); len(got) != 1 {
+		t.Errorf("got len(p.Prepend(1)) = %d, want = 1", len(got))
+	}
+	if got := p.Prepend(0); got == nil {
+		t.Errorf("got p.Prepend(0) = nil, want non-nil")
+	}
+}
+
+func TestReset(t *testing.T) {
+	tests := []struct {
+		name      string
+		size      int
+		wantReuse bool
+	}{
+		{
+			name:      "same size",
+			size:      8,
+			wantReuse: true,
+		},
+		{
+			name:      "smaller",
+			size:      4,
+			wantReuse: true,
+		},
+		{
+			name:      "larger",
+			size:      16,
+			wantReuse: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p := New(8)
+			copy(p.Prepend(8), bytes.Repeat([]byte{0xff}, 8))
+			backing := &p.buf[0]
+
+			p.Reset(test.size)
+			if got := p.UsedLength(); got != 0 {
+				t.Errorf("got p.UsedLength() = %d, want = 0", got)
+			}
+			if got := p.AvailableLength(); got != test.size {
+				t.Errorf("got p.AvailableLength() = %d, want = %d", got, test.size)
+			}
+			if got := &p.buf[0] == backing; got != test.wantReuse {
+				t.Errorf("got backing buffer reused = %t, want = %t", got, test.wantReuse)
+			}
+
+			v := p.Prepend(test.size)
+			if !bytes.Equal(v, make([]byte, test.size)) {
+				t.Errorf("got p.Prepend(%d) = %x, want all zeros", test.size, v)
+			}
+			if got := p.Prepend(1); got != nil {
+				t.Errorf("got p.Prepend(1) = %x, want = nil", got)
+			}
+		})
+	}
+}
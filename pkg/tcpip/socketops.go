@@ -230,6 +230,10 @@ type SocketOptions struct {
 	// passing is enabled for IPv6.
 	ipv6RecvErrEnabled atomicbitops.Uint32
 
+	// outOfBandInlineEnabled determines whether urgent data is delivered
+	// in-band instead of being read with MSG_OOB.
+	outOfBandInlineEnabled atomicbitops.Uint32
+
 	// errQueue is the per-socket error queue. It is protected by errQueueMu.
 	errQueueMu sync.Mutex `state:"nosave"`
 	errQueue   sockErrorList
@@ -516,13 +520,14 @@ func (so *SocketOptions) GetLastError() Error {
 }
 
 // GetOutOfBandInline gets value for SO_OOBINLINE option.
-func (*SocketOptions) GetOutOfBandInline() bool {
-	return true
+func (so *SocketOptions) GetOutOfBandInline() bool {
+	return so.outOfBandInlineEnabled.Load() != 0
 }
 
-// SetOutOfBandInline sets value for SO_OOBINLINE option. We currently do not
-// support disabling this option.
-func (*SocketOptions) SetOutOfBandInline(bool) {}
+// SetOutOfBandInline sets value for SO_OOBINLINE option.
+func (so *SocketOptions) SetOutOfBandInline(v bool) {
+	storeAtomicBool(&so.outOfBandInlineEnabled, v)
+}
 
 // GetLinger gets value for SO_LINGER option.
 func (so *SocketOptions) GetLinger() LingerOption {
@@ -15,8 +15,15 @@
 package stack
 
 import (
+	"bytes"
 	"math/bits"
 	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
 func TestNBuckets(t *testing.T) {
@@ -26,3 +33,309 @@ func TestNBuckets(t *testing.T) {
 		t.Fatalf("groNBuckets is not a power of two")
 	}
 }
+
+var (
+	groSrcAddr = tcpip.AddrFrom4([4]byte{10, 0, 0, 1})
+	groDstAddr = tcpip.AddrFrom4([4]byte{10, 0, 0, 2})
+)
+
+const (
+	groSrcPort = 1000
+	groDstPort = 80
+)
+
+// groSegment is a TCP segment handed up the stack by GRO.
+type groSegment struct {
+	seq         uint32
+	flags       header.TCPFlags
+	totalLength uint16
+	payload     []byte
+}
+
+// groTestEndpoint is a NetworkEndpoint that records the TCP segments GRO
+// hands up the stack.
+type groTestEndpoint struct {
+	NetworkEndpoint
+
+	mu       sync.Mutex
+	segments []groSegment
+}
+
+// HandlePacket implements NetworkEndpoint.HandlePacket.
+func (ep *groTestEndpoint) HandlePacket(pkt *PacketBuffer) {
+	b := pkt.Data().AsRange().ToSlice()
+	ipHdr := header.IPv4(b)
+	tcpHdr := header.TCP(b[header.IPv4MinimumSize:])
+
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	ep.segments = append(ep.segments, groSegment{
+		seq:         tcpHdr.SequenceNumber(),
+		flags:       tcpHdr.Flags(),
+		totalLength: ipHdr.TotalLength(),
+		payload:     tcpHdr.Payload(),
+	})
+}
+
+func (ep *groTestEndpoint) handled() []groSegment {
+	ep.mu.Lock()
+	defer ep.mu.Unlock()
+	return append([]groSegment(nil), ep.segments...)
+}
+
+// groPayload returns n bytes of the stream starting at seq, so that merged
+// payloads can be checked for order.
+func groPayload(seq uint32, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = byte(seq + uint32(i))
+	}
+	return b
+}
+
+// groTCPPacket4 returns an IPv4 packet carrying a TCP segment with the given
+// sequence number, flags and payload length. The packet is marked as having
+// its checksums validated so they are left unset.
+func groTCPPacket4(seq uint32, flags header.TCPFlags, payloadLen int) *PacketBuffer {
+	const hdrLen = header.IPv4MinimumSize + header.TCPMinimumSize
+	b := make([]byte, hdrLen, hdrLen+payloadLen)
+	header.IPv4(b).Encode(&header.IPv4Fields{
+		TotalLength: uint16(hdrLen + payloadLen),
+		TTL:         64,
+		Protocol:    uint8(header.TCPProtocolNumber),
+		SrcAddr:     groSrcAddr,
+		DstAddr:     groDstAddr,
+	})
+	header.TCP(b[header.IPv4MinimumSize:]).Encode(&header.TCPFields{
+		SrcPort:    groSrcPort,
+		DstPort:    groDstPort,
+		SeqNum:     seq,
+		AckNum:     1,
+		DataOffset: header.TCPMinimumSize,
+		Flags:      flags | header.TCPFlagAck,
+		WindowSize: 0xffff,
+	})
+	b = append(b, groPayload(seq, payloadLen)...)
+	pkt := NewPacketBuffer(PacketBufferOptions{
+		Payload: buffer.MakeWithData(b),
+	})
+	pkt.RXChecksumValidated = true
+	return pkt
+}
+
+func TestGROCoalesce(t *testing.T) {
+	const (
+		mss    = 1000
+		bigMSS = 16384
+		hdrLen = header.IPv4MinimumSize + header.TCPMinimumSize
+	)
+
+	type segment struct {
+		seq   uint32
+		flags header.TCPFlags
+		len   int
+	}
+
+	tests := []struct {
+		name string
+		in   []segment
+		want []segment
+	}{
+		{
+			name: "in order",
+			in: []segment{
+				{seq: 1, len: mss},
+				{seq: 1 + mss, len: mss},
+				{seq: 1 + 2*mss, len: mss},
+			},
+			want: []segment{
+				{seq: 1, len: 3 * mss},
+			},
+		},
+		{
+			name: "flush on PSH",
+			in: []segment{
+				{seq: 1, len: mss},
+				{seq: 1 + mss, len: mss},
+				{seq: 1 + 2*mss, flags: header.TCPFlagPsh, len: mss},
+				{seq: 1 + 3*mss, len: mss},
+			},
+			want: []segment{
+				{seq: 1, flags: header.TCPFlagPsh, len: 3 * mss},
+				{seq: 1 + 3*mss, len: mss},
+			},
+		},
+		{
+			name: "flush on short segment",
+			in: []segment{
+				{seq: 1, len: mss},
+				{seq: 1 + mss, len: mss / 2},
+				{seq: 1 + mss + mss/2, len: mss},
+			},
+			want: []segment{
+				{seq: 1, len: mss + mss/2},
+				{seq: 1 + mss + mss/2, len: mss},
+			},
+		},
+		{
+			name: "gap",
+			in: []segment{
+				{seq: 1, len: mss},
+				{seq: 1 + 2*mss, len: mss},
+				{seq: 1 + 3*mss, len: mss},
+			},
+			want: []segment{
+				{seq: 1, len: mss},
+				{seq: 1 + 2*mss, len: 2 * mss},
+			},
+		},
+		{
+			name: "reordered",
+			in: []segment{
+				{seq: 1 + mss, len: mss},
+				{seq: 1, len: mss},
+			},
+			want: []segment{
+				{seq: 1 + mss, len: mss},
+				{seq: 1, len: mss},
+			},
+		},
+		{
+			name: "max size",
+			in: []segment{
+				{seq: 1, len: bigMSS},
+				{seq: 1 + bigMSS, len: bigMSS},
+				{seq: 1 + 2*bigMSS, len: bigMSS},
+				{seq: 1 + 3*bigMSS, len: bigMSS},
+			},
+			want: []segment{
+				{seq: 1, len: 3 * bigMSS},
+				{seq: 1 + 3*bigMSS, len: bigMSS},
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var gd groDispatcher
+			// Use an interval long enough that the flush timer never fires
+			// during the test.
+			gd.init(time.Hour)
+			defer gd.close()
+
+			var ep groTestEndpoint
+			for _, s := range test.in {
+				pkt := groTCPPacket4(s.seq, s.flags, s.len)
+				gd.dispatch(pkt, header.IPv4ProtocolNumber, &ep)
+				pkt.DecRef()
+			}
+			gd.flushAll()
+
+			got := ep.handled()
+			if len(got) != len(test.want) {
+				t.Fatalf("got %d segments handled, want = %d: %+v", len(got), len(test.want), got)
+			}
+			for i, want := range test.want {
+				got := got[i]
+				if got.seq != want.seq {
+					t.Errorf("got segments[%d].seq = %d, want = %d", i, got.seq, want.seq)
+				}
+				if wantFlags := want.flags | header.TCPFlagAck; got.flags != wantFlags {
+					t.Errorf("got segments[%d].flags = %s, want = %s", i, got.flags, wantFlags)
+				}
+				if wantLen := uint16(hdrLen + want.len); got.totalLength != wantLen {
+					t.Errorf("got segments[%d].totalLength = %d, want = %d", i, got.totalLength, wantLen)
+				}
+				if wantPayload := groPayload(want.seq, want.len); !bytes.Equal(got.payload, wantPayload) {
+					t.Errorf("segments[%d] payload is not the contiguous stream starting at %d", i, want.seq)
+				}
+			}
+		})
+	}
+}
+
+func TestGROFlushTimer(t *testing.T) {
+	const mss = 1000
+
+	var gd groDispatcher
+	gd.init(time.Millisecond)
+	defer gd.close()
+
+	var ep groTestEndpoint
+	for i := 0; i < 2; i++ {
+		pkt := groTCPPacket4(uint32(1+i*mss), 0, mss)
+		gd.dispatch(pkt, header.IPv4ProtocolNumber, &ep)
+		pkt.DecRef()
+	}
+
+	// The coalesced segment is handed up once the timer fires, without
+	// anything else arriving.
+	deadline := time.Now().Add(5 * time.Second)
+	for len(ep.handled()) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for the flush timer to hand up the coalesced segment")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	got := ep.handled()
+	if len(got) != 1 {
+		t.Fatalf("got %d segments handled, want = 1: %+v", len(got), got)
+	}
+	if want := groPayload(1, 2*mss); !bytes.Equal(got[0].payload, want) {
+		t.Errorf("got payload of %d bytes, want the %d byte contiguous stream starting at 1", len(got[0].payload), len(want))
+	}
+}
+
+// groDiscardEndpoint is a NetworkEndpoint that drops the packets GRO hands up
+// the stack.
+type groDiscardEndpoint struct {
+	NetworkEndpoint
+}
+
+// HandlePacket implements NetworkEndpoint.HandlePacket.
+func (*groDiscardEndpoint) HandlePacket(*PacketBuffer) {}
+
+func BenchmarkGRO(b *testing.B) {
+	const (
+		mss = 1448
+		// pshInterval is the number of segments after which the sender sets
+		// PSH, bounding the size of coalesced segments.
+		pshInterval = 32
+		batchSize   = 1024
+	)
+
+	for _, interval := range []time.Duration{0, time.Hour} {
+		name := "disabled"
+		if interval != 0 {
+			name = "enabled"
+		}
+		b.Run(name, func(b *testing.B) {
+			var gd groDispatcher
+			gd.init(interval)
+			defer gd.close()
+
+			var ep groDiscardEndpoint
+			pkts := make([]*PacketBuffer, 0, batchSize)
+			b.ResetTimer()
+			for i := 0; i < b.N; {
+				// Build packets in batches with the timer stopped so that only
+				// dispatching them is measured.
+				b.StopTimer()
+				pkts = pkts[:0]
+				for j := 0; j < batchSize && i+j < b.N; j++ {
+					var flags header.TCPFlags
+					if (i+j)%pshInterval == pshInterval-1 {
+						flags = header.TCPFlagPsh
+					}
+					pkts = append(pkts, groTCPPacket4(uint32(1+(i+j)*mss), flags, mss))
+				}
+				b.StartTimer()
+				for _, pkt := range pkts {
+					gd.dispatch(pkt, header.IPv4ProtocolNumber, &ep)
+					pkt.DecRef()
+				}
+				i += len(pkts)
+			}
+		})
+	}
+}
@@ -19,6 +19,7 @@ import (
 	"reflect"
 
 	"gvisor.dev/gvisor/pkg/atomicbitops"
+	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
@@ -31,6 +32,7 @@ type linkResolver struct {
 
 var _ NetworkInterface = (*nic)(nil)
 var _ NetworkDispatcher = (*nic)(nil)
+var _ LinkStateDispatcher = (*nic)(nil)
 
 // nic represents a "network interface card" to which the networking stack is
 // attached.
@@ -64,10 +66,22 @@ type nic struct {
 	// promiscuous indicates whether the NIC is promiscuous.
 	promiscuous atomicbitops.Bool
 
+	// linkUp indicates whether the link of the NIC is up.
+	linkUp atomicbitops.Bool
+
 	// linkResQueue holds packets that are waiting for link resolution to
 	// complete.
 	linkResQueue packetsPendingLinkResolution
 
+	// tentativeIPv4Mu protects tentativeIPv4.
+	tentativeIPv4Mu sync.Mutex
+
+	// tentativeIPv4 holds the tentative IPv4 addresses that are waiting for
+	// address conflict detection to complete, and a reference to each of them.
+	//
+	// +checklocks:tentativeIPv4Mu
+	tentativeIPv4 map[tcpip.Address]AddressEndpoint
+
 	// packetEPsMu protects annotated fields below.
 	packetEPsMu packetEPsRWMutex
 
@@ -180,6 +194,7 @@ func newNIC(stack *Stack, id tcpip.NICID, ep LinkEndpoint, opts NICOptions) *nic
 		networkEndpoints:          make(map[tcpip.NetworkProtocolNumber]NetworkEndpoint),
 		linkAddrResolvers:         make(map[tcpip.NetworkProtocolNumber]*linkResolver),
 		duplicateAddressDetectors: make(map[tcpip.NetworkProtocolNumber]DuplicateAddressDetector),
+		tentativeIPv4:             make(map[tcpip.Address]AddressEndpoint),
 		qDisc:                     qDisc,
 		deliverLinkPackets:        opts.DeliverLinkPackets,
 	}
@@ -211,7 +226,15 @@ func newNIC(stack *Stack, id tcpip.NICID, ep LinkEndpoint, opts NICOptions) *nic
 	}
 
 	nic.gro.init(opts.GROTimeout)
+	nic.linkUp.Store(true)
 	nic.NetworkLinkEndpoint.Attach(nic)
+	// The link state is read after attaching so that a change racing with
+	// NIC creation is either delivered to the NIC or observed here.
+	if ep.Capabilities()&CapabilityLinkState != 0 {
+		if lse, ok := ep.(LinkStateEndpoint); ok {
+			nic.linkUp.Store(lse.LinkUp())
+		}
+	}
 
 	return nic
 }
@@ -225,6 +248,21 @@ func (n *nic) Enabled() bool {
 	return n.enabled.Load()
 }
 
+// LinkUp returns true if the link of the NIC is up.
+func (n *nic) LinkUp() bool {
+	return n.linkUp.Load()
+}
+
+// DeliverLinkState implements LinkStateDispatcher.
+func (n *nic) DeliverLinkState(up bool) {
+	if n.linkUp.Swap(up) == up {
+		return
+	}
+	if disp := n.stack.linkStateDisp; disp != nil {
+		disp.OnNICLinkStateChanged(n.id, up)
+	}
+}
+
 // setEnabled sets the enabled status for the NIC.
 //
 // Returns true if the enabled status was updated.
@@ -286,6 +324,8 @@ func (n *nic) disableLocked() {
 // address (ff02::1), start DAD for permanent addresses, and start soliciting
 // routers if the stack is not operating as a router. If the stack is also
 // configured to auto-generate a link-local address, one will be generated.
+//
+// Address conflict detection is started for tentative IPv4 addresses.
 func (n *nic) enable() tcpip.Error {
 	n.enableDisableMu.Lock()
 	defer n.enableDisableMu.Unlock()
@@ -300,6 +340,19 @@ func (n *nic) enable() tcpip.Error {
 		}
 	}
 
+	n.tentativeIPv4Mu.Lock()
+	tentative := make([]AddressEndpoint, 0, len(n.tentativeIPv4))
+	for _, addressEndpoint := range n.tentativeIPv4 {
+		tentative = append(tentative, addressEndpoint)
+	}
+	n.tentativeIPv4Mu.Unlock()
+	if len(tentative) != 0 {
+		ep := n.getNetworkEndpoint(header.IPv4ProtocolNumber).(AddressableEndpoint)
+		for _, addressEndpoint := range tentative {
+			n.detectIPv4AddressConflict(ep, addressEndpoint)
+		}
+	}
+
 	return nil
 }
 
@@ -317,6 +370,13 @@ func (n *nic) remove() tcpip.Error {
 
 	n.enableDisableMu.Unlock()
 
+	n.tentativeIPv4Mu.Lock()
+	for addr, addressEndpoint := range n.tentativeIPv4 {
+		addressEndpoint.DecRef()
+		delete(n.tentativeIPv4, addr)
+	}
+	n.tentativeIPv4Mu.Unlock()
+
 	// Shutdown GRO.
 	n.gro.close()
 
@@ -528,11 +588,109 @@ func (n *nic) addAddress(protocolAddress tcpip.ProtocolAddress, properties Addre
 	}
 
 	addressEndpoint, err := addressableEndpoint.AddAndAcquirePermanentAddress(protocolAddress.AddressWithPrefix, properties)
-	if err == nil {
-		// We have no need for the address endpoint.
+	if err != nil {
+		return err
+	}
+
+	if protocolAddress.Protocol == header.IPv4ProtocolNumber && addressEndpoint.GetKind() == PermanentTentative {
+		// The reference is held until address conflict detection completes.
+		n.tentativeIPv4Mu.Lock()
+		if prev, ok := n.tentativeIPv4[protocolAddress.AddressWithPrefix.Address]; ok {
+			// The address was removed and added again before it was probed.
+			prev.DecRef()
+		}
+		n.tentativeIPv4[protocolAddress.AddressWithPrefix.Address] = addressEndpoint
+		n.tentativeIPv4Mu.Unlock()
+
+		if n.Enabled() {
+			n.detectIPv4AddressConflict(addressableEndpoint, addressEndpoint)
+		}
+		return nil
+	}
+
+	// We have no need for the address endpoint.
+	addressEndpoint.DecRef()
+	return nil
+}
+
+// detectIPv4AddressConflict probes for a neighbor that is already using the
+// tentative address of addressEndpoint, as per RFC 5227.
+func (n *nic) detectIPv4AddressConflict(ep AddressableEndpoint, addressEndpoint AddressEndpoint) {
+	if addressEndpoint.GetKind() != PermanentTentative {
+		// The address was removed before it was probed.
+		n.forgetTentativeIPv4Address(addressEndpoint)
+		return
+	}
+
+	d, ok := n.duplicateAddressDetectors[header.IPv4ProtocolNumber]
+	if !ok {
+		n.onIPv4AddressConflictDetectionResult(ep, addressEndpoint, &DADSucceeded{})
+		return
+	}
+
+	addr := addressEndpoint.AddressWithPrefix().Address
+	switch d.CheckDuplicateAddress(addr, func(r DADResult) {
+		n.onIPv4AddressConflictDetectionResult(ep, addressEndpoint, r)
+	}) {
+	case DADDisabled:
+		// Consider probing to have succeeded even if no probes were actually
+		// transmitted.
+		n.onIPv4AddressConflictDetectionResult(ep, addressEndpoint, &DADSucceeded{})
+	case DADStarting, DADAlreadyRunning:
+	default:
+		panic(fmt.Sprintf("unrecognized DAD disposition while checking %s", addr))
+	}
+}
+
+// onIPv4AddressConflictDetectionResult handles the result of address conflict
+// detection for a tentative IPv4 address.
+//
+// If no conflict was found, the address is assigned and announced to
+// neighbors. If a conflict was found, the address is removed from ep.
+// Otherwise, the address stays tentative and is probed for again when n is
+// enabled.
+func (n *nic) onIPv4AddressConflictDetectionResult(ep AddressableEndpoint, addressEndpoint AddressEndpoint, r DADResult) {
+	if addressEndpoint.GetKind() != PermanentTentative {
+		// The address was removed while probing, or the result was already
+		// handled.
+		n.forgetTentativeIPv4Address(addressEndpoint)
+		return
+	}
+
+	addr := addressEndpoint.AddressWithPrefix().Address
+	switch r.(type) {
+	case *DADSucceeded:
+		addressEndpoint.SetKind(Permanent)
+		n.forgetTentativeIPv4Address(addressEndpoint)
+		if a, ok := n.duplicateAddressDetectors[header.IPv4ProtocolNumber].(AddressAnnouncer); ok {
+			// Announcing is best-effort; the address has already been assigned.
+			_ = a.AnnounceAddress(addr)
+		}
+	case *DADDupAddrDetected:
+		n.forgetTentativeIPv4Address(addressEndpoint)
+		// The address may have been removed concurrently.
+		_ = ep.RemovePermanentAddress(addr)
+	case *DADAborted, *DADError:
+	default:
+		panic(fmt.Sprintf("unrecognized DAD result = %T", r))
+	}
+
+	if disp := n.stack.addressConflictDisp; disp != nil {
+		disp.OnAddressConflictDetectionResult(n.id, addr, r)
+	}
+}
+
+// forgetTentativeIPv4Address stops tracking addressEndpoint as a tentative
+// IPv4 address and releases the reference held on it.
+func (n *nic) forgetTentativeIPv4Address(addressEndpoint AddressEndpoint) {
+	addr := addressEndpoint.AddressWithPrefix().Address
+
+	n.tentativeIPv4Mu.Lock()
+	defer n.tentativeIPv4Mu.Unlock()
+	if n.tentativeIPv4[addr] == addressEndpoint {
+		delete(n.tentativeIPv4, addr)
 		addressEndpoint.DecRef()
 	}
-	return err
 }
 
 // allPermanentAddresses returns all permanent addresses associated with
@@ -817,6 +975,11 @@ func (n *nic) DeliverLinkPacket(protocol tcpip.NetworkProtocolNumber, pkt *Packe
 func (n *nic) DeliverTransportPacket(protocol tcpip.TransportProtocolNumber, pkt *PacketBuffer) TransportPacketDisposition {
 	state, ok := n.stack.transportProtocols[protocol]
 	if !ok {
+		// Packets of protocols the stack doesn't implement have already
+		// been delivered to any raw endpoints by the network layer.
+		if n.stack.demux.hasRawEndpoints(pkt.NetworkProtocolNumber, protocol) {
+			return TransportPacketHandled
+		}
 		n.stats.unknownL4ProtocolRcvdPacketCounts.Increment(uint64(protocol))
 		return TransportPacketProtocolUnreachable
 	}
@@ -222,7 +222,7 @@ func TestPacketWithUnknownNetworkProtocolNumber(t *testing.T) {
 
 func TestPacketWithUnknownTransportProtocolNumber(t *testing.T) {
 	nic := nic{
-		stack:   &Stack{},
+		stack:   &Stack{demux: &transportDemuxer{}},
 		stats:   makeNICStats(tcpip.NICStats{}.FillIn()),
 		enabled: atomicbitops.FromBool(true),
 	}
@@ -20,6 +20,7 @@ import (
 	"gvisor.dev/gvisor/pkg/buffer"
 	"gvisor.dev/gvisor/pkg/sync"
 	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 )
 
@@ -736,6 +737,15 @@ func (r Range) ToView() *buffer.View {
 	return newV
 }
 
+// Checksum returns a checksum over the data in r.
+func (r Range) Checksum() uint16 {
+	var cs checksum.Checksumer
+	r.iterate(func(v *buffer.View) {
+		cs.Add(v.AsSlice())
+	})
+	return cs.Checksum()
+}
+
 // iterate calls fn for each piece in r. fn is always called with a non-empty
 // slice.
 func (r Range) iterate(fn func(*buffer.View)) {
@@ -443,7 +443,7 @@ const (
 	AddressDisabled
 
 	// AddressTentative indicates an address is yet to pass DAD (IPv4 addresses
-	// are never tentative).
+	// are only tentative when address conflict detection is enabled).
 	AddressTentative
 
 	// AddressAssigned indicates an address is assigned.
@@ -595,8 +595,9 @@ const (
 	// destined to the address MUST NOT be accepted and MUST be silently
 	// dropped, and the address MUST NOT be used as a source address for
 	// outgoing packets. For IPv6, addresses are of this kind until NDP's
-	// Duplicate Address Detection (DAD) resolves. If DAD fails, the address
-	// is removed.
+	// Duplicate Address Detection (DAD) resolves. For IPv4, addresses are of
+	// this kind until address conflict detection resolves, if enabled. If DAD
+	// fails, the address is removed.
 	PermanentTentative AddressKind = iota
 
 	// Permanent is a permanent endpoint (vs. a temporary one) assigned to the
@@ -1056,8 +1057,51 @@ const (
 	CapabilitySaveRestore
 	CapabilityDisconnectOk
 	CapabilityLoopback
+	// CapabilityLinkState indicates that the link endpoint implements
+	// LinkStateEndpoint and notifies its dispatcher of link state changes
+	// through LinkStateDispatcher. Links of endpoints without it are always
+	// considered up.
+	CapabilityLinkState
 )
 
+// LinkStateEndpoint is implemented by link endpoints with CapabilityLinkState
+// to report the state of their link.
+type LinkStateEndpoint interface {
+	// LinkUp returns true if the link is up, e.g. if the underlying device has
+	// a carrier.
+	LinkUp() bool
+}
+
+// LinkStateDispatcher is implemented by network dispatchers that accept link
+// state changes. Link endpoints with CapabilityLinkState call
+// DeliverLinkState on their dispatcher, if it implements this interface,
+// whenever their link goes up or down.
+type LinkStateDispatcher interface {
+	// DeliverLinkState is called with the new state of the link.
+	DeliverLinkState(up bool)
+}
+
+// NICLinkStateDispatcher is the interface integrators of netstack must
+// implement to receive link state changes of NICs.
+type NICLinkStateDispatcher interface {
+	// OnNICLinkStateChanged is called when the link of the NIC with the given
+	// ID goes up or down.
+	//
+	// May be called concurrently, and must not call back into the stack.
+	OnNICLinkStateChanged(nicID tcpip.NICID, up bool)
+}
+
+// AddressConflictDispatcher is the interface integrators of netstack must
+// implement to receive the results of IPv4 address conflict detection.
+type AddressConflictDispatcher interface {
+	// OnAddressConflictDetectionResult is called when address conflict
+	// detection for an IPv4 address completes. A *DADDupAddrDetected result
+	// means that the address was removed from the NIC.
+	//
+	// May be called concurrently, and must not call back into the stack.
+	OnAddressConflictDetectionResult(nicID tcpip.NICID, addr tcpip.Address, res DADResult)
+}
+
 // LinkWriter is an interface that supports sending packets via a data-link
 // layer endpoint. It is used with QueueingDiscipline to batch writes from
 // upper layer endpoints.
@@ -1288,6 +1332,14 @@ type DuplicateAddressDetector interface {
 	DuplicateAddressProtocol() tcpip.NetworkProtocolNumber
 }
 
+// AddressAnnouncer is implemented by duplicate address detectors that can
+// announce an address to neighbors once it is assigned.
+type AddressAnnouncer interface {
+	// AnnounceAddress informs neighbors that the address is assigned to the
+	// interface.
+	AnnounceAddress(tcpip.Address) tcpip.Error
+}
+
 // LinkAddressResolver handles link address resolution for a network protocol.
 type LinkAddressResolver interface {
 	// LinkAddressRequest sends a request for the link address of the target
@@ -1314,6 +1366,10 @@ type RawFactory interface {
 	// be used to write arbitrary packets that include the network header.
 	NewUnassociatedEndpoint(stack *Stack, netProto tcpip.NetworkProtocolNumber, transProto tcpip.TransportProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, tcpip.Error)
 
+	// NewAssociatedEndpoint produces endpoints for reading and writing
+	// packets of a transport protocol the stack has no implementation for.
+	NewAssociatedEndpoint(stack *Stack, netProto tcpip.NetworkProtocolNumber, transProto tcpip.TransportProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, tcpip.Error)
+
 	// NewPacketEndpoint produces endpoints for reading and writing packets
 	// that include network and (when cooked is false) link layer headers.
 	NewPacketEndpoint(stack *Stack, cooked bool, netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, tcpip.Error)
@@ -477,6 +477,12 @@ func (r *Route) isValidForOutgoingRLocked() bool {
 		return false
 	}
 
+	// Routes held across a link down event stop being usable until the link
+	// comes back up. Packets that are only looped back never reach the link.
+	if r.Loop() != PacketLoop && !r.outgoingNIC.LinkUp() {
+		return false
+	}
+
 	localAddressEndpoint := r.localAddressEndpoint
 	if localAddressEndpoint == nil || !r.localAddressNIC.isValidForOutgoing(localAddressEndpoint) {
 		return false
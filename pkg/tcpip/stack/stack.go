@@ -23,7 +23,9 @@ import (
 	"encoding/binary"
 	"fmt"
 	"io"
+	"math"
 	"math/rand"
+	"sort"
 	"sync/atomic"
 	"time"
 
@@ -117,6 +119,14 @@ type Stack struct {
 	// handleLocal allows non-loopback interfaces to loop packets.
 	handleLocal bool
 
+	// ipv4AddressConflictDetection enables probing and announcing IPv4
+	// addresses when they are added to an interface.
+	ipv4AddressConflictDetection bool
+
+	// addressConflictDisp is the dispatcher that is used to send the netstack
+	// integrator the results of IPv4 address conflict detection.
+	addressConflictDisp AddressConflictDispatcher
+
 	// tables are the iptables packet filtering and manipulation rules.
 	// TODO(gvisor.dev/issue/4595): S/R this field.
 	tables *IPTables
@@ -145,6 +155,10 @@ type Stack struct {
 	// integrator NUD related events.
 	nudDisp NUDDispatcher
 
+	// linkStateDisp is the dispatcher that is used to send the netstack
+	// integrator link state changes of NICs.
+	linkStateDisp NICLinkStateDispatcher
+
 	// uniqueIDGenerator is a generator of unique identifiers.
 	uniqueIDGenerator UniqueID
 
@@ -179,6 +193,20 @@ type Stack struct {
 	// tsOffsetSecret is the secret key for generating timestamp offsets
 	// initialized at stack startup.
 	tsOffsetSecret uint32
+
+	// receiveMemoryLimit is the maximum number of bytes that may be held in
+	// the receive queues of all transport endpoints. Zero means no limit. It
+	// is set during Stack creation and is immutable.
+	receiveMemoryLimit int64
+
+	// receiveMemoryUsed is the number of bytes currently held in the receive
+	// queues of all transport endpoints. It is only maintained when
+	// receiveMemoryLimit is non-zero.
+	receiveMemoryUsed atomicbitops.Int64
+
+	// receiveMemoryConsumers is the number of ReceiveMemoryAccounts with
+	// memory charged to them.
+	receiveMemoryConsumers atomicbitops.Int64
 }
 
 // UniqueID is an abstract generator of unique identifiers.
@@ -229,6 +257,10 @@ type Options struct {
 	// receive NUD related events.
 	NUDDisp NUDDispatcher
 
+	// LinkStateDisp is the link state dispatcher that an integrator can
+	// provide to be notified when the link of a NIC goes up or down.
+	LinkStateDisp NICLinkStateDispatcher
+
 	// RawFactory produces raw endpoints. Raw endpoints are enabled only if
 	// this is non-nil.
 	RawFactory RawFactory
@@ -256,6 +288,34 @@ type Options struct {
 
 	// SecureRNG is a cryptographically secure random number generator.
 	SecureRNG io.Reader
+
+	// IPv4AddressConflictDetection enables IPv4 address conflict detection as
+	// described in RFC 5227.
+	//
+	// When enabled, an IPv4 address added to an interface that requires link
+	// resolution is tentative until it has been probed for with ARP using the
+	// ARP protocol's DAD configurations. Addresses added while the interface
+	// is disabled are probed for when it is enabled. If a neighbor replies
+	// claiming the address, the address is removed from the interface.
+	// Otherwise, the address is assigned and announced with a gratuitous ARP
+	// request.
+	IPv4AddressConflictDetection bool
+
+	// AddressConflictDisp is the dispatcher that an integrator can provide to
+	// be notified of the results of IPv4 address conflict detection.
+	AddressConflictDisp AddressConflictDispatcher
+
+	// ReceiveMemoryLimit is the maximum number of bytes that may be held in
+	// the receive queues of all transport endpoints combined. Zero means no
+	// limit.
+	//
+	// The limit applies on top of each endpoint's own receive buffer size,
+	// which acts as the endpoint's hard limit. Once three quarters of the
+	// limit are in use, endpoints holding more than their fair share of
+	// those three quarters are throttled: TCP closes their advertised window
+	// and UDP drops their datagrams. Endpoints holding less may use the
+	// remaining memory, up to the limit.
+	ReceiveMemoryLimit int64
 }
 
 // TransportEndpointInfo holds useful information about a transport endpoint
@@ -388,12 +448,15 @@ func New(opts Options) *Stack {
 		clock:                        clock,
 		stats:                        opts.Stats.FillIn(),
 		handleLocal:                  opts.HandleLocal,
+		ipv4AddressConflictDetection: opts.IPv4AddressConflictDetection,
+		addressConflictDisp:          opts.AddressConflictDisp,
 		tables:                       opts.IPTables,
 		icmpRateLimiter:              NewICMPRateLimiter(clock),
 		seed:                         secureRNG.Uint32(),
 		nudConfigs:                   opts.NUDConfigs,
 		uniqueIDGenerator:            opts.UniqueID,
 		nudDisp:                      opts.NUDDisp,
+		linkStateDisp:                opts.LinkStateDisp,
 		insecureRNG:                  insecureRNG,
 		secureRNG:                    secureRNG,
 		sendBufferSize: tcpip.SendBufferSizeOption{
@@ -408,6 +471,7 @@ func New(opts Options) *Stack {
 		},
 		tcpInvalidRateLimit: defaultTCPInvalidRateLimit,
 		tsOffsetSecret:      secureRNG.Uint32(),
+		receiveMemoryLimit:  opts.ReceiveMemoryLimit,
 	}
 
 	// Add specified network protocols.
@@ -531,6 +595,134 @@ func (s *Stack) Stats() tcpip.Stats {
 	return s.stats
 }
 
+// receiveMemoryPressureFraction is the inverse of the fraction of the
+// stack-wide receive memory limit above which the stack is under memory
+// pressure.
+const receiveMemoryPressureFraction = 4
+
+// ReceiveMemoryAccount tracks the receive memory charged by a single
+// transport endpoint against the stack-wide receive memory limit.
+//
+// +stateify savable
+type ReceiveMemoryAccount struct {
+	// used is the number of bytes charged to this account. It is not saved;
+	// restored endpoints charge what they hold to the new stack.
+	used atomicbitops.Int64 `state:"nosave"`
+}
+
+// Used returns the number of bytes charged to a.
+func (a *ReceiveMemoryAccount) Used() int64 {
+	return a.used.Load()
+}
+
+// receiveMemoryPressure returns the stack-wide receive memory usage above
+// which endpoints are limited to their fair share.
+func (s *Stack) receiveMemoryPressure() int64 {
+	return s.receiveMemoryLimit - s.receiveMemoryLimit/receiveMemoryPressureFraction
+}
+
+// receiveMemoryAvailable returns the number of bytes that may still be
+// charged to a, given used bytes charged across the stack.
+//
+// Endpoints may use memory freely until the stack is under pressure. Past
+// that point an endpoint may only grow up to its fair share, the pressure
+// threshold divided by the number of endpoints holding memory. This throttles
+// the heaviest consumers first and leaves the remaining memory to the others.
+// The limit itself is never exceeded.
+func (s *Stack) receiveMemoryAvailable(a *ReceiveMemoryAccount, used int64) int64 {
+	avail := s.receiveMemoryLimit - used
+	pressure := s.receiveMemoryPressure()
+	aUsed := a.used.Load()
+	consumers := s.receiveMemoryConsumers.Load()
+	if aUsed == 0 {
+		consumers++
+	}
+	share := pressure/consumers - aUsed
+	if free := pressure - used; share < free {
+		share = free
+	}
+	if share < avail {
+		avail = share
+	}
+	if avail < 0 {
+		avail = 0
+	}
+	return avail
+}
+
+// chargeReceiveMemoryAccount adds n bytes to a and keeps track of the number
+// of accounts holding memory.
+func (s *Stack) chargeReceiveMemoryAccount(a *ReceiveMemoryAccount, n int64) {
+	used := a.used.Add(n)
+	switch old := used - n; {
+	case old <= 0 && used > 0:
+		s.receiveMemoryConsumers.Add(1)
+	case old > 0 && used <= 0:
+		s.receiveMemoryConsumers.Add(-1)
+	}
+}
+
+// ReserveReceiveMemory charges n bytes to a. It returns false without
+// charging anything if a may not hold n more bytes, see
+// ReceiveMemoryAvailable.
+//
+// Every successful reservation must be balanced by a call to
+// ReleaseReceiveMemory.
+func (s *Stack) ReserveReceiveMemory(a *ReceiveMemoryAccount, n int) bool {
+	if s.receiveMemoryLimit == 0 {
+		return true
+	}
+	for {
+		used := s.receiveMemoryUsed.Load()
+		if int64(n) > s.receiveMemoryAvailable(a, used) {
+			return false
+		}
+		if s.receiveMemoryUsed.CompareAndSwap(used, used+int64(n)) {
+			s.chargeReceiveMemoryAccount(a, int64(n))
+			return true
+		}
+	}
+}
+
+// ChargeReceiveMemory unconditionally charges n bytes to a. It is used by
+// endpoints that apply backpressure through other means, such as TCP's
+// receive window.
+func (s *Stack) ChargeReceiveMemory(a *ReceiveMemoryAccount, n int) {
+	if s.receiveMemoryLimit == 0 {
+		return
+	}
+	s.receiveMemoryUsed.Add(int64(n))
+	s.chargeReceiveMemoryAccount(a, int64(n))
+}
+
+// ReleaseReceiveMemory releases n bytes previously charged to a with
+// ReserveReceiveMemory or ChargeReceiveMemory.
+func (s *Stack) ReleaseReceiveMemory(a *ReceiveMemoryAccount, n int) {
+	s.ChargeReceiveMemory(a, -n)
+}
+
+// ReceiveMemoryAvailable returns the number of bytes that may still be
+// charged to a, and whether a stack-wide receive memory limit is configured
+// at all.
+//
+// An endpoint is limited by its own receive buffer size first. On top of
+// that, once more than three quarters of the stack-wide limit are in use, it
+// may only hold its fair share of those three quarters. The rest is left for
+// endpoints holding less.
+func (s *Stack) ReceiveMemoryAvailable(a *ReceiveMemoryAccount) (int, bool) {
+	if s.receiveMemoryLimit == 0 {
+		return 0, false
+	}
+	return int(s.receiveMemoryAvailable(a, s.receiveMemoryUsed.Load())), true
+}
+
+// ReceiveMemoryUsed returns the number of bytes currently charged against the
+// stack-wide receive memory limit. It is always zero when no limit is
+// configured.
+func (s *Stack) ReceiveMemoryUsed() int64 {
+	return s.receiveMemoryUsed.Load()
+}
+
 // SetNICForwarding enables or disables packet forwarding on the specified NIC
 // for the passed protocol.
 //
@@ -761,8 +953,16 @@ func (s *Stack) SetGROTimeout(nicID tcpip.NICID, timeout time.Duration) tcpip.Er
 // SetRouteTable assigns the route table to be used by this stack. It
 // specifies which NIC to use for given destination address ranges.
 //
+// Routes are selected by longest prefix match, so the table is sorted by
+// decreasing destination prefix length. Routes with the same prefix length
+// keep their relative order and the first viable one wins.
+//
 // This method takes ownership of the table.
 func (s *Stack) SetRouteTable(table []tcpip.Route) {
+	sort.SliceStable(table, func(i, j int) bool {
+		return table[i].Destination.Prefix() > table[j].Destination.Prefix()
+	})
+
 	s.routeMu.Lock()
 	defer s.routeMu.Unlock()
 	s.routeTable = table
@@ -775,11 +975,17 @@ func (s *Stack) GetRouteTable() []tcpip.Route {
 	return append([]tcpip.Route(nil), s.routeTable...)
 }
 
-// AddRoute appends a route to the route table.
+// AddRoute adds a route to the route table. It is placed after every route
+// with a destination prefix at least as long as its own.
 func (s *Stack) AddRoute(route tcpip.Route) {
 	s.routeMu.Lock()
 	defer s.routeMu.Unlock()
-	s.routeTable = append(s.routeTable, route)
+	i := sort.Search(len(s.routeTable), func(i int) bool {
+		return s.routeTable[i].Destination.Prefix() < route.Destination.Prefix()
+	})
+	s.routeTable = append(s.routeTable, tcpip.Route{})
+	copy(s.routeTable[i+1:], s.routeTable[i:])
+	s.routeTable[i] = route
 }
 
 // RemoveRoutes removes matching routes from the route table.
@@ -821,7 +1027,12 @@ func (s *Stack) NewRawEndpoint(transport tcpip.TransportProtocolNumber, network
 
 	t, ok := s.transportProtocols[transport]
 	if !ok {
-		return nil, &tcpip.ErrUnknownProtocol{}
+		// Transport protocols the stack doesn't implement are only
+		// accessible through raw endpoints.
+		if _, ok := s.networkProtocols[network]; !ok || transport > math.MaxUint8 {
+			return nil, &tcpip.ErrUnknownProtocol{}
+		}
+		return s.rawFactory.NewAssociatedEndpoint(s, network, transport, waiterQueue)
 	}
 
 	return t.proto.NewRawEndpoint(network, waiterQueue)
@@ -1069,7 +1280,7 @@ func (s *Stack) NICInfo() map[tcpip.NICID]NICInfo {
 	for id, nic := range s.nics {
 		flags := NICStateFlags{
 			Up:          true, // Netstack interfaces are always up.
-			Running:     nic.Enabled(),
+			Running:     nic.Enabled() && nic.LinkUp(),
 			Promiscuous: nic.Promiscuous(),
 			Loopback:    nic.IsLoopback(),
 		}
@@ -1113,7 +1324,7 @@ type NICStateFlags struct {
 	// Up indicates whether the interface is running.
 	Up bool
 
-	// Running indicates whether resources are allocated.
+	// Running indicates whether resources are allocated and the link is up.
 	Running bool
 
 	// Promiscuous indicates whether the interface is in promiscuous mode.
@@ -1302,6 +1513,12 @@ func (s *Stack) HandleLocal() bool {
 	return s.handleLocal
 }
 
+// IPv4AddressConflictDetection returns true if IPv4 addresses are probed for
+// before they are assigned to interfaces that require link resolution.
+func (s *Stack) IPv4AddressConflictDetection() bool {
+	return s.ipv4AddressConflictDetection
+}
+
 func isNICForwarding(nic *nic, proto tcpip.NetworkProtocolNumber) bool {
 	switch forwarding, err := nic.forwarding(proto); err.(type) {
 	case nil:
@@ -1373,7 +1590,7 @@ func (s *Stack) FindRoute(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, n
 	// If the interface is specified and we do not need a route, return a route
 	// through the interface if the interface is valid and enabled.
 	if id != 0 && !needRoute {
-		if nic, ok := s.nics[id]; ok && nic.Enabled() {
+		if nic, ok := s.nics[id]; ok && nic.Enabled() && nic.LinkUp() {
 			if addressEndpoint := s.getAddressEP(nic, localAddr, remoteAddr, tcpip.Address{} /* srcHint */, netProto); addressEndpoint != nil {
 				return makeRoute(
 					netProto,
@@ -1408,8 +1625,10 @@ func (s *Stack) FindRoute(id tcpip.NICID, localAddr, remoteAddr tcpip.Address, n
 				continue
 			}
 
+			// Routes over a NIC whose link is down are unusable until the link
+			// comes back up.
 			nic, ok := s.nics[route.NIC]
-			if !ok || !nic.Enabled() {
+			if !ok || !nic.Enabled() || !nic.LinkUp() {
 				continue
 			}
 
@@ -1446,6 +1446,68 @@ func TestAddressRemovalWithRouteHeld(t *testing.T) {
 	}
 }
 
+func TestMultipleAddressesAddRemove(t *testing.T) {
+	localAddrBytes := []byte{0x01, 0x02, 0x03}
+
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{fakeNetFactory},
+	})
+
+	ep := channel.New(10, defaultMTU, "")
+	if err := s.CreateNIC(1, ep); err != nil {
+		t.Fatal("CreateNIC failed:", err)
+	}
+	fakeNet := s.NetworkProtocolInstance(fakeNetNumber).(*fakeNetworkProtocol)
+	buf := make([]byte, 30)
+
+	protocolAddr := func(b byte) tcpip.ProtocolAddress {
+		return tcpip.ProtocolAddress{
+			Protocol: fakeNetNumber,
+			AddressWithPrefix: tcpip.AddressWithPrefix{
+				Address:   tcpip.AddrFromSlice([]byte{b, 0, 0, 0}),
+				PrefixLen: fakeDefaultPrefixLen,
+			},
+		}
+	}
+	for _, b := range localAddrBytes {
+		if err := s.AddProtocolAddress(1, protocolAddr(b), stack.AddressProperties{}); err != nil {
+			t.Fatalf("AddProtocolAddress(%d, %+v, {}): %s", 1, protocolAddr(b), err)
+		}
+	}
+
+	// Packets to every address are delivered.
+	for _, b := range localAddrBytes {
+		buf[dstAddrOffset] = b
+		testRecv(t, fakeNet, b, ep, buf)
+	}
+
+	const removed byte = 0x02
+	removedAddr := protocolAddr(removed)
+	for i := 0; i < 3; i++ {
+		// Removing one address leaves the others working.
+		if err := s.RemoveAddress(1, removedAddr.AddressWithPrefix.Address); err != nil {
+			t.Fatalf("RemoveAddress(1, %s): %s", removedAddr.AddressWithPrefix.Address, err)
+		}
+		for _, b := range localAddrBytes {
+			buf[dstAddrOffset] = b
+			if b == removed {
+				testFailingRecv(t, fakeNet, b, ep, buf)
+			} else {
+				testRecv(t, fakeNet, b, ep, buf)
+			}
+		}
+
+		// Adding it back restores delivery.
+		if err := s.AddProtocolAddress(1, removedAddr, stack.AddressProperties{}); err != nil {
+			t.Fatalf("AddProtocolAddress(%d, %+v, {}): %s", 1, removedAddr, err)
+		}
+		for _, b := range localAddrBytes {
+			buf[dstAddrOffset] = b
+			testRecv(t, fakeNet, b, ep, buf)
+		}
+	}
+}
+
 func verifyAddress(t *testing.T, s *stack.Stack, nicID tcpip.NICID, addr tcpip.Address) {
 	t.Helper()
 	info, ok := s.NICInfo()[nicID]
@@ -2657,6 +2719,146 @@ func TestNICContextPreservation(t *testing.T) {
 	}
 }
 
+type linkStateEvent struct {
+	nicID tcpip.NICID
+	up    bool
+}
+
+type linkStateDispatcher struct {
+	events chan linkStateEvent
+}
+
+// OnNICLinkStateChanged implements stack.NICLinkStateDispatcher.
+func (d *linkStateDispatcher) OnNICLinkStateChanged(nicID tcpip.NICID, up bool) {
+	d.events <- linkStateEvent{nicID: nicID, up: up}
+}
+
+// TestNICLinkState tests that link state changes are reported to the
+// integrator and that routes over a NIC whose link is down are not used.
+func TestNICLinkState(t *testing.T) {
+	const (
+		nicID1 = 1
+		nicID2 = 2
+		nicID3 = 3
+	)
+	remoteAddr := testutil.MustParse4("192.168.0.1")
+
+	disp := linkStateDispatcher{events: make(chan linkStateEvent, 10)}
+	s := stack.New(stack.Options{
+		NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		LinkStateDisp:    &disp,
+	})
+	defer s.Destroy()
+
+	// Only ep1 reports its link state.
+	ep1 := channel.New(1, defaultMTU, "")
+	ep1.LinkEPCapabilities |= stack.CapabilityLinkState
+	ep2 := channel.New(0, defaultMTU, "")
+	for i, ep := range []*channel.Endpoint{ep1, ep2} {
+		nicID := tcpip.NICID(i + 1)
+		if err := s.CreateNIC(nicID, ep); err != nil {
+			t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+		}
+		protocolAddr := tcpip.ProtocolAddress{
+			Protocol: ipv4.ProtocolNumber,
+			AddressWithPrefix: tcpip.AddressWithPrefix{
+				Address:   tcpip.AddrFrom4([4]byte{10, 0, byte(nicID), 1}),
+				PrefixLen: 24,
+			},
+		}
+		if err := s.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+			t.Fatalf("AddProtocolAddress(%d, %+v, {}): %s", nicID, protocolAddr, err)
+		}
+	}
+	s.SetRouteTable([]tcpip.Route{
+		{Destination: header.IPv4EmptySubnet, NIC: nicID1},
+		{Destination: header.IPv4EmptySubnet, NIC: nicID2},
+	})
+
+	checkRoute := func(t *testing.T, wantNIC tcpip.NICID) {
+		t.Helper()
+		r, err := s.FindRoute(0, tcpip.Address{}, remoteAddr, ipv4.ProtocolNumber, false /* multicastLoop */)
+		if err != nil {
+			t.Fatalf("FindRoute(0, '', %s, %d, false): %s", remoteAddr, ipv4.ProtocolNumber, err)
+		}
+		defer r.Release()
+		if got := r.NICID(); got != wantNIC {
+			t.Errorf("got r.NICID() = %d, want = %d", got, wantNIC)
+		}
+	}
+	checkRunning := func(t *testing.T, nicID tcpip.NICID, want bool) {
+		t.Helper()
+		if got := s.NICInfo()[nicID].Flags.Running; got != want {
+			t.Errorf("got NICInfo()[%d].Flags.Running = %t, want = %t", nicID, got, want)
+		}
+	}
+	checkEvent := func(t *testing.T, want linkStateEvent) {
+		t.Helper()
+		select {
+		case got := <-disp.events:
+			if got != want {
+				t.Errorf("got link state event = %+v, want = %+v", got, want)
+			}
+		default:
+			t.Errorf("no link state event, want = %+v", want)
+		}
+	}
+	checkNoEvent := func(t *testing.T) {
+		t.Helper()
+		select {
+		case got := <-disp.events:
+			t.Errorf("got unexpected link state event = %+v", got)
+		default:
+		}
+	}
+
+	checkRunning(t, nicID1, true)
+	checkRoute(t, nicID1)
+
+	// Routes acquired while the link is up can't be used while it is down.
+	r, err := s.FindRoute(nicID1, tcpip.Address{}, remoteAddr, ipv4.ProtocolNumber, false /* multicastLoop */)
+	if err != nil {
+		t.Fatalf("FindRoute(%d, '', %s, %d, false): %s", nicID1, remoteAddr, ipv4.ProtocolNumber, err)
+	}
+	defer r.Release()
+	testSend(t, r, ep1, nil)
+
+	ep1.SetLinkUp(false)
+	checkEvent(t, linkStateEvent{nicID: nicID1, up: false})
+	checkRunning(t, nicID1, false)
+	checkRoute(t, nicID2)
+	testFailingSend(t, r, nil, &tcpip.ErrInvalidEndpointState{})
+	if _, err := s.FindRoute(nicID1, tcpip.Address{}, remoteAddr, ipv4.ProtocolNumber, false /* multicastLoop */); err == nil {
+		t.Errorf("got FindRoute(%d, '', %s, %d, false) = nil error, want route to be unusable", nicID1, remoteAddr, ipv4.ProtocolNumber)
+	}
+
+	// Repeating the state is not reported.
+	ep1.SetLinkUp(false)
+	checkNoEvent(t)
+
+	// The state of endpoints without CapabilityLinkState is ignored.
+	ep2.SetLinkUp(false)
+	checkNoEvent(t)
+	checkRunning(t, nicID2, true)
+	checkRoute(t, nicID2)
+
+	ep1.SetLinkUp(true)
+	checkEvent(t, linkStateEvent{nicID: nicID1, up: true})
+	checkRunning(t, nicID1, true)
+	checkRoute(t, nicID1)
+	testSend(t, r, ep1, nil)
+
+	// NICs start with the state of their link.
+	ep3 := channel.New(0, defaultMTU, "")
+	ep3.LinkEPCapabilities |= stack.CapabilityLinkState
+	ep3.SetLinkUp(false)
+	if err := s.CreateNIC(nicID3, ep3); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", nicID3, err)
+	}
+	checkNoEvent(t)
+	checkRunning(t, nicID3, false)
+}
+
 // TestNICAutoGenLinkLocalAddr tests the auto-generation of IPv6 link-local
 // addresses.
 func TestNICAutoGenLinkLocalAddr(t *testing.T) {
@@ -3922,6 +4124,82 @@ func TestStackReceiveBufferSizeOption(t *testing.T) {
 	}
 }
 
+// TestReceiveMemoryAccounting tests that once the stack is under receive
+// memory pressure, only endpoints holding more than their fair share are
+// throttled, and that the stack-wide limit is never exceeded.
+func TestReceiveMemoryAccounting(t *testing.T) {
+	const (
+		limit = 4000
+		// pressure is three quarters of limit.
+		pressure = 3000
+	)
+	s := stack.New(stack.Options{ReceiveMemoryLimit: limit})
+	defer s.Close()
+
+	var heavy, light stack.ReceiveMemoryAccount
+	reserve := func(name string, a *stack.ReceiveMemoryAccount, n int, want bool) {
+		t.Helper()
+		if got := s.ReserveReceiveMemory(a, n); got != want {
+			t.Errorf("got s.ReserveReceiveMemory(%s, %d) = %t, want = %t", name, n, got, want)
+		}
+	}
+	available := func(name string, a *stack.ReceiveMemoryAccount, want int) {
+		t.Helper()
+		if got, ok := s.ReceiveMemoryAvailable(a); !ok || got != want {
+			t.Errorf("got s.ReceiveMemoryAvailable(%s) = (%d, %t), want = (%d, true)", name, got, ok, want)
+		}
+	}
+
+	// A lone endpoint may use memory up to the pressure threshold.
+	reserve("heavy", &heavy, pressure, true)
+	reserve("heavy", &heavy, 1, false)
+	available("heavy", &heavy, 0)
+
+	// Another endpoint may use what is left, up to the limit.
+	available("light", &light, limit-pressure)
+	reserve("light", &light, limit-pressure, true)
+	reserve("light", &light, 1, false)
+	if got := s.ReceiveMemoryUsed(); got != limit {
+		t.Errorf("got s.ReceiveMemoryUsed() = %d, want = %d", got, limit)
+	}
+
+	// Once below the pressure threshold, an endpoint may grow up to it
+	// regardless of its share.
+	s.ReleaseReceiveMemory(&heavy, 2000)
+	available("heavy", &heavy, pressure-(limit-2000))
+	available("light", &light, pressure-(limit-2000))
+
+	// Under pressure, the fair share is the pressure threshold split between
+	// the endpoints holding memory.
+	s.ChargeReceiveMemory(&heavy, 1000)
+	available("heavy", &heavy, 0)
+	available("light", &light, pressure/2-(limit-pressure))
+
+	s.ReleaseReceiveMemory(&heavy, int(heavy.Used()))
+	s.ReleaseReceiveMemory(&light, int(light.Used()))
+	if got := s.ReceiveMemoryUsed(); got != 0 {
+		t.Errorf("got s.ReceiveMemoryUsed() = %d, want = 0", got)
+	}
+}
+
+// TestReceiveMemoryNoLimit tests that no receive memory is accounted for when
+// no limit is configured.
+func TestReceiveMemoryNoLimit(t *testing.T) {
+	s := stack.New(stack.Options{})
+	defer s.Close()
+
+	var a stack.ReceiveMemoryAccount
+	if !s.ReserveReceiveMemory(&a, math.MaxInt32) {
+		t.Errorf("got s.ReserveReceiveMemory(_, %d) = false, want = true", math.MaxInt32)
+	}
+	if _, ok := s.ReceiveMemoryAvailable(&a); ok {
+		t.Error("got s.ReceiveMemoryAvailable(_) = (_, true), want = (_, false)")
+	}
+	if got := s.ReceiveMemoryUsed(); got != 0 {
+		t.Errorf("got s.ReceiveMemoryUsed() = %d, want = 0", got)
+	}
+}
+
 func TestStackSendBufferSizeOption(t *testing.T) {
 	const sMin = stack.MinBufferSize
 	testCases := []struct {
@@ -4455,6 +4733,104 @@ func TestRemoveRoutes(t *testing.T) {
 	}
 }
 
+// TestFindRouteLongestPrefixMatch tests that the most specific route to a
+// destination is used regardless of the order in which routes were added,
+// and that the default route is used when nothing else matches.
+func TestFindRouteLongestPrefixMatch(t *testing.T) {
+	const (
+		nicID1 = 1
+		nicID2 = 2
+		nicID3 = 3
+	)
+	var (
+		nic1Addr = testutil.MustParse4("10.0.0.1")
+		nic2Addr = testutil.MustParse4("10.1.0.1")
+		nic3Addr = testutil.MustParse4("192.168.0.1")
+		gateway  = testutil.MustParse4("192.168.0.254")
+
+		defaultRoute = tcpip.Route{Destination: header.IPv4EmptySubnet, Gateway: gateway, NIC: nicID3}
+		route8       = tcpip.Route{Destination: tcpip.AddressWithPrefix{Address: nic1Addr, PrefixLen: 8}.Subnet(), NIC: nicID1}
+		route16      = tcpip.Route{Destination: tcpip.AddressWithPrefix{Address: nic2Addr, PrefixLen: 16}.Subnet(), NIC: nicID2}
+	)
+
+	tests := []struct {
+		name      string
+		setRoutes func(*stack.Stack)
+	}{
+		{
+			name: "SetRouteTable",
+			setRoutes: func(s *stack.Stack) {
+				s.SetRouteTable([]tcpip.Route{defaultRoute, route8, route16})
+			},
+		},
+		{
+			name: "AddRoute",
+			setRoutes: func(s *stack.Stack) {
+				s.AddRoute(defaultRoute)
+				s.AddRoute(route8)
+				s.AddRoute(route16)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+			})
+			for _, nic := range []struct {
+				id   tcpip.NICID
+				addr tcpip.Address
+			}{
+				{id: nicID1, addr: nic1Addr},
+				{id: nicID2, addr: nic2Addr},
+				{id: nicID3, addr: nic3Addr},
+			} {
+				if err := s.CreateNIC(nic.id, channel.New(0, defaultMTU, "")); err != nil {
+					t.Fatalf("CreateNIC(%d, _): %s", nic.id, err)
+				}
+				protocolAddr := tcpip.ProtocolAddress{
+					Protocol:          ipv4.ProtocolNumber,
+					AddressWithPrefix: nic.addr.WithPrefix(),
+				}
+				if err := s.AddProtocolAddress(nic.id, protocolAddr, stack.AddressProperties{}); err != nil {
+					t.Fatalf("AddProtocolAddress(%d, %+v, {}): %s", nic.id, protocolAddr, err)
+				}
+			}
+			test.setRoutes(s)
+
+			if diff := cmp.Diff([]tcpip.Route{route16, route8, defaultRoute}, s.GetRouteTable()); diff != "" {
+				t.Errorf("route table mismatch (-want +got):\n%s", diff)
+			}
+
+			for _, dst := range []struct {
+				remoteAddr  tcpip.Address
+				wantNIC     tcpip.NICID
+				wantNextHop tcpip.Address
+			}{
+				// Matches all routes; the on-link /16 is the most specific.
+				{remoteAddr: testutil.MustParse4("10.1.2.3"), wantNIC: nicID2},
+				// Matches the on-link /8 and the default route.
+				{remoteAddr: testutil.MustParse4("10.2.0.1"), wantNIC: nicID1},
+				// Only matches the default route, which goes through the gateway.
+				{remoteAddr: testutil.MustParse4("8.8.8.8"), wantNIC: nicID3, wantNextHop: gateway},
+			} {
+				r, err := s.FindRoute(0, tcpip.Address{}, dst.remoteAddr, ipv4.ProtocolNumber, false /* multicastLoop */)
+				if err != nil {
+					t.Fatalf("FindRoute(0, '', %s, %d, false): %s", dst.remoteAddr, ipv4.ProtocolNumber, err)
+				}
+				if got := r.NICID(); got != dst.wantNIC {
+					t.Errorf("got FindRoute(0, '', %s, %d, false).NICID() = %d, want = %d", dst.remoteAddr, ipv4.ProtocolNumber, got, dst.wantNIC)
+				}
+				if got := r.NextHop(); got != dst.wantNextHop {
+					t.Errorf("got FindRoute(0, '', %s, %d, false).NextHop() = %s, want = %s", dst.remoteAddr, ipv4.ProtocolNumber, got, dst.wantNextHop)
+				}
+				r.Release()
+			}
+		})
+	}
+}
+
 func TestFindRouteWithForwarding(t *testing.T) {
 	const (
 		nicID1 = 1
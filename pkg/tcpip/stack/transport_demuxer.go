@@ -272,6 +272,14 @@ type transportDemuxer struct {
 	// protocol is immutable.
 	protocol        map[protocolIDs]*transportEndpoints
 	queuedProtocols map[protocolIDs]queuedTransportProtocol
+
+	rawOnlyMu rawOnlyProtocolsRWMutex
+	// rawOnlyProtocols holds the raw endpoints of transport protocols the
+	// stack has no implementation for. Entries are created when the first
+	// raw endpoint for a protocol pair is registered and are never removed.
+	//
+	// +checklocks:rawOnlyMu
+	rawOnlyProtocols map[protocolIDs]*transportEndpoints
 }
 
 // queuedTransportProtocol if supported by a protocol implementation will cause
@@ -283,9 +291,10 @@ type queuedTransportProtocol interface {
 
 func newTransportDemuxer(stack *Stack) *transportDemuxer {
 	d := &transportDemuxer{
-		stack:           stack,
-		protocol:        make(map[protocolIDs]*transportEndpoints),
-		queuedProtocols: make(map[protocolIDs]queuedTransportProtocol),
+		stack:            stack,
+		protocol:         make(map[protocolIDs]*transportEndpoints),
+		queuedProtocols:  make(map[protocolIDs]queuedTransportProtocol),
+		rawOnlyProtocols: make(map[protocolIDs]*transportEndpoints),
 	}
 
 	// Add each network and transport pair to the demuxer.
@@ -552,9 +561,9 @@ func (d *transportDemuxer) deliverPacket(protocol tcpip.TransportProtocolNumber,
 		return false
 	}
 
-	// If the packet is a UDP broadcast or multicast, then find all matching
-	// transport endpoints.
-	if protocol == header.UDPProtocolNumber && isInboundMulticastOrBroadcast(pkt, id.LocalAddress) {
+	// If the packet is a UDP or UDP-Lite broadcast or multicast, then find all
+	// matching transport endpoints.
+	if (protocol == header.UDPProtocolNumber || protocol == header.UDPLiteProtocolNumber) && isInboundMulticastOrBroadcast(pkt, id.LocalAddress) {
 		eps.mu.RLock()
 		destEPs := eps.findAllEndpointsLocked(id)
 		eps.mu.RUnlock()
@@ -589,7 +598,7 @@ func (d *transportDemuxer) deliverPacket(protocol tcpip.TransportProtocolNumber,
 	ep := eps.findEndpointLocked(id)
 	eps.mu.RUnlock()
 	if ep == nil {
-		if protocol == header.UDPProtocolNumber {
+		if protocol == header.UDPProtocolNumber || protocol == header.UDPLiteProtocolNumber {
 			d.stack.stats.UDP.UnknownPortErrors.Increment()
 		}
 		return false
@@ -600,7 +609,7 @@ func (d *transportDemuxer) deliverPacket(protocol tcpip.TransportProtocolNumber,
 // deliverRawPacket attempts to deliver the given packet and returns whether it
 // was delivered successfully.
 func (d *transportDemuxer) deliverRawPacket(protocol tcpip.TransportProtocolNumber, pkt *PacketBuffer) bool {
-	eps, ok := d.protocol[protocolIDs{pkt.NetworkProtocolNumber, protocol}]
+	eps, ok := d.rawTransportEndpoints(pkt.NetworkProtocolNumber, protocol, false /* create */)
 	if !ok {
 		return false
 	}
@@ -685,7 +694,7 @@ func (d *transportDemuxer) findTransportEndpoint(netProto tcpip.NetworkProtocolN
 // packet can be sent to one or more raw endpoints along with a non-raw
 // endpoint.
 func (d *transportDemuxer) registerRawEndpoint(netProto tcpip.NetworkProtocolNumber, transProto tcpip.TransportProtocolNumber, ep RawTransportEndpoint) tcpip.Error {
-	eps, ok := d.protocol[protocolIDs{netProto, transProto}]
+	eps, ok := d.rawTransportEndpoints(netProto, transProto, true /* create */)
 	if !ok {
 		return &tcpip.ErrNotSupported{}
 	}
@@ -700,7 +709,7 @@ func (d *transportDemuxer) registerRawEndpoint(netProto tcpip.NetworkProtocolNum
 // unregisterRawEndpoint unregisters the raw endpoint for the given transport
 // protocol such that it won't receive any more packets.
 func (d *transportDemuxer) unregisterRawEndpoint(netProto tcpip.NetworkProtocolNumber, transProto tcpip.TransportProtocolNumber, ep RawTransportEndpoint) {
-	eps, ok := d.protocol[protocolIDs{netProto, transProto}]
+	eps, ok := d.rawTransportEndpoints(netProto, transProto, false /* create */)
 	if !ok {
 		panic(fmt.Errorf("tried to unregister endpoint with unsupported network and transport protocol pair: %d, %d", netProto, transProto))
 	}
@@ -718,6 +727,49 @@ func (d *transportDemuxer) unregisterRawEndpoint(netProto tcpip.NetworkProtocolN
 	eps.mu.Unlock()
 }
 
+// rawTransportEndpoints returns the set that raw endpoints of the given
+// protocol pair are registered with. For transport protocols the stack has no
+// implementation for, the set is created on demand if create is true.
+func (d *transportDemuxer) rawTransportEndpoints(netProto tcpip.NetworkProtocolNumber, transProto tcpip.TransportProtocolNumber, create bool) (*transportEndpoints, bool) {
+	ids := protocolIDs{netProto, transProto}
+	if eps, ok := d.protocol[ids]; ok {
+		return eps, true
+	}
+
+	d.rawOnlyMu.RLock()
+	eps, ok := d.rawOnlyProtocols[ids]
+	d.rawOnlyMu.RUnlock()
+	if ok || !create {
+		return eps, ok
+	}
+	if _, ok := d.stack.networkProtocols[netProto]; !ok {
+		return nil, false
+	}
+
+	d.rawOnlyMu.Lock()
+	defer d.rawOnlyMu.Unlock()
+	if eps, ok := d.rawOnlyProtocols[ids]; ok {
+		return eps, true
+	}
+	eps = &transportEndpoints{
+		endpoints: make(map[TransportEndpointID]*endpointsByNIC),
+	}
+	d.rawOnlyProtocols[ids] = eps
+	return eps, true
+}
+
+// hasRawEndpoints returns true if there are raw endpoints registered for the
+// given protocol pair.
+func (d *transportDemuxer) hasRawEndpoints(netProto tcpip.NetworkProtocolNumber, transProto tcpip.TransportProtocolNumber) bool {
+	eps, ok := d.rawTransportEndpoints(netProto, transProto, false /* create */)
+	if !ok {
+		return false
+	}
+	eps.mu.RLock()
+	defer eps.mu.RUnlock()
+	return len(eps.rawEndpoints) != 0
+}
+
 func isInboundMulticastOrBroadcast(pkt *PacketBuffer, localAddr tcpip.Address) bool {
 	return pkt.NetworkPacketInfo.LocalAddressBroadcast || header.IsV4MulticastAddress(localAddr) || header.IsV6MulticastAddress(localAddr)
 }
@@ -547,6 +547,57 @@ type Payloader interface {
 
 var _ Payloader = (*bytes.Buffer)(nil)
 var _ Payloader = (*bytes.Reader)(nil)
+var _ Payloader = (*SlicesReader)(nil)
+
+// SlicesReader implements Payloader for a sequence of slices, reading them in
+// order as if they had been concatenated. It allows a message held in several
+// buffers to be written to an endpoint without first copying it into one.
+//
+// The slices are not copied and must not be modified until they have been
+// read.
+type SlicesReader struct {
+	bufs [][]byte
+	// off is the number of bytes of bufs[0] that have been read.
+	off int
+	// n is the number of bytes left to read.
+	n int
+}
+
+// NewSlicesReader returns a SlicesReader that reads from bufs.
+func NewSlicesReader(bufs ...[]byte) *SlicesReader {
+	r := &SlicesReader{bufs: bufs}
+	for _, b := range bufs {
+		r.n += len(b)
+	}
+	return r
+}
+
+// Read implements io.Reader.Read.
+func (r *SlicesReader) Read(b []byte) (int, error) {
+	if r.n == 0 {
+		if len(b) == 0 {
+			return 0, nil
+		}
+		return 0, io.EOF
+	}
+	n := 0
+	for n < len(b) && len(r.bufs) != 0 {
+		c := copy(b[n:], r.bufs[0][r.off:])
+		n += c
+		r.off += c
+		if r.off == len(r.bufs[0]) {
+			r.bufs = r.bufs[1:]
+			r.off = 0
+		}
+	}
+	r.n -= n
+	return n, nil
+}
+
+// Len implements Payloader.Len.
+func (r *SlicesReader) Len() int {
+	return r.n
+}
 
 var _ io.Writer = (*SliceWriter)(nil)
 
@@ -697,6 +748,10 @@ type ReadOptions struct {
 	// NeedLinkPacketInfo indicates whether to return the link-layer information,
 	// if supported.
 	NeedLinkPacketInfo bool
+
+	// OutOfBand indicates whether to read the pending urgent byte instead of
+	// in-band data, like Linux's MSG_OOB. It is only supported by TCP.
+	OutOfBand bool
 }
 
 // ReadResult represents result for a successful Endpoint.Read.
@@ -907,6 +962,10 @@ type WriteOptions struct {
 
 	// ControlMessages contains optional overrides used when writing a packet.
 	ControlMessages SendableControlMessages
+
+	// OutOfBand marks the last byte written as urgent data, with the same
+	// semantics as Linux's MSG_OOB. It is only supported by TCP.
+	OutOfBand bool
 }
 
 // SockOptInt represents socket options which values have the int type.
@@ -981,6 +1040,17 @@ const (
 	// IPv6Checksum is used to request the stack to populate and validate the IPv6
 	// checksum for transport level headers.
 	IPv6Checksum
+
+	// UDPLiteSendCoverageOption is used by SetSockOptInt/GetSockOptInt to
+	// specify the number of bytes, including the header, covered by the
+	// checksum of outgoing UDP-Lite datagrams. Zero means full coverage.
+	UDPLiteSendCoverageOption
+
+	// UDPLiteRecvCoverageOption is used by SetSockOptInt/GetSockOptInt to
+	// specify the minimum checksum coverage of incoming UDP-Lite datagrams.
+	// Datagrams with a smaller partial coverage are dropped. Zero accepts any
+	// coverage.
+	UDPLiteRecvCoverageOption
 )
 
 const (
@@ -1054,6 +1124,15 @@ func (*TCPSACKEnabled) isGettableTransportProtocolOption() {}
 
 func (*TCPSACKEnabled) isSettableTransportProtocolOption() {}
 
+// TCPECNEnabled enables explicit congestion notification for TCP.
+//
+// See: https://tools.ietf.org/html/rfc3168.
+type TCPECNEnabled bool
+
+func (*TCPECNEnabled) isGettableTransportProtocolOption() {}
+
+func (*TCPECNEnabled) isSettableTransportProtocolOption() {}
+
 // TCPRecovery is the loss deteoction algorithm used by TCP.
 type TCPRecovery int32
 
@@ -1217,6 +1296,16 @@ type TCPInfoOption struct {
 
 	// ReorderSeen indicates if reordering is seen in the endpoint.
 	ReorderSeen bool
+
+	// SndWnd is the send window advertised by the peer, in bytes.
+	SndWnd uint32
+
+	// RcvWnd is the receive window last advertised to the peer, in bytes.
+	RcvWnd uint32
+
+	// TotalRetransmits is the number of segments retransmitted since the
+	// connection was established.
+	TotalRetransmits uint64
 }
 
 func (*TCPInfoOption) isGettableSocketOption() {}
@@ -1343,6 +1432,75 @@ func (*TCPSynRetriesOption) isGettableTransportProtocolOption() {}
 
 func (*TCPSynRetriesOption) isSettableTransportProtocolOption() {}
 
+// TCPMD5SignatureOption is used by SetSockOpt to install the key used to sign
+// and verify the segments exchanged with a peer with the MD5 signature option,
+// as described in RFC 2385. An empty key removes the peer's key.
+//
+// Keys should be installed before connecting or listening, as the segment
+// size of a connection is chosen when it is established.
+type TCPMD5SignatureOption struct {
+	// Addr is the address of the peer.
+	Addr Address
+
+	// Key is the key shared with the peer.
+	Key []byte
+}
+
+func (*TCPMD5SignatureOption) isSettableSocketOption() {}
+
+// TCPSynRTOOption is used by SetTransportProtocolOption/TransportProtocolOption
+// to specify the stack-wide initial retransmission timeout of SYN and SYN-ACK
+// segments. The timeout doubles on every retransmission. A negative value
+// restores the default.
+type TCPSynRTOOption time.Duration
+
+func (*TCPSynRTOOption) isGettableTransportProtocolOption() {}
+
+func (*TCPSynRTOOption) isSettableTransportProtocolOption() {}
+
+// TCPMaxMSSOption is used by SetTransportProtocolOption/TransportProtocolOption
+// to specify a stack-wide upper bound on the MSS that TCP advertises and uses
+// to size outgoing segments. This is useful when packets are encapsulated on
+// their way to the peer, e.g. by a tunnel. Zero means no upper bound.
+type TCPMaxMSSOption uint16
+
+func (*TCPMaxMSSOption) isGettableTransportProtocolOption() {}
+
+func (*TCPMaxMSSOption) isSettableTransportProtocolOption() {}
+
+// TCPDelayedAckTimeoutOption is used by
+// SetTransportProtocolOption/TransportProtocolOption to specify the maximum
+// time that TCP delays the acknowledgement of in-order data, as described in
+// RFC 1122 section 4.2.3.2. Zero disables delayed ACKs.
+//
+// Endpoints with the TCP_QUICKACK socket option set acknowledge data
+// immediately.
+type TCPDelayedAckTimeoutOption time.Duration
+
+func (*TCPDelayedAckTimeoutOption) isGettableTransportProtocolOption() {}
+
+func (*TCPDelayedAckTimeoutOption) isSettableTransportProtocolOption() {}
+
+// TCPInitialCwndOption is used by
+// SetTransportProtocolOption/TransportProtocolOption to specify the initial
+// congestion window of new connections in segments, as described in RFC 6928.
+// It is also the window that TCP restarts from after an idle period.
+type TCPInitialCwndOption int
+
+func (*TCPInitialCwndOption) isGettableTransportProtocolOption() {}
+
+func (*TCPInitialCwndOption) isSettableTransportProtocolOption() {}
+
+// TCPInitialSsthreshOption is used by
+// SetTransportProtocolOption/TransportProtocolOption to specify the initial
+// slow start threshold of new connections in segments. Zero means an
+// arbitrarily high threshold, as recommended by RFC 5681 section 3.1.
+type TCPInitialSsthreshOption int
+
+func (*TCPInitialSsthreshOption) isGettableTransportProtocolOption() {}
+
+func (*TCPInitialSsthreshOption) isSettableTransportProtocolOption() {}
+
 // MulticastInterfaceOption is used by SetSockOpt/GetSockOpt to specify a
 // default interface for multicast.
 type MulticastInterfaceOption struct {
@@ -1959,6 +2117,26 @@ type IPStats struct {
 	// to the IP packet header failing validation checks.
 	MalformedPacketsReceived *StatCounter
 
+	// TruncatedPacketsReceived is the number of IP packets that were dropped
+	// because they were shorter than the IP header or than the length the
+	// header specifies. They are included in MalformedPacketsReceived.
+	TruncatedPacketsReceived *StatCounter
+
+	// BadVersionPacketsReceived is the number of IP packets that were dropped
+	// because their version field did not match the network protocol. They are
+	// included in MalformedPacketsReceived.
+	BadVersionPacketsReceived *StatCounter
+
+	// BadLengthPacketsReceived is the number of IPv4 packets that were dropped
+	// because their header length was less than the minimum or greater than
+	// their total length. They are included in MalformedPacketsReceived.
+	BadLengthPacketsReceived *StatCounter
+
+	// BadChecksumPacketsReceived is the number of IPv4 packets that were
+	// dropped because their header checksum was invalid. They are included in
+	// MalformedPacketsReceived.
+	BadChecksumPacketsReceived *StatCounter
+
 	// MalformedFragmentsReceived is the number of IP Fragments that were dropped
 	// due to the fragment failing validation checks.
 	MalformedFragmentsReceived *StatCounter
@@ -2178,6 +2356,15 @@ type TCPStats struct {
 	// dropped due to exceeding the maximum number of in-flight connection
 	// requests.
 	ForwardMaxInFlightDrop *StatCounter
+
+	// PAWSRejected is the number of segments dropped because their timestamp
+	// was older than the most recent timestamp received on the connection.
+	PAWSRejected *StatCounter
+
+	// MD5Failures is the number of segments dropped because their MD5
+	// signature was missing, unexpected or did not match the key shared with
+	// the peer.
+	MD5Failures *StatCounter
 }
 
 // UDPStats collects UDP-specific stats.
@@ -2394,7 +2581,7 @@ type Stats struct {
 	// TCP holds TCP-specific stats.
 	TCP TCPStats
 
-	// UDP holds UDP-specific stats.
+	// UDP holds UDP-specific stats. UDP-Lite datagrams are counted here too.
 	UDP UDPStats
 }
 
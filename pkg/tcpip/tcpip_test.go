@@ -54,6 +54,33 @@ func TestLimitedWriter_Write(t *testing.T) {
 	}
 }
 
+func TestSlicesReader(t *testing.T) {
+	r := NewSlicesReader([]byte{0, 1, 2}, nil, []byte{3}, []byte{4, 5, 6, 7})
+	if got, want := r.Len(), 8; got != want {
+		t.Errorf("got r.Len() = %d, want = %d", got, want)
+	}
+
+	// Reads may span and split slices.
+	var got []byte
+	for _, size := range []int{2, 3, 0, 10} {
+		b := make([]byte, size)
+		n, err := r.Read(b)
+		if err != nil {
+			t.Fatalf("r.Read(%d bytes): %s", size, err)
+		}
+		got = append(got, b[:n]...)
+	}
+	if diff := cmp.Diff([]byte{0, 1, 2, 3, 4, 5, 6, 7}, got); diff != "" {
+		t.Errorf("%T read incorrect data: (-want +got):\n%s", r, diff)
+	}
+	if got := r.Len(); got != 0 {
+		t.Errorf("got r.Len() = %d after reading everything, want = 0", got)
+	}
+	if n, err := r.Read(make([]byte, 1)); n != 0 || err != io.EOF {
+		t.Errorf("got r.Read(1 byte) = (%d, %v), want = (0, %v)", n, err, io.EOF)
+	}
+}
+
 func TestSubnetContains(t *testing.T) {
 	tests := []struct {
 		s    string
@@ -94,6 +121,43 @@ func TestSubnetContainsDifferentLength(t *testing.T) {
 	}
 }
 
+func TestSubnetContainsPrefixBoundaries(t *testing.T) {
+	tests := []struct {
+		name   string
+		subnet AddressWithPrefix
+		addr   Address
+		want   bool
+	}{
+		{"v4 /0 contains any v4", AddressWithPrefix{AddrFrom4([4]byte{}), 0}, AddrFrom4([4]byte{255, 255, 255, 255}), true},
+		{"v4 /0 excludes v6", AddressWithPrefix{AddrFrom4([4]byte{}), 0}, AddrFrom16([16]byte{}), false},
+		{"v4 /24 first", AddressWithPrefix{AddrFrom4([4]byte{192, 168, 1, 0}), 24}, AddrFrom4([4]byte{192, 168, 1, 0}), true},
+		{"v4 /24 last", AddressWithPrefix{AddrFrom4([4]byte{192, 168, 1, 0}), 24}, AddrFrom4([4]byte{192, 168, 1, 255}), true},
+		{"v4 /24 next", AddressWithPrefix{AddrFrom4([4]byte{192, 168, 1, 0}), 24}, AddrFrom4([4]byte{192, 168, 2, 0}), false},
+		{"v4 /24 previous", AddressWithPrefix{AddrFrom4([4]byte{192, 168, 1, 0}), 24}, AddrFrom4([4]byte{192, 168, 0, 255}), false},
+		{"v4 /31 pair", AddressWithPrefix{AddrFrom4([4]byte{10, 0, 0, 2}), 31}, AddrFrom4([4]byte{10, 0, 0, 3}), true},
+		{"v4 /31 outside", AddressWithPrefix{AddrFrom4([4]byte{10, 0, 0, 2}), 31}, AddrFrom4([4]byte{10, 0, 0, 4}), false},
+		{"v4 /32 self", AddressWithPrefix{AddrFrom4([4]byte{10, 0, 0, 1}), 32}, AddrFrom4([4]byte{10, 0, 0, 1}), true},
+		{"v4 /32 other", AddressWithPrefix{AddrFrom4([4]byte{10, 0, 0, 1}), 32}, AddrFrom4([4]byte{10, 0, 0, 2}), false},
+		{"v6 /0 contains any v6", AddressWithPrefix{AddrFrom16([16]byte{}), 0}, AddrFrom16([16]byte{0: 0xff, 15: 0xff}), true},
+		{"v6 /0 excludes v4", AddressWithPrefix{AddrFrom16([16]byte{}), 0}, AddrFrom4([4]byte{}), false},
+		{"v6 /64 last", AddressWithPrefix{AddrFrom16([16]byte{0: 0x20, 1: 0x01, 2: 0x0d, 3: 0xb8}), 64}, AddrFrom16([16]byte{0: 0x20, 1: 0x01, 2: 0x0d, 3: 0xb8, 8: 0xff, 15: 0xff}), true},
+		{"v6 /64 next", AddressWithPrefix{AddrFrom16([16]byte{0: 0x20, 1: 0x01, 2: 0x0d, 3: 0xb8}), 64}, AddrFrom16([16]byte{0: 0x20, 1: 0x01, 2: 0x0d, 3: 0xb8, 7: 0x01}), false},
+		{"v6 /65 boundary", AddressWithPrefix{AddrFrom16([16]byte{0: 0xfe, 1: 0x80}), 65}, AddrFrom16([16]byte{0: 0xfe, 1: 0x80, 8: 0x7f}), true},
+		{"v6 /65 outside", AddressWithPrefix{AddrFrom16([16]byte{0: 0xfe, 1: 0x80}), 65}, AddrFrom16([16]byte{0: 0xfe, 1: 0x80, 8: 0x80}), false},
+		{"v6 /128 self", AddressWithPrefix{AddrFrom16([16]byte{15: 1}), 128}, AddrFrom16([16]byte{15: 1}), true},
+		{"v6 /128 other", AddressWithPrefix{AddrFrom16([16]byte{15: 1}), 128}, AddrFrom16([16]byte{15: 2}), false},
+		{"v4-mapped v6 excludes v4", AddressWithPrefix{AddrFrom16([16]byte{10: 0xff, 11: 0xff, 12: 10}), 104}, AddrFrom4([4]byte{10, 0, 0, 1}), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := tt.subnet.Subnet()
+			if got := s.Contains(tt.addr); got != tt.want {
+				t.Errorf("got Subnet(%s).Contains(%s) = %t, want = %t", s, tt.addr, got, tt.want)
+			}
+		})
+	}
+}
+
 func TestSubnetBits(t *testing.T) {
 	tests := []struct {
 		a     string
@@ -0,0 +1,248 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package checksum_offload_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/refs"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/tests/utils"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const nicID = 1
+
+func newStack(t *testing.T, caps stack.LinkEndpointCapabilities) (*stack.Stack, *channel.Endpoint) {
+	t.Helper()
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+	})
+	e := channel.New(1, header.IPv4MinimumMTU, "")
+	e.LinkEPCapabilities |= caps
+	if err := s.CreateNIC(nicID, e); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+	protocolAddr := tcpip.ProtocolAddress{
+		Protocol:          ipv4.ProtocolNumber,
+		AddressWithPrefix: utils.Ipv4Addr,
+	}
+	if err := s.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+		t.Fatalf("s.AddProtocolAddress(%d, %+v, {}): %s", nicID, protocolAddr, err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicID}})
+	return s, e
+}
+
+// TestTXChecksumOffload tests that transport checksums are only computed when
+// the outgoing link does not offload them.
+func TestTXChecksumOffload(t *testing.T) {
+	protocols := []struct {
+		name     string
+		protocol tcpip.TransportProtocolNumber
+		send     func(*testing.T, tcpip.Endpoint, tcpip.FullAddress)
+	}{
+		{
+			name:     "TCP",
+			protocol: tcp.ProtocolNumber,
+			send: func(t *testing.T, ep tcpip.Endpoint, to tcpip.FullAddress) {
+				err := ep.Connect(to)
+				if _, ok := err.(*tcpip.ErrConnectStarted); !ok {
+					t.Fatalf("got ep.Connect(%+v) = %v, want = %s", to, err, &tcpip.ErrConnectStarted{})
+				}
+			},
+		},
+		{
+			name:     "UDP",
+			protocol: udp.ProtocolNumber,
+			send: func(t *testing.T, ep tcpip.Endpoint, to tcpip.FullAddress) {
+				var r bytes.Reader
+				r.Reset([]byte{1, 2, 3, 4})
+				if _, err := ep.Write(&r, tcpip.WriteOptions{To: &to}); err != nil {
+					t.Fatalf("ep.Write(_, _): %s", err)
+				}
+			},
+		},
+	}
+
+	tests := []struct {
+		name         string
+		caps         stack.LinkEndpointCapabilities
+		wantChecksum bool
+	}{
+		{
+			name:         "no offload",
+			wantChecksum: true,
+		},
+		{
+			name:         "offload",
+			caps:         stack.CapabilityTXChecksumOffload,
+			wantChecksum: false,
+		},
+	}
+
+	for _, proto := range protocols {
+		t.Run(proto.name, func(t *testing.T) {
+			for _, test := range tests {
+				t.Run(test.name, func(t *testing.T) {
+					s, e := newStack(t, test.caps)
+					defer e.Close()
+					defer s.Destroy()
+
+					var wq waiter.Queue
+					ep, err := s.NewEndpoint(proto.protocol, ipv4.ProtocolNumber, &wq)
+					if err != nil {
+						t.Fatalf("s.NewEndpoint(%d, %d, _): %s", proto.protocol, ipv4.ProtocolNumber, err)
+					}
+					defer ep.Close()
+
+					proto.send(t, ep, tcpip.FullAddress{Addr: utils.RemoteIPv4Addr, Port: utils.RemotePort})
+					pkt := e.Read()
+					if pkt == nil {
+						t.Fatal("expected a packet to be written")
+					}
+					v := pkt.ToView()
+					pkt.DecRef()
+					defer v.Release()
+
+					ip := header.IPv4(v.AsSlice())
+					if !ip.IsChecksumValid() {
+						t.Errorf("got invalid IPv4 header checksum = %#x", ip.Checksum())
+					}
+					transport := ip.Payload()
+					var gotChecksum uint16
+					switch proto.protocol {
+					case tcp.ProtocolNumber:
+						gotChecksum = header.TCP(transport).Checksum()
+					case udp.ProtocolNumber:
+						gotChecksum = header.UDP(transport).Checksum()
+					}
+					if !test.wantChecksum {
+						if gotChecksum != 0 {
+							t.Errorf("got transport checksum = %#x, want = 0", gotChecksum)
+						}
+						return
+					}
+					xsum := header.PseudoHeaderChecksum(proto.protocol, ip.SourceAddress(), ip.DestinationAddress(), uint16(len(transport)))
+					if got := checksum.Checksum(transport, xsum); got != 0xffff {
+						t.Errorf("got transport checksum = %#x which does not verify (sum = %#x)", gotChecksum, got)
+					}
+				})
+			}
+		})
+	}
+}
+
+// TestRXChecksumOffload tests that inbound transport checksums are not
+// validated when the incoming link has already validated them.
+func TestRXChecksumOffload(t *testing.T) {
+	tests := []struct {
+		name          string
+		caps          stack.LinkEndpointCapabilities
+		wantDelivered bool
+	}{
+		{
+			name:          "no offload",
+			wantDelivered: false,
+		},
+		{
+			name:          "offload",
+			caps:          stack.CapabilityRXChecksumOffload,
+			wantDelivered: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s, e := newStack(t, test.caps)
+			defer e.Close()
+			defer s.Destroy()
+
+			var wq waiter.Queue
+			ep, err := s.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+			if err != nil {
+				t.Fatalf("s.NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+			}
+			defer ep.Close()
+			if err := ep.Bind(tcpip.FullAddress{Port: utils.LocalPort}); err != nil {
+				t.Fatalf("ep.Bind(_): %s", err)
+			}
+
+			// Build a UDP packet with a bad checksum.
+			payload := []byte{1, 2, 3, 4}
+			totalLen := header.IPv4MinimumSize + header.UDPMinimumSize + len(payload)
+			hdr := make([]byte, totalLen)
+			ip := header.IPv4(hdr)
+			ip.Encode(&header.IPv4Fields{
+				TotalLength: uint16(totalLen),
+				TTL:         64,
+				Protocol:    uint8(udp.ProtocolNumber),
+				SrcAddr:     utils.RemoteIPv4Addr,
+				DstAddr:     utils.Ipv4Addr.Address,
+			})
+			ip.SetChecksum(^ip.CalculateChecksum())
+			u := header.UDP(ip.Payload())
+			u.Encode(&header.UDPFields{
+				SrcPort:  utils.RemotePort,
+				DstPort:  utils.LocalPort,
+				Length:   uint16(header.UDPMinimumSize + len(payload)),
+				Checksum: 0xbad,
+			})
+			copy(u.Payload(), payload)
+			pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Payload: buffer.MakeWithData(hdr),
+			})
+			e.InjectInbound(ipv4.ProtocolNumber, pkt)
+			pkt.DecRef()
+
+			var buf bytes.Buffer
+			_, err = ep.Read(&buf, tcpip.ReadOptions{})
+			if test.wantDelivered {
+				if err != nil {
+					t.Fatalf("ep.Read(_, _): %s", err)
+				}
+				if !bytes.Equal(buf.Bytes(), payload) {
+					t.Errorf("got ep.Read(_, _) data = %x, want = %x", buf.Bytes(), payload)
+				}
+				return
+			}
+			if _, ok := err.(*tcpip.ErrWouldBlock); !ok {
+				t.Fatalf("got ep.Read(_, _) = %v, want = %s", err, &tcpip.ErrWouldBlock{})
+			}
+			if got := s.Stats().UDP.ChecksumErrors.Value(); got != 1 {
+				t.Errorf("got s.Stats().UDP.ChecksumErrors.Value() = %d, want = 1", got)
+			}
+		})
+	}
+}
+
+func TestMain(m *testing.M) {
+	refs.SetLeakMode(refs.LeaksPanic)
+	code := m.Run()
+	refs.DoLeakCheck()
+	os.Exit(code)
+}
@@ -19,6 +19,7 @@ import (
 	"fmt"
 	"math"
 	"testing"
+	"time"
 
 	"github.com/google/go-cmp/cmp"
 	"gvisor.dev/gvisor/pkg/buffer"
@@ -3247,6 +3248,143 @@ func TestLocallyRoutedPackets(t *testing.T) {
 	}
 }
 
+type tcpDstPortMatcher struct {
+	port uint16
+}
+
+func (m *tcpDstPortMatcher) Match(_ stack.Hook, pkt *stack.PacketBuffer, _, _ string) (matches bool, hotdrop bool) {
+	if pkt.TransportProtocolNumber != header.TCPProtocolNumber {
+		return false, false
+	}
+
+	tcp := header.TCP(pkt.TransportHeader().Slice())
+	if len(tcp) < header.TCPMinimumSize {
+		return false, false
+	}
+	return tcp.DestinationPort() == m.port, false
+}
+
+// TestFilterTCPDestinationPort tests that a filter rule dropping TCP segments
+// to a port prevents connections to that port without affecting connections
+// to other ports.
+func TestFilterTCPDestinationPort(t *testing.T) {
+	const (
+		nicID       = 1
+		blockedPort = 80
+		allowedPort = 81
+	)
+	addr := utils.Host1IPv4Addr.AddressWithPrefix.Address
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol},
+	})
+	defer s.Destroy()
+
+	if err := s.CreateNIC(nicID, loopback.New()); err != nil {
+		t.Fatalf("CreateNIC(%d, _) = %s", nicID, err)
+	}
+	protocolAddr := tcpip.ProtocolAddress{
+		Protocol:          ipv4.ProtocolNumber,
+		AddressWithPrefix: addr.WithPrefix(),
+	}
+	if err := s.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+		t.Fatalf("AddProtocolAddress(%d, %+v, {}): %s", nicID, protocolAddr, err)
+	}
+	s.SetRouteTable([]tcpip.Route{
+		{
+			Destination: protocolAddr.AddressWithPrefix.Subnet(),
+			NIC:         nicID,
+		},
+	})
+
+	// Drop inbound TCP segments to blockedPort and accept everything else.
+	ipt := s.IPTables()
+	ipt.ForceReplaceTable(stack.FilterID, stack.Table{
+		Rules: []stack.Rule{
+			{
+				Filter:   stack.IPHeaderFilter{Protocol: header.TCPProtocolNumber, CheckProtocol: true},
+				Matchers: []stack.Matcher{&tcpDstPortMatcher{port: blockedPort}},
+				Target:   &stack.DropTarget{NetworkProtocol: ipv4.ProtocolNumber},
+			},
+			{Filter: stack.EmptyFilter4(), Target: &stack.AcceptTarget{NetworkProtocol: ipv4.ProtocolNumber}},
+			{Filter: stack.EmptyFilter4(), Target: &stack.AcceptTarget{NetworkProtocol: ipv4.ProtocolNumber}},
+			{Filter: stack.EmptyFilter4(), Target: &stack.AcceptTarget{NetworkProtocol: ipv4.ProtocolNumber}},
+			{Filter: stack.EmptyFilter4(), Target: &stack.ErrorTarget{NetworkProtocol: ipv4.ProtocolNumber}},
+		},
+		BuiltinChains: [stack.NumHooks]int{
+			stack.Prerouting:  stack.HookUnset,
+			stack.Input:       0,
+			stack.Forward:     2,
+			stack.Output:      3,
+			stack.Postrouting: stack.HookUnset,
+		},
+		Underflows: [stack.NumHooks]int{
+			stack.Prerouting:  stack.HookUnset,
+			stack.Input:       1,
+			stack.Forward:     2,
+			stack.Output:      3,
+			stack.Postrouting: stack.HookUnset,
+		},
+	}, false /* ipv6 */)
+
+	for _, port := range []uint16{blockedPort, allowedPort} {
+		var wq waiter.Queue
+		listener, err := s.NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+		if err != nil {
+			t.Fatalf("s.NewEndpoint(%d, %d, _): %s", tcp.ProtocolNumber, ipv4.ProtocolNumber, err)
+		}
+		defer listener.Close()
+		bindAddr := tcpip.FullAddress{Addr: addr, Port: port}
+		if err := listener.Bind(bindAddr); err != nil {
+			t.Fatalf("listener.Bind(%#v): %s", bindAddr, err)
+		}
+		if err := listener.Listen(1); err != nil {
+			t.Fatalf("listener.Listen(1): %s", err)
+		}
+	}
+
+	connect := func(port uint16, wait time.Duration) tcpip.Error {
+		t.Helper()
+
+		var wq waiter.Queue
+		we, ch := waiter.NewChannelEntry(waiter.WritableEvents)
+		wq.EventRegister(&we)
+		defer wq.EventUnregister(&we)
+
+		ep, err := s.NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+		if err != nil {
+			t.Fatalf("s.NewEndpoint(%d, %d, _): %s", tcp.ProtocolNumber, ipv4.ProtocolNumber, err)
+		}
+		defer ep.Close()
+
+		connectAddr := tcpip.FullAddress{Addr: addr, Port: port}
+		if err := ep.Connect(connectAddr); err != nil {
+			if _, ok := err.(*tcpip.ErrConnectStarted); !ok {
+				t.Fatalf("ep.Connect(%#v): %s", connectAddr, err)
+			}
+		}
+		select {
+		case <-ch:
+			return ep.LastError()
+		case <-time.After(wait):
+			return &tcpip.ErrTimeout{}
+		}
+	}
+
+	if err := connect(allowedPort, 10*time.Second); err != nil {
+		t.Errorf("connect(%d): %s", allowedPort, err)
+	}
+
+	dropped := s.Stats().IP.IPTablesInputDropped.Value()
+	if err := connect(blockedPort, 100*time.Millisecond); err == nil {
+		t.Errorf("connect(%d) succeeded, want a filtered connection", blockedPort)
+	}
+	if got := s.Stats().IP.IPTablesInputDropped.Value(); got <= dropped {
+		t.Errorf("got s.Stats().IP.IPTablesInputDropped.Value() = %d, want > %d", got, dropped)
+	}
+}
+
 type icmpv4Matcher struct {
 	icmpType header.ICMPv4Type
 }
@@ -16,6 +16,7 @@ package loopback_test
 
 import (
 	"bytes"
+	"runtime"
 	"testing"
 	"time"
 
@@ -341,6 +342,90 @@ func TestLoopbackSubnetLifetimeBoundToAddr(t *testing.T) {
 	}
 }
 
+// TestLoopbackMTUFragmentation tests that the network layer fragments packets
+// that exceed the MTU of a loopback interface and that the fragments are
+// reassembled on the receiving side.
+func TestLoopbackMTUFragmentation(t *testing.T) {
+	const (
+		nicID       = 1
+		localPort   = 80
+		mtu         = 1500
+		payloadSize = 4000
+		// Each fragment carries at most mtu-header.IPv4MinimumSize bytes of the
+		// UDP datagram, rounded down to a multiple of 8.
+		wantFragments = 3
+	)
+
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+	})
+	defer s.Destroy()
+	if err := s.CreateNIC(nicID, loopback.NewWithMTU(mtu)); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+	}
+	protoAddr := tcpip.ProtocolAddress{
+		Protocol:          header.IPv4ProtocolNumber,
+		AddressWithPrefix: utils.Ipv4Addr,
+	}
+	if err := s.AddProtocolAddress(nicID, protoAddr, stack.AddressProperties{}); err != nil {
+		t.Fatalf("AddProtocolAddress(%d, %+v, {}): %s", nicID, protoAddr, err)
+	}
+	s.SetRouteTable([]tcpip.Route{
+		{
+			Destination: header.IPv4EmptySubnet,
+			NIC:         nicID,
+		},
+	})
+
+	var wq waiter.Queue
+	rep, err := s.NewEndpoint(udp.ProtocolNumber, header.IPv4ProtocolNumber, &wq)
+	if err != nil {
+		t.Fatalf("NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, header.IPv4ProtocolNumber, err)
+	}
+	defer rep.Close()
+	bindAddr := tcpip.FullAddress{Port: localPort}
+	if err := rep.Bind(bindAddr); err != nil {
+		t.Fatalf("rep.Bind(%+v): %s", bindAddr, err)
+	}
+
+	sep, err := s.NewEndpoint(udp.ProtocolNumber, header.IPv4ProtocolNumber, &wq)
+	if err != nil {
+		t.Fatalf("NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, header.IPv4ProtocolNumber, err)
+	}
+	defer sep.Close()
+
+	data := make([]byte, payloadSize)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	wopts := tcpip.WriteOptions{
+		To: &tcpip.FullAddress{
+			Addr: utils.Ipv4Addr.Address,
+			Port: localPort,
+		},
+	}
+	var r bytes.Reader
+	r.Reset(data)
+	if n, err := sep.Write(&r, wopts); err != nil {
+		t.Fatalf("sep.Write(_, _): %s", err)
+	} else if want := int64(len(data)); n != want {
+		t.Fatalf("got sep.Write(_, _) = (%d, nil), want = (%d, nil)", n, want)
+	}
+
+	if got := s.Stats().IP.PacketsSent.Value(); got != wantFragments {
+		t.Errorf("got s.Stats().IP.PacketsSent.Value() = %d, want = %d", got, wantFragments)
+	}
+
+	var buf bytes.Buffer
+	if _, err := rep.Read(&buf, tcpip.ReadOptions{}); err != nil {
+		t.Fatalf("rep.Read(_, {}): %s", err)
+	}
+	if diff := cmp.Diff(data, buf.Bytes()); diff != "" {
+		t.Errorf("got UDP payload mismatch (-want +got):\n%s", diff)
+	}
+}
+
 // TestLoopbackAcceptAllInSubnetTCP tests that a loopback interface considers
 // itself bound to all addresses in the subnet of an assigned address and TCP
 // traffic is sent/received correctly.
@@ -513,6 +598,86 @@ func TestLoopbackAcceptAllInSubnetTCP(t *testing.T) {
 	}
 }
 
+// TestStackDestroyStopsGoroutines tests that destroying stacks with
+// established TCP connections stops all the goroutines they started.
+func TestStackDestroyStopsGoroutines(t *testing.T) {
+	const (
+		nicID      = 1
+		localPort  = 80
+		iterations = 10
+	)
+
+	connect := func(t *testing.T, s *stack.Stack) {
+		t.Helper()
+
+		var wq waiter.Queue
+		we, ch := waiter.NewChannelEntry(waiter.ReadableEvents)
+		wq.EventRegister(&we)
+		defer wq.EventUnregister(&we)
+		listeningEndpoint, err := s.NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+		if err != nil {
+			t.Fatalf("s.NewEndpoint(%d, %d, _): %s", tcp.ProtocolNumber, ipv4.ProtocolNumber, err)
+		}
+		bindAddr := tcpip.FullAddress{Port: localPort}
+		if err := listeningEndpoint.Bind(bindAddr); err != nil {
+			t.Fatalf("listeningEndpoint.Bind(%#v): %s", bindAddr, err)
+		}
+		if err := listeningEndpoint.Listen(1); err != nil {
+			t.Fatalf("listeningEndpoint.Listen(1): %s", err)
+		}
+
+		connectingEndpoint, err := s.NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &waiter.Queue{})
+		if err != nil {
+			t.Fatalf("s.NewEndpoint(%d, %d, _): %s", tcp.ProtocolNumber, ipv4.ProtocolNumber, err)
+		}
+		connectAddr := tcpip.FullAddress{Addr: utils.Ipv4Addr.Address, Port: localPort}
+		if err := connectingEndpoint.Connect(connectAddr); err != nil {
+			if _, ok := err.(*tcpip.ErrConnectStarted); !ok {
+				t.Fatalf("connectingEndpoint.Connect(%#v): %s", connectAddr, err)
+			}
+		}
+
+		// Wait for the listening endpoint to be "readable". That is, wait for a
+		// new connection.
+		<-ch
+		if _, _, err := listeningEndpoint.Accept(nil); err != nil {
+			t.Fatalf("listeningEndpoint.Accept(nil): %s", err)
+		}
+	}
+
+	before := runtime.NumGoroutine()
+	for i := 0; i < iterations; i++ {
+		s := stack.New(stack.Options{
+			NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+			TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol, udp.NewProtocol},
+		})
+		if err := s.CreateNIC(nicID, loopback.New()); err != nil {
+			t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+		}
+		protocolAddr := tcpip.ProtocolAddress{
+			Protocol:          ipv4.ProtocolNumber,
+			AddressWithPrefix: utils.Ipv4Addr,
+		}
+		if err := s.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+			t.Fatalf("AddProtocolAddress(%d, %+v, {}): %s", nicID, protocolAddr, err)
+		}
+		s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicID}})
+
+		// Leave the connection open; Destroy must tear it down.
+		connect(t, s)
+		s.Destroy()
+	}
+
+	// Goroutines may take a moment to observe that they have been stopped.
+	got := runtime.NumGoroutine()
+	for deadline := time.Now().Add(5 * time.Second); got > before && time.Now().Before(deadline); got = runtime.NumGoroutine() {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got > before {
+		t.Errorf("got runtime.NumGoroutine() = %d after destroying %d stacks, want <= %d", got, iterations, before)
+	}
+}
+
 func TestExternalLoopbackTraffic(t *testing.T) {
 	const (
 		nicID1 = 1
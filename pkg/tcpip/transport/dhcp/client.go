@@ -0,0 +1,457 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/sync"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+const (
+	// maxRetransmission is the longest time the client waits for a reply
+	// before retransmitting a request, as recommended by RFC 2131 section
+	// 4.1.
+	maxRetransmission = 64 * time.Second
+
+	// requestTimeout is how long the client waits for the server to
+	// acknowledge a DHCPREQUEST for an offered address before restarting
+	// with a new DHCPDISCOVER.
+	requestTimeout = time.Minute
+)
+
+var (
+	errNak     = errors.New("dhcp: server rejected the request")
+	errTimeout = errors.New("dhcp: timed out waiting for the server")
+)
+
+// Config is the configuration obtained with a lease.
+type Config struct {
+	// ServerAddress is the address of the server that granted the lease.
+	ServerAddress tcpip.Address
+
+	// Router is the default gateway. It is unset if the server did not
+	// provide one.
+	Router tcpip.Address
+
+	// DNS holds the addresses of the DNS servers provided by the server.
+	DNS []tcpip.Address
+
+	// LeaseLength is the duration of the lease.
+	LeaseLength time.Duration
+
+	// RenewalTime (T1) is the time after which the client starts renewing the
+	// lease with the server that granted it.
+	RenewalTime time.Duration
+
+	// RebindingTime (T2) is the time after which the client starts renewing
+	// the lease with any server.
+	RebindingTime time.Duration
+}
+
+// AcquiredFunc is called when a Client acquires, renews or loses a lease.
+//
+// When a lease is acquired or renewed, lost is unset and acquired is the
+// leased address. When a lease is lost, lost is the address given up and
+// acquired is unset.
+type AcquiredFunc func(lost, acquired tcpip.AddressWithPrefix, cfg Config)
+
+// Client is a DHCP client for a single NIC.
+//
+// The client installs the leased address on the NIC, along with a route to
+// its subnet and a default route through the router provided by the server.
+// The stack has no resolver configuration, so DNS servers are not installed;
+// they are reported in Config.DNS through Lease and the AcquiredFunc instead.
+type Client struct {
+	stack          *stack.Stack
+	nicID          tcpip.NICID
+	linkAddr       tcpip.LinkAddress
+	retransmission time.Duration
+	acquiredFunc   AcquiredFunc
+
+	mu sync.Mutex
+	// +checklocks:mu
+	addr tcpip.AddressWithPrefix
+	// +checklocks:mu
+	cfg Config
+}
+
+// NewClient returns a client that obtains an IPv4 address for the NIC with
+// the given ID and link address. The stack must support IPv4 and UDP.
+//
+// Requests that receive no reply are first retransmitted after retransmission,
+// backing off exponentially. acquiredFunc, if not nil, is called whenever the
+// lease changes.
+func NewClient(s *stack.Stack, nicID tcpip.NICID, linkAddr tcpip.LinkAddress, retransmission time.Duration, acquiredFunc AcquiredFunc) *Client {
+	return &Client{
+		stack:          s,
+		nicID:          nicID,
+		linkAddr:       linkAddr,
+		retransmission: retransmission,
+		acquiredFunc:   acquiredFunc,
+	}
+}
+
+// Lease returns the currently leased address and its configuration. The
+// address is unset if the client holds no lease.
+func (c *Client) Lease() (tcpip.AddressWithPrefix, Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.addr, c.cfg
+}
+
+// Run acquires a lease and keeps renewing it until ctx is done. If the lease
+// is rejected or expires, the address is removed and a new lease is acquired.
+//
+// A lease held when ctx is done is left installed.
+func (c *Client) Run(ctx context.Context) error {
+	var wq waiter.Queue
+	ep, err := c.stack.NewEndpoint(header.UDPProtocolNumber, header.IPv4ProtocolNumber, &wq)
+	if err != nil {
+		return fmt.Errorf("dhcp: creating UDP endpoint: %s", err)
+	}
+	defer ep.Close()
+	ep.SocketOptions().SetBroadcast(true)
+	if err := ep.Bind(tcpip.FullAddress{NIC: c.nicID, Port: ClientPort}); err != nil {
+		return fmt.Errorf("dhcp: binding to port %d: %s", ClientPort, err)
+	}
+
+	entry, ch := waiter.NewChannelEntry(waiter.ReadableEvents)
+	wq.EventRegister(&entry)
+	defer wq.EventUnregister(&entry)
+	conn := conn{client: c, ep: ep, readable: ch}
+
+	for {
+		addr, cfg, err := conn.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		if err := c.install(addr, cfg); err != nil {
+			return err
+		}
+		if err := conn.hold(ctx, addr, cfg); err != nil {
+			return err
+		}
+		c.uninstall()
+		c.notify(addr, tcpip.AddressWithPrefix{}, Config{})
+	}
+}
+
+// install adds addr and the routes described by cfg to the stack.
+func (c *Client) install(addr tcpip.AddressWithPrefix, cfg Config) error {
+	protocolAddr := tcpip.ProtocolAddress{
+		Protocol:          header.IPv4ProtocolNumber,
+		AddressWithPrefix: addr,
+	}
+	if err := c.stack.AddProtocolAddress(c.nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+		return fmt.Errorf("dhcp: adding address %s to NIC %d: %s", addr, c.nicID, err)
+	}
+	c.addRoutes(addr, cfg)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.addr = addr
+	c.cfg = cfg
+	return nil
+}
+
+// update replaces the routes of the current lease with those described by
+// cfg.
+func (c *Client) update(cfg Config) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeRoutes(c.addr, c.cfg)
+	c.addRoutes(c.addr, cfg)
+	c.cfg = cfg
+}
+
+// uninstall removes the current lease from the stack.
+func (c *Client) uninstall() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeRoutes(c.addr, c.cfg)
+	// The address can only be missing if it was removed by someone else, in
+	// which case there is nothing left to do.
+	_ = c.stack.RemoveAddress(c.nicID, c.addr.Address)
+	c.addr = tcpip.AddressWithPrefix{}
+	c.cfg = Config{}
+}
+
+func (c *Client) routes(addr tcpip.AddressWithPrefix, cfg Config) []tcpip.Route {
+	routes := []tcpip.Route{{Destination: addr.Subnet(), NIC: c.nicID}}
+	if cfg.Router.BitLen() != 0 {
+		routes = append(routes, tcpip.Route{
+			Destination: header.IPv4EmptySubnet,
+			Gateway:     cfg.Router,
+			NIC:         c.nicID,
+		})
+	}
+	return routes
+}
+
+func (c *Client) addRoutes(addr tcpip.AddressWithPrefix, cfg Config) {
+	for _, r := range c.routes(addr, cfg) {
+		c.stack.AddRoute(r)
+	}
+}
+
+func (c *Client) removeRoutes(addr tcpip.AddressWithPrefix, cfg Config) {
+	routes := c.routes(addr, cfg)
+	c.stack.RemoveRoutes(func(r tcpip.Route) bool {
+		for _, route := range routes {
+			if r.Equal(route) {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (c *Client) notify(lost, acquired tcpip.AddressWithPrefix, cfg Config) {
+	if c.acquiredFunc != nil {
+		c.acquiredFunc(lost, acquired, cfg)
+	}
+}
+
+// after returns a channel that is closed once d has elapsed on the stack's
+// clock, and a function that stops the timer.
+func (c *Client) after(d time.Duration) (<-chan struct{}, func()) {
+	ch := make(chan struct{})
+	t := c.stack.Clock().AfterFunc(d, func() { close(ch) })
+	return ch, func() { t.Stop() }
+}
+
+// conn is the UDP endpoint a client exchanges messages over.
+type conn struct {
+	client   *Client
+	ep       tcpip.Endpoint
+	readable <-chan struct{}
+}
+
+// acquire obtains a new lease through a DHCPDISCOVER, DHCPOFFER, DHCPREQUEST,
+// DHCPACK exchange, as described in RFC 2131 section 3.1. It only returns an
+// error once ctx is done.
+func (c *conn) acquire(ctx context.Context) (tcpip.AddressWithPrefix, Config, error) {
+	// Until an address is leased, requests must be sent from the unspecified
+	// address, which the stack only uses as a source address if it is
+	// assigned to the NIC.
+	unspecified := tcpip.ProtocolAddress{
+		Protocol:          header.IPv4ProtocolNumber,
+		AddressWithPrefix: header.IPv4Any.WithPrefix(),
+	}
+	if err := c.client.stack.AddProtocolAddress(c.client.nicID, unspecified, stack.AddressProperties{}); err != nil {
+		return tcpip.AddressWithPrefix{}, Config{}, fmt.Errorf("dhcp: adding %s to NIC %d: %s", unspecified.AddressWithPrefix, c.client.nicID, err)
+	}
+	defer func() {
+		_ = c.client.stack.RemoveAddress(c.client.nicID, header.IPv4Any)
+	}()
+
+	broadcast := tcpip.FullAddress{Addr: header.IPv4Broadcast, Port: ServerPort, NIC: c.client.nicID}
+	for {
+		xid := c.client.stack.InsecureRNG().Uint32()
+		discover := newMessage(xid, c.client.linkAddr, options{
+			messageTypeOption(msgDiscover),
+			parameterRequestOption,
+		})
+		discover.setBroadcast()
+		offer, offerOpts, err := c.exchange(ctx, broadcast, discover, msgOffer, nil /* stop */)
+		if err != nil {
+			return tcpip.AddressWithPrefix{}, Config{}, err
+		}
+		serverID, ok := offerOpts.addr(optServerIdentifier)
+		if !ok {
+			continue
+		}
+
+		request := newMessage(xid, c.client.linkAddr, options{
+			messageTypeOption(msgRequest),
+			addrOption(optRequestedIPAddress, offer.yiaddr()),
+			addrOption(optServerIdentifier, serverID),
+			parameterRequestOption,
+		})
+		request.setBroadcast()
+		stop, stopTimer := c.client.after(requestTimeout)
+		ack, ackOpts, err := c.exchange(ctx, broadcast, request, msgAck, stop)
+		stopTimer()
+		switch {
+		case err == errNak || err == errTimeout:
+			continue
+		case err != nil:
+			return tcpip.AddressWithPrefix{}, Config{}, err
+		}
+		addr, cfg, ok := parseAck(ack, ackOpts)
+		if !ok {
+			continue
+		}
+		return addr, cfg, nil
+	}
+}
+
+// hold keeps renewing the lease of addr, as described in RFC 2131 section
+// 4.4.5. It returns nil when the lease is lost, and an error once ctx is done.
+func (c *conn) hold(ctx context.Context, addr tcpip.AddressWithPrefix, cfg Config) error {
+	clock := c.client.stack.Clock()
+	for {
+		start := clock.NowMonotonic()
+		renew, stopRenew := c.client.after(cfg.RenewalTime)
+		c.client.notify(tcpip.AddressWithPrefix{}, addr, cfg)
+		select {
+		case <-renew:
+		case <-ctx.Done():
+			stopRenew()
+			return ctx.Err()
+		}
+
+		request := newMessage(c.client.stack.InsecureRNG().Uint32(), c.client.linkAddr, options{
+			messageTypeOption(msgRequest),
+			parameterRequestOption,
+		})
+		request.setCiaddr(addr.Address)
+
+		// Renew with the server that granted the lease until T2, then with any
+		// server until the lease expires.
+		rebind, stopRebind := c.client.after(start.Add(cfg.RebindingTime).Sub(clock.NowMonotonic()))
+		to := tcpip.FullAddress{Addr: cfg.ServerAddress, Port: ServerPort, NIC: c.client.nicID}
+		ack, ackOpts, err := c.exchange(ctx, to, request, msgAck, rebind)
+		stopRebind()
+		if err == errTimeout {
+			expire, stopExpire := c.client.after(start.Add(cfg.LeaseLength).Sub(clock.NowMonotonic()))
+			to := tcpip.FullAddress{Addr: header.IPv4Broadcast, Port: ServerPort, NIC: c.client.nicID}
+			ack, ackOpts, err = c.exchange(ctx, to, request, msgAck, expire)
+			stopExpire()
+		}
+		switch {
+		case err == errNak || err == errTimeout:
+			return nil
+		case err != nil:
+			return err
+		}
+
+		newAddr, newCfg, ok := parseAck(ack, ackOpts)
+		if !ok || newAddr != addr {
+			return nil
+		}
+		cfg = newCfg
+		c.client.update(cfg)
+	}
+}
+
+// parseAck returns the lease granted by a DHCPACK.
+func parseAck(ack message, opts options) (tcpip.AddressWithPrefix, Config, bool) {
+	serverID, ok := opts.addr(optServerIdentifier)
+	if !ok {
+		return tcpip.AddressWithPrefix{}, Config{}, false
+	}
+	lease, ok := opts.duration(optLeaseTime)
+	if !ok {
+		return tcpip.AddressWithPrefix{}, Config{}, false
+	}
+	cfg := Config{
+		ServerAddress: serverID,
+		DNS:           opts.addrs(optDNS),
+		LeaseLength:   lease,
+		RenewalTime:   lease / 2,
+		RebindingTime: lease * 7 / 8,
+	}
+	if router, ok := opts.addr(optRouter); ok {
+		cfg.Router = router
+	}
+	if t1, ok := opts.duration(optRenewalTime); ok && t1 < lease {
+		cfg.RenewalTime = t1
+	}
+	if t2, ok := opts.duration(optRebindingTime); ok && t2 < lease && t2 > cfg.RenewalTime {
+		cfg.RebindingTime = t2
+	}
+
+	addr := ack.yiaddr().WithPrefix()
+	if b := opts.get(optSubnetMask); len(b) == header.IPv4AddressSize {
+		addr.PrefixLen = tcpip.MaskFromBytes(b).Prefix()
+	}
+	return addr, cfg, true
+}
+
+// exchange sends req to the server at to and returns the first reply to it
+// of type want. req is retransmitted with exponential backoff until a reply is
+// received.
+//
+// exchange returns errNak if the server rejects a DHCPREQUEST, errTimeout
+// once stop is closed and ctx.Err() once ctx is done.
+func (c *conn) exchange(ctx context.Context, to tcpip.FullAddress, req message, want messageType, stop <-chan struct{}) (message, options, error) {
+	for backoff := c.client.retransmission; ; backoff *= 2 {
+		if backoff > maxRetransmission {
+			backoff = maxRetransmission
+		}
+		retransmit, stopRetransmit := c.client.after(backoff)
+		// Failing to send is not fatal: the stack may not have a route to the
+		// server yet, so keep retransmitting.
+		var r bytes.Reader
+		r.Reset(req)
+		_, _ = c.ep.Write(&r, tcpip.WriteOptions{To: &to})
+
+	wait:
+		for {
+			select {
+			case <-c.readable:
+				reply, opts, err := c.read(req.xid(), want)
+				if reply != nil || err != nil {
+					stopRetransmit()
+					return reply, opts, err
+				}
+			case <-retransmit:
+				break wait
+			case <-stop:
+				stopRetransmit()
+				return nil, nil, errTimeout
+			case <-ctx.Done():
+				stopRetransmit()
+				return nil, nil, ctx.Err()
+			}
+		}
+	}
+}
+
+// read reads the queued datagrams until it finds a reply with the given
+// transaction ID and message type, or a DHCPNAK when want is msgAck. Other
+// datagrams are discarded.
+func (c *conn) read(xid uint32, want messageType) (message, options, error) {
+	for {
+		var b bytes.Buffer
+		if _, err := c.ep.Read(&b, tcpip.ReadOptions{}); err != nil {
+			return nil, nil, nil
+		}
+		m := message(b.Bytes())
+		if !m.isValid() || m.op() != opReply || m.xid() != xid || m.chaddr() != c.client.linkAddr {
+			continue
+		}
+		opts, err := m.options()
+		if err != nil {
+			continue
+		}
+		switch t := opts.messageType(); {
+		case t == want:
+			return m, opts, nil
+		case t == msgNak && want == msgAck:
+			return nil, nil, errNak
+		}
+	}
+}
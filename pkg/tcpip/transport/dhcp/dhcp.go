@@ -0,0 +1,296 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package dhcp implements a DHCPv4 client, as specified in RFC 2131, that runs
+// on top of a netstack UDP endpoint.
+package dhcp
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+const (
+	// ServerPort is the well-known UDP port of DHCP servers.
+	ServerPort = 67
+
+	// ClientPort is the well-known UDP port of DHCP clients.
+	ClientPort = 68
+)
+
+// Message offsets and sizes, as defined in RFC 2131 section 2.
+const (
+	opOffset     = 0
+	htypeOffset  = 1
+	hlenOffset   = 2
+	xidOffset    = 4
+	flagsOffset  = 10
+	ciaddrOffset = 12
+	yiaddrOffset = 16
+	chaddrOffset = 28
+	magicOffset  = 236
+
+	chaddrSize = 16
+
+	// headerSize is the size of the fixed part of a message, including the
+	// options magic cookie.
+	headerSize = magicOffset + len(magicCookie)
+)
+
+// magicCookie starts the options field of a message, as defined in RFC 2131
+// section 3.
+var magicCookie = [4]byte{99, 130, 83, 99}
+
+// op is the message op code.
+type op byte
+
+const (
+	opRequest op = 1
+	opReply   op = 2
+)
+
+// htypeEthernet is the hardware address type of Ethernet, as assigned in RFC
+// 1700.
+const htypeEthernet = 1
+
+// flagBroadcast asks servers to broadcast their replies, as the client cannot
+// receive unicast datagrams before it has an address.
+const flagBroadcast = 1 << 15
+
+// message is a DHCP message.
+type message []byte
+
+// newMessage returns a client request with the given transaction ID and client
+// hardware address, and room for options.
+func newMessage(xid uint32, chaddr tcpip.LinkAddress, opts options) message {
+	m := make(message, headerSize, headerSize+opts.len())
+	m[opOffset] = byte(opRequest)
+	m[htypeOffset] = htypeEthernet
+	m[hlenOffset] = byte(len(chaddr))
+	binary.BigEndian.PutUint32(m[xidOffset:], xid)
+	copy(m[chaddrOffset:][:chaddrSize], chaddr)
+	copy(m[magicOffset:], magicCookie[:])
+	return opts.encode(m)
+}
+
+// isValid returns true if m is long enough to hold the fixed header and
+// carries the options magic cookie.
+func (m message) isValid() bool {
+	return len(m) >= headerSize && [4]byte(m[magicOffset:headerSize]) == magicCookie
+}
+
+func (m message) op() op { return op(m[opOffset]) }
+
+func (m message) xid() uint32 { return binary.BigEndian.Uint32(m[xidOffset:]) }
+
+func (m message) setBroadcast() {
+	binary.BigEndian.PutUint16(m[flagsOffset:], flagBroadcast)
+}
+
+func (m message) ciaddr() tcpip.Address { return addrAt(m, ciaddrOffset) }
+
+func (m message) setCiaddr(addr tcpip.Address) {
+	copy(m[ciaddrOffset:][:header.IPv4AddressSize], addr.AsSlice())
+}
+
+func (m message) yiaddr() tcpip.Address { return addrAt(m, yiaddrOffset) }
+
+func (m message) chaddr() tcpip.LinkAddress {
+	n := int(m[hlenOffset])
+	if n > chaddrSize {
+		n = chaddrSize
+	}
+	return tcpip.LinkAddress(m[chaddrOffset:][:n])
+}
+
+func addrAt(b []byte, off int) tcpip.Address {
+	return tcpip.AddrFrom4Slice(b[off:][:header.IPv4AddressSize])
+}
+
+// options returns the options carried by m.
+func (m message) options() (options, error) {
+	var opts options
+	b := m[headerSize:]
+	for len(b) > 0 {
+		code := optionCode(b[0])
+		switch code {
+		case optPad:
+			b = b[1:]
+			continue
+		case optEnd:
+			return opts, nil
+		}
+		if len(b) < 2 || len(b) < 2+int(b[1]) {
+			return nil, fmt.Errorf("option %d overflows message", code)
+		}
+		opts = append(opts, option{code: code, body: b[2:][:b[1]]})
+		b = b[2+int(b[1]):]
+	}
+	return opts, nil
+}
+
+// optionCode is a DHCP option code, as defined in RFC 2132.
+type optionCode byte
+
+const (
+	optPad                optionCode = 0
+	optSubnetMask         optionCode = 1
+	optRouter             optionCode = 3
+	optDNS                optionCode = 6
+	optRequestedIPAddress optionCode = 50
+	optLeaseTime          optionCode = 51
+	optMessageType        optionCode = 53
+	optServerIdentifier   optionCode = 54
+	optParameterRequest   optionCode = 55
+	optRenewalTime        optionCode = 58
+	optRebindingTime      optionCode = 59
+	optEnd                optionCode = 255
+)
+
+// messageType is the value of the DHCP message type option.
+type messageType byte
+
+const (
+	msgDiscover messageType = 1
+	msgOffer    messageType = 2
+	msgRequest  messageType = 3
+	msgDecline  messageType = 4
+	msgAck      messageType = 5
+	msgNak      messageType = 6
+	msgRelease  messageType = 7
+)
+
+// String implements fmt.Stringer.
+func (t messageType) String() string {
+	switch t {
+	case msgDiscover:
+		return "DHCPDISCOVER"
+	case msgOffer:
+		return "DHCPOFFER"
+	case msgRequest:
+		return "DHCPREQUEST"
+	case msgDecline:
+		return "DHCPDECLINE"
+	case msgAck:
+		return "DHCPACK"
+	case msgNak:
+		return "DHCPNAK"
+	case msgRelease:
+		return "DHCPRELEASE"
+	default:
+		return fmt.Sprintf("DHCP(%d)", t)
+	}
+}
+
+// option is a single DHCP option.
+type option struct {
+	code optionCode
+	body []byte
+}
+
+// options is a list of DHCP options.
+type options []option
+
+// len returns the number of bytes needed to encode opts, including the end
+// option.
+func (opts options) len() int {
+	n := 1
+	for _, opt := range opts {
+		n += 2 + len(opt.body)
+	}
+	return n
+}
+
+// encode appends opts and the end option to b.
+func (opts options) encode(b []byte) []byte {
+	for _, opt := range opts {
+		b = append(b, byte(opt.code), byte(len(opt.body)))
+		b = append(b, opt.body...)
+	}
+	return append(b, byte(optEnd))
+}
+
+// get returns the body of the first option with the given code, or nil.
+func (opts options) get(code optionCode) []byte {
+	for _, opt := range opts {
+		if opt.code == code {
+			return opt.body
+		}
+	}
+	return nil
+}
+
+// messageType returns the value of the message type option, or zero if it is
+// missing or malformed.
+func (opts options) messageType() messageType {
+	if b := opts.get(optMessageType); len(b) == 1 {
+		return messageType(b[0])
+	}
+	return 0
+}
+
+// addr returns the first address in the option with the given code.
+func (opts options) addr(code optionCode) (tcpip.Address, bool) {
+	b := opts.get(code)
+	if len(b) < header.IPv4AddressSize {
+		return tcpip.Address{}, false
+	}
+	return tcpip.AddrFrom4Slice(b[:header.IPv4AddressSize]), true
+}
+
+// addrs returns the addresses in the option with the given code.
+func (opts options) addrs(code optionCode) []tcpip.Address {
+	b := opts.get(code)
+	var addrs []tcpip.Address
+	for ; len(b) >= header.IPv4AddressSize; b = b[header.IPv4AddressSize:] {
+		addrs = append(addrs, tcpip.AddrFrom4Slice(b[:header.IPv4AddressSize]))
+	}
+	return addrs
+}
+
+// duration returns the number of seconds in the option with the given code as
+// a time.Duration.
+func (opts options) duration(code optionCode) (time.Duration, bool) {
+	b := opts.get(code)
+	if len(b) != 4 {
+		return 0, false
+	}
+	return time.Duration(binary.BigEndian.Uint32(b)) * time.Second, true
+}
+
+func messageTypeOption(t messageType) option {
+	return option{code: optMessageType, body: []byte{byte(t)}}
+}
+
+func addrOption(code optionCode, addr tcpip.Address) option {
+	return option{code: code, body: addr.AsSlice()}
+}
+
+// parameterRequestOption asks servers for the configuration the client
+// installs.
+var parameterRequestOption = option{
+	code: optParameterRequest,
+	body: []byte{
+		byte(optSubnetMask),
+		byte(optRouter),
+		byte(optDNS),
+		byte(optLeaseTime),
+		byte(optRenewalTime),
+		byte(optRebindingTime),
+	},
+}
@@ -0,0 +1,408 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package dhcp
+
+import (
+	"context"
+	"encoding/binary"
+	"testing"
+	"time"
+
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/faketime"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+const (
+	nicID          = 1
+	clientLinkAddr = tcpip.LinkAddress("\x02\x03\x04\x05\x06\x07")
+	retransmission = time.Second
+
+	leaseLength   = 100 * time.Second
+	renewalTime   = 50 * time.Second
+	rebindingTime = 80 * time.Second
+
+	// waitTimeout bounds how long the tests wait, in real time, for the
+	// client goroutine to react.
+	waitTimeout = 5 * time.Second
+)
+
+var (
+	serverAddr = tcpip.AddrFrom4([4]byte{192, 168, 0, 1})
+	routerAddr = tcpip.AddrFrom4([4]byte{192, 168, 0, 254})
+	clientAddr = tcpip.AddressWithPrefix{
+		Address:   tcpip.AddrFrom4([4]byte{192, 168, 0, 10}),
+		PrefixLen: 24,
+	}
+	dnsAddr = tcpip.AddrFrom4([4]byte{192, 168, 0, 53})
+)
+
+// event is a call to the client's AcquiredFunc.
+type event struct {
+	lost, acquired tcpip.AddressWithPrefix
+	cfg            Config
+}
+
+type testContext struct {
+	t      *testing.T
+	s      *stack.Stack
+	clock  *faketime.ManualClock
+	ep     *channel.Endpoint
+	client *Client
+	events chan event
+}
+
+func newTestContext(t *testing.T) *testContext {
+	t.Helper()
+
+	clock := faketime.NewManualClock()
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+		Clock:              clock,
+	})
+	ep := channel.New(16, header.IPv4MinimumProcessableDatagramSize, clientLinkAddr)
+	if err := s.CreateNIC(nicID, ep); err != nil {
+		t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+	}
+
+	c := &testContext{
+		t:      t,
+		s:      s,
+		clock:  clock,
+		ep:     ep,
+		events: make(chan event, 10),
+	}
+	c.client = NewClient(s, nicID, clientLinkAddr, retransmission, func(lost, acquired tcpip.AddressWithPrefix, cfg Config) {
+		c.events <- event{lost: lost, acquired: acquired, cfg: cfg}
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	errCh := make(chan error, 1)
+	go func() { errCh <- c.client.Run(ctx) }()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-errCh; err != context.Canceled {
+			t.Errorf("got Run(_) = %v, want = %s", err, context.Canceled)
+		}
+		ep.Close()
+		s.Close()
+		s.Wait()
+	})
+	return c
+}
+
+// request is a DHCP message sent by the client.
+type request struct {
+	src, dst tcpip.Address
+	msg      message
+	opts     options
+}
+
+// readRequest returns the next DHCP message written by the client and checks
+// that it is of type want.
+func (c *testContext) readRequest(want messageType) request {
+	c.t.Helper()
+
+	ctx, cancel := context.WithTimeout(context.Background(), waitTimeout)
+	defer cancel()
+	pkt := c.ep.ReadContext(ctx)
+	if pkt == nil {
+		c.t.Fatalf("timed out waiting for a %s", want)
+	}
+	defer pkt.DecRef()
+
+	b := stack.PayloadSince(pkt.NetworkHeader())
+	defer b.Release()
+	ip := header.IPv4(b.AsSlice())
+	if got := ip.TransportProtocol(); got != header.UDPProtocolNumber {
+		c.t.Fatalf("got ip.TransportProtocol() = %d, want = %d", got, header.UDPProtocolNumber)
+	}
+	u := header.UDP(ip.Payload())
+	if got := u.SourcePort(); got != ClientPort {
+		c.t.Errorf("got u.SourcePort() = %d, want = %d", got, ClientPort)
+	}
+	if got := u.DestinationPort(); got != ServerPort {
+		c.t.Errorf("got u.DestinationPort() = %d, want = %d", got, ServerPort)
+	}
+	m := message(append([]byte(nil), u.Payload()...))
+	if !m.isValid() {
+		c.t.Fatalf("got invalid message %x", m)
+	}
+	if got := m.op(); got != opRequest {
+		c.t.Errorf("got m.op() = %d, want = %d", got, opRequest)
+	}
+	if got := m.chaddr(); got != clientLinkAddr {
+		c.t.Errorf("got m.chaddr() = %s, want = %s", got, clientLinkAddr)
+	}
+	opts, err := m.options()
+	if err != nil {
+		c.t.Fatalf("m.options(): %s", err)
+	}
+	if got := opts.messageType(); got != want {
+		c.t.Fatalf("got opts.messageType() = %s, want = %s", got, want)
+	}
+	return request{src: ip.SourceAddress(), dst: ip.DestinationAddress(), msg: m, opts: opts}
+}
+
+// reply injects a reply of type t to req, sent by the server to dst.
+func (c *testContext) reply(req request, t messageType, dst tcpip.Address) {
+	opts := options{
+		messageTypeOption(t),
+		addrOption(optServerIdentifier, serverAddr),
+	}
+	if t != msgNak {
+		opts = append(opts,
+			addrOption(optSubnetMask, tcpip.AddrFrom4([4]byte{255, 255, 255, 0})),
+			addrOption(optRouter, routerAddr),
+			addrOption(optDNS, dnsAddr),
+			durationOption(optLeaseTime, leaseLength),
+			durationOption(optRenewalTime, renewalTime),
+			durationOption(optRebindingTime, rebindingTime),
+		)
+	}
+	m := newMessage(req.msg.xid(), clientLinkAddr, opts)
+	m[opOffset] = byte(opReply)
+	if t != msgNak {
+		copy(m[yiaddrOffset:], clientAddr.Address.AsSlice())
+	}
+
+	const hdrLen = header.IPv4MinimumSize + header.UDPMinimumSize
+	b := make([]byte, hdrLen+len(m))
+	ip := header.IPv4(b)
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(b)),
+		TTL:         64,
+		Protocol:    uint8(header.UDPProtocolNumber),
+		SrcAddr:     serverAddr,
+		DstAddr:     dst,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	// A zero UDP checksum means none was computed.
+	header.UDP(ip.Payload()).Encode(&header.UDPFields{
+		SrcPort: ServerPort,
+		DstPort: ClientPort,
+		Length:  uint16(header.UDPMinimumSize + len(m)),
+	})
+	copy(b[hdrLen:], m)
+
+	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+		Payload: buffer.MakeWithData(b),
+	})
+	defer pkt.DecRef()
+	c.ep.InjectInbound(header.IPv4ProtocolNumber, pkt)
+}
+
+func durationOption(code optionCode, d time.Duration) option {
+	body := make([]byte, 4)
+	binary.BigEndian.PutUint32(body, uint32(d/time.Second))
+	return option{code: code, body: body}
+}
+
+// waitEvent returns the next call to the client's AcquiredFunc.
+func (c *testContext) waitEvent() event {
+	c.t.Helper()
+	select {
+	case ev := <-c.events:
+		return ev
+	case <-time.After(waitTimeout):
+		c.t.Fatal("timed out waiting for the lease to change")
+		return event{}
+	}
+}
+
+// acquire runs the exchange that leases clientAddr to the client.
+func (c *testContext) acquire() {
+	c.t.Helper()
+
+	discover := c.readRequest(msgDiscover)
+	c.reply(discover, msgOffer, header.IPv4Broadcast)
+	req := c.readRequest(msgRequest)
+	if got := req.msg.xid(); got != discover.msg.xid() {
+		c.t.Errorf("got DHCPREQUEST xid = %d, want = %d", got, discover.msg.xid())
+	}
+	if got, ok := req.opts.addr(optRequestedIPAddress); !ok || got != clientAddr.Address {
+		c.t.Errorf("got requested IP address = (%s, %t), want = (%s, true)", got, ok, clientAddr.Address)
+	}
+	if got, ok := req.opts.addr(optServerIdentifier); !ok || got != serverAddr {
+		c.t.Errorf("got server identifier = (%s, %t), want = (%s, true)", got, ok, serverAddr)
+	}
+	c.reply(req, msgAck, header.IPv4Broadcast)
+
+	ev := c.waitEvent()
+	if ev.lost != (tcpip.AddressWithPrefix{}) || ev.acquired != clientAddr {
+		c.t.Fatalf("got event = (lost %s, acquired %s), want = (lost none, acquired %s)", ev.lost, ev.acquired, clientAddr)
+	}
+}
+
+func (c *testContext) checkInstalled(want bool) {
+	c.t.Helper()
+
+	found := false
+	for _, addr := range c.s.AllAddresses()[nicID] {
+		switch addr.AddressWithPrefix {
+		case clientAddr:
+			found = true
+		case header.IPv4Any.WithPrefix():
+			// Once the lease is lost, the client may already be acquiring a
+			// new one from the unspecified address.
+			if want {
+				c.t.Errorf("unspecified address left assigned to NIC %d", nicID)
+			}
+		}
+	}
+	if found != want {
+		c.t.Errorf("got %s assigned = %t, want = %t", clientAddr, found, want)
+	}
+
+	routes := []tcpip.Route{
+		{Destination: clientAddr.Subnet(), NIC: nicID},
+		{Destination: header.IPv4EmptySubnet, Gateway: routerAddr, NIC: nicID},
+	}
+	for _, route := range routes {
+		found := false
+		for _, r := range c.s.GetRouteTable() {
+			if r.Equal(route) {
+				found = true
+			}
+		}
+		if found != want {
+			c.t.Errorf("got route %s installed = %t, want = %t", route, found, want)
+		}
+	}
+}
+
+func TestAcquire(t *testing.T) {
+	c := newTestContext(t)
+
+	discover := c.readRequest(msgDiscover)
+	if discover.src != header.IPv4Any {
+		t.Errorf("got DHCPDISCOVER source = %s, want = %s", discover.src, header.IPv4Any)
+	}
+	if discover.dst != header.IPv4Broadcast {
+		t.Errorf("got DHCPDISCOVER destination = %s, want = %s", discover.dst, header.IPv4Broadcast)
+	}
+	if got := binary.BigEndian.Uint16(discover.msg[flagsOffset:]); got != flagBroadcast {
+		t.Errorf("got DHCPDISCOVER flags = %#x, want = %#x", got, flagBroadcast)
+	}
+
+	// Without a reply, the DHCPDISCOVER is retransmitted.
+	c.clock.Advance(retransmission)
+	if got := c.readRequest(msgDiscover).msg.xid(); got != discover.msg.xid() {
+		t.Errorf("got retransmitted DHCPDISCOVER xid = %d, want = %d", got, discover.msg.xid())
+	}
+	c.clock.Advance(2 * retransmission)
+	c.acquire()
+	c.checkInstalled(true)
+
+	addr, cfg := c.client.Lease()
+	if addr != clientAddr {
+		t.Errorf("got Lease() address = %s, want = %s", addr, clientAddr)
+	}
+	if cfg.ServerAddress != serverAddr {
+		t.Errorf("got cfg.ServerAddress = %s, want = %s", cfg.ServerAddress, serverAddr)
+	}
+	if cfg.Router != routerAddr {
+		t.Errorf("got cfg.Router = %s, want = %s", cfg.Router, routerAddr)
+	}
+	if len(cfg.DNS) != 1 || cfg.DNS[0] != dnsAddr {
+		t.Errorf("got cfg.DNS = %s, want = [%s]", cfg.DNS, dnsAddr)
+	}
+	if cfg.LeaseLength != leaseLength || cfg.RenewalTime != renewalTime || cfg.RebindingTime != rebindingTime {
+		t.Errorf("got cfg times = (%s, %s, %s), want = (%s, %s, %s)", cfg.LeaseLength, cfg.RenewalTime, cfg.RebindingTime, leaseLength, renewalTime, rebindingTime)
+	}
+}
+
+func TestRenew(t *testing.T) {
+	c := newTestContext(t)
+	c.acquire()
+
+	// At T1, the lease is renewed by unicast with the server that granted it.
+	c.clock.Advance(renewalTime)
+	req := c.readRequest(msgRequest)
+	if req.src != clientAddr.Address {
+		t.Errorf("got renewal source = %s, want = %s", req.src, clientAddr.Address)
+	}
+	if req.dst != serverAddr {
+		t.Errorf("got renewal destination = %s, want = %s", req.dst, serverAddr)
+	}
+	if got := req.msg.ciaddr(); got != clientAddr.Address {
+		t.Errorf("got renewal ciaddr = %s, want = %s", got, clientAddr.Address)
+	}
+	if _, ok := req.opts.addr(optServerIdentifier); ok {
+		t.Error("renewal carries a server identifier")
+	}
+	c.reply(req, msgAck, clientAddr.Address)
+
+	if ev := c.waitEvent(); ev.lost != (tcpip.AddressWithPrefix{}) || ev.acquired != clientAddr {
+		t.Errorf("got event = (lost %s, acquired %s), want = (lost none, acquired %s)", ev.lost, ev.acquired, clientAddr)
+	}
+	c.checkInstalled(true)
+}
+
+func TestRebind(t *testing.T) {
+	c := newTestContext(t)
+	c.acquire()
+
+	c.clock.Advance(renewalTime)
+	c.readRequest(msgRequest)
+
+	// Once T2 passes without a reply, the lease is renewed with any server.
+	// Retransmissions that were due by then may still be sent by unicast.
+	c.clock.Advance(rebindingTime - renewalTime)
+	for i := 0; ; i++ {
+		req := c.readRequest(msgRequest)
+		if req.dst == header.IPv4Broadcast {
+			c.reply(req, msgAck, header.IPv4Broadcast)
+			break
+		}
+		if req.dst != serverAddr {
+			t.Fatalf("got renewal destination = %s, want = %s or %s", req.dst, serverAddr, header.IPv4Broadcast)
+		}
+		if i == 10 {
+			t.Fatal("renewal was never broadcast")
+		}
+	}
+
+	if ev := c.waitEvent(); ev.acquired != clientAddr {
+		t.Errorf("got event acquired = %s, want = %s", ev.acquired, clientAddr)
+	}
+	c.checkInstalled(true)
+}
+
+func TestNak(t *testing.T) {
+	c := newTestContext(t)
+	c.acquire()
+
+	c.clock.Advance(renewalTime)
+	c.reply(c.readRequest(msgRequest), msgNak, clientAddr.Address)
+
+	if ev := c.waitEvent(); ev.lost != clientAddr || ev.acquired != (tcpip.AddressWithPrefix{}) {
+		t.Errorf("got event = (lost %s, acquired %s), want = (lost %s, acquired none)", ev.lost, ev.acquired, clientAddr)
+	}
+	c.checkInstalled(false)
+	if addr, _ := c.client.Lease(); addr != (tcpip.AddressWithPrefix{}) {
+		t.Errorf("got Lease() address = %s, want none", addr)
+	}
+
+	// The client starts over.
+	c.acquire()
+	c.checkInstalled(true)
+}
@@ -19,11 +19,14 @@ import (
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/buffer"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/faketime"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
 	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
 	"gvisor.dev/gvisor/pkg/tcpip/link/ethernet"
+	"gvisor.dev/gvisor/pkg/tcpip/network/arp"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/raw"
 	"gvisor.dev/gvisor/pkg/waiter"
@@ -112,3 +115,111 @@ func TestWriteRaw(t *testing.T) {
 		})
 	}
 }
+
+func TestReceive(t *testing.T) {
+	const (
+		nicID      = 1
+		payloadLen = 32
+		linkAddr   = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x06")
+		remoteAddr = tcpip.LinkAddress("\x02\x02\x03\x04\x05\x07")
+	)
+
+	tests := []struct {
+		name      string
+		cooked    bool
+		netProto  tcpip.NetworkProtocolNumber
+		wantFrame bool
+	}{
+		{
+			name:      "raw all protocols",
+			cooked:    false,
+			netProto:  header.EthernetProtocolAll,
+			wantFrame: true,
+		},
+		{
+			name:      "cooked all protocols",
+			cooked:    true,
+			netProto:  header.EthernetProtocolAll,
+			wantFrame: true,
+		},
+		{
+			name:      "raw matching protocol",
+			cooked:    false,
+			netProto:  header.IPv4ProtocolNumber,
+			wantFrame: true,
+		},
+		{
+			name:      "cooked other protocol",
+			cooked:    true,
+			netProto:  header.ARPProtocolNumber,
+			wantFrame: false,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol, arp.NewProtocol},
+				RawFactory:       &raw.EndpointFactory{},
+				Clock:            &faketime.NullClock{},
+			})
+			defer s.Destroy()
+
+			chEP := channel.New(1, header.IPv4MinimumMTU, linkAddr)
+			defer chEP.Close()
+			if err := s.CreateNICWithOptions(nicID, ethernet.New(chEP), stack.NICOptions{DeliverLinkPackets: true}); err != nil {
+				t.Fatalf("s.CreateNICWithOptions(%d, _, _): %s", nicID, err)
+			}
+
+			var wq waiter.Queue
+			ep, err := s.NewPacketEndpoint(test.cooked, test.netProto, &wq)
+			if err != nil {
+				t.Fatalf("s.NewPacketEndpoint(%t, %d, _): %s", test.cooked, test.netProto, err)
+			}
+			defer ep.Close()
+
+			frame := make([]byte, header.EthernetMinimumSize+payloadLen)
+			header.Ethernet(frame).Encode(&header.EthernetFields{
+				SrcAddr: remoteAddr,
+				DstAddr: linkAddr,
+				Type:    header.IPv4ProtocolNumber,
+			})
+			for i := header.EthernetMinimumSize; i < len(frame); i++ {
+				frame[i] = byte(i)
+			}
+			pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{
+				Payload: buffer.MakeWithData(frame),
+			})
+			chEP.InjectInbound(0, pkt)
+			pkt.DecRef()
+
+			var buf bytes.Buffer
+			res, err := ep.Read(&buf, tcpip.ReadOptions{NeedRemoteAddr: true, NeedLinkPacketInfo: true})
+			if !test.wantFrame {
+				if _, ok := err.(*tcpip.ErrWouldBlock); !ok {
+					t.Fatalf("got ep.Read(...) = (%+v, %v), want = (_, %s)", res, err, &tcpip.ErrWouldBlock{})
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ep.Read(...): %s", err)
+			}
+
+			want := frame
+			if test.cooked {
+				want = frame[header.EthernetMinimumSize:]
+			}
+			if diff := cmp.Diff(want, buf.Bytes()); diff != "" {
+				t.Errorf("packet data mismatch (-want +got):\n%s", diff)
+			}
+			wantRemote := tcpip.FullAddress{NIC: nicID, LinkAddr: remoteAddr}
+			if diff := cmp.Diff(wantRemote, res.RemoteAddr); diff != "" {
+				t.Errorf("remote address mismatch (-want +got):\n%s", diff)
+			}
+			wantInfo := tcpip.LinkPacketInfo{Protocol: header.IPv4ProtocolNumber, PktType: tcpip.PacketHost}
+			if diff := cmp.Diff(wantInfo, res.LinkPacketInfo); diff != "" {
+				t.Errorf("link packet info mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
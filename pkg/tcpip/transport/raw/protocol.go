@@ -30,6 +30,11 @@ func (EndpointFactory) NewUnassociatedEndpoint(stack *stack.Stack, netProto tcpi
 	return newEndpoint(stack, netProto, transProto, waiterQueue, false /* associated */)
 }
 
+// NewAssociatedEndpoint implements stack.RawFactory.NewAssociatedEndpoint.
+func (EndpointFactory) NewAssociatedEndpoint(stack *stack.Stack, netProto tcpip.NetworkProtocolNumber, transProto tcpip.TransportProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, tcpip.Error) {
+	return newEndpoint(stack, netProto, transProto, waiterQueue, true /* associated */)
+}
+
 // NewPacketEndpoint implements stack.RawFactory.NewPacketEndpoint.
 func (EndpointFactory) NewPacketEndpoint(stack *stack.Stack, cooked bool, netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, tcpip.Error) {
 	return packet.NewEndpoint(stack, cooked, netProto, waiterQueue), nil
@@ -44,6 +49,11 @@ func (CreateOnlyFactory) NewUnassociatedEndpoint(stk *stack.Stack, _ tcpip.Netwo
 	return noop.New(stk), nil
 }
 
+// NewAssociatedEndpoint implements stack.RawFactory.NewAssociatedEndpoint.
+func (CreateOnlyFactory) NewAssociatedEndpoint(stk *stack.Stack, _ tcpip.NetworkProtocolNumber, _ tcpip.TransportProtocolNumber, _ *waiter.Queue) (tcpip.Endpoint, tcpip.Error) {
+	return noop.New(stk), nil
+}
+
 // NewPacketEndpoint implements stack.RawFactory.NewPacketEndpoint.
 func (CreateOnlyFactory) NewPacketEndpoint(*stack.Stack, bool, tcpip.NetworkProtocolNumber, *waiter.Queue) (tcpip.Endpoint, tcpip.Error) {
 	// This isn't needed by anything, so it isn't implemented.
@@ -15,16 +15,22 @@
 package raw_test
 
 import (
+	"bytes"
 	"os"
 	"testing"
 
+	"gvisor.dev/gvisor/pkg/buffer"
 	"gvisor.dev/gvisor/pkg/refs"
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/checker"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/loopback"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/raw"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/testing/context"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+	"gvisor.dev/gvisor/pkg/waiter"
 )
 
 const (
@@ -154,6 +160,107 @@ func TestReceiveControlMessage(t *testing.T) {
 	}
 }
 
+func TestCustomProtocolLoopback(t *testing.T) {
+	const (
+		nicID = 1
+		// customProto is a transport protocol number the stack has no
+		// protocol implementation for.
+		customProto tcpip.TransportProtocolNumber = 253
+	)
+	loopbackAddr := tcpip.AddrFromSlice([]byte{127, 0, 0, 1})
+	payload := []byte{1, 2, 3, 4, 5, 6}
+
+	for _, test := range []struct {
+		name           string
+		headerIncluded bool
+	}{
+		{name: "Payload", headerIncluded: false},
+		{name: "HeaderIncluded", headerIncluded: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s := stack.New(stack.Options{
+				NetworkProtocols: []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+				RawFactory:       raw.EndpointFactory{},
+			})
+			defer s.Destroy()
+			if err := s.CreateNIC(nicID, loopback.New()); err != nil {
+				t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+			}
+			protocolAddr := tcpip.ProtocolAddress{
+				Protocol:          ipv4.ProtocolNumber,
+				AddressWithPrefix: loopbackAddr.WithPrefix(),
+			}
+			if err := s.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+				t.Fatalf("AddProtocolAddress(%d, %#v, {}): %s", nicID, protocolAddr, err)
+			}
+			s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicID}})
+
+			var rwq waiter.Queue
+			rep, err := s.NewRawEndpoint(customProto, ipv4.ProtocolNumber, &rwq, true /* associated */)
+			if err != nil {
+				t.Fatalf("NewRawEndpoint(%d, %d, _, true): %s", customProto, ipv4.ProtocolNumber, err)
+			}
+			defer rep.Close()
+			we, ch := waiter.NewChannelEntry(waiter.ReadableEvents)
+			rwq.EventRegister(&we)
+			defer rwq.EventUnregister(&we)
+
+			var swq waiter.Queue
+			sep, err := s.NewRawEndpoint(customProto, ipv4.ProtocolNumber, &swq, true /* associated */)
+			if err != nil {
+				t.Fatalf("NewRawEndpoint(%d, %d, _, true): %s", customProto, ipv4.ProtocolNumber, err)
+			}
+			defer sep.Close()
+
+			data := payload
+			if test.headerIncluded {
+				sep.SocketOptions().SetHeaderIncluded(true)
+				data = make([]byte, header.IPv4MinimumSize+len(payload))
+				ip := header.IPv4(data)
+				ip.Encode(&header.IPv4Fields{
+					TotalLength: uint16(len(data)),
+					TTL:         64,
+					Protocol:    uint8(customProto),
+					SrcAddr:     loopbackAddr,
+					DstAddr:     loopbackAddr,
+				})
+				ip.SetChecksum(^ip.CalculateChecksum())
+				copy(data[header.IPv4MinimumSize:], payload)
+			}
+			var r bytes.Reader
+			r.Reset(data)
+			if _, err := sep.Write(&r, tcpip.WriteOptions{To: &tcpip.FullAddress{Addr: loopbackAddr}}); err != nil {
+				t.Fatalf("sep.Write(_, _): %s", err)
+			}
+
+			<-ch
+			var buf bytes.Buffer
+			if _, err := rep.Read(&buf, tcpip.ReadOptions{}); err != nil {
+				t.Fatalf("rep.Read(_, {}): %s", err)
+			}
+			// Raw IPv4 endpoints return the network header.
+			v := buffer.NewViewWithData(buf.Bytes())
+			defer v.Release()
+			checker.IPv4(t, v,
+				checker.SrcAddr(loopbackAddr),
+				checker.DstAddr(loopbackAddr),
+			)
+			if got, want := header.IPv4(buf.Bytes()).Protocol(), uint8(customProto); got != want {
+				t.Errorf("got protocol = %d, want = %d", got, want)
+			}
+			if got := buf.Bytes()[header.IPv4MinimumSize:]; !bytes.Equal(got, payload) {
+				t.Errorf("got payload = %v, want = %v", got, payload)
+			}
+
+			// The packet was consumed by the raw endpoint so it must not
+			// trigger a protocol unreachable error.
+			if got := s.Stats().ICMP.V4.PacketsSent.DstUnreachable.Value(); got != 0 {
+				t.Errorf("got s.Stats().ICMP.V4.PacketsSent.DstUnreachable.Value() = %d, want = 0", got)
+			}
+		})
+	}
+}
+
 func TestMain(m *testing.M) {
 	refs.SetLeakMode(refs.LeaksPanic)
 	code := m.Run()
@@ -203,7 +203,7 @@ func (l *listenContext) createConnectingEndpoint(s *segment, rcvdSynOpts header.
 	n.route = route
 	n.effectiveNetProtos = []tcpip.NetworkProtocolNumber{s.pkt.NetworkProtocolNumber}
 	n.ops.SetReceiveBufferSize(int64(l.rcvWnd), false /* notify */)
-	n.amss = calculateAdvertisedMSS(n.userMSS, n.route)
+	n.amss = calculateAdvertisedMSS(n.userMSS, n.maxMSS, n.route)
 	n.setEndpointState(StateConnecting)
 
 	n.maybeEnableTimestamp(rcvdSynOpts)
@@ -293,6 +293,11 @@ func (l *listenContext) startHandshake(s *segment, opts header.TCPSynOptions, qu
 	// Initialize and start the handshake.
 	h = ep.newPassiveHandshake(isn, irs, opts, deferAccept)
 	h.listenEP = l.listenEP
+	// Agree to use ECN if the peer offered it. start() withdraws the
+	// agreement if ECN is disabled.
+	if s.flags.Contains(header.TCPFlagEce | header.TCPFlagCwr) {
+		h.flags |= header.TCPFlagEce
+	}
 	h.start()
 	h.ep.mu.Unlock()
 	return h, nil
@@ -363,6 +368,16 @@ func (e *Endpoint) propagateInheritableOptionsLocked(n *Endpoint) {
 	n.boundBindToDevice = e.boundBindToDevice
 	n.boundPortFlags = e.boundPortFlags
 	n.userMSS = e.userMSS
+	n.windowClamp = e.windowClamp
+	n.windowClampSet = e.windowClampSet
+	n.ops.SetOutOfBandInline(e.ops.GetOutOfBandInline())
+	if key := e.md5Key(n.TransportEndpointInfo.ID.RemoteAddress); key != nil {
+		n.md5Mu.Lock()
+		n.md5Keys = map[tcpip.Address][]byte{n.TransportEndpointInfo.ID.RemoteAddress: key}
+		n.md5Mu.Unlock()
+		// Segmentation offload was set up before the key was known.
+		n.gso = stack.GSO{}
+	}
 }
 
 // reserveTupleLocked reserves an accepted endpoint's tuple.
@@ -444,7 +459,7 @@ func (e *Endpoint) handleListenSegment(ctx *listenContext, s *segment) tcpip.Err
 		// RFC 793 section 3.4 page 35 (figure 12) outlines that a RST
 		// must be sent in response to a SYN-ACK while in the listen
 		// state to prevent completing a handshake from an old SYN.
-		return replyWithReset(e.stack, s, e.sendTOS, e.ipv4TTL, e.ipv6HopLimit)
+		return replyWithReset(e.stack, s, e.sendTOS, e.ipv4TTL, e.ipv6HopLimit, e.md5Key(s.id.RemoteAddress))
 	}
 
 	switch {
@@ -517,7 +532,7 @@ func (e *Endpoint) handleListenSegment(ctx *listenContext, s *segment) tcpip.Err
 			WS:    -1,
 			TS:    opts.TS,
 			TSEcr: opts.TSVal,
-			MSS:   calculateAdvertisedMSS(e.userMSS, route),
+			MSS:   calculateAdvertisedMSS(e.userMSS, e.maxMSS, route),
 		}
 		if opts.TS {
 			offset := e.protocol.tsOffset(net.DestinationAddress(), net.SourceAddress())
@@ -607,7 +622,7 @@ func (e *Endpoint) handleListenSegment(ctx *listenContext, s *segment) tcpip.Err
 			// The only time we should reach here when a connection
 			// was opened and closed really quickly and a delayed
 			// ACK was received from the sender.
-			return replyWithReset(e.stack, s, e.sendTOS, e.ipv4TTL, e.ipv6HopLimit)
+			return replyWithReset(e.stack, s, e.sendTOS, e.ipv4TTL, e.ipv6HopLimit, e.md5Key(s.id.RemoteAddress))
 		}
 
 		// Keep hold of acceptMu until the new endpoint is in the accept queue (or
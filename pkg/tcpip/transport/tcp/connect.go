@@ -113,6 +113,9 @@ type handshake struct {
 	// retransmitTimer is used to retransmit SYN/SYN-ACK with exponential backoff
 	// till handshake is either completed or timesout.
 	retransmitTimer *backoffTimer `state:"nosave"`
+
+	// retransmits is the number of times the SYN or SYN-ACK was retransmitted.
+	retransmits uint8
 }
 
 // timerHandler takes a handler function for a timer and returns a function that
@@ -134,7 +137,9 @@ func timerHandler(e *Endpoint, f func() tcpip.Error) func() {
 			} else {
 				e.hardError = err
 			}
-			e.lastError = err
+			// The hard error replaces any pending error so that it is only
+			// reported once.
+			e.lastError = nil
 			e.lastErrorMu.Unlock()
 			e.cleanupLocked()
 			e.setEndpointState(StateError)
@@ -168,9 +173,10 @@ func (e *Endpoint) newHandshake() (h *handshake) {
 	e.h = h
 	// By the time handshake is created, e.ID is already initialized.
 	e.TSOffset = e.protocol.tsOffset(e.ID.LocalAddress, e.ID.RemoteAddress)
-	timer, err := newBackoffTimer(h.ep.stack.Clock(), InitialRTO, MaxRTO, timerHandler(e, h.retransmitHandlerLocked))
+	rto := e.protocol.initialSynRTO()
+	timer, err := newBackoffTimer(h.ep.stack.Clock(), rto, MaxRTO, timerHandler(e, h.retransmitHandlerLocked))
 	if err != nil {
-		panic(fmt.Sprintf("newBackOffTimer(_, %s, %s, _) failed: %s", InitialRTO, MaxRTO, err))
+		panic(fmt.Sprintf("newBackOffTimer(_, %s, %s, _) failed: %s", rto, MaxRTO, err))
 	}
 	h.retransmitTimer = timer
 	return h
@@ -315,6 +321,13 @@ func (h *handshake) synSentState(s *segment) tcpip.Error {
 	// Remember if the SACKPermitted option was negotiated.
 	h.ep.maybeEnableSACKPermitted(rcvSynOpts)
 
+	// Keep using ECN only if the peer agreed to. Our SYN-ACK in a
+	// simultaneous open only carries ECE.
+	if !ecnAccepted(s) {
+		h.flags &^= header.TCPFlagEce
+	}
+	h.flags &^= header.TCPFlagCwr
+
 	// Remember the sequence we'll ack from now on.
 	h.ackNum = s.sequenceNumber + 1
 	h.flags |= header.TCPFlagAck
@@ -353,6 +366,9 @@ func (h *handshake) synSentState(s *segment) tcpip.Error {
 	if ttl == 0 {
 		ttl = h.ep.route.DefaultTTL()
 	}
+	// Retransmissions must carry the options negotiated with the peer's SYN
+	// rather than the ones offered in our own.
+	h.sendSYNOpts = synOpts
 	h.ep.sendSynTCP(h.ep.route, tcpFields{
 		id:     h.ep.TransportEndpointInfo.ID,
 		ttl:    ttl,
@@ -408,7 +424,13 @@ func (h *handshake) synRcvdState(s *segment) tcpip.Error {
 	// sequence number outside of the window causes an ACK with the proper seq
 	// number and "After sending the acknowledgment, drop the unacceptable
 	// segment and return."
-	if !s.sequenceNumber.InWindow(h.ackNum, h.rcvWnd) {
+	//
+	// In a simultaneous open the peer's SYN-ACK reuses the sequence number
+	// of the SYN we already acknowledged, so it falls just before the
+	// window. Accept it if it acknowledges our SYN, as Linux does in
+	// tcp_validate_incoming.
+	simOpenSynAck := h.active && s.flags.Contains(header.TCPFlagSyn|header.TCPFlagAck) && s.sequenceNumber == h.ackNum-1 && s.ackNumber == h.iss+1
+	if !simOpenSynAck && !s.sequenceNumber.InWindow(h.ackNum, h.rcvWnd) {
 		if h.ep.allowOutOfWindowAck() {
 			h.ep.sendEmptyRaw(header.TCPFlagAck, h.iss+1, h.ackNum, h.rcvWnd)
 		}
@@ -546,7 +568,7 @@ func (h *handshake) processSegments() tcpip.Error {
 // resolution is required.
 func (h *handshake) start() {
 	h.startTime = h.ep.stack.Clock().NowMonotonic()
-	h.ep.amss = calculateAdvertisedMSS(h.ep.userMSS, h.ep.route)
+	h.ep.amss = calculateAdvertisedMSS(h.ep.userMSS, h.ep.maxMSS, h.ep.route)
 	var sackEnabled tcpip.TCPSACKEnabled
 	if err := h.ep.stack.TransportProtocolOption(ProtocolNumber, &sackEnabled); err != nil {
 		// If stack returned an error when checking for SACKEnabled
@@ -554,6 +576,15 @@ func (h *handshake) start() {
 		sackEnabled = false
 	}
 
+	// Offer ECN in a SYN, or accept it in a SYN-ACK if the peer offered it,
+	// only if it is enabled. See RFC 3168 section 6.1.1.
+	var ecnEnabled tcpip.TCPECNEnabled
+	if err := h.ep.stack.TransportProtocolOption(ProtocolNumber, &ecnEnabled); err != nil || !ecnEnabled {
+		h.flags &^= header.TCPFlagEce | header.TCPFlagCwr
+	} else if h.state == handshakeSynSent {
+		h.flags |= header.TCPFlagEce | header.TCPFlagCwr
+	}
+
 	synOpts := header.TCPSynOptions{
 		WS:            h.rcvWndScale,
 		TS:            true,
@@ -599,6 +630,12 @@ func (h *handshake) retransmitHandlerLocked() tcpip.Error {
 		return nil
 	}
 
+	// Give up on an active open once the SYN has been retransmitted
+	// maxSynRetries times, like Linux does with TCP_SYNCNT.
+	if h.active && h.retransmits >= e.maxSynRetries {
+		return &tcpip.ErrTimeout{}
+	}
+
 	if err := h.retransmitTimer.reset(); err != nil {
 		return err
 	}
@@ -621,6 +658,7 @@ func (h *handshake) retransmitHandlerLocked() tcpip.Error {
 			ack:    h.ackNum,
 			rcvWnd: h.rcvWnd,
 		}, h.sendSYNOpts)
+		h.retransmits++
 		// If we have ever retransmitted the SYN-ACK or
 		// SYN segment, we should only measure RTT if
 		// TS option is present.
@@ -639,6 +677,9 @@ func (h *handshake) transitionToStateEstablishedLocked(s *segment) {
 		h.retransmitTimer.stop()
 	}
 
+	// ECN is in use if it is still offered in our last SYN or SYN-ACK.
+	h.ep.ecn = h.flags.Contains(header.TCPFlagEce)
+
 	// Transfer handshake state to TCP connection. We disable
 	// receive window scaling if the peer doesn't support it
 	// (indicated by a negative send window scale).
@@ -729,7 +770,7 @@ func putOptions(options []byte) {
 	optionPool.Put(optionsToArray(options))
 }
 
-func makeSynOptions(opts header.TCPSynOptions) []byte {
+func makeSynOptions(opts header.TCPSynOptions, md5 bool) []byte {
 	// Emulate linux option order. This is as follows:
 	//
 	// if md5: NOP NOP MD5SIG 18 md5sig(16)
@@ -747,9 +788,16 @@ func makeSynOptions(opts header.TCPSynOptions) []byte {
 	//	cookie(variable) [padding to four bytes]
 	//
 	options := getOptions()
+	offset := 0
+
+	if md5 {
+		offset += header.EncodeNOP(options[offset:])
+		offset += header.EncodeNOP(options[offset:])
+		offset += header.EncodeMD5Option(options[offset:])
+	}
 
 	// Always encode the mss.
-	offset := header.EncodeMSSOption(uint32(opts.MSS), options)
+	offset += header.EncodeMSSOption(uint32(opts.MSS), options[offset:])
 
 	// Special ordering is required here. If both TS and SACK are enabled,
 	// then the SACK option precedes TS, with no padding. If they are
@@ -794,10 +842,19 @@ type tcpFields struct {
 	rcvWnd seqnum.Size
 	opts   []byte
 	txHash uint32
+
+	// md5Key is the key used to sign the segment. If it is set, opts must
+	// hold an MD5 signature option.
+	md5Key []byte
+
+	// urgent is set if sndUp holds an urgent pointer that segments starting
+	// before it must carry.
+	urgent bool
+	sndUp  seqnum.Value
 }
 
 func (e *Endpoint) sendSynTCP(r *stack.Route, tf tcpFields, opts header.TCPSynOptions) tcpip.Error {
-	tf.opts = makeSynOptions(opts)
+	tf.opts = makeSynOptions(opts, e.md5Key(tf.id.RemoteAddress) != nil)
 	// We ignore SYN send errors and let the callers re-attempt send.
 	p := stack.NewPacketBuffer(stack.PacketBufferOptions{ReserveHeaderBytes: header.TCPMinimumSize + int(r.MaxHeaderLength()) + len(tf.opts)})
 	defer p.DecRef()
@@ -811,6 +868,7 @@ func (e *Endpoint) sendSynTCP(r *stack.Route, tf tcpFields, opts header.TCPSynOp
 // This method takes ownership of pkt.
 func (e *Endpoint) sendTCP(r *stack.Route, tf tcpFields, pkt *stack.PacketBuffer, gso stack.GSO) tcpip.Error {
 	tf.txHash = e.txHash
+	tf.md5Key = e.md5Key(tf.id.RemoteAddress)
 	if err := sendTCP(r, tf, pkt, gso, e.owner); err != nil {
 		e.stats.SendErrors.SegmentSendToNetworkFailed.Increment()
 		return err
@@ -823,17 +881,36 @@ func buildTCPHdr(r *stack.Route, tf tcpFields, pkt *stack.PacketBuffer, gso stac
 	optLen := len(tf.opts)
 	tcp := header.TCP(pkt.TransportHeader().Push(header.TCPMinimumSize + optLen))
 	pkt.TransportProtocolNumber = header.TCPProtocolNumber
+	flags := tf.flags
+	var urgentPointer uint16
+	if tf.urgent && tf.seq.LessThan(tf.sndUp) {
+		// As in Linux, a pointer too far ahead to be represented is capped so
+		// that the peer still learns that urgent data is coming.
+		flags |= header.TCPFlagUrg
+		urgentPointer = math.MaxUint16
+		if up := tf.seq.Size(tf.sndUp); up < math.MaxUint16 {
+			urgentPointer = uint16(up)
+		}
+	}
 	tcp.Encode(&header.TCPFields{
-		SrcPort:    tf.id.LocalPort,
-		DstPort:    tf.id.RemotePort,
-		SeqNum:     uint32(tf.seq),
-		AckNum:     uint32(tf.ack),
-		DataOffset: uint8(header.TCPMinimumSize + optLen),
-		Flags:      tf.flags,
-		WindowSize: uint16(tf.rcvWnd),
+		SrcPort:       tf.id.LocalPort,
+		DstPort:       tf.id.RemotePort,
+		SeqNum:        uint32(tf.seq),
+		AckNum:        uint32(tf.ack),
+		DataOffset:    uint8(header.TCPMinimumSize + optLen),
+		Flags:         flags,
+		WindowSize:    uint16(tf.rcvWnd),
+		UrgentPointer: urgentPointer,
 	})
 	copy(tcp[header.TCPMinimumSize:], tf.opts)
 
+	// Signed segments are never offloaded (see initGSO and setMD5KeyLocked),
+	// so the digest covers exactly the segment put on the wire.
+	if tf.md5Key != nil {
+		digest := md5Digest(tf.md5Key, r.LocalAddress(), r.RemoteAddress(), tcp, pkt.Data())
+		copy(header.TCPMD5Digest(tcp[header.TCPMinimumSize:]), digest[:])
+	}
+
 	xsum := r.PseudoHeaderChecksum(ProtocolNumber, uint16(pkt.Size()))
 	// Only calculate the checksum if offloading isn't supported.
 	if gso.Type != stack.GSONone && gso.NeedsCsum {
@@ -932,6 +1009,11 @@ func (e *Endpoint) makeOptions(sackBlocks []header.SACKBlock) []byte {
 	// N.B. the ordering here matches the ordering used by Linux internally
 	// and described in the raw makeOptions function. We don't include
 	// unnecessary cases here (post connection.)
+	if e.md5Key(e.TransportEndpointInfo.ID.RemoteAddress) != nil {
+		offset += header.EncodeNOP(options[offset:])
+		offset += header.EncodeNOP(options[offset:])
+		offset += header.EncodeMD5Option(options[offset:])
+	}
 	if e.SendTSOk {
 		// Embed the timestamp if timestamp has been enabled.
 		//
@@ -949,7 +1031,9 @@ func (e *Endpoint) makeOptions(sackBlocks []header.SACKBlock) []byte {
 		offset += header.EncodeNOP(options[offset:])
 		offset += header.EncodeTSOption(e.tsValNow(), e.recentTimestamp(), options[offset:])
 	}
-	if e.SACKPermitted && len(sackBlocks) > 0 {
+	// Only add SACK blocks if there is room for at least one of them, which
+	// is not the case when both timestamps and MD5 signatures are in use.
+	if e.SACKPermitted && len(sackBlocks) > 0 && maxOptionSize-offset >= 4+8 {
 		offset += header.EncodeNOP(options[offset:])
 		offset += header.EncodeNOP(options[offset:])
 		offset += header.EncodeSACKBlocks(sackBlocks, options[offset:])
@@ -967,12 +1051,13 @@ func (e *Endpoint) makeOptions(sackBlocks []header.SACKBlock) []byte {
 func (e *Endpoint) sendEmptyRaw(flags header.TCPFlags, seq, ack seqnum.Value, rcvWnd seqnum.Size) tcpip.Error {
 	pkt := stack.NewPacketBuffer(stack.PacketBufferOptions{})
 	defer pkt.DecRef()
-	return e.sendRaw(pkt, flags, seq, ack, rcvWnd)
+	return e.sendRaw(pkt, flags, seq, ack, rcvWnd, false /* ect */)
 }
 
 // sendRaw sends a TCP segment to the endpoint's peer. This method takes
-// ownership of pkt. pkt must not have any headers set.
-func (e *Endpoint) sendRaw(pkt *stack.PacketBuffer, flags header.TCPFlags, seq, ack seqnum.Value, rcvWnd seqnum.Size) tcpip.Error {
+// ownership of pkt. pkt must not have any headers set. If ect is set, the
+// segment is marked ECN-capable.
+func (e *Endpoint) sendRaw(pkt *stack.PacketBuffer, flags header.TCPFlags, seq, ack seqnum.Value, rcvWnd seqnum.Size, ect bool) tcpip.Error {
 	var sackBlocks []header.SACKBlock
 	if e.EndpointState() == StateEstablished && e.rcv.pendingRcvdSegments.Len() > 0 && (flags&header.TCPFlagAck != 0) {
 		sackBlocks = e.sack.Blocks[:e.sack.NumBlocks]
@@ -980,7 +1065,7 @@ func (e *Endpoint) sendRaw(pkt *stack.PacketBuffer, flags header.TCPFlags, seq,
 	options := e.makeOptions(sackBlocks)
 	defer putOptions(options)
 	pkt.ReserveHeaderBytes(header.TCPMinimumSize + int(e.route.MaxHeaderLength()) + len(options))
-	return e.sendTCP(e.route, tcpFields{
+	tf := tcpFields{
 		id:     e.TransportEndpointInfo.ID,
 		ttl:    calculateTTL(e.route, e.ipv4TTL, e.ipv6HopLimit),
 		tos:    e.sendTOS,
@@ -989,7 +1074,20 @@ func (e *Endpoint) sendRaw(pkt *stack.PacketBuffer, flags header.TCPFlags, seq,
 		ack:    ack,
 		rcvWnd: rcvWnd,
 		opts:   options,
-	}, pkt, e.gso)
+	}
+	if ect {
+		tf.tos |= ecnECT0
+	}
+	// Keep echoing a CE mark until the peer signals it reduced its
+	// window. See RFC 3168 section 6.1.3.
+	if e.ecnEcho && flags&(header.TCPFlagAck|header.TCPFlagSyn|header.TCPFlagRst) == header.TCPFlagAck {
+		tf.flags |= header.TCPFlagEce
+	}
+	if snd := e.snd; snd != nil && snd.urgent {
+		tf.urgent = true
+		tf.sndUp = snd.sndUp
+	}
+	return e.sendTCP(e.route, tf, pkt, e.gso)
 }
 
 // +checklocks:e.mu
@@ -1079,7 +1177,7 @@ func (e *Endpoint) tryDeliverSegmentFromClosedEndpoint(s *segment) {
 	}
 	if ep == nil {
 		if !s.flags.Contains(header.TCPFlagRst) {
-			replyWithReset(e.stack, s, stack.DefaultTOS, tcpip.UseDefaultIPv4TTL, tcpip.UseDefaultIPv6HopLimit)
+			replyWithReset(e.stack, s, stack.DefaultTOS, tcpip.UseDefaultIPv4TTL, tcpip.UseDefaultIPv6HopLimit, e.md5Key(s.id.RemoteAddress))
 		}
 		return
 	}
@@ -1110,48 +1208,55 @@ func (e *Endpoint) drainClosingSegmentQueue() {
 
 // +checklocks:e.mu
 func (e *Endpoint) handleReset(s *segment) (ok bool, err tcpip.Error) {
-	if e.rcv.acceptable(s.sequenceNumber, 0) {
-		// RFC 793, page 37 states that "in all states
-		// except SYN-SENT, all reset (RST) segments are
-		// validated by checking their SEQ-fields." So
-		// we only process it if it's acceptable.
-		switch e.EndpointState() {
-		// In case of a RST in CLOSE-WAIT linux moves
-		// the socket to closed state with an error set
-		// to indicate EPIPE.
+	if s.sequenceNumber != e.rcv.RcvNxt {
+		// See: https://tools.ietf.org/html/rfc5961#section-3.2
+		//   2) If the RST bit is set and the sequence number exactly matches
+		//    the next expected sequence number (RCV.NXT), then TCP MUST reset
+		//    the connection.
 		//
-		// Technically this seems to be at odds w/ RFC.
-		// As per https://tools.ietf.org/html/rfc793#section-2.7
-		// page 69 the behavior for a segment arriving
-		// w/ RST bit set in CLOSE-WAIT is inlined below.
+		//   3) If the RST bit is set and the sequence number does not exactly
+		//    match the next expected sequence value, yet is within the
+		//    current receive window, TCP MUST send an acknowledgment
+		//    (challenge ACK).
 		//
-		//  ESTABLISHED
-		//  FIN-WAIT-1
-		//  FIN-WAIT-2
-		//  CLOSE-WAIT
-
-		//  If the RST bit is set then, any outstanding RECEIVEs and
-		//  SEND should receive "reset" responses. All segment queues
-		//  should be flushed.  Users should also receive an unsolicited
-		//  general "connection reset" signal. Enter the CLOSED state,
-		//  delete the TCB, and return.
-		case StateCloseWait:
-			e.transitionToStateCloseLocked()
-			e.hardError = &tcpip.ErrAborted{}
-			return false, nil
-		default:
-			// RFC 793, page 37 states that "in all states
-			// except SYN-SENT, all reset (RST) segments are
-			// validated by checking their SEQ-fields." So
-			// we only process it if it's acceptable.
-
-			// Notify protocol goroutine. This is required when
-			// handleSegment is invoked from the processor goroutine
-			// rather than the worker goroutine.
-			return false, &tcpip.ErrConnectionReset{}
+		// This keeps an off-path attacker from resetting the connection
+		// with a RST that merely lands in the receive window. RSTs outside
+		// the window are silently dropped.
+		if e.rcv.acceptable(s.sequenceNumber, 0) {
+			e.snd.maybeSendOutOfWindowAck(s)
 		}
+		return true, nil
+	}
+	switch e.EndpointState() {
+	// In case of a RST in CLOSE-WAIT linux moves
+	// the socket to closed state with an error set
+	// to indicate EPIPE.
+	//
+	// Technically this seems to be at odds w/ RFC.
+	// As per https://tools.ietf.org/html/rfc793#section-2.7
+	// page 69 the behavior for a segment arriving
+	// w/ RST bit set in CLOSE-WAIT is inlined below.
+	//
+	//  ESTABLISHED
+	//  FIN-WAIT-1
+	//  FIN-WAIT-2
+	//  CLOSE-WAIT
+
+	//  If the RST bit is set then, any outstanding RECEIVEs and
+	//  SEND should receive "reset" responses. All segment queues
+	//  should be flushed.  Users should also receive an unsolicited
+	//  general "connection reset" signal. Enter the CLOSED state,
+	//  delete the TCB, and return.
+	case StateCloseWait:
+		e.transitionToStateCloseLocked()
+		e.hardError = &tcpip.ErrAborted{}
+		return false, nil
+	default:
+		// Notify protocol goroutine. This is required when
+		// handleSegment is invoked from the processor goroutine
+		// rather than the worker goroutine.
+		return false, &tcpip.ErrConnectionReset{}
 	}
-	return true, nil
 }
 
 // handleSegments processes all inbound segments.
@@ -1190,9 +1295,7 @@ func (e *Endpoint) handleSegmentsLocked() tcpip.Error {
 	}
 
 	// Send an ACK for all processed packets if needed.
-	if e.rcv.RcvNxt != e.snd.MaxSentAck {
-		e.snd.sendAck()
-	}
+	e.snd.sendPendingAck()
 
 	e.resetKeepaliveTimer(true /* receivedData */)
 
@@ -1372,8 +1475,10 @@ func (e *Endpoint) finWait2TimerExpired() {
 
 // +checklocks:e.mu
 func (e *Endpoint) handshakeFailed(err tcpip.Error) {
+	// The hard error replaces any pending error so that it is only reported
+	// once.
 	e.lastErrorMu.Lock()
-	e.lastError = err
+	e.lastError = nil
 	e.lastErrorMu.Unlock()
 	// handshakeFailed is also called from startHandshake when a listener
 	// transitions out of Listen state by the time the SYN is processed. In
@@ -0,0 +1,62 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+)
+
+// ECN codepoints carried in the low bits of the IPv4 TOS and IPv6 traffic
+// class fields, as defined in RFC 3168 section 5.
+const (
+	ecnMask = 0x3
+	ecnECT0 = 0x2
+	ecnCE   = 0x3
+)
+
+// congestionExperienced returns true if a router marked s with the CE
+// codepoint.
+func (s *segment) congestionExperienced() bool {
+	tos, _ := s.pkt.Network().TOS()
+	return tos&ecnMask == ecnCE
+}
+
+// ecnAccepted returns true if the peer agreed to use ECN in s, the SYN or
+// SYN-ACK it answered ours with. As described in RFC 3168 section 6.1.1, a
+// SYN-ACK sets ECE alone while a SYN sets both ECE and CWR.
+func ecnAccepted(s *segment) bool {
+	want := header.TCPFlagEce | header.TCPFlagCwr
+	if s.flags.Contains(header.TCPFlagAck) {
+		want = header.TCPFlagEce
+	}
+	return s.flags&(header.TCPFlagEce|header.TCPFlagCwr) == want
+}
+
+// handleECE reduces the congestion window in response to an ACK echoing a
+// CE mark, as if a segment had been lost but without retransmitting anything.
+// The window is reduced at most once per window of data, and the next new
+// data segment carries CWR to tell the peer to stop echoing. See RFC 3168
+// section 6.1.2.
+// +checklocks:s.ep.mu
+func (s *sender) handleECE() {
+	if s.FastRecovery.Active || s.state == tcpip.RTORecovery || !s.ecnRecover.LessThan(s.SndUna) {
+		return
+	}
+	s.cc.HandleLossDetected()
+	s.SndCwnd = s.Ssthresh
+	s.ecnRecover = s.SndNxt - 1
+	s.ecnCWR = true
+}
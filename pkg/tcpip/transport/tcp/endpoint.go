@@ -384,6 +384,14 @@ type Endpoint struct {
 	// +checklocks:rcvQueueMu
 	stack.TCPRcvBufState
 
+	// urgentData is the most recently received urgent byte. It is valid if
+	// hasUrgentData is set, until it is read with ReadOptions.OutOfBand.
+	//
+	// +checklocks:rcvQueueMu
+	urgentData byte
+	// +checklocks:rcvQueueMu
+	hasUrgentData bool
+
 	// rcvMemUsed tracks the total amount of memory in use by received segments
 	// held in rcvQueue, pendingRcvdSegments and the segment queue. This is used to
 	// compute the window and the actual available buffer space. This is distinct
@@ -391,6 +399,10 @@ type Endpoint struct {
 	// the buffer not including any segment overheads.
 	rcvMemUsed atomicbitops.Int32
 
+	// rcvMemAccount charges rcvMemUsed against the stack-wide receive memory
+	// limit.
+	rcvMemAccount stack.ReceiveMemoryAccount
+
 	// mu protects all endpoint fields unless documented otherwise. mu must
 	// be acquired before interacting with the endpoint fields.
 	//
@@ -478,17 +490,39 @@ type Endpoint struct {
 	// for this endpoint using the TCP_MAXSEG setsockopt.
 	userMSS uint16
 
+	// maxMSS if non-zero is the stack-wide upper bound on the MSS set with
+	// tcpip.TCPMaxMSSOption. It is read when the endpoint is created.
+	maxMSS uint16
+
+	// delayedAckTimeout if non-zero is the maximum time that the
+	// acknowledgement of in-order data is delayed when TCP_QUICKACK is not
+	// set. It is read from tcpip.TCPDelayedAckTimeoutOption when the
+	// endpoint is created.
+	delayedAckTimeout time.Duration
+
 	// maxSynRetries is the maximum number of SYN retransmits that TCP should
 	// send before aborting the attempt to connect. It cannot exceed 255.
-	//
-	// NOTE: This is currently a no-op and does not change the SYN
-	// retransmissions.
 	maxSynRetries uint8
 
 	// windowClamp is used to bound the size of the advertised window to
 	// this value.
 	windowClamp uint32
 
+	// windowClampSet is true if windowClamp was set by the user. Only then
+	// does it bound the advertised window, which otherwise grows with the
+	// receive buffer.
+	windowClampSet bool
+
+	// md5Mu protects md5Keys. It is separate from mu as keys are looked up
+	// when segments are queued, before the endpoint is locked.
+	md5Mu sync.RWMutex `state:"nosave"`
+
+	// md5Keys maps peer addresses to the keys used to sign the segments
+	// exchanged with them.
+	//
+	// +checklocks:md5Mu
+	md5Keys map[tcpip.Address][]byte
+
 	// sndQueueInfo contains the implementation of the endpoint's send queue.
 	sndQueueInfo sndQueueInfo
 
@@ -554,6 +588,14 @@ type Endpoint struct {
 	// applied while sending packets. Defaults to 0 as on Linux.
 	sendTOS uint8
 
+	// ecn is set if explicit congestion notification was negotiated during
+	// the handshake.
+	ecn bool
+
+	// ecnEcho is set once a segment marked with congestion experienced is
+	// received, until the peer acknowledges the echo with the CWR flag.
+	ecnEcho bool
+
 	gso stack.GSO
 
 	stats Stats
@@ -610,11 +652,15 @@ func (e *Endpoint) UniqueID() uint64 {
 // calculateAdvertisedMSS calculates the MSS to advertise.
 //
 // If userMSS is non-zero and is not greater than the maximum possible MSS for
-// r, it will be used; otherwise, the maximum possible MSS will be used.
-func calculateAdvertisedMSS(userMSS uint16, r *stack.Route) uint16 {
+// r, it will be used; otherwise, the maximum possible MSS will be used. In
+// either case, the result is capped to stackMSS if it is non-zero.
+func calculateAdvertisedMSS(userMSS, stackMSS uint16, r *stack.Route) uint16 {
 	// The maximum possible MSS is dependent on the route.
 	// TODO(b/143359391): Respect TCP Min and Max size.
 	maxMSS := uint16(r.MTU() - header.TCPMinimumSize)
+	if stackMSS != 0 && stackMSS < maxMSS {
+		maxMSS = stackMSS
+	}
 
 	if userMSS != 0 && userMSS < maxMSS {
 		return userMSS
@@ -912,6 +958,17 @@ func newEndpoint(s *stack.Stack, protocol *protocol, netProto tcpip.NetworkProto
 		e.maxSynRetries = uint8(synRetries)
 	}
 
+	var maxMSS tcpip.TCPMaxMSSOption
+	if err := s.TransportProtocolOption(ProtocolNumber, &maxMSS); err == nil {
+		e.maxMSS = uint16(maxMSS)
+	}
+
+	var delayedAck tcpip.TCPDelayedAckTimeoutOption
+	if err := s.TransportProtocolOption(ProtocolNumber, &delayedAck); err == nil && delayedAck != 0 {
+		e.delayedAckTimeout = time.Duration(delayedAck)
+		e.ops.SetQuickAck(false)
+	}
+
 	if p := s.GetTCPProbe(); p != nil {
 		e.probe = p
 	}
@@ -973,11 +1030,21 @@ func (e *Endpoint) Readiness(mask waiter.EventMask) waiter.EventMask {
 			if e.RcvBufUsed > 0 || e.RcvClosed {
 				result |= waiter.ReadableEvents
 			}
+
 			if e.RcvClosed {
 				e.updateConnDirectionState(connDirectionStateRcvClosed)
 			}
 			e.rcvQueueMu.Unlock()
 		}
+
+		// Determine if there is urgent data to read if requested.
+		if (mask & waiter.EventPri) != 0 {
+			e.rcvQueueMu.Lock()
+			if e.hasUrgentData {
+				result |= waiter.EventPri
+			}
+			e.rcvQueueMu.Unlock()
+		}
 	}
 
 	// Determine whether endpoint is half-closed with rcv shutdown
@@ -1191,6 +1258,8 @@ func (e *Endpoint) cleanupLocked() {
 		e.snd.probeTimer.cleanup()
 		e.snd.reorderTimer.cleanup()
 		e.snd.corkTimer.cleanup()
+		e.snd.delayedAckTimer.cleanup()
+		e.snd.pmtuTimer.cleanup()
 	}
 
 	if e.finWait2Timer != nil {
@@ -1251,14 +1320,15 @@ func wndFromSpace(space int) int {
 
 // initialReceiveWindow returns the initial receive window to advertise in the
 // SYN/SYN-ACK.
+// +checklocks:e.mu
 func (e *Endpoint) initialReceiveWindow() int {
-	rcvWnd := wndFromSpace(e.receiveBufferAvailable())
+	rcvWnd := e.clampWindow(wndFromSpace(e.receiveBufferAvailable()))
 	if rcvWnd > math.MaxUint16 {
 		rcvWnd = math.MaxUint16
 	}
 
 	// Use the user supplied MSS, if available.
-	routeWnd := InitialCwnd * int(calculateAdvertisedMSS(e.userMSS, e.route)) * 2
+	routeWnd := InitialCwnd * int(calculateAdvertisedMSS(e.userMSS, e.maxMSS, e.route)) * 2
 	if rcvWnd > routeWnd {
 		rcvWnd = routeWnd
 	}
@@ -1416,6 +1486,10 @@ func (e *Endpoint) Read(dst io.Writer, opts tcpip.ReadOptions) (tcpip.ReadResult
 		return tcpip.ReadResult{}, err
 	}
 
+	if opts.OutOfBand {
+		return e.readUrgentData(dst, opts.Peek)
+	}
+
 	var err error
 	done := 0
 	// N.B. Here we get the first segment to be processed. It is safe to not
@@ -1641,6 +1715,13 @@ func (e *Endpoint) queueSegment(p tcpip.Payloader, opts tcpip.WriteOptions) (*se
 	e.sndQueueInfo.SndBufUsed += size
 	e.snd.writeList.PushBack(s)
 
+	if opts.OutOfBand {
+		// Like Linux, point just past the last byte queued so far, which
+		// makes it the urgent byte.
+		e.snd.urgent = true
+		e.snd.sndUp = e.snd.SndUna.Add(seqnum.Size(e.sndQueueInfo.SndBufUsed))
+	}
+
 	return s, size, nil
 }
 
@@ -1683,6 +1764,7 @@ func (e *Endpoint) selectWindowLocked(rcvBufSize int) (wnd seqnum.Size) {
 	if newWnd > wndFromUsedBytes {
 		newWnd = wndFromUsedBytes
 	}
+	newWnd = e.clampWindow(newWnd)
 	if newWnd < 0 {
 		newWnd = 0
 	}
@@ -1698,6 +1780,17 @@ func (e *Endpoint) selectWindow() (wnd seqnum.Size) {
 	return wnd
 }
 
+// clampWindow bounds wnd by the window clamp set through
+// TCPWindowClampOption, if any.
+//
+// +checklocks:e.mu
+func (e *Endpoint) clampWindow(wnd int) int {
+	if e.windowClampSet && wnd > int(e.windowClamp) {
+		return int(e.windowClamp)
+	}
+	return wnd
+}
+
 // windowCrossedACKThresholdLocked checks if the receive window to be announced
 // would be under aMSS or under the window derived from half receive buffer,
 // whichever smaller. This is useful as a receive side silly window syndrome
@@ -1727,6 +1820,9 @@ func (e *Endpoint) windowCrossedACKThresholdLocked(deltaBefore int, rcvBufSize i
 	if wndThreshold := wndFromSpace(rcvBufSize / rcvBufFraction); threshold > wndThreshold {
 		threshold = wndThreshold
 	}
+	// The window can't grow past the clamp, so the threshold must not be
+	// above it either. Otherwise a zero window would never be reopened.
+	threshold = e.clampWindow(threshold)
 
 	switch {
 	case oldAvail < threshold && newAvail >= threshold:
@@ -1854,9 +1950,6 @@ func (e *Endpoint) WakeupWriters() {
 
 // SetSockOptInt sets a socket option.
 func (e *Endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) tcpip.Error {
-	// Lower 2 bits represents ECN bits. RFC 3168, section 23.1
-	const inetECNMask = 3
-
 	switch opt {
 	case tcpip.KeepaliveCountOption:
 		e.LockUser()
@@ -1868,16 +1961,16 @@ func (e *Endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) tcpip.Error {
 
 	case tcpip.IPv4TOSOption:
 		e.LockUser()
-		// TODO(gvisor.dev/issue/995): ECN is not currently supported,
-		// ignore the bits for now.
-		e.sendTOS = uint8(v) & ^uint8(inetECNMask)
+		// The ECN bits are owned by TCP, ignore the ones set by the
+		// user.
+		e.sendTOS = uint8(v) &^ ecnMask
 		e.UnlockUser()
 
 	case tcpip.IPv6TrafficClassOption:
 		e.LockUser()
-		// TODO(gvisor.dev/issue/995): ECN is not currently supported,
-		// ignore the bits for now.
-		e.sendTOS = uint8(v) & ^uint8(inetECNMask)
+		// The ECN bits are owned by TCP, ignore the ones set by the
+		// user.
+		e.sendTOS = uint8(v) &^ ecnMask
 		e.UnlockUser()
 
 	case tcpip.MaxSegOption:
@@ -1920,6 +2013,7 @@ func (e *Endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) tcpip.Error {
 			switch e.EndpointState() {
 			case StateClose, StateInitial:
 				e.windowClamp = 0
+				e.windowClampSet = false
 				e.UnlockUser()
 				return nil
 			default:
@@ -1935,6 +2029,7 @@ func (e *Endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) tcpip.Error {
 		}
 		e.LockUser()
 		e.windowClamp = uint32(v)
+		e.windowClampSet = true
 		e.UnlockUser()
 	}
 	return nil
@@ -2029,6 +2124,11 @@ func (e *Endpoint) SetSockOpt(opt tcpip.SettableSocketOption) tcpip.Error {
 		e.deferAccept = time.Duration(*v)
 		e.UnlockUser()
 
+	case *tcpip.TCPMD5SignatureOption:
+		e.LockUser()
+		defer e.UnlockUser()
+		return e.setMD5KeyLocked(v)
+
 	case *tcpip.SocketDetachFilterOption:
 		return nil
 
@@ -2054,6 +2154,44 @@ func (e *Endpoint) readyReceiveSize() (int, tcpip.Error) {
 	return e.RcvBufUsed, nil
 }
 
+// SendQueueLen returns the number of bytes written to the endpoint that have
+// not yet been acknowledged by the peer, whether or not they have been sent.
+func (e *Endpoint) SendQueueLen() int {
+	e.sndQueueInfo.sndQueueMu.Lock()
+	defer e.sndQueueInfo.sndQueueMu.Unlock()
+	return e.sndQueueInfo.SndBufUsed
+}
+
+// RecvQueueLen returns the number of bytes received in order that are waiting
+// to be read. Out-of-order data held for reassembly is not included.
+func (e *Endpoint) RecvQueueLen() int {
+	e.rcvQueueMu.Lock()
+	defer e.rcvQueueMu.Unlock()
+	return e.RcvBufUsed
+}
+
+// UnackedSegments returns the number of segments that have been sent but not
+// yet acknowledged by the peer.
+func (e *Endpoint) UnackedSegments() int {
+	e.LockUser()
+	defer e.UnlockUser()
+	if e.snd == nil {
+		return 0
+	}
+	return e.snd.Outstanding
+}
+
+// OutOfOrderSegments returns the number of segments received out of order
+// that are held until the data before them arrives.
+func (e *Endpoint) OutOfOrderSegments() int {
+	e.LockUser()
+	defer e.UnlockUser()
+	if e.rcv == nil {
+		return 0
+	}
+	return e.rcv.pendingRcvdSegments.Len()
+}
+
 // GetSockOptInt implements tcpip.Endpoint.GetSockOptInt.
 func (e *Endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, tcpip.Error) {
 	switch opt {
@@ -2096,6 +2234,12 @@ func (e *Endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, tcpip.Error) {
 	case tcpip.ReceiveQueueSizeOption:
 		return e.readyReceiveSize()
 
+	case tcpip.SendQueueSizeOption:
+		if e.EndpointState() == StateListen {
+			return 0, &tcpip.ErrInvalidEndpointState{}
+		}
+		return e.SendQueueLen(), nil
+
 	case tcpip.IPv4TTLOption:
 		e.LockUser()
 		v := int(e.ipv4TTL)
@@ -2151,7 +2295,12 @@ func (e *Endpoint) getTCPInfo() tcpip.TCPInfoOption {
 		info.SndSsthresh = uint32(snd.Ssthresh)
 		info.SndCwnd = uint32(snd.SndCwnd)
 		info.ReorderSeen = snd.rc.Reord
+		info.SndWnd = uint32(snd.SndWnd)
+	}
+	if rcv := e.rcv; rcv != nil {
+		info.RcvWnd = uint32(rcv.rcvWnd)
 	}
+	info.TotalRetransmits = e.stats.SendErrors.Retransmits.Value()
 	e.UnlockUser()
 	return info
 }
@@ -2907,6 +3056,9 @@ func (e *Endpoint) onICMPError(err tcpip.Error, transErr stack.TransportError, p
 		}
 		e.stack.Stats().TCP.FailedConnectionAttempts.Increment()
 		e.cleanupLocked()
+		e.lastErrorMu.Lock()
+		e.lastError = nil
+		e.lastErrorMu.Unlock()
 		e.hardError = err
 		e.setEndpointState(StateError)
 		e.mu.Unlock()
@@ -2999,6 +3151,37 @@ func (e *Endpoint) readyToRead(s *segment) {
 	e.waiterQueue.Notify(waiter.ReadableEvents)
 }
 
+// urgentDataReceived makes b available to be read out-of-band and notifies
+// waiters of the exceptional condition.
+func (e *Endpoint) urgentDataReceived(b byte) {
+	e.rcvQueueMu.Lock()
+	e.urgentData = b
+	e.hasUrgentData = true
+	e.rcvQueueMu.Unlock()
+	e.waiterQueue.Notify(waiter.EventPri)
+}
+
+// readUrgentData reads the pending urgent byte into dst. Like Linux, it fails
+// if SO_OOBINLINE is set, as urgent data is then delivered in-band.
+func (e *Endpoint) readUrgentData(dst io.Writer, peek bool) (tcpip.ReadResult, tcpip.Error) {
+	if e.ops.GetOutOfBandInline() {
+		return tcpip.ReadResult{}, &tcpip.ErrInvalidOptionValue{}
+	}
+	e.rcvQueueMu.Lock()
+	defer e.rcvQueueMu.Unlock()
+	if !e.hasUrgentData {
+		return tcpip.ReadResult{}, &tcpip.ErrWouldBlock{}
+	}
+	n, err := dst.Write([]byte{e.urgentData})
+	if n == 0 || err != nil {
+		return tcpip.ReadResult{}, &tcpip.ErrBadBuffer{}
+	}
+	if !peek {
+		e.hasUrgentData = false
+	}
+	return tcpip.ReadResult{Count: 1, Total: 1}, nil
+}
+
 // receiveBufferAvailableLocked calculates how many bytes are still available
 // in the receive buffer.
 // +checklocks:e.rcvQueueMu
@@ -3010,7 +3193,12 @@ func (e *Endpoint) receiveBufferAvailableLocked(rcvBufSize int) int {
 		return 0
 	}
 
-	return rcvBufSize - memUsed
+	avail := rcvBufSize - memUsed
+	// Never advertise more than the stack lets this endpoint hold.
+	if stackAvail, ok := e.stack.ReceiveMemoryAvailable(&e.rcvMemAccount); ok && stackAvail < avail {
+		avail = stackAvail
+	}
+	return avail
 }
 
 // receiveBufferAvailable calculates how many bytes are still available in the
@@ -3040,6 +3228,7 @@ func (e *Endpoint) receiveMemUsed() int {
 // updateReceiveMemUsed adds the provided delta to e.rcvMemUsed.
 func (e *Endpoint) updateReceiveMemUsed(delta int) {
 	e.rcvMemUsed.Add(int32(delta))
+	e.stack.ChargeReceiveMemory(&e.rcvMemAccount, delta)
 }
 
 // maxReceiveBufferSize returns the stack wide maximum receive buffer size for
@@ -3195,6 +3384,11 @@ func (e *Endpoint) initHostGSO() {
 }
 
 func (e *Endpoint) initGSO() {
+	// As in Linux, segmentation offload is not used for signed segments as
+	// each segment needs its own signature.
+	if e.md5Key(e.TransportEndpointInfo.ID.RemoteAddress) != nil {
+		return
+	}
 	if e.route.HasHostGSOCapability() {
 		e.initHostGSO()
 	} else if e.route.HasGvisorGSOCapability() {
@@ -3300,7 +3494,7 @@ func (e *Endpoint) computeTCPSendBufferSize() int64 {
 
 	const packetOverheadFactor = 2
 	curMSS := e.snd.MaxPayloadSize
-	numSeg := InitialCwnd
+	numSeg := e.snd.initialCwnd
 	if numSeg < e.snd.SndCwnd {
 		numSeg = e.snd.SndCwnd
 	}
@@ -131,8 +131,13 @@ func (e *Endpoint) Restore(s *stack.Stack) {
 		snd.reorderTimer.init(s.Clock(), timerHandler(e, e.snd.rc.reorderTimerExpired))
 		snd.probeTimer.init(s.Clock(), timerHandler(e, e.snd.probeTimerExpired))
 		snd.corkTimer.init(s.Clock(), timerHandler(e, e.snd.corkTimerExpired))
+		snd.delayedAckTimer.init(s.Clock(), timerHandler(e, e.snd.delayedAckTimerExpired))
+		snd.pmtuTimer.init(s.Clock(), timerHandler(e, e.snd.pmtuTimerExpired))
 	}
 	e.stack = s
+	// Segments restored with the endpoint are not yet accounted for by the
+	// new stack.
+	s.ChargeReceiveMemory(&e.rcvMemAccount, e.receiveMemUsed())
 	e.protocol = protocolFromStack(s)
 	e.ops.InitHandler(e, e.stack, GetTCPSendBufferLimits, GetTCPReceiveBufferLimits)
 	e.segmentQueue.thaw()
@@ -252,9 +257,10 @@ func (e *Endpoint) Restore(s *stack.Stack) {
 			panic(fmt.Sprintf("FindRoute failed when restoring endpoint w/ ID: %+v", e.ID))
 		}
 		e.route = r
-		timer, err := newBackoffTimer(e.stack.Clock(), InitialRTO, MaxRTO, timerHandler(e, e.h.retransmitHandlerLocked))
+		rto := e.protocol.initialSynRTO()
+		timer, err := newBackoffTimer(e.stack.Clock(), rto, MaxRTO, timerHandler(e, e.h.retransmitHandlerLocked))
 		if err != nil {
-			panic(fmt.Sprintf("newBackOffTimer(_, %s, %s, _) failed: %s", InitialRTO, MaxRTO, err))
+			panic(fmt.Sprintf("newBackOffTimer(_, %s, %s, _) failed: %s", rto, MaxRTO, err))
 		}
 		e.h.retransmitTimer = timer
 		connectingLoading.Done()
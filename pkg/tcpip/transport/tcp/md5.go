@@ -0,0 +1,130 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp
+
+import (
+	"crypto/md5"
+	"crypto/subtle"
+	"encoding/binary"
+
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+)
+
+// maxMD5KeyLength is the maximum length of an MD5 signature key. It matches
+// Linux's TCP_MD5SIG_MAXKEYLEN.
+const maxMD5KeyLength = 80
+
+// md5OptionSize is the number of option bytes used by the MD5 signature
+// option, including the padding that aligns it.
+const md5OptionSize = 2 + header.TCPOptionMD5Length
+
+// setMD5KeyLocked installs the key for the peer in opt, or removes it if the
+// key is empty.
+//
+// +checklocks:e.mu
+func (e *Endpoint) setMD5KeyLocked(opt *tcpip.TCPMD5SignatureOption) tcpip.Error {
+	if len(opt.Key) > maxMD5KeyLength {
+		return &tcpip.ErrInvalidOptionValue{}
+	}
+
+	e.md5Mu.Lock()
+	if len(opt.Key) == 0 {
+		delete(e.md5Keys, opt.Addr)
+		e.md5Mu.Unlock()
+		return nil
+	}
+	if e.md5Keys == nil {
+		e.md5Keys = make(map[tcpip.Address][]byte)
+	}
+	hadKey := e.md5Keys[opt.Addr] != nil
+	e.md5Keys[opt.Addr] = append([]byte(nil), opt.Key...)
+	e.md5Mu.Unlock()
+
+	if opt.Addr != e.TransportEndpointInfo.ID.RemoteAddress {
+		return nil
+	}
+	// The key was installed after segmentation offload was set up by
+	// initGSO. Offload can't be used for signed segments as each segment
+	// needs its own signature.
+	e.gso = stack.GSO{}
+	if e.snd != nil {
+		e.snd.gso = false
+		// Make room for the signature option in segments sized for an
+		// unsigned connection.
+		if !hadKey && e.snd.MaxPayloadSize > md5OptionSize {
+			e.snd.MaxPayloadSize -= md5OptionSize
+		}
+	}
+	return nil
+}
+
+// md5Key returns the key shared with the peer at addr, or nil if segments
+// exchanged with it are not signed.
+func (e *Endpoint) md5Key(addr tcpip.Address) []byte {
+	e.md5Mu.RLock()
+	defer e.md5Mu.RUnlock()
+	return e.md5Keys[addr]
+}
+
+// md5Valid returns true if s carries the signature expected from its sender.
+// Segments from peers without a key must not be signed, and segments from
+// peers with one must carry a valid signature.
+func (e *Endpoint) md5Valid(s *segment) bool {
+	key := e.md5Key(s.id.RemoteAddress)
+	digest := header.TCPMD5Digest(s.options)
+	if key == nil || digest == nil {
+		return key == nil && digest == nil
+	}
+	want := md5Digest(key, s.id.RemoteAddress, s.id.LocalAddress, s.pkt.TransportHeader().Slice(), s.pkt.Data())
+	return subtle.ConstantTimeCompare(digest, want[:]) == 1
+}
+
+// md5Digest returns the MD5 signature of a segment as described in RFC 2385
+// section 2.0. It covers the pseudo-header, the TCP header without options
+// and with a zero checksum, the payload and finally the key. As in Linux, the
+// IPv6 pseudo-header of RFC 8200 is used for IPv6 segments.
+func md5Digest(key []byte, src, dst tcpip.Address, tcpHdr header.TCP, data stack.PacketData) [md5.Size]byte {
+	h := md5.New()
+	segLen := len(tcpHdr) + data.Size()
+	if src.Len() == header.IPv4AddressSize {
+		var pseudo [2*header.IPv4AddressSize + 4]byte
+		copy(pseudo[:], src.AsSlice())
+		copy(pseudo[header.IPv4AddressSize:], dst.AsSlice())
+		pseudo[2*header.IPv4AddressSize+1] = uint8(ProtocolNumber)
+		binary.BigEndian.PutUint16(pseudo[2*header.IPv4AddressSize+2:], uint16(segLen))
+		h.Write(pseudo[:])
+	} else {
+		var pseudo [2*header.IPv6AddressSize + 8]byte
+		copy(pseudo[:], src.AsSlice())
+		copy(pseudo[header.IPv6AddressSize:], dst.AsSlice())
+		binary.BigEndian.PutUint32(pseudo[2*header.IPv6AddressSize:], uint32(segLen))
+		pseudo[2*header.IPv6AddressSize+7] = uint8(ProtocolNumber)
+		h.Write(pseudo[:])
+	}
+
+	var hdr [header.TCPMinimumSize]byte
+	copy(hdr[:], tcpHdr)
+	header.TCP(hdr[:]).SetChecksum(0)
+	h.Write(hdr[:])
+
+	data.ReadTo(h, true /* peek */)
+	h.Write(key)
+
+	var digest [md5.Size]byte
+	h.Sum(digest[:0])
+	return digest
+}
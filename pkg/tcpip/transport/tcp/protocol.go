@@ -79,6 +79,10 @@ const (
 	// DefaultKeepaliveCount is the number of keep-alive probes that are sent
 	// before declaring the connection dead.
 	DefaultKeepaliveCount = 9
+
+	// MaxDelayedAckTimeout is the maximum time that an ACK for in-order data
+	// may be delayed, as per RFC 1122 section 4.2.3.2.
+	MaxDelayedAckTimeout = 500 * time.Millisecond
 )
 
 const (
@@ -91,6 +95,7 @@ type protocol struct {
 
 	mu                         sync.RWMutex
 	sackEnabled                bool
+	ecnEnabled                 bool
 	recovery                   tcpip.TCPRecovery
 	delayEnabled               bool
 	alwaysUseSynCookies        bool
@@ -106,6 +111,11 @@ type protocol struct {
 	maxRTO                     time.Duration
 	maxRetries                 uint32
 	synRetries                 uint8
+	synRTO                     time.Duration
+	maxMSS                     uint16
+	delayedAckTimeout          time.Duration
+	initialCwnd                int
+	initialSsthresh            int
 	dispatcher                 dispatcher
 
 	// The following secrets are initialized once and stay unchanged after.
@@ -167,7 +177,7 @@ func (p *protocol) HandleUnknownDestinationPacket(id stack.TransportEndpointID,
 	}
 
 	if !s.flags.Contains(header.TCPFlagRst) {
-		replyWithReset(p.stack, s, stack.DefaultTOS, tcpip.UseDefaultIPv4TTL, tcpip.UseDefaultIPv6HopLimit)
+		replyWithReset(p.stack, s, stack.DefaultTOS, tcpip.UseDefaultIPv4TTL, tcpip.UseDefaultIPv6HopLimit, nil /* md5Key */)
 	}
 
 	return stack.UnknownDestinationPacketHandled
@@ -190,11 +200,22 @@ func (p *protocol) tsOffset(src, dst tcpip.Address) tcp.TSOffset {
 	return tcp.NewTSOffset(binary.LittleEndian.Uint32(h.Sum(nil)[:4]))
 }
 
+// initialSynRTO returns the initial retransmission timeout of SYN and SYN-ACK
+// segments.
+func (p *protocol) initialSynRTO() time.Duration {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.synRTO
+}
+
 // replyWithReset replies to the given segment with a reset segment.
 //
 // If the relevant TTL has its reset value (0 for ipv4TTL, -1 for ipv6HopLimit),
 // then the route's default TTL will be used.
-func replyWithReset(st *stack.Stack, s *segment, tos, ipv4TTL uint8, ipv6HopLimit int16) tcpip.Error {
+//
+// The reset is signed with md5Key if it is set. Resets sent for segments that
+// don't belong to any endpoint are never signed, as no key is known for them.
+func replyWithReset(st *stack.Stack, s *segment, tos, ipv4TTL uint8, ipv6HopLimit int16, md5Key []byte) tcpip.Error {
 	net := s.pkt.Network()
 	route, err := st.FindRoute(s.pkt.NICID, net.DestinationAddress(), net.SourceAddress(), s.pkt.NetworkProtocolNumber, false /* multicastLoop */)
 	if err != nil {
@@ -226,7 +247,15 @@ func replyWithReset(st *stack.Stack, s *segment, tos, ipv4TTL uint8, ipv6HopLimi
 		ack = s.sequenceNumber.Add(s.logicalLen())
 	}
 
-	p := stack.NewPacketBuffer(stack.PacketBufferOptions{ReserveHeaderBytes: header.TCPMinimumSize + int(route.MaxHeaderLength())})
+	var opts []byte
+	if md5Key != nil {
+		opts = make([]byte, md5OptionSize)
+		offset := header.EncodeNOP(opts)
+		offset += header.EncodeNOP(opts[offset:])
+		header.EncodeMD5Option(opts[offset:])
+	}
+
+	p := stack.NewPacketBuffer(stack.PacketBufferOptions{ReserveHeaderBytes: header.TCPMinimumSize + int(route.MaxHeaderLength()) + len(opts)})
 	defer p.DecRef()
 	return sendTCP(route, tcpFields{
 		id:     s.id,
@@ -236,6 +265,8 @@ func replyWithReset(st *stack.Stack, s *segment, tos, ipv4TTL uint8, ipv6HopLimi
 		seq:    seq,
 		ack:    ack,
 		rcvWnd: 0,
+		opts:   opts,
+		md5Key: md5Key,
 	}, p, stack.GSO{}, nil /* PacketOwner */)
 }
 
@@ -248,6 +279,12 @@ func (p *protocol) SetOption(option tcpip.SettableTransportProtocolOption) tcpip
 		p.mu.Unlock()
 		return nil
 
+	case *tcpip.TCPECNEnabled:
+		p.mu.Lock()
+		p.ecnEnabled = bool(*v)
+		p.mu.Unlock()
+		return nil
+
 	case *tcpip.TCPRecovery:
 		p.mu.Lock()
 		p.recovery = *v
@@ -371,6 +408,54 @@ func (p *protocol) SetOption(option tcpip.SettableTransportProtocolOption) tcpip
 		p.mu.Unlock()
 		return nil
 
+	case *tcpip.TCPSynRTOOption:
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		if *v < 0 {
+			p.synRTO = InitialRTO
+		} else if synRTO := time.Duration(*v); synRTO > 0 && synRTO <= MaxRTO {
+			p.synRTO = synRTO
+		} else {
+			return &tcpip.ErrInvalidOptionValue{}
+		}
+		return nil
+
+	case *tcpip.TCPMaxMSSOption:
+		if *v != 0 && *v < header.TCPMinimumMSS {
+			return &tcpip.ErrInvalidOptionValue{}
+		}
+		p.mu.Lock()
+		p.maxMSS = uint16(*v)
+		p.mu.Unlock()
+		return nil
+
+	case *tcpip.TCPDelayedAckTimeoutOption:
+		if *v < 0 || time.Duration(*v) > MaxDelayedAckTimeout {
+			return &tcpip.ErrInvalidOptionValue{}
+		}
+		p.mu.Lock()
+		p.delayedAckTimeout = time.Duration(*v)
+		p.mu.Unlock()
+		return nil
+
+	case *tcpip.TCPInitialCwndOption:
+		if *v <= 0 {
+			return &tcpip.ErrInvalidOptionValue{}
+		}
+		p.mu.Lock()
+		p.initialCwnd = int(*v)
+		p.mu.Unlock()
+		return nil
+
+	case *tcpip.TCPInitialSsthreshOption:
+		if *v < 0 {
+			return &tcpip.ErrInvalidOptionValue{}
+		}
+		p.mu.Lock()
+		p.initialSsthresh = int(*v)
+		p.mu.Unlock()
+		return nil
+
 	default:
 		return &tcpip.ErrUnknownProtocolOption{}
 	}
@@ -385,6 +470,12 @@ func (p *protocol) Option(option tcpip.GettableTransportProtocolOption) tcpip.Er
 		p.mu.RUnlock()
 		return nil
 
+	case *tcpip.TCPECNEnabled:
+		p.mu.RLock()
+		*v = tcpip.TCPECNEnabled(p.ecnEnabled)
+		p.mu.RUnlock()
+		return nil
+
 	case *tcpip.TCPRecovery:
 		p.mu.RLock()
 		*v = p.recovery
@@ -475,6 +566,36 @@ func (p *protocol) Option(option tcpip.GettableTransportProtocolOption) tcpip.Er
 		p.mu.RUnlock()
 		return nil
 
+	case *tcpip.TCPSynRTOOption:
+		p.mu.RLock()
+		*v = tcpip.TCPSynRTOOption(p.synRTO)
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPMaxMSSOption:
+		p.mu.RLock()
+		*v = tcpip.TCPMaxMSSOption(p.maxMSS)
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPDelayedAckTimeoutOption:
+		p.mu.RLock()
+		*v = tcpip.TCPDelayedAckTimeoutOption(p.delayedAckTimeout)
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPInitialCwndOption:
+		p.mu.RLock()
+		*v = tcpip.TCPInitialCwndOption(p.initialCwnd)
+		p.mu.RUnlock()
+		return nil
+
+	case *tcpip.TCPInitialSsthreshOption:
+		p.mu.RLock()
+		*v = tcpip.TCPInitialSsthreshOption(p.initialSsthresh)
+		p.mu.RUnlock()
+		return nil
+
 	default:
 		return &tcpip.ErrUnknownProtocolOption{}
 	}
@@ -542,9 +663,11 @@ func NewProtocol(s *stack.Stack) stack.TransportProtocol {
 		timeWaitTimeout:            DefaultTCPTimeWaitTimeout,
 		timeWaitReuse:              tcpip.TCPTimeWaitReuseLoopbackOnly,
 		synRetries:                 DefaultSynRetries,
+		synRTO:                     InitialRTO,
 		minRTO:                     MinRTO,
 		maxRTO:                     MaxRTO,
 		maxRetries:                 MaxRetries,
+		initialCwnd:                InitialCwnd,
 		recovery:                   tcpip.TCPRACKLossDetection,
 		seqnumSecret:               seqnumSecret,
 		tsOffsetSecret:             tsOffsetSecret,
@@ -17,6 +17,7 @@ package tcp
 import (
 	"container/heap"
 	"math"
+	"time"
 
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
@@ -24,6 +25,17 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/stack"
 )
 
+const (
+	// pawsWindow is the amount by which the timestamp of an incoming segment
+	// may be older than TS.Recent without the segment being rejected by PAWS.
+	// Linux uses the same value (TCP_PAWS_WINDOW).
+	pawsWindow = 1
+
+	// pawsIdleTimeout is the time after which TS.Recent is considered invalid
+	// and PAWS is no longer applied, as described in RFC 7323 section 5.5.
+	pawsIdleTimeout = 24 * 24 * time.Hour
+)
+
 // receiver holds the state necessary to receive TCP segments and turn them
 // into a stream of bytes.
 //
@@ -50,9 +62,25 @@ type receiver struct {
 
 	// Time when the last ack was received.
 	lastRcvdAckTime tcpip.MonotonicTime
+
+	// urgentPending is set when the peer has signalled urgent data that has
+	// not been received yet. rcvUp is the sequence number following the
+	// urgent byte.
+	urgentPending bool
+	rcvUp         seqnum.Value
+
+	// rcvMSS is an estimate of the segment size used by the peer. It starts
+	// at the smaller of the advertised MSS and the default MSS and grows to
+	// the largest payload received, up to the advertised MSS. This is
+	// similar to Linux's rcv_mss.
+	rcvMSS uint16
 }
 
 func newReceiver(ep *Endpoint, irs seqnum.Value, rcvWnd seqnum.Size, rcvWndScale uint8) *receiver {
+	rcvMSS := ep.amss
+	if rcvMSS > header.TCPDefaultMSS {
+		rcvMSS = header.TCPDefaultMSS
+	}
 	return &receiver{
 		ep: ep,
 		TCPReceiverState: stack.TCPReceiverState{
@@ -63,7 +91,41 @@ func newReceiver(ep *Endpoint, irs seqnum.Value, rcvWnd seqnum.Size, rcvWndScale
 		rcvWnd:          rcvWnd,
 		rcvWUP:          irs + 1,
 		lastRcvdAckTime: ep.stack.Clock().NowMonotonic(),
+		rcvMSS:          rcvMSS,
+	}
+}
+
+// measureRcvMSS updates the estimate of the peer's segment size with a
+// received payload of segLen bytes.
+// +checklocks:r.ep.mu
+func (r *receiver) measureRcvMSS(segLen seqnum.Size) {
+	if segLen <= seqnum.Size(r.rcvMSS) {
+		return
+	}
+	if segLen > seqnum.Size(r.ep.amss) {
+		segLen = seqnum.Size(r.ep.amss)
+	}
+	r.rcvMSS = uint16(segLen)
+}
+
+// windowUpdateNeeded returns true if the peer should be told about the
+// receive window right away: either less than a segment is left in the
+// window last advertised, or the window that can be advertised now is at
+// least two segments larger than it.
+// +checklocks:r.ep.mu
+func (r *receiver) windowUpdateNeeded() bool {
+	curWnd := r.currentWindow()
+	if curWnd < seqnum.Size(r.rcvMSS) {
+		return true
+	}
+	if !r.canGrowWindow(r.ep.receiveBufferUsed()) {
+		return false
 	}
+	newWnd := r.ep.selectWindow()
+	if maxWnd := seqnum.Size(math.MaxUint16) << r.RcvWndScale; newWnd > maxWnd {
+		newWnd = maxWnd
+	}
+	return newWnd > curWnd && newWnd-curWnd >= 2*seqnum.Size(r.rcvMSS)
 }
 
 // acceptable checks if the segment sequence number range is acceptable
@@ -94,13 +156,21 @@ func (r *receiver) currentWindow() (curWnd seqnum.Size) {
 	return r.RcvNxt.Size(endOfWnd)
 }
 
+// canGrowWindow returns true if the right edge of the window may move when
+// the window is next advertised, with bufUsed bytes of the receive buffer in
+// use. See getSendParams.
+// +checklocks:r.ep.mu
+func (r *receiver) canGrowWindow(bufUsed int) bool {
+	unackLen := int(r.ep.snd.MaxSentAck.Size(r.RcvNxt))
+	return unackLen >= SegOverheadSize || bufUsed <= r.prevBufUsed
+}
+
 // getSendParams returns the parameters needed by the sender when building
 // segments to send.
 // +checklocks:r.ep.mu
 func (r *receiver) getSendParams() (RcvNxt seqnum.Value, rcvWnd seqnum.Size) {
 	newWnd := r.ep.selectWindow()
 	curWnd := r.currentWindow()
-	unackLen := int(r.ep.snd.MaxSentAck.Size(r.RcvNxt))
 	bufUsed := r.ep.receiveBufferUsed()
 
 	// Grow the right edge of the window only for payloads larger than the
@@ -126,7 +196,7 @@ func (r *receiver) getSendParams() (RcvNxt seqnum.Value, rcvWnd seqnum.Size) {
 	//
 	// Also, if the application is reading the data, we keep growing the right
 	// edge, as we are still advertising a window that we think can be serviced.
-	toGrow := unackLen >= SegOverheadSize || bufUsed <= r.prevBufUsed
+	toGrow := r.canGrowWindow(bufUsed)
 
 	// Update RcvAcc only if new window is > previously advertised window. We
 	// should never shrink the acceptable sequence space once it has been
@@ -219,8 +289,28 @@ func (r *receiver) consumeSegment(s *segment, segSeq seqnum.Value, segLen seqnum
 			s.TrimFront(diff)
 		}
 
-		// Move segment to ready-to-deliver list. Wakeup any waiters.
-		r.ep.readyToRead(s)
+		// Save the urgent byte if it is in this segment. Like Linux, it is
+		// only delivered in-band if SO_OOBINLINE is set.
+		if r.urgentPending && segSeq.LessThan(r.rcvUp) && !segSeq.Add(segLen).LessThan(r.rcvUp) {
+			off := int(segSeq.Size(r.rcvUp)) - 1
+			r.urgentPending = false
+			data := s.pkt.Data()
+			r.ep.urgentDataReceived(data.AsRange().SubRange(off).Capped(1).ToSlice()[0])
+			if !r.ep.ops.GetOutOfBandInline() {
+				tail := data.AsRange().SubRange(off + 1).ToView()
+				data.CapLength(off)
+				if tail != nil {
+					data.AppendView(tail)
+				}
+			}
+		}
+
+		// Move segment to ready-to-deliver list. Wakeup any waiters. A
+		// segment that only carried the urgent byte has nothing left to
+		// deliver.
+		if s.payloadSize() != 0 {
+			r.ep.readyToRead(s)
+		}
 
 	} else if segSeq != r.RcvNxt {
 		return false
@@ -460,6 +550,15 @@ func (r *receiver) handleRcvdSegment(s *segment) (drop bool, err tcpip.Error) {
 	segLen := seqnum.Size(s.payloadSize())
 	segSeq := s.sequenceNumber
 
+	// Segments with an old timestamp are duplicates from an earlier
+	// incarnation of the sequence space. Acknowledge and drop them as
+	// described in RFC 7323 section 5.3.
+	if r.pawsReject(s) {
+		r.ep.stack.Stats().TCP.PAWSRejected.Increment()
+		r.ep.snd.maybeSendOutOfWindowAck(s)
+		return true, nil
+	}
+
 	// If the sequence number range is outside the acceptable range, just
 	// send an ACK and stop further processing of the segment.
 	// This is according to RFC 793, page 68.
@@ -478,6 +577,21 @@ func (r *receiver) handleRcvdSegment(s *segment) (drop bool, err tcpip.Error) {
 	// Store the time of the last ack.
 	r.lastRcvdAckTime = r.ep.stack.Clock().NowMonotonic()
 
+	r.measureRcvMSS(segLen)
+
+	// Echo congestion experienced on the path to us back to the peer
+	// until it reduces its window. See RFC 3168 section 6.1.3.
+	if r.ep.ecn {
+		if s.flags.Contains(header.TCPFlagCwr) {
+			r.ep.ecnEcho = false
+		}
+		if s.congestionExperienced() {
+			r.ep.ecnEcho = true
+		}
+	}
+
+	r.updateUrgentPointer(s)
+
 	// Defer segment processing if it can't be consumed now.
 	if !r.consumeSegment(s, segSeq, segLen) {
 		if segLen > 0 || s.flags.Contains(header.TCPFlagFin) {
@@ -545,6 +659,55 @@ func (r *receiver) handleRcvdSegment(s *segment) (drop bool, err tcpip.Error) {
 	return false, nil
 }
 
+// updateUrgentPointer records the urgent pointer carried by s, if any, as
+// described in RFC 6093. Pointers that do not advance past data already
+// received are ignored.
+// +checklocks:r.ep.mu
+func (r *receiver) updateUrgentPointer(s *segment) {
+	if !s.flags.Contains(header.TCPFlagUrg) || s.urgentPointer == 0 {
+		return
+	}
+	up := s.sequenceNumber.Add(seqnum.Size(s.urgentPointer))
+	if !r.RcvNxt.LessThan(up) || (r.urgentPending && !r.rcvUp.LessThan(up)) {
+		return
+	}
+	r.urgentPending = true
+	r.rcvUp = up
+}
+
+// pawsReject returns true if s must be rejected by PAWS (Protection Against
+// Wrapped Sequences) because its timestamp is older than TS.Recent. See
+// RFC 7323 section 5.
+//
+// As in Linux, reordered duplicate ACKs are not rejected, see disorderedAck.
+// +checklocks:r.ep.mu
+func (r *receiver) pawsReject(s *segment) bool {
+	if !r.ep.SendTSOk || !s.parsedOptions.TS || s.flags.Contains(header.TCPFlagRst) {
+		return false
+	}
+	if r.disorderedAck(s) {
+		return false
+	}
+	if r.ep.stack.Clock().NowMonotonic().Sub(r.ep.recentTSTime) > pawsIdleTimeout {
+		return false
+	}
+	return int32(r.ep.recentTimestamp()-s.parsedOptions.TSVal) > pawsWindow
+}
+
+// disorderedAck returns true if s is a duplicate ACK that may have been
+// reordered with newer segments, and so carry an older timestamp. Such a
+// segment has no data, acknowledges nothing new and doesn't change the send
+// window, so accepting it changes no state. It matches Linux's
+// tcp_disordered_ack.
+// +checklocks:r.ep.mu
+func (r *receiver) disorderedAck(s *segment) bool {
+	return s.flags&(header.TCPFlagAck|header.TCPFlagSyn|header.TCPFlagFin) == header.TCPFlagAck &&
+		s.payloadSize() == 0 &&
+		s.sequenceNumber == r.RcvNxt &&
+		s.ackNumber == r.ep.snd.SndUna &&
+		s.window == r.ep.snd.SndWnd
+}
+
 // handleTimeWaitSegment handles inbound segments received when the endpoint
 // has entered the TIME_WAIT state.
 // +checklocks:r.ep.mu
@@ -561,8 +724,8 @@ func (r *receiver) handleTimeWaitSegment(s *segment) (resetTimeWait bool, newSyn
 	// on receiving RST, which is also default Linux behavior.
 	// On Linux the RST can be ignored by setting sysctl net.ipv4.tcp_rfc1337.
 	//
-	// As we do not yet support PAWS, we are being conservative in ignoring
-	// RSTs by default.
+	// As PAWS does not apply to RST segments, we are being conservative in
+	// ignoring RSTs by default.
 	if s.flags.Contains(header.TCPFlagRst) {
 		return false, false
 	}
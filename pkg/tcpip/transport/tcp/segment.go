@@ -65,6 +65,8 @@ type segment struct {
 	ackNumber      seqnum.Value
 	flags          header.TCPFlags
 	window         seqnum.Size
+	// urgentPointer is only populated for received segments.
+	urgentPointer uint16
 	// csum is only populated for received segments.
 	csum uint16
 	// csumValid is true if the csum in the received segment is valid.
@@ -128,6 +130,7 @@ func newIncomingSegment(id stack.TransportEndpointID, clock tcpip.Clock, pkt *st
 	s.ackNumber = seqnum.Value(hdr.AckNumber())
 	s.flags = hdr.Flags()
 	s.window = seqnum.Size(hdr.WindowSize())
+	s.urgentPointer = hdr.UrgentPointer()
 	s.rcvdTime = clock.NowMonotonic()
 	s.dataMemSize = pkt.MemSize()
 	s.pkt = pkt.IncRef()
@@ -155,6 +158,7 @@ func (s *segment) clone() *segment {
 	t.ackNumber = s.ackNumber
 	t.flags = s.flags
 	t.window = s.window
+	t.urgentPointer = s.urgentPointer
 	t.rcvdTime = s.rcvdTime
 	t.xmitTime = s.xmitTime
 	t.xmitCount = s.xmitCount
@@ -52,13 +52,15 @@ func (q *segmentQueue) enqueue(s *segment) bool {
 	// avoid lock order inversion.
 	bufSz := q.ep.ops.GetReceiveBufferSize()
 	used := q.ep.receiveMemUsed()
+	stackAvail, stackLimited := q.ep.stack.ReceiveMemoryAvailable(&q.ep.rcvMemAccount)
 
 	q.mu.Lock()
 	defer q.mu.Unlock()
 
 	// Allow zero sized segments (ACK/FIN/RSTs etc even if the segment queue
-	// is currently full).
-	allow := (used <= int(bufSz) || s.payloadSize() == 0) && !q.frozen
+	// is currently full or the stack has run out of receive memory).
+	fits := used <= int(bufSz) && (!stackLimited || stackAvail > 0)
+	allow := (fits || s.payloadSize() == 0) && !q.frozen
 
 	if allow {
 		s.IncRef()
@@ -37,7 +37,8 @@ const (
 	// MinSRTT is the minimum allowed value for smoothed RTT.
 	MinSRTT = 1 * time.Millisecond
 
-	// InitialCwnd is the initial congestion window.
+	// InitialCwnd is the default initial congestion window, as recommended by
+	// RFC 6928.
 	InitialCwnd = 10
 
 	// nDupAckThreshold is the number of duplicate ACK's required
@@ -48,6 +49,12 @@ const (
 	// before timing out the connection.
 	// Linux default TCP_RETR2, net.ipv4.tcp_retries2.
 	MaxRetries = 15
+
+	// PMTUIncreaseInterval is how long the sender waits after the path MTU
+	// was lowered by a "packet too big" control packet before it tries a
+	// larger segment size again. See RFC 1191 section 6.3 and RFC 8201
+	// section 4.
+	PMTUIncreaseInterval = 10 * time.Minute
 )
 
 // congestionControl is an interface that must be implemented by any supported
@@ -109,6 +116,12 @@ type sender struct {
 	writeList   segmentList
 	resendTimer timer `state:"nosave"`
 
+	// urgent is set while urgent data written with WriteOptions.OutOfBand
+	// has not been acknowledged. sndUp is the sequence number following the
+	// last byte of urgent data.
+	urgent bool
+	sndUp  seqnum.Value
+
 	// rtt.TCPRTTState.SRTT and rtt.TCPRTTState.RTTVar are the "smoothed
 	// round-trip time", and "round-trip time variation", as defined in
 	// section 2 of RFC 6298.
@@ -123,6 +136,10 @@ type sender struct {
 	// maxRetries is the maximum permitted retransmissions.
 	maxRetries uint32
 
+	// initialCwnd is the initial congestion window, which is also the
+	// restart window after idle periods.
+	initialCwnd int
+
 	// gso is set if generic segmentation offload is enabled.
 	gso bool
 
@@ -152,12 +169,33 @@ type sender struct {
 	// RFC3522 Section 3.2.
 	retransmitTS uint32
 
+	// ecnCWR is set when the congestion window was reduced in response to
+	// an ECN echo, until the CWR flag is sent on the next new data segment.
+	ecnCWR bool
+
+	// ecnRecover is the last sequence number outstanding when the
+	// congestion window was last reduced in response to an ECN echo. No
+	// further reduction happens until it is acknowledged.
+	ecnRecover seqnum.Value
+
 	// startCork start corking the segments.
 	startCork bool
 
 	// corkTimer is used to drain the segments which are held when TCP_CORK
 	// option is enabled.
 	corkTimer timer `state:"nosave"`
+
+	// delayedAckTimer is used to acknowledge in-order data that was received
+	// while delayed ACKs are enabled.
+	delayedAckTimer timer `state:"nosave"`
+
+	// peerMSS is the MSS advertised by the peer, capped to the stack-wide
+	// MSS bound. It bounds the segment size restored by pmtuTimer.
+	peerMSS uint16
+
+	// pmtuTimer is used to raise the maximum payload size again after it
+	// was lowered in response to a "packet too big" control packet.
+	pmtuTimer timer `state:"nosave"`
 }
 
 // rtt is a synchronization wrapper used to appease stateify. See the comment
@@ -172,6 +210,12 @@ type rtt struct {
 
 // +checklocks:ep.mu
 func newSender(ep *Endpoint, iss, irs seqnum.Value, sndWnd seqnum.Size, mss uint16, sndWndScale int) *sender {
+	// Never send segments larger than the stack-wide MSS bound, even if the
+	// peer's MSS allows it.
+	if ep.maxMSS != 0 && mss > ep.maxMSS {
+		mss = ep.maxMSS
+	}
+
 	// The sender MUST reduce the TCP data length to account for any IP or
 	// TCP options that it is including in the packets that it sends.
 	// See: https://tools.ietf.org/html/rfc6691#section-2
@@ -195,7 +239,9 @@ func newSender(ep *Endpoint, iss, irs seqnum.Value, sndWnd seqnum.Size, mss uint
 			},
 			RTO: 1 * time.Second,
 		},
-		gso: ep.gso.Type != stack.GSONone,
+		gso:        ep.gso.Type != stack.GSONone,
+		ecnRecover: iss,
+		peerMSS:    mss,
 	}
 
 	if s.gso {
@@ -216,6 +262,8 @@ func newSender(ep *Endpoint, iss, irs seqnum.Value, sndWnd seqnum.Size, mss uint
 	s.reorderTimer.init(s.ep.stack.Clock(), timerHandler(s.ep, s.rc.reorderTimerExpired))
 	s.probeTimer.init(s.ep.stack.Clock(), timerHandler(s.ep, s.probeTimerExpired))
 	s.corkTimer.init(s.ep.stack.Clock(), timerHandler(s.ep, s.corkTimerExpired))
+	s.delayedAckTimer.init(s.ep.stack.Clock(), timerHandler(s.ep, s.delayedAckTimerExpired))
+	s.pmtuTimer.init(s.ep.stack.Clock(), timerHandler(s.ep, s.pmtuTimerExpired))
 
 	s.ep.AssertLockHeld(ep)
 	s.updateMaxPayloadSize(int(ep.route.MTU()), 0)
@@ -250,10 +298,24 @@ func newSender(ep *Endpoint, iss, irs seqnum.Value, sndWnd seqnum.Size, mss uint
 // returns a handle to it. It also initializes the sndCwnd and sndSsThresh to
 // their initial values.
 func (s *sender) initCongestionControl(congestionControlName tcpip.CongestionControlOption) congestionControl {
-	s.SndCwnd = InitialCwnd
-	// Set sndSsthresh to the maximum int value, which depends on the
-	// platform.
-	s.Ssthresh = int(^uint(0) >> 1)
+	var initialCwnd tcpip.TCPInitialCwndOption
+	if err := s.ep.stack.TransportProtocolOption(ProtocolNumber, &initialCwnd); err != nil {
+		panic(fmt.Sprintf("unable to get initialCwnd from stack: %s", err))
+	}
+	s.initialCwnd = int(initialCwnd)
+	s.SndCwnd = s.initialCwnd
+
+	var initialSsthresh tcpip.TCPInitialSsthreshOption
+	if err := s.ep.stack.TransportProtocolOption(ProtocolNumber, &initialSsthresh); err != nil {
+		panic(fmt.Sprintf("unable to get initialSsthresh from stack: %s", err))
+	}
+	if initialSsthresh != 0 {
+		s.Ssthresh = int(initialSsthresh)
+	} else {
+		// Set sndSsthresh to the maximum int value, which depends on the
+		// platform.
+		s.Ssthresh = int(^uint(0) >> 1)
+	}
 
 	switch congestionControlName {
 	case ccCubic:
@@ -283,7 +345,7 @@ func (s *sender) updateMaxPayloadSize(mtu, count int) {
 
 	m -= s.ep.maxOptionSize()
 
-	// We don't adjust up for now.
+	// The size is only raised again by pmtuTimerExpired.
 	if m >= s.MaxPayloadSize {
 		return
 	}
@@ -309,6 +371,10 @@ func (s *sender) updateMaxPayloadSize(mtu, count int) {
 	// maxPayloadSize.
 	s.ep.scoreboard.smss = uint16(m)
 
+	// Periodically try a larger size again in case the path MTU has
+	// increased. See RFC 1191 section 6.3.
+	s.pmtuTimer.enable(PMTUIncreaseInterval)
+
 	s.Outstanding -= count
 	if s.Outstanding < 0 {
 		s.Outstanding = 0
@@ -980,8 +1046,8 @@ func (s *sender) sendData() {
 	// transmission if the TCP has not sent data in the interval exceeding
 	// the retrasmission timeout."
 	if !s.FastRecovery.Active && s.state != tcpip.RTORecovery && s.ep.stack.Clock().NowMonotonic().Sub(s.LastSendTime) > s.RTO {
-		if s.SndCwnd > InitialCwnd {
-			s.SndCwnd = InitialCwnd
+		if s.SndCwnd > s.initialCwnd {
+			s.SndCwnd = s.initialCwnd
 		}
 	}
 
@@ -1586,6 +1652,11 @@ func (s *sender) handleRcvdSegment(rcvdSeg *segment) {
 		// Update the send buffer usage and notify potential waiters.
 		s.ep.updateSndBufferUsage(int(acked))
 
+		// Leave urgent mode once all urgent data is acknowledged.
+		if s.urgent && !s.SndUna.LessThan(s.sndUp) {
+			s.urgent = false
+		}
+
 		// It is possible for s.outstanding to drop below zero if we get
 		// a retransmit timeout, reset outstanding to zero but later
 		// get an ack that cover previously sent data.
@@ -1606,6 +1677,11 @@ func (s *sender) handleRcvdSegment(rcvdSeg *segment) {
 		}
 	}
 
+	// React to congestion experienced by the peer.
+	if s.ep.ecn && rcvdSeg.flags.Contains(header.TCPFlagEce) {
+		s.handleECE()
+	}
+
 	if s.ep.SACKPermitted && s.ep.tcpRecovery&tcpip.TCPRACKLossDetection != 0 {
 		// Update RACK reorder window.
 		// See: https://tools.ietf.org/html/draft-ietf-tcpm-rack-08#section-7.2
@@ -1663,7 +1739,16 @@ func (s *sender) sendSegment(seg *segment) tcpip.Error {
 	seg.xmitCount++
 	seg.lost = false
 
-	err := s.sendSegmentFromPacketBuffer(seg.pkt, seg.flags, seg.sequenceNumber)
+	// Only new data is sent ECN-capable, and the first such segment after
+	// a window reduction carries CWR. See RFC 3168 section 6.1.5.
+	flags := seg.flags
+	ect := s.ep.ecn && seg.payloadSize() > 0 && seg.xmitCount == 1
+	if ect && s.ecnCWR {
+		flags |= header.TCPFlagCwr
+		s.ecnCWR = false
+	}
+
+	err := s.sendSegmentFromPacketBuffer(seg.pkt, flags, seg.sequenceNumber, ect)
 
 	// Every time a packet containing data is sent (including a
 	// retransmission), if SACK is enabled and we are retransmitting data
@@ -1683,10 +1768,11 @@ func (s *sender) sendSegment(seg *segment) tcpip.Error {
 }
 
 // sendSegmentFromPacketBuffer sends a new segment containing the given payload,
-// flags and sequence number.
+// flags and sequence number. If ect is set, the segment is marked
+// ECN-capable.
 // +checklocks:s.ep.mu
 // +checklocksalias:s.ep.rcv.ep.mu=s.ep.mu
-func (s *sender) sendSegmentFromPacketBuffer(pkt *stack.PacketBuffer, flags header.TCPFlags, seq seqnum.Value) tcpip.Error {
+func (s *sender) sendSegmentFromPacketBuffer(pkt *stack.PacketBuffer, flags header.TCPFlags, seq seqnum.Value, ect bool) tcpip.Error {
 	s.LastSendTime = s.ep.stack.Clock().NowMonotonic()
 	if seq == s.RTTMeasureSeqNum {
 		s.RTTMeasureTime = s.LastSendTime
@@ -1702,7 +1788,7 @@ func (s *sender) sendSegmentFromPacketBuffer(pkt *stack.PacketBuffer, flags head
 	pkt = pkt.Clone()
 	defer pkt.DecRef()
 
-	return s.ep.sendRaw(pkt, flags, seq, rcvNxt, rcvWnd)
+	return s.ep.sendRaw(pkt, flags, seq, rcvNxt, rcvWnd, ect)
 }
 
 // sendEmptySegment sends a new empty segment, flags and sequence number.
@@ -1743,6 +1829,83 @@ func (s *sender) updateWriteNext(seg *segment) {
 	s.writeNext = seg
 }
 
+// sendPendingAck acknowledges the data received since the last ACK was sent.
+//
+// As per RFC 1122 section 4.2.3.2, when delayed ACKs are enabled the ACK for
+// in-order data is held until either a second full-sized segment arrives or
+// the delayed ACK timer fires. Segments are full-sized if they are as large as
+// the ones the peer has been sending, see receiver.rcvMSS. Out-of-order
+// segments and FINs are acknowledged immediately by the receiver, and so is
+// in-order data while out-of-order data is still queued or while the receive
+// window needs updating.
+// +checklocks:s.ep.mu
+// +checklocksalias:s.ep.rcv.ep.mu=s.ep.mu
+func (s *sender) sendPendingAck() {
+	rcvNxt := s.ep.rcv.RcvNxt
+	if rcvNxt == s.MaxSentAck {
+		return
+	}
+
+	if s.ep.delayedAckTimeout == 0 || s.ep.ops.GetQuickAck() || s.MaxSentAck.Size(rcvNxt) >= 2*seqnum.Size(s.ep.rcv.rcvMSS) || s.ep.rcv.pendingRcvdSegments.Len() > 0 || s.ep.rcv.windowUpdateNeeded() {
+		s.delayedAckTimer.disable()
+		s.sendAck()
+		return
+	}
+
+	if !s.delayedAckTimer.enabled() {
+		s.delayedAckTimer.enable(s.ep.delayedAckTimeout)
+	}
+}
+
+// delayedAckTimerExpired sends the ACK that was delayed by sendPendingAck.
+// +checklocks:s.ep.mu
+// +checklocksalias:s.ep.rcv.ep.mu=s.ep.mu
+func (s *sender) delayedAckTimerExpired() tcpip.Error {
+	// Check if the timer actually expired or if it's a spurious wake due
+	// to a previously orphaned runtime timer.
+	if s.delayedAckTimer.isUninitialized() || !s.delayedAckTimer.checkExpiration() {
+		return nil
+	}
+
+	// The ACK may already have been sent along with data.
+	if s.ep.rcv.RcvNxt != s.MaxSentAck {
+		s.sendAck()
+	}
+	return nil
+}
+
+// pmtuTimerExpired forgets the path MTU learned from "packet too big" control
+// packets and raises the maximum payload size back to what the route and the
+// peer's MSS allow. If the path MTU is still low, the next "packet too big"
+// lowers it again.
+// +checklocks:s.ep.mu
+func (s *sender) pmtuTimerExpired() tcpip.Error {
+	// Check if the timer actually expired or if it's a spurious wake due
+	// to a previously orphaned runtime timer.
+	if s.pmtuTimer.isUninitialized() || !s.pmtuTimer.checkExpiration() {
+		return nil
+	}
+
+	s.ep.sndQueueInfo.sndQueueMu.Lock()
+	s.ep.sndQueueInfo.SndMTU = math.MaxInt32
+	s.ep.sndQueueInfo.sndQueueMu.Unlock()
+
+	m := int(s.peerMSS) - s.ep.maxOptionSize()
+	if v := int(s.ep.route.MTU()) - header.TCPMinimumSize - s.ep.maxOptionSize(); v < m {
+		m = v
+	}
+	if m <= s.MaxPayloadSize {
+		return nil
+	}
+
+	s.MaxPayloadSize = m
+	if s.gso {
+		s.ep.gso.MSS = uint16(m)
+	}
+	s.ep.scoreboard.smss = uint16(m)
+	return nil
+}
+
 // corkTimerExpired drains all the segments when TCP_CORK is enabled.
 // +checklocks:s.ep.mu
 func (s *sender) corkTimerExpired() tcpip.Error {
@@ -392,6 +392,12 @@ func TestV6AcceptOnV6(t *testing.T) {
 		t.Fatalf("Listen failed: %v", err)
 	}
 
+	testV6Accept(t, c)
+}
+
+// testV6Accept completes an IPv6 handshake with the listening c.EP and
+// accepts the connection.
+func testV6Accept(t *testing.T, c *context.Context) {
 	// Send a SYN request.
 	irs := seqnum.Value(789)
 	c.SendV6Packet(nil, &context.Headers{
@@ -451,6 +457,22 @@ func TestV6AcceptOnV6(t *testing.T) {
 	}
 }
 
+func TestV4AndV6AcceptOnDualStack(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	c.CreateV6Endpoint(false)
+
+	// Bind to wildcard.
+	if err := c.EP.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+		t.Fatalf("Bind failed: %v", err)
+	}
+
+	// A single listener accepts connections from both families.
+	testV4Accept(t, c)
+	testV6Accept(t, c)
+}
+
 func TestV4AcceptOnV4(t *testing.T) {
 	c := context.New(t, e2e.DefaultMTU)
 	defer c.Cleanup()
@@ -0,0 +1,459 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_ecn_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/refs"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checker"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/seqnum"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp/test/e2e"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp/testing/context"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+// ECN codepoints, as defined in RFC 3168 section 5.
+const (
+	notECT = 0x0
+	ect0   = 0x2
+	ce     = 0x3
+)
+
+const rcvWnd = 30000
+
+func enableECN(t *testing.T, c *context.Context) {
+	t.Helper()
+	opt := tcpip.TCPECNEnabled(true)
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%t)): %s", tcp.ProtocolNumber, opt, opt, err)
+	}
+}
+
+// connect performs an active open of c.EP and answers its SYN, which must
+// carry synFlags, with a SYN-ACK carrying synAckFlags.
+func connect(t *testing.T, c *context.Context, synFlags, synAckFlags header.TCPFlags) {
+	t.Helper()
+	c.Create(-1 /* epRcvBuf */)
+
+	we, ch := waiter.NewChannelEntry(waiter.WritableEvents)
+	c.WQ.EventRegister(&we)
+	defer c.WQ.EventUnregister(&we)
+	if err := c.EP.Connect(tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}); !cmp.Equal(&tcpip.ErrConnectStarted{}, err) {
+		t.Fatalf("got c.EP.Connect(...) = %v, want = %s", err, &tcpip.ErrConnectStarted{})
+	}
+
+	b := c.GetPacket()
+	defer b.Release()
+	checker.IPv4(t, b,
+		checker.TOS(notECT, 0),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPFlags(synFlags),
+		),
+	)
+	tcpHdr := header.TCP(header.IPv4(b.AsSlice()).Payload())
+	c.IRS = seqnum.Value(tcpHdr.SequenceNumber())
+	c.Port = tcpHdr.SourcePort()
+
+	iss := seqnum.Value(context.TestInitialSequenceNumber)
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagSyn | header.TCPFlagAck | synAckFlags,
+		SeqNum:  iss,
+		AckNum:  c.IRS.Add(1),
+		RcvWnd:  rcvWnd,
+	})
+	b2 := c.GetPacket()
+	defer b2.Release()
+	checker.IPv4(t, b2,
+		checker.TOS(notECT, 0),
+		checker.TCP(
+			checker.TCPFlags(header.TCPFlagAck),
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+			checker.TCPAckNum(uint32(iss)+1),
+		),
+	)
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the connection to be established")
+	}
+}
+
+// sendWithTOS sends a segment whose IPv4 TOS field is set to tos.
+func sendWithTOS(c *context.Context, tos uint8, payload []byte, h *context.Headers) {
+	buf := c.BuildSegment(payload, h)
+	b := buf.Flatten()
+	buf.Release()
+	ip := header.IPv4(b)
+	ip.SetTOS(tos, 0)
+	ip.SetChecksum(0)
+	ip.SetChecksum(^ip.CalculateChecksum())
+	c.SendSegment(buffer.MakeWithData(b))
+}
+
+func write(t *testing.T, ep tcpip.Endpoint, data []byte) {
+	t.Helper()
+	var r bytes.Reader
+	r.Reset(data)
+	if _, err := ep.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+}
+
+func sndCwnd(t *testing.T, ep tcpip.Endpoint) uint32 {
+	t.Helper()
+	var info tcpip.TCPInfoOption
+	if err := ep.GetSockOpt(&info); err != nil {
+		t.Fatalf("GetSockOpt(&%T{}): %s", info, err)
+	}
+	return info.SndCwnd
+}
+
+func TestECNActiveOpen(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		enabled     bool
+		synFlags    header.TCPFlags
+		synAckFlags header.TCPFlags
+		wantTOS     uint8
+	}{
+		{
+			name:     "disabled",
+			synFlags: header.TCPFlagSyn,
+			wantTOS:  notECT,
+		},
+		{
+			name:        "disabled peer agrees anyway",
+			synFlags:    header.TCPFlagSyn,
+			synAckFlags: header.TCPFlagEce,
+			wantTOS:     notECT,
+		},
+		{
+			name:        "accepted",
+			enabled:     true,
+			synFlags:    header.TCPFlagSyn | header.TCPFlagEce | header.TCPFlagCwr,
+			synAckFlags: header.TCPFlagEce,
+			wantTOS:     ect0,
+		},
+		{
+			name:     "declined",
+			enabled:  true,
+			synFlags: header.TCPFlagSyn | header.TCPFlagEce | header.TCPFlagCwr,
+			wantTOS:  notECT,
+		},
+		{
+			name:        "bad SYN-ACK",
+			enabled:     true,
+			synFlags:    header.TCPFlagSyn | header.TCPFlagEce | header.TCPFlagCwr,
+			synAckFlags: header.TCPFlagEce | header.TCPFlagCwr,
+			wantTOS:     notECT,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := context.New(t, e2e.DefaultMTU)
+			defer c.Cleanup()
+			if test.enabled {
+				enableECN(t, c)
+			}
+			connect(t, c, test.synFlags, test.synAckFlags)
+
+			// Only data segments are marked ECN-capable.
+			data := []byte{1, 2, 3}
+			write(t, c.EP, data)
+			b := c.GetPacket()
+			defer b.Release()
+			checker.IPv4(t, b,
+				checker.TOS(test.wantTOS, 0),
+				checker.PayloadLen(len(data)+header.TCPMinimumSize),
+				checker.TCP(
+					checker.TCPSeqNum(uint32(c.IRS)+1),
+					checker.TCPFlags(header.TCPFlagAck|header.TCPFlagPsh),
+				),
+			)
+		})
+	}
+}
+
+func TestECNPassiveOpen(t *testing.T) {
+	for _, test := range []struct {
+		name            string
+		enabled         bool
+		synFlags        header.TCPFlags
+		wantSynAckFlags header.TCPFlags
+		wantTOS         uint8
+	}{
+		{
+			name:            "disabled",
+			synFlags:        header.TCPFlagEce | header.TCPFlagCwr,
+			wantSynAckFlags: header.TCPFlagSyn | header.TCPFlagAck,
+			wantTOS:         notECT,
+		},
+		{
+			name:            "offered",
+			enabled:         true,
+			synFlags:        header.TCPFlagEce | header.TCPFlagCwr,
+			wantSynAckFlags: header.TCPFlagSyn | header.TCPFlagAck | header.TCPFlagEce,
+			wantTOS:         ect0,
+		},
+		{
+			name:            "not offered",
+			enabled:         true,
+			wantSynAckFlags: header.TCPFlagSyn | header.TCPFlagAck,
+			wantTOS:         notECT,
+		},
+		{
+			name:            "ECE only",
+			enabled:         true,
+			synFlags:        header.TCPFlagEce,
+			wantSynAckFlags: header.TCPFlagSyn | header.TCPFlagAck,
+			wantTOS:         notECT,
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := context.New(t, e2e.DefaultMTU)
+			defer c.Cleanup()
+			if test.enabled {
+				enableECN(t, c)
+			}
+
+			wq := &waiter.Queue{}
+			ep, err := c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, wq)
+			if err != nil {
+				t.Fatalf("NewEndpoint failed: %s", err)
+			}
+			defer ep.Close()
+			if err := ep.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+				t.Fatalf("Bind failed: %s", err)
+			}
+			if err := ep.Listen(10); err != nil {
+				t.Fatalf("Listen failed: %s", err)
+			}
+
+			iss := seqnum.Value(context.TestInitialSequenceNumber)
+			c.SendPacket(nil, &context.Headers{
+				SrcPort: context.TestPort,
+				DstPort: context.StackPort,
+				Flags:   header.TCPFlagSyn | test.synFlags,
+				SeqNum:  iss,
+				RcvWnd:  rcvWnd,
+			})
+			b := c.GetPacket()
+			defer b.Release()
+			checker.IPv4(t, b,
+				checker.TOS(notECT, 0),
+				checker.TCP(
+					checker.DstPort(context.TestPort),
+					checker.TCPFlags(test.wantSynAckFlags),
+					checker.TCPAckNum(uint32(iss)+1),
+				),
+			)
+			irs := seqnum.Value(header.TCP(header.IPv4(b.AsSlice()).Payload()).SequenceNumber())
+
+			we, ch := waiter.NewChannelEntry(waiter.ReadableEvents)
+			wq.EventRegister(&we)
+			defer wq.EventUnregister(&we)
+			c.SendPacket(nil, &context.Headers{
+				SrcPort: context.TestPort,
+				DstPort: context.StackPort,
+				Flags:   header.TCPFlagAck,
+				SeqNum:  iss.Add(1),
+				AckNum:  irs.Add(1),
+				RcvWnd:  rcvWnd,
+			})
+			select {
+			case <-ch:
+			case <-time.After(time.Second):
+				t.Fatalf("timed out waiting for the connection to be accepted")
+			}
+			n, _, err := ep.Accept(nil)
+			if err != nil {
+				t.Fatalf("Accept failed: %s", err)
+			}
+			defer n.Close()
+
+			data := []byte{1, 2, 3}
+			write(t, n, data)
+			b2 := c.GetPacket()
+			defer b2.Release()
+			checker.IPv4(t, b2,
+				checker.TOS(test.wantTOS, 0),
+				checker.PayloadLen(len(data)+header.TCPMinimumSize),
+				checker.TCP(
+					checker.TCPSeqNum(uint32(irs)+1),
+					checker.TCPAckNum(uint32(iss)+1),
+				),
+			)
+		})
+	}
+}
+
+func TestECNEcho(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+	enableECN(t, c)
+	connect(t, c, header.TCPFlagSyn|header.TCPFlagEce|header.TCPFlagCwr, header.TCPFlagEce)
+
+	data := []byte{1, 2, 3}
+	seq := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	for _, step := range []struct {
+		name      string
+		tos       uint8
+		flags     header.TCPFlags
+		wantFlags header.TCPFlags
+	}{
+		{
+			name:      "not congested",
+			tos:       ect0,
+			flags:     header.TCPFlagAck,
+			wantFlags: header.TCPFlagAck,
+		},
+		{
+			name:      "congestion experienced",
+			tos:       ce,
+			flags:     header.TCPFlagAck,
+			wantFlags: header.TCPFlagAck | header.TCPFlagEce,
+		},
+		{
+			name:      "congestion not yet acknowledged",
+			tos:       ect0,
+			flags:     header.TCPFlagAck,
+			wantFlags: header.TCPFlagAck | header.TCPFlagEce,
+		},
+		{
+			name:      "congestion window reduced",
+			tos:       ect0,
+			flags:     header.TCPFlagAck | header.TCPFlagCwr,
+			wantFlags: header.TCPFlagAck,
+		},
+	} {
+		sendWithTOS(c, step.tos, data, &context.Headers{
+			SrcPort: context.TestPort,
+			DstPort: c.Port,
+			Flags:   step.flags,
+			SeqNum:  seq,
+			AckNum:  c.IRS.Add(1),
+			RcvWnd:  rcvWnd,
+		})
+		seq = seq.Add(seqnum.Size(len(data)))
+
+		// ACKs are never marked ECN-capable.
+		b := c.GetPacket()
+		checker.IPv4(t, b,
+			checker.TOS(notECT, 0),
+			checker.TCP(
+				checker.TCPFlags(step.wantFlags),
+				checker.TCPAckNum(uint32(seq)),
+			),
+		)
+		b.Release()
+		if t.Failed() {
+			t.Fatalf("%s: unexpected ACK", step.name)
+		}
+	}
+}
+
+func TestECNCongestionResponse(t *testing.T) {
+	const maxPayload = 32
+	c := context.New(t, uint32(header.TCPMinimumSize+header.IPv4MinimumSize+maxPayload))
+	defer c.Cleanup()
+	enableECN(t, c)
+	connect(t, c, header.TCPFlagSyn|header.TCPFlagEce|header.TCPFlagCwr, header.TCPFlagEce)
+
+	data := make([]byte, 4*tcp.InitialCwnd*maxPayload)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	write(t, c.EP, data)
+
+	// Receive the initial window without acknowledging it.
+	bytesRead := 0
+	for i := 0; i < tcp.InitialCwnd; i++ {
+		c.ReceiveAndCheckPacket(data, bytesRead, maxPayload)
+		bytesRead += maxPayload
+	}
+	c.CheckNoPacketTimeout("more packets received than expected for the initial window", 50*time.Millisecond)
+	cwnd := sndCwnd(t, c.EP)
+
+	ack := func(bytesAcked int, flags header.TCPFlags) {
+		c.SendPacket(nil, &context.Headers{
+			SrcPort: context.TestPort,
+			DstPort: c.Port,
+			Flags:   header.TCPFlagAck | flags,
+			SeqNum:  seqnum.Value(context.TestInitialSequenceNumber).Add(1),
+			AckNum:  c.IRS.Add(1 + seqnum.Size(bytesAcked)),
+			RcvWnd:  rcvWnd,
+		})
+	}
+
+	// Echo a CE mark in the ACK of half the window. The sender reduces its
+	// window, leaving no room to send anything, and retransmits nothing.
+	ack(bytesRead/2, header.TCPFlagEce)
+	c.CheckNoPacketTimeout("got a packet after the window was reduced", 50*time.Millisecond)
+	reduced := sndCwnd(t, c.EP)
+	if reduced >= cwnd {
+		t.Fatalf("got SndCwnd = %d after ECE, want < %d", reduced, cwnd)
+	}
+	if got := c.Stack().Stats().TCP.Retransmits.Value(); got != 0 {
+		t.Errorf("got stats.TCP.Retransmits.Value() = %d, want = 0", got)
+	}
+
+	// The window is only reduced once per window of data.
+	ack(bytesRead/2+maxPayload, header.TCPFlagEce)
+	if got := sndCwnd(t, c.EP); got < reduced {
+		t.Errorf("got SndCwnd = %d after a second ECE in the same window, want >= %d", got, reduced)
+	}
+
+	// The first new data segment sent afterwards carries CWR, and the
+	// next ones don't.
+	ack(bytesRead, 0)
+	for i, wantFlags := range []header.TCPFlags{header.TCPFlagAck | header.TCPFlagCwr, header.TCPFlagAck} {
+		b := c.GetPacket()
+		checker.IPv4(t, b,
+			checker.TOS(ect0, 0),
+			checker.PayloadLen(maxPayload+header.TCPMinimumSize),
+			checker.TCP(
+				checker.TCPSeqNum(uint32(c.IRS)+1+uint32(bytesRead)),
+				checker.TCPFlagsMatch(wantFlags, ^header.TCPFlagPsh),
+			),
+		)
+		b.Release()
+		if t.Failed() {
+			t.Fatalf("unexpected segment %d after the window was reduced", i)
+		}
+		bytesRead += maxPayload
+	}
+}
+
+func TestMain(m *testing.M) {
+	refs.SetLeakMode(refs.LeaksPanic)
+	code := m.Run()
+	// Allow TCP async work to complete to avoid false reports of leaks.
+	// TODO(gvisor.dev/issue/5940): Use fake clock in tests.
+	time.Sleep(1 * time.Second)
+	refs.DoLeakCheck()
+	os.Exit(code)
+}
@@ -0,0 +1,479 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package tcp_md5_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"gvisor.dev/gvisor/pkg/buffer"
+	"gvisor.dev/gvisor/pkg/refs"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checker"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/seqnum"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp/test/e2e"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp/testing/context"
+	"gvisor.dev/gvisor/pkg/waiter"
+)
+
+var (
+	md5Key      = []byte("shared secret")
+	wrongMD5Key = []byte("wrong secret")
+)
+
+// md5Option returns the options of a segment carrying an MD5 signature option
+// with an empty digest.
+func md5Option() []byte {
+	opts := make([]byte, 2+header.TCPOptionMD5Length)
+	opts[0], opts[1] = header.TCPOptionNOP, header.TCPOptionNOP
+	header.EncodeMD5Option(opts[2:])
+	return opts
+}
+
+// md5Digest computes the signature of the TCP segment in the IPv4 packet ip as
+// described in RFC 2385 section 2.0.
+func md5Digest(ip header.IPv4, key []byte) []byte {
+	tcpHdr := header.TCP(ip.Payload())
+	h := md5.New()
+	src, dst := ip.SourceAddress().As4(), ip.DestinationAddress().As4()
+	h.Write(src[:])
+	h.Write(dst[:])
+	h.Write([]byte{0, uint8(tcp.ProtocolNumber)})
+	h.Write(binary.BigEndian.AppendUint16(nil, uint16(len(tcpHdr))))
+	hdr := append([]byte(nil), tcpHdr[:header.TCPMinimumSize]...)
+	header.TCP(hdr).SetChecksum(0)
+	h.Write(hdr)
+	h.Write(tcpHdr.Payload())
+	h.Write(key)
+	return h.Sum(nil)
+}
+
+// sendSigned sends a segment carrying an MD5 signature option. The segment is
+// signed with key; if key is nil the option is sent with an empty digest.
+func sendSigned(c *context.Context, key, payload []byte, h *context.Headers) {
+	h.TCPOpts = md5Option()
+	buf := c.BuildSegment(payload, h)
+	b := buf.Flatten()
+	buf.Release()
+	ip := header.IPv4(b)
+	tcpHdr := header.TCP(ip.Payload())
+	if key != nil {
+		copy(header.TCPMD5Digest(tcpHdr.Options()), md5Digest(ip, key))
+	}
+	tcpHdr.SetChecksum(0)
+	xsum := header.PseudoHeaderChecksum(tcp.ProtocolNumber, ip.SourceAddress(), ip.DestinationAddress(), uint16(len(tcpHdr)))
+	xsum = checksum.Checksum(tcpHdr.Payload(), xsum)
+	tcpHdr.SetChecksum(^tcpHdr.CalculateChecksum(xsum))
+	c.SendSegment(buffer.MakeWithData(b))
+}
+
+// checkSigned checks that the TCP segment in the IPv4 packet b is signed with
+// key.
+func checkSigned(t *testing.T, b *buffer.View, key []byte) {
+	t.Helper()
+	ip := header.IPv4(b.AsSlice())
+	got := header.TCPMD5Digest(header.TCP(ip.Payload()).Options())
+	if got == nil {
+		t.Fatalf("segment has no MD5 signature option")
+	}
+	if want := md5Digest(ip, key); !bytes.Equal(got, want) {
+		t.Fatalf("got MD5 signature = %x, want = %x", got, want)
+	}
+}
+
+func setMD5Key(t *testing.T, ep tcpip.Endpoint, key []byte) {
+	t.Helper()
+	opt := tcpip.TCPMD5SignatureOption{Addr: context.TestAddr, Key: key}
+	if err := ep.SetSockOpt(&opt); err != nil {
+		t.Fatalf("SetSockOpt(&%#v): %s", opt, err)
+	}
+}
+
+func TestMD5SignatureKeyLength(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+	c.Create(-1 /* epRcvBuf */)
+
+	opt := tcpip.TCPMD5SignatureOption{Addr: context.TestAddr, Key: make([]byte, 81)}
+	if err := c.EP.SetSockOpt(&opt); !cmp.Equal(&tcpip.ErrInvalidOptionValue{}, err) {
+		t.Errorf("got SetSockOpt(&%T{Key: <%d bytes>}) = %v, want = %s", opt, len(opt.Key), err, &tcpip.ErrInvalidOptionValue{})
+	}
+	setMD5Key(t, c.EP, make([]byte, 80))
+	// An empty key removes the key.
+	setMD5Key(t, c.EP, nil)
+}
+
+func TestMD5SignatureActiveOpen(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+	c.Create(-1 /* epRcvBuf */)
+	setMD5Key(t, c.EP, md5Key)
+
+	we, ch := waiter.NewChannelEntry(waiter.WritableEvents)
+	c.WQ.EventRegister(&we)
+	defer c.WQ.EventUnregister(&we)
+	if err := c.EP.Connect(tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}); !cmp.Equal(&tcpip.ErrConnectStarted{}, err) {
+		t.Fatalf("got c.EP.Connect(...) = %v, want = %s", err, &tcpip.ErrConnectStarted{})
+	}
+
+	// The SYN is signed.
+	b := c.GetPacket()
+	defer b.Release()
+	checker.IPv4(t, b, checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPFlags(header.TCPFlagSyn),
+	))
+	checkSigned(t, b, md5Key)
+	tcpHdr := header.TCP(header.IPv4(b.AsSlice()).Payload())
+	c.IRS = seqnum.Value(tcpHdr.SequenceNumber())
+	c.Port = tcpHdr.SourcePort()
+
+	iss := seqnum.Value(context.TestInitialSequenceNumber)
+	rcvWnd := seqnum.Size(30000)
+	sendSigned(c, md5Key, nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagSyn | header.TCPFlagAck,
+		SeqNum:  iss,
+		AckNum:  c.IRS.Add(1),
+		RcvWnd:  rcvWnd,
+	})
+
+	// So is the ACK completing the handshake.
+	b2 := c.GetPacket()
+	defer b2.Release()
+	checker.IPv4(t, b2, checker.TCP(
+		checker.TCPFlags(header.TCPFlagAck),
+		checker.TCPSeqNum(uint32(c.IRS)+1),
+		checker.TCPAckNum(uint32(iss)+1),
+	))
+	checkSigned(t, b2, md5Key)
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the connection to be established")
+	}
+	if got := tcp.EndpointState(c.EP.State()); got != tcp.StateEstablished {
+		t.Fatalf("got c.EP.State() = %s, want = %s", got, tcp.StateEstablished)
+	}
+
+	// Segments that are not signed with the key are dropped silently.
+	data := []byte{1, 2, 3}
+	hdrs := context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  iss.Add(1),
+		AckNum:  c.IRS.Add(1),
+		RcvWnd:  rcvWnd,
+	}
+	md5Failures := c.Stack().Stats().TCP.MD5Failures
+	unsignedHdrs := hdrs
+	c.SendPacket(data, &unsignedHdrs)
+	c.CheckNoPacketTimeout("got a reply to an unsigned segment", 100*time.Millisecond)
+	if got := md5Failures.Value(); got != 1 {
+		t.Errorf("got stats.TCP.MD5Failures.Value() = %d, want = 1", got)
+	}
+	wrongHdrs := hdrs
+	sendSigned(c, wrongMD5Key, data, &wrongHdrs)
+	c.CheckNoPacketTimeout("got a reply to a segment with a bad signature", 100*time.Millisecond)
+	if got := md5Failures.Value(); got != 2 {
+		t.Errorf("got stats.TCP.MD5Failures.Value() = %d, want = 2", got)
+	}
+	if got := c.EP.(*tcp.Endpoint).RecvQueueLen(); got != 0 {
+		t.Errorf("got c.EP.RecvQueueLen() = %d, want = 0", got)
+	}
+
+	// A correctly signed segment is accepted and acknowledged with a signed
+	// ACK.
+	sendSigned(c, md5Key, data, &hdrs)
+	b3 := c.GetPacket()
+	defer b3.Release()
+	checker.IPv4(t, b3, checker.TCP(
+		checker.TCPFlags(header.TCPFlagAck),
+		checker.TCPAckNum(uint32(iss)+1+uint32(len(data))),
+	))
+	checkSigned(t, b3, md5Key)
+	var got bytes.Buffer
+	if _, err := c.EP.Read(&got, tcpip.ReadOptions{}); err != nil {
+		t.Fatalf("c.EP.Read(_, {}): %s", err)
+	}
+	if !bytes.Equal(got.Bytes(), data) {
+		t.Errorf("got c.EP.Read(_, {}) = %v, want = %v", got.Bytes(), data)
+	}
+
+	// Data sent on the connection is signed too.
+	var r bytes.Reader
+	r.Reset(data)
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	b4 := c.GetPacket()
+	defer b4.Release()
+	checker.IPv4(t, b4,
+		checker.PayloadLen(len(data)+header.TCPMinimumSize+len(md5Option())),
+		checker.TCP(
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+		),
+	)
+	checkSigned(t, b4, md5Key)
+}
+
+func TestMD5SignatureUnexpected(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	iss := seqnum.Value(context.TestInitialSequenceNumber)
+	c.CreateConnected(iss, 30000, -1 /* epRcvBuf */)
+
+	// Signed segments are dropped when no key is shared with the peer.
+	sendSigned(c, md5Key, []byte{1, 2, 3}, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  iss.Add(1),
+		AckNum:  c.IRS.Add(1),
+		RcvWnd:  30000,
+	})
+	c.CheckNoPacketTimeout("got a reply to a signed segment", 100*time.Millisecond)
+	if got := c.Stack().Stats().TCP.MD5Failures.Value(); got != 1 {
+		t.Errorf("got stats.TCP.MD5Failures.Value() = %d, want = 1", got)
+	}
+}
+
+func TestMD5SignaturePassiveOpen(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	wq := &waiter.Queue{}
+	ep, err := c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, wq)
+	if err != nil {
+		t.Fatalf("NewEndpoint failed: %s", err)
+	}
+	defer ep.Close()
+	setMD5Key(t, ep, md5Key)
+	if err := ep.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+		t.Fatalf("Bind failed: %s", err)
+	}
+	if err := ep.Listen(10); err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+
+	iss := seqnum.Value(context.TestInitialSequenceNumber)
+	syn := context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: context.StackPort,
+		Flags:   header.TCPFlagSyn,
+		SeqNum:  iss,
+		RcvWnd:  30000,
+	}
+
+	// An unsigned SYN from a peer with a key is dropped.
+	unsignedSyn := syn
+	c.SendPacket(nil, &unsignedSyn)
+	c.CheckNoPacketTimeout("got a reply to an unsigned SYN", 100*time.Millisecond)
+	if got := c.Stack().Stats().TCP.MD5Failures.Value(); got != 1 {
+		t.Errorf("got stats.TCP.MD5Failures.Value() = %d, want = 1", got)
+	}
+
+	sendSigned(c, md5Key, nil, &syn)
+	b := c.GetPacket()
+	defer b.Release()
+	checker.IPv4(t, b, checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPFlags(header.TCPFlagSyn|header.TCPFlagAck),
+		checker.TCPAckNum(uint32(iss)+1),
+	))
+	checkSigned(t, b, md5Key)
+	irs := seqnum.Value(header.TCP(header.IPv4(b.AsSlice()).Payload()).SequenceNumber())
+
+	we, ch := waiter.NewChannelEntry(waiter.ReadableEvents)
+	wq.EventRegister(&we)
+	defer wq.EventUnregister(&we)
+	sendSigned(c, md5Key, nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: context.StackPort,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  iss.Add(1),
+		AckNum:  irs.Add(1),
+		RcvWnd:  30000,
+	})
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the connection to be accepted")
+	}
+	n, _, err := ep.Accept(nil)
+	if err != nil {
+		t.Fatalf("Accept failed: %s", err)
+	}
+	defer n.Close()
+
+	// The accepted endpoint inherits the key for its peer.
+	data := []byte{1, 2, 3}
+	var r bytes.Reader
+	r.Reset(data)
+	if _, err := n.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	b2 := c.GetPacket()
+	defer b2.Release()
+	checker.IPv4(t, b2,
+		checker.PayloadLen(len(data)+header.TCPMinimumSize+len(md5Option())),
+		checker.TCP(
+			checker.TCPSeqNum(uint32(irs)+1),
+			checker.TCPAckNum(uint32(iss)+1),
+		),
+	)
+	checkSigned(t, b2, md5Key)
+}
+
+func TestMD5SignatureLargeWrite(t *testing.T) {
+	c := context.New(t, 1500)
+	defer c.Cleanup()
+
+	// The key is installed once segmentation offload is set up for the
+	// connection. The handshake is done by hand as the ACK completing it is
+	// offloaded and so carries a partial checksum.
+	c.SetGSOEnabled(true)
+	defer c.SetGSOEnabled(false)
+	c.Create(-1 /* epRcvBuf */)
+	we, ch := waiter.NewChannelEntry(waiter.WritableEvents)
+	c.WQ.EventRegister(&we)
+	defer c.WQ.EventUnregister(&we)
+	if err := c.EP.Connect(tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}); !cmp.Equal(&tcpip.ErrConnectStarted{}, err) {
+		t.Fatalf("got c.EP.Connect(...) = %v, want = %s", err, &tcpip.ErrConnectStarted{})
+	}
+	b := c.GetPacket()
+	defer b.Release()
+	tcpHdr := header.TCP(header.IPv4(b.AsSlice()).Payload())
+	c.IRS = seqnum.Value(tcpHdr.SequenceNumber())
+	c.Port = tcpHdr.SourcePort()
+	mss := c.MSSWithoutOptions()
+	iss := seqnum.Value(context.TestInitialSequenceNumber)
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagSyn | header.TCPFlagAck,
+		SeqNum:  iss,
+		AckNum:  c.IRS.Add(1),
+		RcvWnd:  60000,
+		TCPOpts: []byte{header.TCPOptionMSS, 4, byte(mss / 256), byte(mss % 256)},
+	})
+	c.GetPacket().Release()
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the connection to be established")
+	}
+	setMD5Key(t, c.EP, md5Key)
+
+	dataLen := 3 * int(mss)
+	data := make([]byte, dataLen)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	var r bytes.Reader
+	r.Reset(data)
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+
+	// Each segment is signed on its own and leaves room for the signature
+	// option.
+	maxPayload := int(mss) - len(md5Option())
+	for bytesReceived := 0; bytesReceived != dataLen; {
+		b := c.GetPacket()
+		defer b.Release()
+		wantLen := maxPayload
+		if remaining := dataLen - bytesReceived; remaining < wantLen {
+			wantLen = remaining
+		}
+		checker.IPv4(t, b,
+			checker.PayloadLen(header.TCPMinimumSize+len(md5Option())+wantLen),
+			checker.TCP(
+				checker.TCPSeqNum(uint32(c.IRS)+1+uint32(bytesReceived)),
+			),
+		)
+		checkSigned(t, b, md5Key)
+		bytesReceived += wantLen
+
+		sendSigned(c, md5Key, nil, &context.Headers{
+			SrcPort: context.TestPort,
+			DstPort: c.Port,
+			Flags:   header.TCPFlagAck,
+			SeqNum:  iss.Add(1),
+			AckNum:  c.IRS.Add(1 + seqnum.Size(bytesReceived)),
+			RcvWnd:  60000,
+		})
+	}
+}
+
+func TestMD5SignatureReset(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	ep, err := c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &waiter.Queue{})
+	if err != nil {
+		t.Fatalf("NewEndpoint failed: %s", err)
+	}
+	defer ep.Close()
+	setMD5Key(t, ep, md5Key)
+	if err := ep.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+		t.Fatalf("Bind failed: %s", err)
+	}
+	if err := ep.Listen(10); err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+
+	// A SYN-ACK sent to a listener is answered with a reset signed with the
+	// key of the peer.
+	ackNum := seqnum.Value(789)
+	sendSigned(c, md5Key, nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: context.StackPort,
+		Flags:   header.TCPFlagSyn | header.TCPFlagAck,
+		SeqNum:  seqnum.Value(context.TestInitialSequenceNumber),
+		AckNum:  ackNum,
+		RcvWnd:  30000,
+	})
+	b := c.GetPacket()
+	defer b.Release()
+	checker.IPv4(t, b, checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPFlags(header.TCPFlagRst),
+		checker.TCPSeqNum(uint32(ackNum)),
+	))
+	checkSigned(t, b, md5Key)
+}
+
+func TestMain(m *testing.M) {
+	refs.SetLeakMode(refs.LeaksPanic)
+	code := m.Run()
+	// Allow TCP async work to complete to avoid false reports of leaks.
+	// TODO(gvisor.dev/issue/5940): Use fake clock in tests.
+	time.Sleep(1 * time.Second)
+	refs.DoLeakCheck()
+	os.Exit(code)
+}
@@ -202,6 +202,68 @@ func TestFastRecovery(t *testing.T) {
 	}
 }
 
+// TestFastRetransmitDupAckThreshold tests that fast retransmit is triggered by
+// the third duplicate ACK and not by the first two.
+func TestFastRetransmitDupAckThreshold(t *testing.T) {
+	maxPayload := 32
+	c := context.New(t, uint32(header.TCPMinimumSize+header.IPv4MinimumSize+maxPayload))
+	defer c.Cleanup()
+
+	c.CreateConnected(789, 30000, -1 /* epRcvBuf */)
+
+	data := make([]byte, 2*maxPayload*tcp.InitialCwnd)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	var r bytes.Reader
+	r.Reset(data)
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+
+	// Receive the initial congestion window worth of packets.
+	for i := 0; i < tcp.InitialCwnd; i++ {
+		c.ReceiveAndCheckPacket(data, i*maxPayload, maxPayload)
+	}
+	c.CheckNoPacketTimeout("More packets received than expected for this cwnd.", 50*time.Millisecond)
+
+	// Acknowledge the first packet. In slow start this releases two more
+	// packets.
+	c.SendAck(790, maxPayload)
+	for i := tcp.InitialCwnd; i < tcp.InitialCwnd+2; i++ {
+		c.ReceiveAndCheckPacket(data, i*maxPayload, maxPayload)
+	}
+	c.CheckNoPacketTimeout("More packets received than expected for this cwnd.", 50*time.Millisecond)
+
+	// Send two duplicate ACKs as if the second packet was lost. Nothing
+	// should be retransmitted yet.
+	for i := 0; i < 2; i++ {
+		c.SendAck(790, maxPayload)
+	}
+	c.CheckNoPacketTimeout("Packet received before the third duplicate ACK.", 50*time.Millisecond)
+	if got := c.Stack().Stats().TCP.FastRetransmit.Value(); got != 0 {
+		t.Errorf("got stats.TCP.FastRetransmit.Value = %d, want = 0", got)
+	}
+
+	// The third duplicate ACK triggers the retransmission of the lost packet.
+	c.SendAck(790, maxPayload)
+	c.ReceiveAndCheckPacket(data, maxPayload, maxPayload)
+
+	metricPollFn := func() error {
+		if got, want := c.Stack().Stats().TCP.FastRetransmit.Value(), uint64(1); got != want {
+			return fmt.Errorf("got stats.TCP.FastRetransmit.Value = %d, want = %d", got, want)
+		}
+		if got, want := c.Stack().Stats().TCP.Timeouts.Value(), uint64(0); got != want {
+			return fmt.Errorf("got stats.TCP.Timeouts.Value = %d, want = %d", got, want)
+		}
+		return nil
+	}
+	if err := testutil.Poll(metricPollFn, 1*time.Second); err != nil {
+		t.Error(err)
+	}
+}
+
 func TestExponentialIncreaseDuringSlowStart(t *testing.T) {
 	maxPayload := 32
 	c := context.New(t, uint32(header.TCPMinimumSize+header.IPv4MinimumSize+maxPayload))
@@ -337,6 +399,75 @@ func TestCongestionAvoidance(t *testing.T) {
 	}
 }
 
+func TestInitialCongestionWindow(t *testing.T) {
+	const (
+		maxPayload  = 32
+		initialCwnd = 4
+	)
+	tests := []struct {
+		name            string
+		initialSsthresh int
+		// wantAfterAck is the number of packets sent once the initial window
+		// is acknowledged.
+		wantAfterAck int
+	}{
+		{
+			// Slow start doubles the window.
+			name:         "slow start",
+			wantAfterAck: 2 * initialCwnd,
+		},
+		{
+			// The window starts above ssthresh, so congestion avoidance only
+			// grows it by one.
+			name:            "congestion avoidance",
+			initialSsthresh: initialCwnd / 2,
+			wantAfterAck:    initialCwnd + 1,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := context.New(t, uint32(header.TCPMinimumSize+header.IPv4MinimumSize+maxPayload))
+			defer c.Cleanup()
+
+			iw := tcpip.TCPInitialCwndOption(initialCwnd)
+			if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &iw); err != nil {
+				t.Fatalf("SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, iw, iw, err)
+			}
+			ssthresh := tcpip.TCPInitialSsthreshOption(test.initialSsthresh)
+			if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &ssthresh); err != nil {
+				t.Fatalf("SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, ssthresh, ssthresh, err)
+			}
+
+			c.CreateConnected(789, 30000, -1 /* epRcvBuf */)
+
+			data := make([]byte, maxPayload*4*initialCwnd)
+			for i := range data {
+				data[i] = byte(i)
+			}
+			var r bytes.Reader
+			r.Reset(data)
+			if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+				t.Fatalf("Write failed: %s", err)
+			}
+
+			// Only the initial window is sent before the first ACK.
+			bytesRead := 0
+			for i := 0; i < initialCwnd; i++ {
+				c.ReceiveAndCheckPacket(data, bytesRead, maxPayload)
+				bytesRead += maxPayload
+			}
+			c.CheckNoPacketTimeout("More packets received than the initial cwnd.", 50*time.Millisecond)
+
+			c.SendAck(790, bytesRead)
+			for i := 0; i < test.wantAfterAck; i++ {
+				c.ReceiveAndCheckPacket(data, bytesRead, maxPayload)
+				bytesRead += maxPayload
+			}
+			c.CheckNoPacketTimeout("More packets received than expected for this cwnd.", 50*time.Millisecond)
+		})
+	}
+}
+
 // cubicCwnd returns an estimate of a cubic window given the
 // originalCwnd, wMax, last congestion event time and sRTT.
 func cubicCwnd(origCwnd int, wMax int, congEventTime time.Time, sRTT time.Duration) int {
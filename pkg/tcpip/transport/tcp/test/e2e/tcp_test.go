@@ -33,6 +33,7 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip/checker"
 	"gvisor.dev/gvisor/pkg/tcpip/faketime"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/link/channel"
 	"gvisor.dev/gvisor/pkg/tcpip/link/loopback"
 	"gvisor.dev/gvisor/pkg/tcpip/link/sniffer"
 	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
@@ -179,24 +180,18 @@ func TestConnectICMPError(t *testing.T) {
 	defer syn.Release()
 	checker.IPv4(t, syn, checker.TCP(checker.TCPFlags(header.TCPFlagSyn)))
 
-	wep := ep.(interface {
-		LastErrorLocked() tcpip.Error
-	})
-
 	c.SendICMPPacket(header.ICMPv4DstUnreachable, header.ICMPv4HostUnreachable, nil, syn, e2e.DefaultMTU)
 
-	for {
-		if err := wep.LastErrorLocked(); err != nil {
-			if d := cmp.Diff(&tcpip.ErrHostUnreachable{}, err); d != "" {
-				t.Errorf("ep.LastErrorLocked() mismatch (-want +got):\n%s", d)
-			}
-			break
-		}
-		time.Sleep(time.Millisecond)
-	}
-
 	<-notifyCh
 
+	if d := cmp.Diff(&tcpip.ErrHostUnreachable{}, ep.LastError()); d != "" {
+		t.Errorf("ep.LastError() mismatch (-want +got):\n%s", d)
+	}
+	// The error is reported only once.
+	if err := ep.LastError(); err != nil {
+		t.Errorf("got second ep.LastError() = %s, want = nil", err)
+	}
+
 	// The stack would have unregistered the endpoint because of the ICMP error.
 	// Expect a RST for any subsequent packets sent to the endpoint.
 	c.SendPacket(nil, &context.Headers{
@@ -609,6 +604,148 @@ func TestTCPResetsDoNotGenerateResets(t *testing.T) {
 	c.CheckNoPacketTimeout("got an unexpected packet", 100*time.Millisecond)
 }
 
+// TestRSTSequenceValidation checks that inbound RSTs are validated as
+// described in RFC 5961 section 3.2.
+func TestRSTSequenceValidation(t *testing.T) {
+	tests := []struct {
+		name string
+		// seqOffset is added to the next expected sequence number to get the
+		// sequence number of the RST.
+		seqOffset        seqnum.Size
+		wantReset        bool
+		wantChallengeAck bool
+	}{
+		{
+			name:      "exact",
+			seqOffset: 0,
+			wantReset: true,
+		},
+		{
+			name:             "in window",
+			seqOffset:        1,
+			wantChallengeAck: true,
+		},
+		{
+			name:      "out of window",
+			seqOffset: 1 << 30,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := context.New(t, e2e.DefaultMTU)
+			defer c.Cleanup()
+
+			stats := c.Stack().Stats()
+			wantEstablishedResets := stats.TCP.EstablishedResets.Value()
+			if test.wantReset {
+				wantEstablishedResets++
+			}
+			iss := seqnum.Value(context.TestInitialSequenceNumber)
+			rcvWnd := seqnum.Size(30000)
+			c.CreateConnected(iss, rcvWnd, -1 /* epRcvBuf */)
+
+			c.SendPacket(nil, &context.Headers{
+				SrcPort: context.TestPort,
+				DstPort: c.Port,
+				SeqNum:  iss.Add(1).Add(test.seqOffset),
+				AckNum:  c.IRS.Add(1),
+				RcvWnd:  rcvWnd,
+				Flags:   header.TCPFlagRst,
+			})
+
+			if test.wantChallengeAck {
+				b := c.GetPacket()
+				defer b.Release()
+				checker.IPv4(t, b, checker.TCP(
+					checker.DstPort(context.TestPort),
+					checker.TCPSeqNum(uint32(c.IRS)+1),
+					checker.TCPAckNum(uint32(iss)+1),
+					checker.TCPFlags(header.TCPFlagAck),
+				))
+			}
+			c.CheckNoPacketTimeout("got an unexpected packet", 100*time.Millisecond)
+
+			wantState := tcp.StateEstablished
+			if test.wantReset {
+				wantState = tcp.StateError
+			}
+			if got := tcp.EndpointState(c.EP.State()); got != wantState {
+				t.Errorf("got c.EP.State() = %s, want = %s", got, wantState)
+			}
+			if got := stats.TCP.EstablishedResets.Value(); got != wantEstablishedResets {
+				t.Errorf("got stats.TCP.EstablishedResets.Value() = %d, want = %d", got, wantEstablishedResets)
+			}
+		})
+	}
+}
+
+// TestSynOnEstablishedConnection checks that a SYN received on an established
+// connection is answered with a rate-limited challenge ACK, as described in
+// RFC 5961 section 4.2, and leaves the connection intact.
+func TestSynOnEstablishedConnection(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	// Make sure a second challenge ACK is never allowed during the test.
+	limit := stack.TCPInvalidRateLimitOption(time.Hour)
+	if err := c.Stack().SetOption(limit); err != nil {
+		t.Fatalf("c.Stack().SetOption(%#v): %s", limit, err)
+	}
+
+	iss := seqnum.Value(context.TestInitialSequenceNumber)
+	rcvWnd := seqnum.Size(30000)
+	c.CreateConnected(iss, rcvWnd, -1 /* epRcvBuf */)
+
+	sendSyn := func() {
+		t.Helper()
+		c.SendPacket(nil, &context.Headers{
+			SrcPort: context.TestPort,
+			DstPort: c.Port,
+			SeqNum:  iss.Add(1),
+			AckNum:  c.IRS.Add(1),
+			RcvWnd:  rcvWnd,
+			Flags:   header.TCPFlagSyn,
+		})
+	}
+
+	sendSyn()
+	b := c.GetPacket()
+	defer b.Release()
+	checker.IPv4(t, b, checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPSeqNum(uint32(c.IRS)+1),
+		checker.TCPAckNum(uint32(iss)+1),
+		checker.TCPFlags(header.TCPFlagAck),
+	))
+
+	// A second SYN within the rate limit is dropped without a reply.
+	sendSyn()
+	c.CheckNoPacketTimeout("got a challenge ACK within the rate limit", 100*time.Millisecond)
+
+	if got := c.EP.State(); got != uint32(tcp.StateEstablished) {
+		t.Fatalf("got c.EP.State() = %s, want = %s", tcp.EndpointState(got), tcp.StateEstablished)
+	}
+
+	// The connection still carries data.
+	data := []byte{1, 2, 3}
+	c.SendPacket(data, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		SeqNum:  iss.Add(1),
+		AckNum:  c.IRS.Add(1),
+		RcvWnd:  rcvWnd,
+		Flags:   header.TCPFlagAck,
+	})
+	b2 := c.GetPacket()
+	defer b2.Release()
+	checker.IPv4(t, b2, checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPSeqNum(uint32(c.IRS)+1),
+		checker.TCPAckNum(uint32(iss)+1+uint32(len(data))),
+		checker.TCPFlags(header.TCPFlagAck),
+	))
+}
+
 func TestActiveHandshake(t *testing.T) {
 	c := context.New(t, e2e.DefaultMTU)
 	defer c.Cleanup()
@@ -632,6 +769,163 @@ func TestNonBlockingClose(t *testing.T) {
 	}
 }
 
+func TestAbortEstablished(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	iss := seqnum.Value(context.TestInitialSequenceNumber)
+	c.CreateConnected(iss, 30000 /* rcvWnd */, -1 /* epRcvBuf */)
+
+	// Leave both unacknowledged and unread data behind.
+	data := []byte{1, 2, 3}
+	var r bytes.Reader
+	r.Reset(data)
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("c.EP.Write(_, _): %s", err)
+	}
+	b := c.GetPacket()
+	b.Release()
+	c.SendPacket(data, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  iss.Add(1),
+		AckNum:  c.IRS.Add(1),
+		RcvWnd:  30000,
+	})
+	b = c.GetPacket()
+	b.Release()
+
+	c.EP.Abort()
+
+	// The RST follows the data that was already sent and acknowledges the
+	// data that was received.
+	b = c.GetPacket()
+	defer b.Release()
+	checker.IPv4(t, b, checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPSeqNum(uint32(c.IRS)+1+uint32(len(data))),
+		checker.TCPAckNum(uint32(iss)+1+uint32(len(data))),
+		checker.TCPFlags(header.TCPFlagAck|header.TCPFlagRst),
+	))
+
+	if got := c.EP.State(); got != uint32(tcp.StateError) {
+		t.Errorf("got c.EP.State() = %s, want = %s", tcp.EndpointState(got), tcp.StateError)
+	}
+	ept := endpointTester{c.EP}
+	ept.CheckReadError(t, &tcpip.ErrAborted{})
+	r.Reset(data)
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); !cmp.Equal(&tcpip.ErrClosedForSend{}, err) {
+		t.Errorf("got c.EP.Write(_, _) = %v, want = %s", err, &tcpip.ErrClosedForSend{})
+	}
+
+	// The local port is released.
+	wq := &waiter.Queue{}
+	ep, err := c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, wq)
+	if err != nil {
+		t.Fatalf("NewEndpoint failed: %s", err)
+	}
+	defer ep.Close()
+	if err := ep.Bind(tcpip.FullAddress{Port: c.Port}); err != nil {
+		t.Errorf("ep.Bind(%d) after abort: %s", c.Port, err)
+	}
+
+	// Aborting again is a no-op.
+	c.EP.Abort()
+	c.CheckNoPacket("got an unexpected packet after a second abort")
+}
+
+func TestAbortUnconnected(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	c.Create(-1 /* epRcvBuf */)
+	if err := c.EP.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+		t.Fatalf("c.EP.Bind(%d): %s", context.StackPort, err)
+	}
+	if err := c.EP.Listen(10); err != nil {
+		t.Fatalf("c.EP.Listen(10): %s", err)
+	}
+
+	c.EP.Abort()
+	if got := c.EP.State(); got != uint32(tcp.StateClose) {
+		t.Errorf("got c.EP.State() = %s, want = %s", tcp.EndpointState(got), tcp.StateClose)
+	}
+	c.CheckNoPacket("got an unexpected packet after aborting a listener")
+}
+
+func TestNICDisableEnable(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	iss := seqnum.Value(context.TestInitialSequenceNumber)
+	c.CreateConnected(iss, 30000 /* rcvWnd */, -1 /* epRcvBuf */)
+
+	const nicID = 1
+	if err := c.Stack().DisableNIC(nicID); err != nil {
+		t.Fatalf("c.Stack().DisableNIC(%d): %s", nicID, err)
+	}
+
+	// Inbound segments are dropped while the NIC is disabled.
+	stats := c.Stack().Stats()
+	disabledRx := stats.NICs.DisabledRx.Packets.Value()
+	data := []byte{1, 2, 3}
+	inHdrs := &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  iss.Add(1),
+		AckNum:  c.IRS.Add(1),
+		RcvWnd:  30000,
+	}
+	c.SendPacket(data, inHdrs)
+	if got, want := stats.NICs.DisabledRx.Packets.Value(), disabledRx+1; got != want {
+		t.Errorf("got stats.NICs.DisabledRx.Packets.Value() = %d, want = %d", got, want)
+	}
+	ept := endpointTester{c.EP}
+	ept.CheckReadError(t, &tcpip.ErrWouldBlock{})
+
+	// Writes are queued but nothing is sent.
+	var r bytes.Reader
+	r.Reset(data)
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("c.EP.Write(_, _): %s", err)
+	}
+	c.CheckNoPacketTimeout("got a packet while the NIC is disabled", 100*time.Millisecond)
+
+	// The connection survives and traffic resumes once the NIC is enabled
+	// again: the unsent data is retransmitted and inbound segments are
+	// accepted.
+	if err := c.Stack().EnableNIC(nicID); err != nil {
+		t.Fatalf("c.Stack().EnableNIC(%d): %s", nicID, err)
+	}
+	b := c.GetPacket()
+	defer b.Release()
+	checker.IPv4(t, b,
+		checker.PayloadLen(len(data)+header.TCPMinimumSize),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+			checker.TCPAckNum(uint32(iss)+1),
+			checker.TCPFlagsMatch(header.TCPFlagAck, ^header.TCPFlagPsh),
+		),
+	)
+	c.SendPacket(data, inHdrs)
+	b2 := c.GetPacket()
+	defer b2.Release()
+	checker.IPv4(t, b2, checker.TCP(
+		checker.TCPSeqNum(uint32(c.IRS)+1+uint32(len(data))),
+		checker.TCPAckNum(uint32(iss)+1+uint32(len(data))),
+		checker.TCPFlags(header.TCPFlagAck),
+	))
+	if got := ept.CheckRead(t); !bytes.Equal(got, data) {
+		t.Errorf("got ept.CheckRead(_) = %v, want = %v", got, data)
+	}
+	if got := c.EP.State(); got != uint32(tcp.StateEstablished) {
+		t.Errorf("got c.EP.State() = %s, want = %s", tcp.EndpointState(got), tcp.StateEstablished)
+	}
+}
+
 func TestConnectResetAfterClose(t *testing.T) {
 	c := context.New(t, e2e.DefaultMTU)
 	defer c.Cleanup()
@@ -934,41 +1228,316 @@ func TestSimpleReceive(t *testing.T) {
 
 	ept := endpointTester{c.EP}
 
-	data := []byte{1, 2, 3}
-	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
-	c.SendPacket(data, &context.Headers{
+	data := []byte{1, 2, 3}
+	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	c.SendPacket(data, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  iss,
+		AckNum:  c.IRS.Add(1),
+		RcvWnd:  30000,
+	})
+
+	// Wait for receive to be notified.
+	select {
+	case <-ch:
+	case <-time.After(1 * time.Second):
+		t.Fatalf("Timed out waiting for data to arrive")
+	}
+
+	// Receive data.
+	v := ept.CheckRead(t)
+	if !bytes.Equal(data, v) {
+		t.Fatalf("got data = %v, want = %v", v, data)
+	}
+
+	// Check that ACK is received.
+	b := c.GetPacket()
+	defer b.Release()
+	checker.IPv4(t, b,
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+			checker.TCPAckNum(uint32(iss)+uint32(len(data))),
+			checker.TCPFlags(header.TCPFlagAck),
+		),
+	)
+}
+
+// TestDelayedAck tests that in-order data is acknowledged after the delayed
+// ACK timeout unless a second full-sized segment arrives, TCP_QUICKACK is set,
+// data arrives out of order or the receive window is nearly used up.
+func TestDelayedAck(t *testing.T) {
+	const (
+		mtu        = 1500
+		mss        = mtu - header.IPv4MinimumSize - header.TCPMinimumSize
+		ackTimeout = 40 * time.Millisecond
+	)
+
+	tests := []struct {
+		name     string
+		quickAck bool
+		// rcvBuf is the receive buffer size of the endpoint, if non-zero.
+		rcvBuf int
+		sizes  []int
+		// gap is the number of bytes skipped before the first segment.
+		gap       int
+		wantDelay bool
+		wantAck   int
+	}{
+		{
+			name:      "SmallSegments",
+			sizes:     []int{100, 100},
+			wantDelay: true,
+			wantAck:   200,
+		},
+		{
+			name:    "TwoFullSizedSegments",
+			sizes:   []int{mss, mss},
+			wantAck: 2 * mss,
+		},
+		{
+			// Segments are full-sized if they are as large as the ones the
+			// peer sends, even if they are smaller than the advertised MSS.
+			// The receive buffer keeps the window from growing.
+			name:    "TwoPeerSizedSegments",
+			rcvBuf:  8192,
+			sizes:   []int{1000, 1000},
+			wantAck: 2000,
+		},
+		{
+			// Less than a segment is left in the 4096 byte window after
+			// the second segment.
+			name:    "WindowNearlyFull",
+			rcvBuf:  4096,
+			sizes:   []int{mss, 1400},
+			wantAck: mss + 1400,
+		},
+		{
+			name:     "QuickAck",
+			quickAck: true,
+			sizes:    []int{100},
+			wantAck:  100,
+		},
+		{
+			name:    "OutOfOrder",
+			sizes:   []int{100},
+			gap:     100,
+			wantAck: 0,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clock := faketime.NewManualClock()
+			c := context.NewWithOpts(t, context.Options{
+				EnableV4: true,
+				EnableV6: true,
+				MTU:      mtu,
+				Clock:    clock,
+			})
+			defer c.Cleanup()
+
+			opt := tcpip.TCPDelayedAckTimeoutOption(ackTimeout)
+			if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+				t.Fatalf("SetTransportProtocolOption(%d, &%T(%s)): %s", tcp.ProtocolNumber, opt, time.Duration(opt), err)
+			}
+
+			rcvBuf := -1
+			if test.rcvBuf != 0 {
+				rcvBuf = test.rcvBuf
+			}
+			c.CreateConnected(context.TestInitialSequenceNumber, 30000 /* rcvWnd */, rcvBuf)
+			if got := c.EP.SocketOptions().GetQuickAck(); got {
+				t.Fatalf("got GetQuickAck() = %t, want = false", got)
+			}
+			c.EP.SocketOptions().SetQuickAck(test.quickAck)
+
+			iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+			seq := iss.Add(seqnum.Size(test.gap))
+			for _, size := range test.sizes {
+				c.SendPacket(make([]byte, size), &context.Headers{
+					SrcPort: context.TestPort,
+					DstPort: c.Port,
+					Flags:   header.TCPFlagAck,
+					SeqNum:  seq,
+					AckNum:  c.IRS.Add(1),
+					RcvWnd:  30000,
+				})
+				seq = seq.Add(seqnum.Size(size))
+			}
+
+			if test.wantDelay {
+				c.CheckNoPacketTimeout("unexpected ACK before the delayed ACK timeout", 50*time.Millisecond)
+				clock.Advance(ackTimeout - time.Nanosecond)
+				c.CheckNoPacketTimeout("unexpected ACK before the delayed ACK timeout", 50*time.Millisecond)
+				clock.Advance(time.Nanosecond)
+			}
+
+			b := c.GetPacket()
+			defer b.Release()
+			checker.IPv4(t, b,
+				checker.TCP(
+					checker.DstPort(context.TestPort),
+					checker.TCPSeqNum(uint32(c.IRS)+1),
+					checker.TCPAckNum(uint32(iss)+uint32(test.wantAck)),
+					checker.TCPFlags(header.TCPFlagAck),
+				),
+			)
+			c.CheckNoPacketTimeout("more than one ACK was sent", 50*time.Millisecond)
+		})
+	}
+}
+
+func TestDelayedAckTimeoutOption(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	for _, v := range []time.Duration{-1, tcp.MaxDelayedAckTimeout + 1} {
+		opt := tcpip.TCPDelayedAckTimeoutOption(v)
+		if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); !cmp.Equal(&tcpip.ErrInvalidOptionValue{}, err) {
+			t.Errorf("got SetTransportProtocolOption(%d, &%T(%s)) = %s, want = %s", tcp.ProtocolNumber, opt, v, err, &tcpip.ErrInvalidOptionValue{})
+		}
+	}
+
+	want := tcpip.TCPDelayedAckTimeoutOption(tcp.MaxDelayedAckTimeout)
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &want); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%s)): %s", tcp.ProtocolNumber, want, time.Duration(want), err)
+	}
+	var got tcpip.TCPDelayedAckTimeoutOption
+	if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &got); err != nil {
+		t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, got, err)
+	}
+	if got != want {
+		t.Errorf("got TransportProtocolOption(%d, &%T) = %s, want = %s", tcp.ProtocolNumber, got, time.Duration(got), time.Duration(want))
+	}
+}
+
+func TestInitialCongestionWindowOptions(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	var iw tcpip.TCPInitialCwndOption
+	if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &iw); err != nil {
+		t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, iw, err)
+	}
+	if iw != tcp.InitialCwnd {
+		t.Errorf("got default TransportProtocolOption(%d, &%T) = %d, want = %d", tcp.ProtocolNumber, iw, iw, tcp.InitialCwnd)
+	}
+
+	for _, v := range []int{-1, 0} {
+		opt := tcpip.TCPInitialCwndOption(v)
+		if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); !cmp.Equal(&tcpip.ErrInvalidOptionValue{}, err) {
+			t.Errorf("got SetTransportProtocolOption(%d, &%T(%d)) = %s, want = %s", tcp.ProtocolNumber, opt, v, err, &tcpip.ErrInvalidOptionValue{})
+		}
+	}
+	ssthresh := tcpip.TCPInitialSsthreshOption(-1)
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &ssthresh); !cmp.Equal(&tcpip.ErrInvalidOptionValue{}, err) {
+		t.Errorf("got SetTransportProtocolOption(%d, &%T(%d)) = %s, want = %s", tcp.ProtocolNumber, ssthresh, ssthresh, err, &tcpip.ErrInvalidOptionValue{})
+	}
+
+	wantIW := tcpip.TCPInitialCwndOption(4)
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &wantIW); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, wantIW, wantIW, err)
+	}
+	if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &iw); err != nil {
+		t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, iw, err)
+	}
+	if iw != wantIW {
+		t.Errorf("got TransportProtocolOption(%d, &%T) = %d, want = %d", tcp.ProtocolNumber, iw, iw, wantIW)
+	}
+
+	wantSsthresh := tcpip.TCPInitialSsthreshOption(8)
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &wantSsthresh); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, wantSsthresh, wantSsthresh, err)
+	}
+	if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &ssthresh); err != nil {
+		t.Fatalf("TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, ssthresh, err)
+	}
+	if ssthresh != wantSsthresh {
+		t.Errorf("got TransportProtocolOption(%d, &%T) = %d, want = %d", tcp.ProtocolNumber, ssthresh, ssthresh, wantSsthresh)
+	}
+}
+
+func TestStackReceiveMemoryLimit(t *testing.T) {
+	const (
+		limit       = 20000
+		payloadSize = 1000
+		// A lone endpoint is throttled once the stack is under memory
+		// pressure, at three quarters of the limit.
+		pressure = limit - limit/4
+	)
+	c := context.NewWithOpts(t, context.Options{
+		EnableV4:           true,
+		EnableV6:           true,
+		MTU:                e2e.DefaultMTU,
+		ReceiveMemoryLimit: limit,
+	})
+	defer c.Cleanup()
+
+	// The endpoint's own receive buffer is far larger than the stack-wide
+	// limit, so only the latter constrains the advertised window.
+	c.CreateConnected(context.TestInitialSequenceNumber, 30000 /* rcvWnd */, -1 /* epRcvBuf */)
+
+	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	seq := iss
+	sent := 0
+	for c.Stack().ReceiveMemoryUsed() < pressure {
+		if sent >= pressure {
+			t.Fatalf("got ReceiveMemoryUsed() = %d after receiving %d bytes, want >= %d", c.Stack().ReceiveMemoryUsed(), sent, pressure)
+		}
+		c.SendPacket(make([]byte, payloadSize), &context.Headers{
+			SrcPort: context.TestPort,
+			DstPort: c.Port,
+			Flags:   header.TCPFlagAck,
+			SeqNum:  seq,
+			AckNum:  c.IRS.Add(1),
+			RcvWnd:  30000,
+		})
+		seq = seq.Add(payloadSize)
+		sent += payloadSize
+
+		b := c.GetPacket()
+		checker.IPv4(t, b,
+			checker.TCP(
+				checker.DstPort(context.TestPort),
+				checker.TCPSeqNum(uint32(c.IRS)+1),
+				checker.TCPAckNum(uint32(seq)),
+				checker.TCPFlags(header.TCPFlagAck),
+				checker.TCPWindowLessThanEq(uint16(pressure-sent)),
+			),
+		)
+		b.Release()
+	}
+
+	// Once the endpoint has used up its share of receive memory, data
+	// segments are dropped even though they are within the advertised
+	// window.
+	c.SendPacket(make([]byte, 1), &context.Headers{
 		SrcPort: context.TestPort,
 		DstPort: c.Port,
 		Flags:   header.TCPFlagAck,
-		SeqNum:  iss,
+		SeqNum:  seq,
 		AckNum:  c.IRS.Add(1),
 		RcvWnd:  30000,
 	})
-
-	// Wait for receive to be notified.
-	select {
-	case <-ch:
-	case <-time.After(1 * time.Second):
-		t.Fatalf("Timed out waiting for data to arrive")
+	c.CheckNoPacketTimeout("unexpected ACK for a segment received while out of memory", 50*time.Millisecond)
+	if got := c.EP.Stats().(*tcp.Stats).ReceiveErrors.SegmentQueueDropped.Value(); got != 1 {
+		t.Errorf("got EP stats ReceiveErrors.SegmentQueueDropped = %d, want = 1", got)
 	}
 
-	// Receive data.
-	v := ept.CheckRead(t)
-	if !bytes.Equal(data, v) {
-		t.Fatalf("got data = %v, want = %v", v, data)
+	// Reading the data releases the memory back to the stack.
+	var buf bytes.Buffer
+	if _, err := c.EP.Read(&buf, tcpip.ReadOptions{}); err != nil {
+		t.Fatalf("c.EP.Read(_, {}): %s", err)
+	}
+	if got := buf.Len(); got != sent {
+		t.Errorf("got buf.Len() = %d, want = %d", got, sent)
+	}
+	if got := c.Stack().ReceiveMemoryUsed(); got != 0 {
+		t.Errorf("got ReceiveMemoryUsed() = %d after reading, want = 0", got)
 	}
-
-	// Check that ACK is received.
-	b := c.GetPacket()
-	defer b.Release()
-	checker.IPv4(t, b,
-		checker.TCP(
-			checker.DstPort(context.TestPort),
-			checker.TCPSeqNum(uint32(c.IRS)+1),
-			checker.TCPAckNum(uint32(iss)+uint32(len(data))),
-			checker.TCPFlags(header.TCPFlagAck),
-		),
-	)
 }
 
 // TestUserSuppliedMSSOnConnect tests that the user supplied MSS is used when
@@ -1699,6 +2268,85 @@ func TestListenCloseWhileConnect(t *testing.T) {
 	))
 }
 
+func TestListenCloseResetsQueuedConnections(t *testing.T) {
+	const numConns = 3
+
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	c.Create(-1 /* epRcvBuf */)
+
+	if err := c.EP.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+		t.Fatal("Bind failed:", err)
+	}
+
+	if err := c.EP.Listen(10 /* backlog */); err != nil {
+		t.Fatal("Listen failed:", err)
+	}
+
+	for i := 0; i < numConns; i++ {
+		executeHandshake(t, c, context.TestPort+uint16(i), false /* synCookiesInUse */)
+	}
+	// Wait for the connections to be delivered to the listening endpoint's
+	// accept queue.
+	established := c.Stack().Stats().TCP.CurrentEstablished
+	for start := time.Now(); established.Value() != numConns; {
+		if time.Since(start) > time.Minute {
+			t.Fatalf("got CurrentEstablished = %d, want = %d", established.Value(), numConns)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Close the listening endpoint without accepting the connections.
+	c.EP.Close()
+
+	// Expect each queued connection to be reset.
+	reset := make(map[uint16]struct{})
+	for i := 0; i < numConns; i++ {
+		v := c.GetPacket()
+		defer v.Release()
+		checker.IPv4(t, v, checker.TCP(
+			checker.SrcPort(context.StackPort),
+			checker.TCPFlags(header.TCPFlagAck|header.TCPFlagRst),
+		))
+		reset[header.TCP(header.IPv4(v.AsSlice()).Payload()).DestinationPort()] = struct{}{}
+	}
+	for i := 0; i < numConns; i++ {
+		if _, ok := reset[context.TestPort+uint16(i)]; !ok {
+			t.Errorf("connection from port %d was not reset", context.TestPort+uint16(i))
+		}
+	}
+
+	if _, _, err := c.EP.Accept(nil); err == nil {
+		t.Error("got c.EP.Accept(nil) = nil, want non-nil error")
+	}
+
+	// New connection attempts are refused.
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort + numConns,
+		DstPort: context.StackPort,
+		Flags:   header.TCPFlagSyn,
+		SeqNum:  context.TestInitialSequenceNumber,
+		RcvWnd:  30000,
+	})
+	v := c.GetPacket()
+	defer v.Release()
+	checker.IPv4(t, v, checker.TCP(
+		checker.DstPort(context.TestPort+numConns),
+		checker.TCPFlags(header.TCPFlagAck|header.TCPFlagRst),
+	))
+
+	// The listening port was released.
+	ep, err := c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &waiter.Queue{})
+	if err != nil {
+		t.Fatalf("NewEndpoint failed: %s", err)
+	}
+	defer ep.Close()
+	if err := ep.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+		t.Fatalf("Bind(%d) after closing the listener: %s", context.StackPort, err)
+	}
+}
+
 func TestTOSV4(t *testing.T) {
 	c := context.New(t, e2e.DefaultMTU)
 	defer c.Cleanup()
@@ -1993,29 +2641,332 @@ func TestSynSent(t *testing.T) {
 				c.EP.Close()
 			}
 
-			// Wait for receive to be notified.
-			select {
-			case <-ch:
-			case <-time.After(3 * time.Second):
-				t.Fatal("timed out waiting for packet to arrive")
-			}
+			// Wait for receive to be notified.
+			select {
+			case <-ch:
+			case <-time.After(3 * time.Second):
+				t.Fatal("timed out waiting for packet to arrive")
+			}
+
+			ept := endpointTester{c.EP}
+			if test.reset {
+				ept.CheckReadError(t, &tcpip.ErrConnectionRefused{})
+			} else {
+				ept.CheckReadError(t, &tcpip.ErrAborted{})
+			}
+
+			if got := c.Stack().Stats().TCP.CurrentConnected.Value(); got != 0 {
+				t.Errorf("got stats.TCP.CurrentConnected.Value() = %d, want = 0", got)
+			}
+
+			// Due to the RST the endpoint should be in an error state.
+			if got, want := tcp.EndpointState(c.EP.State()), tcp.StateError; got != want {
+				t.Fatalf("got State() = %s, want %s", got, want)
+			}
+		})
+	}
+}
+
+func TestConnectRefusedLastError(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	c.Create(-1 /* epRcvBuf */)
+
+	waitEntry, ch := waiter.NewChannelEntry(waiter.EventHUp)
+	c.WQ.EventRegister(&waitEntry)
+	defer c.WQ.EventUnregister(&waitEntry)
+
+	addr := tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}
+	err := c.EP.Connect(addr)
+	if d := cmp.Diff(&tcpip.ErrConnectStarted{}, err); d != "" {
+		t.Fatalf("Connect(...) mismatch (-want +got):\n%s", d)
+	}
+
+	// Receive SYN packet and refuse the connection.
+	v := c.GetPacket()
+	defer v.Release()
+	checker.IPv4(t, v,
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPFlags(header.TCPFlagSyn),
+		),
+	)
+	tcpHdr := header.TCP(header.IPv4(v.AsSlice()).Payload())
+	c.IRS = seqnum.Value(tcpHdr.SequenceNumber())
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: tcpHdr.DestinationPort(),
+		DstPort: tcpHdr.SourcePort(),
+		Flags:   header.TCPFlagRst | header.TCPFlagAck,
+		SeqNum:  seqnum.Value(context.TestInitialSequenceNumber),
+		AckNum:  c.IRS.Add(1),
+	})
+
+	select {
+	case <-ch:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for the connection to be refused")
+	}
+
+	if d := cmp.Diff(&tcpip.ErrConnectionRefused{}, c.EP.LastError()); d != "" {
+		t.Fatalf("c.EP.LastError() mismatch (-want +got):\n%s", d)
+	}
+	// Reading the error clears it.
+	if err := c.EP.LastError(); err != nil {
+		t.Fatalf("got second c.EP.LastError() = %s, want = nil", err)
+	}
+}
+
+// TestRemoveAddressWithActiveConnection tests that removing the local address
+// of an established connection stops its traffic without tearing down the
+// endpoint, and that the address can no longer be bound.
+func TestRemoveAddressWithActiveConnection(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	c.CreateConnected(context.TestInitialSequenceNumber, 30000, -1 /* epRcvBuf */)
+
+	if err := c.Stack().RemoveAddress(1, context.StackAddr); err != nil {
+		t.Fatalf("RemoveAddress(1, %s): %s", context.StackAddr, err)
+	}
+
+	// Data is still accepted for sending, but it can't leave the stack
+	// through the endpoint's route.
+	var r bytes.Reader
+	r.Reset([]byte{1, 2, 3})
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	c.CheckNoPacketTimeout("packet sent after the local address was removed", 100*time.Millisecond)
+
+	// Segments addressed to the removed address are not delivered.
+	c.SendPacket([]byte{4, 5, 6}, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  seqnum.Value(context.TestInitialSequenceNumber).Add(1),
+		AckNum:  c.IRS.Add(1),
+		RcvWnd:  30000,
+	})
+	ept := endpointTester{c.EP}
+	ept.CheckReadError(t, &tcpip.ErrWouldBlock{})
+	if got, want := tcp.EndpointState(c.EP.State()), tcp.StateEstablished; got != want {
+		t.Errorf("got c.EP.State() = %s, want = %s", got, want)
+	}
+
+	ep, err := c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &waiter.Queue{})
+	if err != nil {
+		t.Fatalf("NewEndpoint failed: %s", err)
+	}
+	defer ep.Close()
+	if d := cmp.Diff(&tcpip.ErrBadLocalAddress{}, ep.Bind(tcpip.FullAddress{Addr: context.StackAddr})); d != "" {
+		t.Errorf("ep.Bind(...) mismatch (-want +got):\n%s", d)
+	}
+}
+
+func TestSimultaneousOpen(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	c.Create(-1 /* epRcvBuf */)
+
+	waitEntry, ch := waiter.NewChannelEntry(waiter.WritableEvents)
+	c.WQ.EventRegister(&waitEntry)
+	defer c.WQ.EventUnregister(&waitEntry)
+
+	addr := tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}
+	err := c.EP.Connect(addr)
+	if d := cmp.Diff(&tcpip.ErrConnectStarted{}, err); d != "" {
+		t.Fatalf("Connect(...) mismatch (-want +got):\n%s", d)
+	}
+
+	// Receive SYN packet.
+	v := c.GetPacket()
+	checker.IPv4(t, v,
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPFlags(header.TCPFlagSyn),
+		),
+	)
+	tcpHdr := header.TCP(header.IPv4(v.AsSlice()).Payload())
+	c.IRS = seqnum.Value(tcpHdr.SequenceNumber())
+	c.Port = tcpHdr.SourcePort()
+	v.Release()
+
+	// Send our own SYN as if both sides connected at the same time.
+	iss := seqnum.Value(context.TestInitialSequenceNumber)
+	const rcvWnd = 30000
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagSyn,
+		SeqNum:  iss,
+		RcvWnd:  rcvWnd,
+	})
+
+	// The endpoint must acknowledge our SYN and resend its own with the same
+	// sequence number.
+	v = c.GetPacket()
+	checker.IPv4(t, v,
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPFlags(header.TCPFlagSyn|header.TCPFlagAck),
+			checker.TCPSeqNum(uint32(c.IRS)),
+			checker.TCPAckNum(uint32(iss)+1),
+		),
+	)
+	v.Release()
+
+	if got, want := tcp.EndpointState(c.EP.State()), tcp.StateSynRecv; got != want {
+		t.Fatalf("got State() = %s, want %s", got, want)
+	}
+
+	// Acknowledge the endpoint's SYN, completing the handshake.
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagSyn | header.TCPFlagAck,
+		SeqNum:  iss,
+		AckNum:  c.IRS.Add(1),
+		RcvWnd:  rcvWnd,
+	})
+
+	select {
+	case <-ch:
+	case <-time.After(3 * time.Second):
+		t.Fatal("timed out waiting for connection to be established")
+	}
+	if got, want := tcp.EndpointState(c.EP.State()), tcp.StateEstablished; got != want {
+		t.Fatalf("got State() = %s, want %s", got, want)
+	}
+
+	// Check that data flows with the negotiated sequence numbers.
+	data := []byte{1, 2, 3}
+	var r bytes.Reader
+	r.Reset(data)
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	v = c.GetPacket()
+	defer v.Release()
+	checker.IPv4(t, v,
+		checker.PayloadLen(len(data)+header.TCPMinimumSize),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+			checker.TCPAckNum(uint32(iss)+1),
+		),
+	)
+}
+
+// TestSimultaneousOpenTwoEndpoints connects two netstack endpoints to each
+// other at the same time, with both SYNs in flight before either is received.
+func TestSimultaneousOpenTwoEndpoints(t *testing.T) {
+	const nicID = 1
+
+	type host struct {
+		s    *stack.Stack
+		link *channel.Endpoint
+		addr tcpip.FullAddress
+		ep   tcpip.Endpoint
+	}
+	hosts := []*host{
+		{addr: tcpip.FullAddress{Addr: tcpip.AddrFrom4([4]byte{10, 0, 0, 1}), Port: 1000}},
+		{addr: tcpip.FullAddress{Addr: tcpip.AddrFrom4([4]byte{10, 0, 0, 2}), Port: 2000}},
+	}
+	for _, h := range hosts {
+		h.s = stack.New(stack.Options{
+			TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol},
+			NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		})
+		defer h.s.Destroy()
+		h.link = channel.New(10, e2e.DefaultMTU, "")
+		defer h.link.Close()
+		if err := h.s.CreateNIC(nicID, h.link); err != nil {
+			t.Fatalf("CreateNIC(%d, _): %s", nicID, err)
+		}
+		protocolAddr := tcpip.ProtocolAddress{
+			Protocol:          ipv4.ProtocolNumber,
+			AddressWithPrefix: h.addr.Addr.WithPrefix(),
+		}
+		if err := h.s.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+			t.Fatalf("AddProtocolAddress(%d, %+v, {}): %s", nicID, protocolAddr, err)
+		}
+		h.s.SetRouteTable([]tcpip.Route{
+			{Destination: header.IPv4EmptySubnet, NIC: nicID},
+		})
+
+		var wq waiter.Queue
+		ep, err := h.s.NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &wq)
+		if err != nil {
+			t.Fatalf("NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, _): %s", err)
+		}
+		defer ep.Close()
+		if err := ep.Bind(h.addr); err != nil {
+			t.Fatalf("Bind(%+v): %s", h.addr, err)
+		}
+		h.ep = ep
+	}
 
-			ept := endpointTester{c.EP}
-			if test.reset {
-				ept.CheckReadError(t, &tcpip.ErrConnectionRefused{})
-			} else {
-				ept.CheckReadError(t, &tcpip.ErrAborted{})
-			}
+	// Start both connections before delivering anything, so that each
+	// endpoint receives the other's SYN while in SYN-SENT.
+	for i, h := range hosts {
+		peer := hosts[1-i]
+		if err := h.ep.Connect(peer.addr); !cmp.Equal(err, &tcpip.ErrConnectStarted{}) {
+			t.Fatalf("Connect(%+v) = %v, want = %s", peer.addr, err, &tcpip.ErrConnectStarted{})
+		}
+	}
+	for _, h := range hosts {
+		if got, want := tcp.EndpointState(h.ep.State()), tcp.StateSynSent; got != want {
+			t.Fatalf("got State() = %s, want %s", got, want)
+		}
+	}
 
-			if got := c.Stack().Stats().TCP.CurrentConnected.Value(); got != 0 {
-				t.Errorf("got stats.TCP.CurrentConnected.Value() = %d, want = 0", got)
+	// Forward packets between the two links until both endpoints are
+	// established.
+	established := func() bool {
+		for _, h := range hosts {
+			if tcp.EndpointState(h.ep.State()) != tcp.StateEstablished {
+				return false
 			}
-
-			// Due to the RST the endpoint should be in an error state.
-			if got, want := tcp.EndpointState(c.EP.State()), tcp.StateError; got != want {
-				t.Fatalf("got State() = %s, want %s", got, want)
+		}
+		return true
+	}
+	for deadline := time.Now().Add(5 * time.Second); !established(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("timed out waiting for both endpoints to be established, got states = %s, %s", tcp.EndpointState(hosts[0].ep.State()), tcp.EndpointState(hosts[1].ep.State()))
+		}
+		forwarded := false
+		for i, h := range hosts {
+			peer := hosts[1-i]
+			for {
+				pkt := h.link.Read()
+				if pkt == nil {
+					break
+				}
+				forwarded = true
+				inbound := stack.NewPacketBuffer(stack.PacketBufferOptions{
+					Payload: pkt.ToBuffer(),
+				})
+				pkt.DecRef()
+				peer.link.InjectInbound(ipv4.ProtocolNumber, inbound)
+				inbound.DecRef()
 			}
-		})
+		}
+		if !forwarded {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	for i, h := range hosts {
+		peer := hosts[1-i]
+		got, err := h.ep.GetRemoteAddress()
+		if err != nil {
+			t.Fatalf("GetRemoteAddress(): %s", err)
+		}
+		if got.Addr != peer.addr.Addr || got.Port != peer.addr.Port {
+			t.Errorf("got GetRemoteAddress() = %+v, want = %+v", got, peer.addr)
+		}
 	}
 }
 
@@ -2089,6 +3040,94 @@ func TestOutOfOrderReceive(t *testing.T) {
 	)
 }
 
+func TestQueueLengths(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	iss := seqnum.Value(context.TestInitialSequenceNumber)
+	c.CreateConnected(iss, 30000, -1 /* epRcvBuf */)
+	ep := c.EP.(*tcp.Endpoint)
+
+	checkQueues := func(wantSend, wantUnacked, wantRecv, wantOutOfOrder int) {
+		t.Helper()
+		if got := ep.SendQueueLen(); got != wantSend {
+			t.Errorf("got ep.SendQueueLen() = %d, want = %d", got, wantSend)
+		}
+		if got, err := ep.GetSockOptInt(tcpip.SendQueueSizeOption); err != nil || got != wantSend {
+			t.Errorf("got ep.GetSockOptInt(tcpip.SendQueueSizeOption) = (%d, %v), want = (%d, nil)", got, err, wantSend)
+		}
+		if got := ep.UnackedSegments(); got != wantUnacked {
+			t.Errorf("got ep.UnackedSegments() = %d, want = %d", got, wantUnacked)
+		}
+		if got := ep.RecvQueueLen(); got != wantRecv {
+			t.Errorf("got ep.RecvQueueLen() = %d, want = %d", got, wantRecv)
+		}
+		if got := ep.OutOfOrderSegments(); got != wantOutOfOrder {
+			t.Errorf("got ep.OutOfOrderSegments() = %d, want = %d", got, wantOutOfOrder)
+		}
+	}
+	checkQueues(0, 0, 0, 0)
+
+	// Write data that the peer does not acknowledge.
+	sent := []byte{1, 2, 3, 4}
+	var r bytes.Reader
+	r.Reset(sent)
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	v := c.GetPacket()
+	defer v.Release()
+	checker.IPv4(t, v,
+		checker.PayloadLen(len(sent)+header.TCPMinimumSize),
+		checker.TCP(
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+		),
+	)
+	checkQueues(len(sent), 1, 0, 0)
+
+	// Receive data in order that acknowledges what was sent, and then a
+	// segment with a gap before it. Both segments are acknowledged
+	// immediately, and the second ACK is only sent once the first segment
+	// has been fully processed.
+	rcvd := []byte{5, 6, 7}
+	c.SendPacket(rcvd, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  iss.Add(1),
+		AckNum:  c.IRS.Add(1 + seqnum.Size(len(sent))),
+		RcvWnd:  30000,
+	})
+	v2 := c.GetPacket()
+	defer v2.Release()
+	checker.IPv4(t, v2, checker.TCP(
+		checker.TCPAckNum(uint32(iss)+1+uint32(len(rcvd))),
+		checker.TCPFlags(header.TCPFlagAck),
+	))
+	c.SendPacket([]byte{8}, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  iss.Add(1 + seqnum.Size(len(rcvd)) + 1),
+		AckNum:  c.IRS.Add(1 + seqnum.Size(len(sent))),
+		RcvWnd:  30000,
+	})
+	v3 := c.GetPacket()
+	defer v3.Release()
+	checker.IPv4(t, v3, checker.TCP(
+		checker.TCPAckNum(uint32(iss)+1+uint32(len(rcvd))),
+		checker.TCPFlags(header.TCPFlagAck),
+	))
+	checkQueues(0, 0, len(rcvd), 1)
+
+	// Reading drains the receive queue.
+	ept := endpointTester{c.EP}
+	if got := ept.CheckRead(t); !bytes.Equal(got, rcvd) {
+		t.Errorf("got ept.CheckRead(_) = %v, want = %v", got, rcvd)
+	}
+	checkQueues(0, 0, 0, 1)
+}
+
 func TestOutOfOrderFlood(t *testing.T) {
 	c := context.New(t, e2e.DefaultMTU)
 	defer c.Cleanup()
@@ -2335,6 +3374,62 @@ func TestRstOnCloseWithUnreadDataFinConvertRst(t *testing.T) {
 	})
 }
 
+func TestCloseLinger(t *testing.T) {
+	tests := []struct {
+		name      string
+		linger    tcpip.LingerOption
+		wantFlags header.TCPFlags
+		wantState tcp.EndpointState
+	}{
+		{
+			name:      "Disabled",
+			linger:    tcpip.LingerOption{},
+			wantFlags: header.TCPFlagAck | header.TCPFlagFin,
+			wantState: tcp.StateFinWait1,
+		},
+		{
+			// The wait for the close to complete is done by the socket
+			// layer; the endpoint performs a graceful close.
+			name:      "NonZeroTimeout",
+			linger:    tcpip.LingerOption{Enabled: true, Timeout: 5 * time.Second},
+			wantFlags: header.TCPFlagAck | header.TCPFlagFin,
+			wantState: tcp.StateFinWait1,
+		},
+		{
+			name:      "ZeroTimeout",
+			linger:    tcpip.LingerOption{Enabled: true},
+			wantFlags: header.TCPFlagAck | header.TCPFlagRst,
+			wantState: tcp.StateError,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := context.New(t, e2e.DefaultMTU)
+			defer c.Cleanup()
+
+			c.CreateConnected(context.TestInitialSequenceNumber, 30000, -1 /* epRcvBuf */)
+			c.EP.SocketOptions().SetLinger(test.linger)
+			if got := c.EP.SocketOptions().GetLinger(); got != test.linger {
+				t.Fatalf("got GetLinger() = %+v, want = %+v", got, test.linger)
+			}
+
+			c.EP.Close()
+
+			v := c.GetPacket()
+			defer v.Release()
+			checker.IPv4(t, v, checker.TCP(
+				checker.DstPort(context.TestPort),
+				checker.TCPSeqNum(uint32(c.IRS)+1),
+				checker.TCPFlags(test.wantFlags),
+			))
+			if got := tcp.EndpointState(c.EP.State()); got != test.wantState {
+				t.Errorf("got c.EP.State() = %s, want = %s", got, test.wantState)
+			}
+		})
+	}
+}
+
 func TestShutdownRead(t *testing.T) {
 	c := context.New(t, e2e.DefaultMTU)
 	defer c.Cleanup()
@@ -2355,6 +3450,89 @@ func TestShutdownRead(t *testing.T) {
 	}
 }
 
+func TestShutdownWriteContinuesReading(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	c.CreateConnected(context.TestInitialSequenceNumber, 30000, -1 /* epRcvBuf */)
+
+	we, ch := waiter.NewChannelEntry(waiter.ReadableEvents)
+	c.WQ.EventRegister(&we)
+	defer c.WQ.EventUnregister(&we)
+
+	if err := c.EP.Shutdown(tcpip.ShutdownWrite); err != nil {
+		t.Fatalf("Shutdown failed: %s", err)
+	}
+
+	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	v := c.GetPacket()
+	checker.IPv4(t, v, checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPSeqNum(uint32(c.IRS)+1),
+		checker.TCPAckNum(uint32(iss)),
+		checker.TCPFlags(header.TCPFlagAck|header.TCPFlagFin),
+	))
+	v.Release()
+
+	if got, want := tcp.EndpointState(c.EP.State()), tcp.StateFinWait1; got != want {
+		t.Fatalf("got State() = %s, want %s", got, want)
+	}
+
+	// Acknowledge the FIN and send some data; the endpoint must still accept
+	// it.
+	data := []byte{1, 2, 3}
+	c.SendPacket(data, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  iss,
+		AckNum:  c.IRS.Add(2),
+		RcvWnd:  30000,
+	})
+
+	v = c.GetPacket()
+	checker.IPv4(t, v, checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPSeqNum(uint32(c.IRS)+2),
+		checker.TCPAckNum(uint32(iss)+uint32(len(data))),
+		checker.TCPFlags(header.TCPFlagAck),
+	))
+	v.Release()
+
+	if got, want := tcp.EndpointState(c.EP.State()), tcp.StateFinWait2; got != want {
+		t.Fatalf("got State() = %s, want %s", got, want)
+	}
+
+	ept := endpointTester{c.EP}
+	if got := ept.CheckReadFull(t, len(data), ch, 5*time.Second); !bytes.Equal(got, data) {
+		t.Fatalf("got data = %v, want = %v", got, data)
+	}
+
+	// Close the other direction and check that the read side reports EOF.
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck | header.TCPFlagFin,
+		SeqNum:  iss.Add(seqnum.Size(len(data))),
+		AckNum:  c.IRS.Add(2),
+		RcvWnd:  30000,
+	})
+
+	v = c.GetPacket()
+	defer v.Release()
+	checker.IPv4(t, v, checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPSeqNum(uint32(c.IRS)+2),
+		checker.TCPAckNum(uint32(iss)+uint32(len(data))+1),
+		checker.TCPFlags(header.TCPFlagAck),
+	))
+
+	if got, want := tcp.EndpointState(c.EP.State()), tcp.StateTimeWait; got != want {
+		t.Fatalf("got State() = %s, want %s", got, want)
+	}
+	ept.CheckReadError(t, &tcpip.ErrClosedForReceive{})
+}
+
 func TestFullWindowReceive(t *testing.T) {
 	c := context.New(t, e2e.DefaultMTU)
 	defer c.Cleanup()
@@ -2868,9 +4046,74 @@ func TestSimpleSend(t *testing.T) {
 	}
 
 	// Check that data is received.
-	b := c.GetPacket()
+	b := c.GetPacket()
+	defer b.Release()
+	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	checker.IPv4(t, b,
+		checker.PayloadLen(len(data)+header.TCPMinimumSize),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+			checker.TCPAckNum(uint32(iss)),
+			checker.TCPFlagsMatch(header.TCPFlagAck, ^header.TCPFlagPsh),
+		),
+	)
+
+	if p := b.AsSlice()[header.IPv4MinimumSize+header.TCPMinimumSize:]; !bytes.Equal(data, p) {
+		t.Fatalf("got data = %v, want = %v", p, data)
+	}
+
+	// Acknowledge the data.
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  iss,
+		AckNum:  c.IRS.Add(1 + seqnum.Size(len(data))),
+		RcvWnd:  30000,
+	})
+}
+
+func TestZeroWindowSend(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	c.CreateConnected(context.TestInitialSequenceNumber, 0 /* rcvWnd */, -1 /* epRcvBuf */)
+
+	data := []byte{1, 2, 3}
+	var r bytes.Reader
+	r.Reset(data)
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+
+	// Check if we got a zero-window probe.
+	b := c.GetPacket()
+	defer b.Release()
+	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	checker.IPv4(t, b,
+		checker.PayloadLen(header.TCPMinimumSize),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPSeqNum(uint32(c.IRS)),
+			checker.TCPAckNum(uint32(iss)),
+			checker.TCPFlagsMatch(header.TCPFlagAck, ^header.TCPFlagPsh),
+		),
+	)
+
+	// Open up the window. Data should be received now.
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  iss,
+		AckNum:  c.IRS.Add(1),
+		RcvWnd:  30000,
+	})
+
+	// Check that data is received.
+	b = c.GetPacket()
 	defer b.Release()
-	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
 	checker.IPv4(t, b,
 		checker.PayloadLen(len(data)+header.TCPMinimumSize),
 		checker.TCP(
@@ -2896,12 +4139,26 @@ func TestSimpleSend(t *testing.T) {
 	})
 }
 
-func TestZeroWindowSend(t *testing.T) {
-	c := context.New(t, e2e.DefaultMTU)
+// TestZeroWindowProbeBackoff tests that zero window probes are sent with
+// exponential backoff while the peer's window stays closed, and that data is
+// sent once the window reopens.
+func TestZeroWindowProbeBackoff(t *testing.T) {
+	clock := faketime.NewManualClock()
+	c := context.NewWithOpts(t, context.Options{
+		EnableV4: true,
+		EnableV6: true,
+		MTU:      e2e.DefaultMTU,
+		Clock:    clock,
+	})
 	defer c.Cleanup()
 
 	c.CreateConnected(context.TestInitialSequenceNumber, 0 /* rcvWnd */, -1 /* epRcvBuf */)
 
+	var info tcpip.TCPInfoOption
+	if err := c.EP.GetSockOpt(&info); err != nil {
+		t.Fatalf("c.EP.GetSockOpt(&%T) = %s", info, err)
+	}
+
 	data := []byte{1, 2, 3}
 	var r bytes.Reader
 	r.Reset(data)
@@ -2909,19 +4166,27 @@ func TestZeroWindowSend(t *testing.T) {
 		t.Fatalf("Write failed: %s", err)
 	}
 
-	// Check if we got a zero-window probe.
-	b := c.GetPacket()
-	defer b.Release()
 	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
-	checker.IPv4(t, b,
-		checker.PayloadLen(header.TCPMinimumSize),
-		checker.TCP(
-			checker.DstPort(context.TestPort),
-			checker.TCPSeqNum(uint32(c.IRS)),
-			checker.TCPAckNum(uint32(iss)),
-			checker.TCPFlagsMatch(header.TCPFlagAck, ^header.TCPFlagPsh),
-		),
-	)
+	rto := info.RTO
+	for i := 0; i < 3; i++ {
+		// No probe is sent until the (backed off) RTO expires.
+		clock.Advance(rto - time.Nanosecond)
+		c.CheckNoPacketTimeout(fmt.Sprintf("unexpected zero window probe %d before the RTO", i), 50*time.Millisecond)
+		clock.Advance(time.Nanosecond)
+
+		b := c.GetPacket()
+		checker.IPv4(t, b,
+			checker.PayloadLen(header.TCPMinimumSize),
+			checker.TCP(
+				checker.DstPort(context.TestPort),
+				checker.TCPSeqNum(uint32(c.IRS)),
+				checker.TCPAckNum(uint32(iss)),
+				checker.TCPFlagsMatch(header.TCPFlagAck, ^header.TCPFlagPsh),
+			),
+		)
+		b.Release()
+		rto *= 2
+	}
 
 	// Open up the window. Data should be received now.
 	c.SendPacket(nil, &context.Headers{
@@ -2933,8 +4198,7 @@ func TestZeroWindowSend(t *testing.T) {
 		RcvWnd:  30000,
 	})
 
-	// Check that data is received.
-	b = c.GetPacket()
+	b := c.GetPacket()
 	defer b.Release()
 	checker.IPv4(t, b,
 		checker.PayloadLen(len(data)+header.TCPMinimumSize),
@@ -2945,20 +4209,9 @@ func TestZeroWindowSend(t *testing.T) {
 			checker.TCPFlagsMatch(header.TCPFlagAck, ^header.TCPFlagPsh),
 		),
 	)
-
 	if p := b.AsSlice()[header.IPv4MinimumSize+header.TCPMinimumSize:]; !bytes.Equal(data, p) {
 		t.Fatalf("got data = %v, want = %v", p, data)
 	}
-
-	// Acknowledge the data.
-	c.SendPacket(nil, &context.Headers{
-		SrcPort: context.TestPort,
-		DstPort: c.Port,
-		Flags:   header.TCPFlagAck,
-		SeqNum:  iss,
-		AckNum:  c.IRS.Add(1 + seqnum.Size(len(data))),
-		RcvWnd:  30000,
-	})
 }
 
 func TestScaledWindowConnect(t *testing.T) {
@@ -3599,6 +4852,65 @@ func TestSendGreaterThanMTU(t *testing.T) {
 	e2e.CheckBrokenUpWrite(t, c, maxPayload)
 }
 
+func TestLargeWriteWithoutGSO(t *testing.T) {
+	const dataLen = 64 << 10
+	c := context.New(t, 1500)
+	defer c.Cleanup()
+
+	c.SetGSOEnabled(false)
+	mss := c.MSSWithoutOptions()
+	c.CreateConnectedWithRawOptions(context.TestInitialSequenceNumber, 60000, -1 /* epRcvBuf */, []byte{
+		header.TCPOptionMSS, 4, byte(mss / 256), byte(mss % 256),
+	})
+
+	data := make([]byte, dataLen)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	var r bytes.Reader
+	r.Reset(data)
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+
+	// Without GSO the stack must hand down one MSS-sized segment at a time,
+	// except for the last one which carries the remainder.
+	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	for bytesReceived := 0; bytesReceived != dataLen; {
+		v := c.GetPacket()
+		defer v.Release()
+		tcpHdr := header.TCP(header.IPv4(v.AsSlice()).Payload())
+		payloadLen := len(tcpHdr.Payload())
+		wantLen := int(mss)
+		if remaining := dataLen - bytesReceived; remaining < wantLen {
+			wantLen = remaining
+		}
+		checker.IPv4(t, v,
+			checker.PayloadLen(header.TCPMinimumSize+wantLen),
+			checker.TCP(
+				checker.DstPort(context.TestPort),
+				checker.TCPSeqNum(uint32(c.IRS)+1+uint32(bytesReceived)),
+				checker.TCPAckNum(uint32(iss)),
+				checker.TCPFlagsMatch(header.TCPFlagAck, ^header.TCPFlagPsh),
+			),
+		)
+		if want := data[bytesReceived : bytesReceived+payloadLen]; !bytes.Equal(tcpHdr.Payload(), want) {
+			t.Fatalf("got data at offset %d = %v, want = %v", bytesReceived, tcpHdr.Payload(), want)
+		}
+		bytesReceived += payloadLen
+
+		// Acknowledge the data.
+		c.SendPacket(nil, &context.Headers{
+			SrcPort: context.TestPort,
+			DstPort: c.Port,
+			Flags:   header.TCPFlagAck,
+			SeqNum:  iss,
+			AckNum:  c.IRS.Add(1 + seqnum.Size(bytesReceived)),
+			RcvWnd:  60000,
+		})
+	}
+}
+
 func TestDefaultTTL(t *testing.T) {
 	for _, test := range []struct {
 		name     string
@@ -3716,6 +5028,104 @@ func TestSetTTL(t *testing.T) {
 	}
 }
 
+// TestSockOptIntRoundTrip tests that every integer option that can be set
+// on an unconnected endpoint reads back the value that was set.
+func TestSockOptIntRoundTrip(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		opt  tcpip.SockOptInt
+		v    int
+	}{
+		{"KeepaliveCount", tcpip.KeepaliveCountOption, 7},
+		{"IPv4TOS", tcpip.IPv4TOSOption, 0x20},
+		{"IPv6TrafficClass", tcpip.IPv6TrafficClassOption, 0x40},
+		{"MaxSeg", tcpip.MaxSegOption, 1000},
+		{"MTUDiscover", tcpip.MTUDiscoverOption, tcpip.PMTUDiscoveryDont},
+		{"IPv4TTL", tcpip.IPv4TTLOption, 42},
+		{"IPv6HopLimit", tcpip.IPv6HopLimitOption, 43},
+		{"TCPSynCount", tcpip.TCPSynCountOption, 3},
+		{"TCPWindowClamp", tcpip.TCPWindowClampOption, 5000},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := context.New(t, e2e.DefaultMTU)
+			defer c.Cleanup()
+			c.Create(-1 /* epRcvBuf */)
+
+			if err := c.EP.SetSockOptInt(test.opt, test.v); err != nil {
+				t.Fatalf("c.EP.SetSockOptInt(%d, %d): %s", test.opt, test.v, err)
+			}
+			v, err := c.EP.GetSockOptInt(test.opt)
+			if err != nil {
+				t.Fatalf("c.EP.GetSockOptInt(%d): %s", test.opt, err)
+			}
+			if v != test.v {
+				t.Errorf("got c.EP.GetSockOptInt(%d) = %d, want = %d", test.opt, v, test.v)
+			}
+		})
+	}
+}
+
+// TestStackMaxMSS tests that the stack-wide MSS bound caps both the MSS
+// advertised in the SYN and the size of segments sent to a peer that
+// advertises a larger MSS.
+func TestStackMaxMSS(t *testing.T) {
+	const (
+		mtu    = 1500
+		maxMSS = 1000
+	)
+
+	newContext := func(t *testing.T) *context.Context {
+		t.Helper()
+
+		c := context.New(t, mtu)
+		opt := tcpip.TCPMaxMSSOption(maxMSS)
+		if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+			t.Fatalf("SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, opt, opt, err)
+		}
+		return c
+	}
+
+	t.Run("Advertised", func(t *testing.T) {
+		c := newContext(t)
+		defer c.Cleanup()
+
+		c.Create(-1)
+		rcvBufSize := c.EP.SocketOptions().GetReceiveBufferSize()
+		ws := tcp.FindWndScale(seqnum.Size(rcvBufSize))
+		connectAddr := tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}
+		if d := cmp.Diff(&tcpip.ErrConnectStarted{}, c.EP.Connect(connectAddr)); d != "" {
+			t.Fatalf("Connect(%+v) mismatch (-want +got):\n%s", connectAddr, d)
+		}
+		v := c.GetPacket()
+		defer v.Release()
+		checker.IPv4(t, v, checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPFlags(header.TCPFlagSyn),
+			checker.TCPSynOptions(header.TCPSynOptions{MSS: maxMSS, WS: ws})))
+	})
+
+	t.Run("Sent", func(t *testing.T) {
+		c := newContext(t)
+		defer c.Cleanup()
+
+		const peerMSS = mtu - header.IPv4MinimumSize - header.TCPMinimumSize
+		c.CreateConnectedWithRawOptions(context.TestInitialSequenceNumber, 30000 /* rcvWnd */, -1 /* epRcvBuf */, []byte{
+			header.TCPOptionMSS, 4, byte(peerMSS / 256), byte(peerMSS % 256),
+		})
+		e2e.CheckBrokenUpWrite(t, c, maxMSS)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		c := context.New(t, mtu)
+		defer c.Cleanup()
+
+		opt := tcpip.TCPMaxMSSOption(header.TCPMinimumMSS - 1)
+		if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err == nil {
+			t.Errorf("SetTransportProtocolOption(%d, &%T(%d)) succeeded, want = %s", tcp.ProtocolNumber, opt, opt, &tcpip.ErrInvalidOptionValue{})
+		}
+	})
+}
+
 func TestSendMSSLessThanOptionsSize(t *testing.T) {
 	const mss = 10
 	const writeSize = 300
@@ -4221,6 +5631,63 @@ func TestZeroSizedWriteRetransmit(t *testing.T) {
 	}
 }
 
+// TestRetransmitManualClock tests that the retransmission timer is driven by
+// the stack's clock.
+func TestRetransmitManualClock(t *testing.T) {
+	clock := faketime.NewManualClock()
+	c := context.NewWithOpts(t, context.Options{
+		EnableV4: true,
+		EnableV6: true,
+		MTU:      e2e.DefaultMTU,
+		Clock:    clock,
+	})
+	defer c.Cleanup()
+
+	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	c.CreateConnected(context.TestInitialSequenceNumber, 30000 /* rcvWnd */, -1 /* epRcvBuf */)
+
+	data := []byte{1, 2, 3}
+	var r bytes.Reader
+	r.Reset(data)
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	checkData := func() {
+		t.Helper()
+
+		v := c.GetPacket()
+		defer v.Release()
+		checker.IPv4(t, v,
+			checker.PayloadLen(len(data)+header.TCPMinimumSize),
+			checker.TCP(
+				checker.DstPort(context.TestPort),
+				checker.TCPSeqNum(uint32(c.IRS)+1),
+				checker.TCPAckNum(uint32(iss)),
+				checker.TCPFlagsMatch(header.TCPFlagAck, ^header.TCPFlagPsh),
+			),
+		)
+		if b := v.AsSlice()[header.IPv4MinimumSize+header.TCPMinimumSize:]; !bytes.Equal(data, b) {
+			t.Errorf("got data = %x, want = %x", b, data)
+		}
+	}
+	checkData()
+
+	var info tcpip.TCPInfoOption
+	if err := c.EP.GetSockOpt(&info); err != nil {
+		t.Fatalf("c.EP.GetSockOpt(&%T) = %s", info, err)
+	}
+
+	// Nothing is retransmitted until the clock reaches the RTO.
+	clock.Advance(info.RTO - time.Nanosecond)
+	c.CheckNoPacketTimeout("unexpected retransmit before the RTO", 50*time.Millisecond)
+
+	clock.Advance(time.Nanosecond)
+	checkData()
+	if got := c.Stack().Stats().TCP.Retransmits.Value(); got != 1 {
+		t.Errorf("got stats.TCP.Retransmits.Value() = %d, want = 1", got)
+	}
+}
+
 // TestRetransmitIPv4IDUniqueness tests that the IPv4 Identification field is
 // unique on retransmits.
 func TestRetransmitIPv4IDUniqueness(t *testing.T) {
@@ -5395,6 +6862,38 @@ func TestDefaultBufferSizes(t *testing.T) {
 	checkRecvBufferSize(t, ep, tcp.DefaultReceiveBufferSize*3)
 }
 
+func TestBufferSizeRangeOptionValidation(t *testing.T) {
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol},
+	})
+	defer s.Close()
+
+	for _, tc := range []struct {
+		name          string
+		min, def, max int
+		wantErr       tcpip.Error
+	}{
+		{name: "valid", min: 1, def: 2, max: 3},
+		{name: "all equal", min: 4, def: 4, max: 4},
+		{name: "zero min", min: 0, def: 2, max: 3, wantErr: &tcpip.ErrInvalidOptionValue{}},
+		{name: "negative min", min: -1, def: 2, max: 3, wantErr: &tcpip.ErrInvalidOptionValue{}},
+		{name: "default below min", min: 2, def: 1, max: 3, wantErr: &tcpip.ErrInvalidOptionValue{}},
+		{name: "default above max", min: 1, def: 4, max: 3, wantErr: &tcpip.ErrInvalidOptionValue{}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			for _, opt := range []tcpip.SettableTransportProtocolOption{
+				&tcpip.TCPSendBufferSizeRangeOption{Min: tc.min, Default: tc.def, Max: tc.max},
+				&tcpip.TCPReceiveBufferSizeRangeOption{Min: tc.min, Default: tc.def, Max: tc.max},
+			} {
+				if err := s.SetTransportProtocolOption(tcp.ProtocolNumber, opt); err != tc.wantErr {
+					t.Errorf("s.SetTransportProtocolOption(%d, %#v) = %v, want = %v", tcp.ProtocolNumber, opt, err, tc.wantErr)
+				}
+			}
+		})
+	}
+}
+
 func TestBindToDeviceOption(t *testing.T) {
 	s := stack.New(stack.Options{
 		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
@@ -5768,6 +7267,134 @@ func TestPathMTUDiscovery(t *testing.T) {
 	receivePackets(c, sizes, -1, uint32(c.IRS)+1)
 }
 
+func TestPathMTUDiscoveryZeroMTU(t *testing.T) {
+	// Routers that predate RFC 1191 report an MTU of zero. This test verifies
+	// that the path MTU is then estimated from the size of the original
+	// datagram instead of shrinking segments to a single byte.
+	c := context.New(t, 1500)
+	defer c.Cleanup()
+
+	// Create new connection with MSS of 1460.
+	const maxPayload = 1500 - header.TCPMinimumSize - header.IPv4MinimumSize
+	c.CreateConnectedWithRawOptions(context.TestInitialSequenceNumber, 30000, -1 /* epRcvBuf */, []byte{
+		header.TCPOptionMSS, 4, byte(maxPayload / 256), byte(maxPayload % 256),
+	})
+
+	// Send 3200 bytes of data.
+	const writeSize = 3200
+	data := make([]byte, writeSize)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	var r bytes.Reader
+	r.Reset(data)
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+
+	// Receive three packets.
+	sizes := []int{maxPayload, maxPayload, writeSize - 2*maxPayload}
+	first := receivePMTUPackets(t, c, sizes, 0, uint32(c.IRS)+1)
+	defer first.Release()
+
+	// Send a "packet too big" message without a next-hop MTU. The largest
+	// RFC 1191 plateau below 1500 is 1492.
+	const estimatedMTU = 1492
+	const newMaxPayload = estimatedMTU - header.IPv4MinimumSize - header.TCPMinimumSize
+	mtu := buffer.NewViewWithData([]byte{0, 0, 0, 0})
+	defer mtu.Release()
+	c.SendICMPPacket(header.ICMPv4DstUnreachable, header.ICMPv4FragmentationNeeded, mtu, first, estimatedMTU)
+
+	// See retransmitted packets. None exceeding the estimated max.
+	sizes = []int{newMaxPayload, maxPayload - newMaxPayload, newMaxPayload, maxPayload - newMaxPayload, writeSize - 2*maxPayload}
+	receivePMTUPackets(t, c, sizes, -1, uint32(c.IRS)+1)
+}
+
+func TestPathMTUIncrease(t *testing.T) {
+	// This test verifies the stack tries full-sized segments again once
+	// PMTUIncreaseInterval has passed since the path MTU was lowered.
+	clock := faketime.NewManualClock()
+	c := context.NewWithOpts(t, context.Options{
+		EnableV4: true,
+		EnableV6: true,
+		MTU:      1500,
+		Clock:    clock,
+	})
+	defer c.Cleanup()
+
+	// Create new connection with MSS of 1460.
+	const maxPayload = 1500 - header.TCPMinimumSize - header.IPv4MinimumSize
+	c.CreateConnectedWithRawOptions(context.TestInitialSequenceNumber, 30000, -1 /* epRcvBuf */, []byte{
+		header.TCPOptionMSS, 4, byte(maxPayload / 256), byte(maxPayload % 256),
+	})
+
+	const writeSize = 3200
+	data := make([]byte, writeSize)
+	write := func() {
+		var r bytes.Reader
+		r.Reset(data)
+		if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+			t.Fatalf("Write failed: %s", err)
+		}
+	}
+
+	// Send data and lower the path MTU.
+	write()
+	sizes := []int{maxPayload, maxPayload, writeSize - 2*maxPayload}
+	first := receivePMTUPackets(t, c, sizes, 0, uint32(c.IRS)+1)
+	defer first.Release()
+
+	const newMTU = 1200
+	const newMaxPayload = newMTU - header.IPv4MinimumSize - header.TCPMinimumSize
+	mtu := buffer.NewViewWithData([]byte{0, 0, newMTU / 256, newMTU % 256})
+	defer mtu.Release()
+	c.SendICMPPacket(header.ICMPv4DstUnreachable, header.ICMPv4FragmentationNeeded, mtu, first, newMTU)
+
+	sizes = []int{newMaxPayload, maxPayload - newMaxPayload, newMaxPayload, maxPayload - newMaxPayload, writeSize - 2*maxPayload}
+	receivePMTUPackets(t, c, sizes, -1, uint32(c.IRS)+1)
+	c.SendAck(seqnum.Value(context.TestInitialSequenceNumber).Add(1), writeSize)
+
+	// New data still uses the lowered size.
+	write()
+	sizes = []int{newMaxPayload, newMaxPayload, writeSize - 2*newMaxPayload}
+	receivePMTUPackets(t, c, sizes, -1, uint32(c.IRS)+1+writeSize)
+	c.SendAck(seqnum.Value(context.TestInitialSequenceNumber).Add(1), 2*writeSize)
+	c.CheckNoPacketTimeout("unexpected packet after all data was acknowledged", 50*time.Millisecond)
+
+	// Once the interval has passed, full-sized segments are sent again.
+	clock.Advance(tcp.PMTUIncreaseInterval)
+	write()
+	sizes = []int{maxPayload, maxPayload, writeSize - 2*maxPayload}
+	receivePMTUPackets(t, c, sizes, -1, uint32(c.IRS)+1+2*writeSize)
+}
+
+// receivePMTUPackets receives data packets of the given sizes, starting with
+// sequence number seqNum, and returns the one at index which.
+func receivePMTUPackets(t *testing.T, c *context.Context, sizes []int, which int, seqNum uint32) *buffer.View {
+	t.Helper()
+	var ret *buffer.View
+	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	for i, size := range sizes {
+		p := c.GetPacket()
+		if i == which {
+			ret = p
+		} else {
+			defer p.Release()
+		}
+		checker.IPv4(t, p,
+			checker.PayloadLen(size+header.TCPMinimumSize),
+			checker.TCP(
+				checker.DstPort(context.TestPort),
+				checker.TCPSeqNum(seqNum),
+				checker.TCPAckNum(uint32(iss)),
+				checker.TCPFlagsMatch(header.TCPFlagAck, ^header.TCPFlagPsh),
+			),
+		)
+		seqNum += uint32(size)
+	}
+	return ret
+}
+
 func TestTCPEndpointProbe(t *testing.T) {
 	c := context.New(t, 1500)
 	defer c.Cleanup()
@@ -6759,6 +8386,104 @@ func TestSYNRetransmit(t *testing.T) {
 	checker.IPv4(t, v, checker.TCP(tcpCheckers...))
 }
 
+func TestSynRetriesTimeout(t *testing.T) {
+	const synRTO = 100 * time.Millisecond
+	for _, test := range []struct {
+		name string
+		// stackRetries, if non-zero, is set as the stack-wide SYN retries.
+		stackRetries uint8
+		// synCount, if non-zero, is set as the endpoint's TCP_SYNCNT.
+		synCount    int
+		wantRetries int
+	}{
+		{name: "default", wantRetries: tcp.DefaultSynRetries},
+		{name: "stack-wide", stackRetries: 2, wantRetries: 2},
+		{name: "TCP_SYNCNT", stackRetries: 2, synCount: 3, wantRetries: 3},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			clock := faketime.NewManualClock()
+			c := context.NewWithOpts(t, context.Options{
+				EnableV4: true,
+				EnableV6: true,
+				MTU:      e2e.DefaultMTU,
+				Clock:    clock,
+			})
+			defer c.Cleanup()
+
+			opt := tcpip.TCPSynRTOOption(synRTO)
+			if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+				t.Fatalf("c.Stack().SetTransportProtocolOption(%d, &%T(%s)): %s", tcp.ProtocolNumber, opt, synRTO, err)
+			}
+			if test.stackRetries != 0 {
+				opt := tcpip.TCPSynRetriesOption(test.stackRetries)
+				if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+					t.Fatalf("c.Stack().SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, opt, opt, err)
+				}
+			}
+
+			c.Create(-1 /* epRcvBuf */)
+			if test.synCount != 0 {
+				if err := c.EP.SetSockOptInt(tcpip.TCPSynCountOption, test.synCount); err != nil {
+					t.Fatalf("c.EP.SetSockOptInt(TCPSynCountOption, %d): %s", test.synCount, err)
+				}
+			}
+
+			we, ch := waiter.NewChannelEntry(waiter.EventHUp)
+			c.WQ.EventRegister(&we)
+			defer c.WQ.EventUnregister(&we)
+
+			to := tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}
+			if err := c.EP.Connect(to); !cmp.Equal(&tcpip.ErrConnectStarted{}, err) {
+				t.Fatalf("got c.EP.Connect(%+v) = %v, want = %s", to, err, &tcpip.ErrConnectStarted{})
+			}
+
+			// The SYN is retransmitted with exponential backoff.
+			b := c.GetPacket()
+			tcpHdr := header.TCP(header.IPv4(b.AsSlice()).Payload())
+			iss := tcpHdr.SequenceNumber()
+			localPort := tcpHdr.SourcePort()
+			b.Release()
+			rto := synRTO
+			for i := 0; i < test.wantRetries; i++ {
+				clock.Advance(rto)
+				b := c.GetPacket()
+				checker.IPv4(t, b, checker.TCP(
+					checker.TCPFlags(header.TCPFlagSyn),
+					checker.TCPSeqNum(iss),
+				))
+				b.Release()
+				rto *= 2
+			}
+
+			// The connection attempt times out once the last retransmission
+			// goes unanswered.
+			clock.Advance(rto)
+			select {
+			case <-ch:
+			default:
+				t.Fatal("expected EventHUp notification")
+			}
+			c.CheckNoPacket("got a SYN after the last retransmission")
+			if err := c.EP.Connect(to); !cmp.Equal(&tcpip.ErrTimeout{}, err) {
+				t.Errorf("got c.EP.Connect(%+v) = %v after the timeout, want = %s", to, err, &tcpip.ErrTimeout{})
+			}
+			if got := c.EP.State(); got != uint32(tcp.StateError) {
+				t.Errorf("got c.EP.State() = %s, want = %s", tcp.EndpointState(got), tcp.StateError)
+			}
+
+			// The local port is released.
+			ep, err := c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &waiter.Queue{})
+			if err != nil {
+				t.Fatalf("NewEndpoint failed: %s", err)
+			}
+			defer ep.Close()
+			if err := ep.Bind(tcpip.FullAddress{Port: localPort}); err != nil {
+				t.Errorf("ep.Bind(%d) after the timeout: %s", localPort, err)
+			}
+		})
+	}
+}
+
 func TestSynRcvdBadSeqNumber(t *testing.T) {
 	c := context.New(t, e2e.DefaultMTU)
 	defer c.Cleanup()
@@ -7904,6 +9629,119 @@ func TestTCPTimeWaitNewSyn(t *testing.T) {
 	}
 }
 
+// closeIntoTimeWait actively closes c.EP and completes the FIN exchange so
+// that the endpoint ends up in TIME_WAIT.
+func closeIntoTimeWait(t *testing.T, c *context.Context) {
+	t.Helper()
+
+	// Close the endpoint, check that we get a FIN.
+	c.EP.Close()
+	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	v := c.GetPacket()
+	checker.IPv4(t, v, checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPSeqNum(uint32(c.IRS)+1),
+		checker.TCPAckNum(uint32(iss)),
+		checker.TCPFlags(header.TCPFlagAck|header.TCPFlagFin),
+	))
+	v.Release()
+
+	// Acknowledge the FIN and send our own.
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: c.Port,
+		Flags:   header.TCPFlagAck | header.TCPFlagFin,
+		SeqNum:  iss,
+		AckNum:  c.IRS.Add(2),
+		RcvWnd:  30000,
+	})
+	v = c.GetPacket()
+	checker.IPv4(t, v, checker.TCP(
+		checker.DstPort(context.TestPort),
+		checker.TCPSeqNum(uint32(c.IRS)+2),
+		checker.TCPAckNum(uint32(iss)+1),
+		checker.TCPFlags(header.TCPFlagAck),
+	))
+	v.Release()
+
+	if got, want := tcp.EndpointState(c.EP.State()), tcp.StateTimeWait; got != want {
+		t.Fatalf("got State() = %s, want %s", got, want)
+	}
+}
+
+// bindNewEndpoint creates a new TCP endpoint with the given SO_REUSEADDR
+// setting and binds it to the local address of c.EP.
+func bindNewEndpoint(t *testing.T, c *context.Context, reuseAddr bool) tcpip.Error {
+	t.Helper()
+
+	ep, err := c.Stack().NewEndpoint(tcp.ProtocolNumber, ipv4.ProtocolNumber, &waiter.Queue{})
+	if err != nil {
+		t.Fatalf("NewEndpoint failed: %s", err)
+	}
+	defer ep.Close()
+	ep.SocketOptions().SetReuseAddress(reuseAddr)
+	return ep.Bind(tcpip.FullAddress{Addr: context.StackAddr, Port: c.Port})
+}
+
+func TestTCPTimeWaitHoldsPort(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	// Set TCPTimeWaitTimeout to 1 second so that the port is released after
+	// 1 second in TIME_WAIT state.
+	tcpTimeWaitTimeout := 1 * time.Second
+	opt := tcpip.TCPTimeWaitTimeoutOption(tcpTimeWaitTimeout)
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%d)): %s", tcp.ProtocolNumber, opt, opt, err)
+	}
+
+	c.CreateConnected(context.TestInitialSequenceNumber, 30000, -1 /* epRcvBuf */)
+	closeIntoTimeWait(t, c)
+
+	// The port must stay reserved while the endpoint is in TIME_WAIT.
+	if err := bindNewEndpoint(t, c, false /* reuseAddr */); !cmp.Equal(err, &tcpip.ErrPortInUse{}) {
+		t.Fatalf("got Bind(_) = %v, want = %s", err, &tcpip.ErrPortInUse{})
+	}
+
+	// Give the stack the chance to transition to closed state from
+	// TIME_WAIT.
+	time.Sleep(tcpTimeWaitTimeout * 2)
+
+	if got, want := tcp.EndpointState(c.EP.State()), tcp.StateClose; got != want {
+		t.Fatalf("got State() = %s, want %s", got, want)
+	}
+	if err := bindNewEndpoint(t, c, false /* reuseAddr */); err != nil {
+		t.Fatalf("Bind(_) after TIME_WAIT: %s", err)
+	}
+}
+
+func TestTCPTimeWaitReuseAddress(t *testing.T) {
+	for _, test := range []struct {
+		name         string
+		oldReuseAddr bool
+		newReuseAddr bool
+		wantErr      tcpip.Error
+	}{
+		{name: "both", oldReuseAddr: true, newReuseAddr: true},
+		{name: "old only", oldReuseAddr: true, newReuseAddr: false, wantErr: &tcpip.ErrPortInUse{}},
+		{name: "new only", oldReuseAddr: false, newReuseAddr: true, wantErr: &tcpip.ErrPortInUse{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := context.New(t, e2e.DefaultMTU)
+			defer c.Cleanup()
+
+			c.Create(-1 /* epRcvBuf */)
+			c.EP.SocketOptions().SetReuseAddress(test.oldReuseAddr)
+			c.Connect(context.TestInitialSequenceNumber, 30000, nil /* options */)
+			closeIntoTimeWait(t, c)
+
+			if err := bindNewEndpoint(t, c, test.newReuseAddr); !cmp.Equal(err, test.wantErr) {
+				t.Fatalf("got Bind(_) = %v, want = %v", err, test.wantErr)
+			}
+		})
+	}
+}
+
 func TestTCPTimeWaitDuplicateFINExtendsTimeWait(t *testing.T) {
 	c := context.New(t, e2e.DefaultMTU)
 	defer c.Cleanup()
@@ -8414,27 +10252,156 @@ func TestKeepaliveWithUserTimeout(t *testing.T) {
 		checker.TCPFlags(header.TCPFlagRst),
 	),
 	)
-
-	ept.CheckReadError(t, &tcpip.ErrTimeout{})
-	if got, want := c.Stack().Stats().TCP.EstablishedTimedout.Value(), origEstablishedTimedout+1; got != want {
-		t.Errorf("got c.Stack().Stats().TCP.EstablishedTimedout = %d, want = %d", got, want)
-	}
-	if got := c.Stack().Stats().TCP.CurrentConnected.Value(); got != 0 {
-		t.Errorf("got stats.TCP.CurrentConnected.Value() = %d, want = 0", got)
-	}
+
+	ept.CheckReadError(t, &tcpip.ErrTimeout{})
+	if got, want := c.Stack().Stats().TCP.EstablishedTimedout.Value(), origEstablishedTimedout+1; got != want {
+		t.Errorf("got c.Stack().Stats().TCP.EstablishedTimedout = %d, want = %d", got, want)
+	}
+	if got := c.Stack().Stats().TCP.CurrentConnected.Value(); got != 0 {
+		t.Errorf("got stats.TCP.CurrentConnected.Value() = %d, want = 0", got)
+	}
+}
+
+func TestIncreaseWindowOnRead(t *testing.T) {
+	// This test ensures that the endpoint sends an ack,
+	// after read() when the window grows by more than 1 MSS.
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	const rcvBuf = 65535 * 10
+	c.CreateConnected(context.TestInitialSequenceNumber, 30000, rcvBuf)
+
+	// Write chunks of ~30000 bytes. It's important that two
+	// payloads make it equal or longer than MSS.
+	remain := rcvBuf * 2
+	sent := 0
+	data := make([]byte, e2e.DefaultMTU/2)
+	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	for remain > len(data) {
+		c.SendPacket(data, &context.Headers{
+			SrcPort: context.TestPort,
+			DstPort: c.Port,
+			Flags:   header.TCPFlagAck,
+			SeqNum:  iss.Add(seqnum.Size(sent)),
+			AckNum:  c.IRS.Add(1),
+			RcvWnd:  30000,
+		})
+		sent += len(data)
+		remain -= len(data)
+		pkt := c.GetPacket()
+		defer pkt.Release()
+		checker.IPv4(t, pkt,
+			checker.PayloadLen(header.TCPMinimumSize),
+			checker.TCP(
+				checker.DstPort(context.TestPort),
+				checker.TCPSeqNum(uint32(c.IRS)+1),
+				checker.TCPAckNum(uint32(iss)+uint32(sent)),
+				checker.TCPFlags(header.TCPFlagAck),
+			),
+		)
+		// Break once the window drops below e2e.DefaultMTU/2
+		if wnd := header.TCP(header.IPv4(pkt.AsSlice()).Payload()).WindowSize(); wnd < e2e.DefaultMTU/2 {
+			break
+		}
+	}
+
+	// We now have < 1 MSS in the buffer space. Read at least > 2 MSS
+	// worth of data as receive buffer space
+	w := tcpip.LimitedWriter{
+		W: ioutil.Discard,
+		// e2e.DefaultMTU is a good enough estimate for the MSS used for this
+		// connection.
+		N: e2e.DefaultMTU * 2,
+	}
+	for w.N != 0 {
+		_, err := c.EP.Read(&w, tcpip.ReadOptions{})
+		if err != nil {
+			t.Fatalf("Read failed: %s", err)
+		}
+	}
+
+	// After reading > MSS worth of data, we surely crossed MSS. See the ack:
+	v := c.GetPacket()
+	defer v.Release()
+	checker.IPv4(t, v,
+		checker.PayloadLen(header.TCPMinimumSize),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+			checker.TCPAckNum(uint32(iss)+uint32(sent)),
+			checker.TCPWindow(uint16(0xffff)),
+			checker.TCPFlags(header.TCPFlagAck),
+		),
+	)
+}
+
+func TestIncreaseWindowOnBufferResize(t *testing.T) {
+	// This test ensures that the endpoint sends an ack,
+	// after available recv buffer grows to more than 1 MSS.
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	const rcvBuf = 65535 * 10
+	c.CreateConnected(context.TestInitialSequenceNumber, 30000, rcvBuf)
+
+	// Write chunks of ~30000 bytes. It's important that two
+	// payloads make it equal or longer than MSS.
+	remain := rcvBuf
+	sent := 0
+	data := make([]byte, e2e.DefaultMTU/2)
+	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	for remain > len(data) {
+		c.SendPacket(data, &context.Headers{
+			SrcPort: context.TestPort,
+			DstPort: c.Port,
+			Flags:   header.TCPFlagAck,
+			SeqNum:  iss.Add(seqnum.Size(sent)),
+			AckNum:  c.IRS.Add(1),
+			RcvWnd:  30000,
+		})
+		sent += len(data)
+		remain -= len(data)
+		v := c.GetPacket()
+		defer v.Release()
+		checker.IPv4(t, v,
+			checker.PayloadLen(header.TCPMinimumSize),
+			checker.TCP(
+				checker.DstPort(context.TestPort),
+				checker.TCPSeqNum(uint32(c.IRS)+1),
+				checker.TCPAckNum(uint32(iss)+uint32(sent)),
+				checker.TCPWindowLessThanEq(0xffff),
+				checker.TCPFlags(header.TCPFlagAck),
+			),
+		)
+	}
+
+	// Increasing the buffer from should generate an ACK,
+	// since window grew from small value to larger equal MSS
+	c.EP.SocketOptions().SetReceiveBufferSize(rcvBuf*4, true /* notify */)
+	v := c.GetPacket()
+	defer v.Release()
+	checker.IPv4(t, v,
+		checker.PayloadLen(header.TCPMinimumSize),
+		checker.TCP(
+			checker.DstPort(context.TestPort),
+			checker.TCPSeqNum(uint32(c.IRS)+1),
+			checker.TCPAckNum(uint32(iss)+uint32(sent)),
+			checker.TCPWindow(uint16(0xffff)),
+			checker.TCPFlags(header.TCPFlagAck),
+		),
+	)
 }
 
-func TestIncreaseWindowOnRead(t *testing.T) {
-	// This test ensures that the endpoint sends an ack,
-	// after read() when the window grows by more than 1 MSS.
+func TestSmallWindowIncreaseWithheld(t *testing.T) {
+	// This test ensures that the endpoint doesn't advertise a small window
+	// increase after a read that frees less than the ACK threshold, to avoid
+	// silly window syndrome.
 	c := context.New(t, e2e.DefaultMTU)
 	defer c.Cleanup()
 
 	const rcvBuf = 65535 * 10
 	c.CreateConnected(context.TestInitialSequenceNumber, 30000, rcvBuf)
 
-	// Write chunks of ~30000 bytes. It's important that two
-	// payloads make it equal or longer than MSS.
 	remain := rcvBuf * 2
 	sent := 0
 	data := make([]byte, e2e.DefaultMTU/2)
@@ -8467,22 +10434,24 @@ func TestIncreaseWindowOnRead(t *testing.T) {
 		}
 	}
 
-	// We now have < 1 MSS in the buffer space. Read at least > 2 MSS
-	// worth of data as receive buffer space
+	// Reading a few bytes frees much less than 1 MSS, so no window update
+	// should be sent.
 	w := tcpip.LimitedWriter{
 		W: ioutil.Discard,
-		// e2e.DefaultMTU is a good enough estimate for the MSS used for this
-		// connection.
-		N: e2e.DefaultMTU * 2,
+		N: 100,
+	}
+	if _, err := c.EP.Read(&w, tcpip.ReadOptions{}); err != nil {
+		t.Fatalf("Read failed: %s", err)
 	}
+	c.CheckNoPacket("window update sent after a small read")
+
+	// Reading more than 1 MSS opens the window.
+	w.N = e2e.DefaultMTU * 2
 	for w.N != 0 {
-		_, err := c.EP.Read(&w, tcpip.ReadOptions{})
-		if err != nil {
+		if _, err := c.EP.Read(&w, tcpip.ReadOptions{}); err != nil {
 			t.Fatalf("Read failed: %s", err)
 		}
 	}
-
-	// After reading > MSS worth of data, we surely crossed MSS. See the ack:
 	v := c.GetPacket()
 	defer v.Release()
 	checker.IPv4(t, v,
@@ -8491,28 +10460,29 @@ func TestIncreaseWindowOnRead(t *testing.T) {
 			checker.DstPort(context.TestPort),
 			checker.TCPSeqNum(uint32(c.IRS)+1),
 			checker.TCPAckNum(uint32(iss)+uint32(sent)),
-			checker.TCPWindow(uint16(0xffff)),
+			checker.TCPWindowGreaterThanEq(uint16(e2e.DefaultMTU/2)),
 			checker.TCPFlags(header.TCPFlagAck),
 		),
 	)
 }
 
-func TestIncreaseWindowOnBufferResize(t *testing.T) {
-	// This test ensures that the endpoint sends an ack,
-	// after available recv buffer grows to more than 1 MSS.
+func TestWindowClamp(t *testing.T) {
+	// This test ensures that TCPWindowClampOption bounds the advertised
+	// window even though the receive buffer has room for more.
+	const clamp = 5000
 	c := context.New(t, e2e.DefaultMTU)
 	defer c.Cleanup()
 
-	const rcvBuf = 65535 * 10
-	c.CreateConnected(context.TestInitialSequenceNumber, 30000, rcvBuf)
+	c.Create(-1 /* epRcvBuf */)
+	if err := c.EP.SetSockOptInt(tcpip.TCPWindowClampOption, clamp); err != nil {
+		t.Fatalf("c.EP.SetSockOptInt(tcpip.TCPWindowClampOption, %d): %s", clamp, err)
+	}
+	c.Connect(context.TestInitialSequenceNumber, 30000, nil /* options */)
 
-	// Write chunks of ~30000 bytes. It's important that two
-	// payloads make it equal or longer than MSS.
-	remain := rcvBuf
 	sent := 0
-	data := make([]byte, e2e.DefaultMTU/2)
+	data := make([]byte, 1000)
 	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
-	for remain > len(data) {
+	for i := 0; i < 3; i++ {
 		c.SendPacket(data, &context.Headers{
 			SrcPort: context.TestPort,
 			DstPort: c.Port,
@@ -8522,7 +10492,6 @@ func TestIncreaseWindowOnBufferResize(t *testing.T) {
 			RcvWnd:  30000,
 		})
 		sent += len(data)
-		remain -= len(data)
 		v := c.GetPacket()
 		defer v.Release()
 		checker.IPv4(t, v,
@@ -8531,27 +10500,11 @@ func TestIncreaseWindowOnBufferResize(t *testing.T) {
 				checker.DstPort(context.TestPort),
 				checker.TCPSeqNum(uint32(c.IRS)+1),
 				checker.TCPAckNum(uint32(iss)+uint32(sent)),
-				checker.TCPWindowLessThanEq(0xffff),
+				checker.TCPWindow(clamp),
 				checker.TCPFlags(header.TCPFlagAck),
 			),
 		)
 	}
-
-	// Increasing the buffer from should generate an ACK,
-	// since window grew from small value to larger equal MSS
-	c.EP.SocketOptions().SetReceiveBufferSize(rcvBuf*4, true /* notify */)
-	v := c.GetPacket()
-	defer v.Release()
-	checker.IPv4(t, v,
-		checker.PayloadLen(header.TCPMinimumSize),
-		checker.TCP(
-			checker.DstPort(context.TestPort),
-			checker.TCPSeqNum(uint32(c.IRS)+1),
-			checker.TCPAckNum(uint32(iss)+uint32(sent)),
-			checker.TCPWindow(uint16(0xffff)),
-			checker.TCPFlags(header.TCPFlagAck),
-		),
-	)
 }
 
 func TestTCPDeferAccept(t *testing.T) {
@@ -8877,6 +10830,60 @@ func TestHandshakeRTT(t *testing.T) {
 	}
 }
 
+func TestTCPInfoWindowsAndRetransmits(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	const rcvWnd = 30000
+	c.CreateConnected(context.TestInitialSequenceNumber, rcvWnd, -1 /* epRcvBuf */)
+
+	var info tcpip.TCPInfoOption
+	if err := c.EP.GetSockOpt(&info); err != nil {
+		t.Fatalf("c.EP.GetSockOpt(&%T) = %s", info, err)
+	}
+	if info.SndWnd != rcvWnd {
+		t.Errorf("got info.SndWnd = %d, want = %d", info.SndWnd, rcvWnd)
+	}
+	if info.RcvWnd == 0 {
+		t.Errorf("got info.RcvWnd = 0, want non zero")
+	}
+	if info.TotalRetransmits != 0 {
+		t.Errorf("got info.TotalRetransmits = %d, want = 0", info.TotalRetransmits)
+	}
+
+	data := []byte{1, 2, 3}
+	var r bytes.Reader
+	r.Reset(data)
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+
+	// Don't acknowledge the data so that it is retransmitted.
+	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	for i := 0; i < 2; i++ {
+		v := c.GetPacket()
+		checker.IPv4(t, v,
+			checker.PayloadLen(len(data)+header.TCPMinimumSize),
+			checker.TCP(
+				checker.DstPort(context.TestPort),
+				checker.TCPSeqNum(uint32(c.IRS)+1),
+				checker.TCPAckNum(uint32(iss)),
+			),
+		)
+		v.Release()
+	}
+
+	if err := c.EP.GetSockOpt(&info); err != nil {
+		t.Fatalf("c.EP.GetSockOpt(&%T) = %s", info, err)
+	}
+	if info.TotalRetransmits != 1 {
+		t.Errorf("got info.TotalRetransmits = %d, want = 1", info.TotalRetransmits)
+	}
+	if info.SndCwnd == 0 {
+		t.Errorf("got info.SndCwnd = 0, want non zero")
+	}
+}
+
 func TestSetRTO(t *testing.T) {
 	c := context.New(t, e2e.DefaultMTU)
 	minRTO, maxRTO := tcpRTOMinMax(t, c)
@@ -8936,6 +10943,45 @@ func TestSetRTO(t *testing.T) {
 	}
 }
 
+func TestSetSynRTO(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		rto  time.Duration
+		want time.Duration
+		err  tcpip.Error
+	}{
+		{name: "valid", rto: 3 * time.Second, want: 3 * time.Second},
+		{name: "negative restores default", rto: -1, want: tcp.InitialRTO},
+		{name: "zero", rto: 0, err: &tcpip.ErrInvalidOptionValue{}},
+		{name: "above MaxRTO", rto: tcp.MaxRTO + time.Second, err: &tcpip.ErrInvalidOptionValue{}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			c := context.New(t, e2e.DefaultMTU)
+			defer c.Cleanup()
+
+			initial := tcpip.TCPSynRTOOption(2 * time.Second)
+			if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &initial); err != nil {
+				t.Fatalf("c.Stack().SetTransportProtocolOption(%d, &%T(%s)): %s", tcp.ProtocolNumber, initial, time.Duration(initial), err)
+			}
+			opt := tcpip.TCPSynRTOOption(test.rto)
+			if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); !cmp.Equal(test.err, err) {
+				t.Fatalf("got c.Stack().SetTransportProtocolOption(%d, &%T(%s)) = %v, want = %v", tcp.ProtocolNumber, opt, test.rto, err, test.err)
+			}
+			want := test.want
+			if test.err != nil {
+				want = time.Duration(initial)
+			}
+			var got tcpip.TCPSynRTOOption
+			if err := c.Stack().TransportProtocolOption(tcp.ProtocolNumber, &got); err != nil {
+				t.Fatalf("c.Stack().TransportProtocolOption(%d, &%T): %s", tcp.ProtocolNumber, got, err)
+			}
+			if time.Duration(got) != want {
+				t.Errorf("got TCPSynRTOOption = %s, want = %s", time.Duration(got), want)
+			}
+		})
+	}
+}
+
 func tcpRTOMinMax(t *testing.T, c *context.Context) (time.Duration, time.Duration) {
 	t.Helper()
 	var minOpt tcpip.TCPMinRTOOption
@@ -9052,6 +11098,227 @@ func TestSendBufferTuning(t *testing.T) {
 	}
 }
 
+// TestWriteSlicesPartial tests that a write from several slices that does not
+// fit in the send buffer is accepted up to the available space, leaving the
+// rest of the slices to be written later.
+func TestWriteSlicesPartial(t *testing.T) {
+	const (
+		sndBufSz = 1000
+		sliceSz  = 600
+	)
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	opt := tcpip.TCPSendBufferSizeRangeOption{Min: 1, Default: sndBufSz, Max: sndBufSz}
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%#v): %s", tcp.ProtocolNumber, opt, err)
+	}
+	c.CreateConnected(context.TestInitialSequenceNumber, 30000 /* rcvWnd */, -1 /* epRcvBuf */)
+	c.EP.SocketOptions().SetSendBufferSize(sndBufSz, true /* notify */)
+
+	data := make([]byte, 3*sliceSz)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	r := tcpip.NewSlicesReader(data[:sliceSz], data[sliceSz:2*sliceSz], data[2*sliceSz:])
+
+	// The peer did not advertise an MSS, so data is sent in segments of the
+	// default MSS.
+	receive := func(offset, size int) {
+		t.Helper()
+		for end := offset + size; offset < end; offset += header.TCPDefaultMSS {
+			c.ReceiveAndCheckPacket(data, offset, min(header.TCPDefaultMSS, end-offset))
+		}
+	}
+
+	if n, err := c.EP.Write(r, tcpip.WriteOptions{}); err != nil || n != sndBufSz {
+		t.Fatalf("got c.EP.Write(_, {}) = (%d, %s), want = (%d, nil)", n, err, sndBufSz)
+	}
+	if got, want := r.Len(), len(data)-sndBufSz; got != want {
+		t.Fatalf("got r.Len() = %d after a partial write, want = %d", got, want)
+	}
+	receive(0, sndBufSz)
+
+	w, ch := waiter.NewChannelEntry(waiter.WritableEvents)
+	c.WQ.EventRegister(&w)
+	defer c.WQ.EventUnregister(&w)
+	c.SendAck(seqnum.Value(context.TestInitialSequenceNumber).Add(1), sndBufSz)
+	select {
+	case <-ch:
+	case <-time.After(time.Second):
+		t.Fatalf("timed out waiting for the endpoint to become writable")
+	}
+
+	if n, err := c.EP.Write(r, tcpip.WriteOptions{}); err != nil || n != int64(len(data)-sndBufSz) {
+		t.Fatalf("got c.EP.Write(_, {}) = (%d, %s), want = (%d, nil)", n, err, len(data)-sndBufSz)
+	}
+	receive(sndBufSz, len(data)-sndBufSz)
+}
+
+func TestUrgentDataSend(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+	c.CreateConnected(context.TestInitialSequenceNumber, 30000 /* rcvWnd */, -1 /* epRcvBuf */)
+
+	write := func(data []byte, oob bool) {
+		t.Helper()
+		var r bytes.Reader
+		r.Reset(data)
+		if n, err := c.EP.Write(&r, tcpip.WriteOptions{OutOfBand: oob}); err != nil || n != int64(len(data)) {
+			t.Fatalf("got c.EP.Write(_, {OutOfBand: %t}) = (%d, %s), want = (%d, nil)", oob, n, err, len(data))
+		}
+	}
+	check := func(seq, size int, flags header.TCPFlags, urgentPointer uint16) {
+		t.Helper()
+		b := c.GetPacket()
+		defer b.Release()
+		checker.IPv4(t, b,
+			checker.PayloadLen(size+header.TCPMinimumSize),
+			checker.TCP(
+				checker.TCPSeqNum(uint32(c.IRS)+1+uint32(seq)),
+				checker.TCPFlags(flags),
+				checker.TCPUrgentPointer(urgentPointer),
+			),
+		)
+	}
+
+	// The urgent pointer points past the last byte of the urgent write.
+	write([]byte("abc"), true /* oob */)
+	check(0, 3, header.TCPFlagAck|header.TCPFlagPsh|header.TCPFlagUrg, 3)
+
+	// Segments that start at or after the urgent pointer are sent without
+	// URG, even while the urgent data is unacknowledged.
+	write([]byte("de"), false /* oob */)
+	check(3, 2, header.TCPFlagAck|header.TCPFlagPsh, 0)
+
+	// Retransmissions carry the urgent pointer.
+	c.SendAck(seqnum.Value(context.TestInitialSequenceNumber).Add(1), 0)
+	c.SendAck(seqnum.Value(context.TestInitialSequenceNumber).Add(1), 0)
+	c.SendAck(seqnum.Value(context.TestInitialSequenceNumber).Add(1), 0)
+	check(0, 3, header.TCPFlagAck|header.TCPFlagPsh|header.TCPFlagUrg, 3)
+
+	// Once the urgent data is acknowledged the sender leaves urgent mode.
+	c.SendAck(seqnum.Value(context.TestInitialSequenceNumber).Add(1), 5)
+	write([]byte("f"), false /* oob */)
+	check(5, 1, header.TCPFlagAck|header.TCPFlagPsh, 0)
+}
+
+func TestUrgentDataReceive(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+	c.CreateConnected(context.TestInitialSequenceNumber, 30000 /* rcvWnd */, -1 /* epRcvBuf */)
+
+	we, ch := waiter.NewChannelEntry(waiter.EventPri)
+	c.WQ.EventRegister(&we)
+	defer c.WQ.EventUnregister(&we)
+
+	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	send := func(seq seqnum.Value, data []byte, urgentPointer uint16) {
+		t.Helper()
+		flags := header.TCPFlagAck
+		if urgentPointer != 0 {
+			flags |= header.TCPFlagUrg
+		}
+		c.SendPacket(data, &context.Headers{
+			SrcPort:       context.TestPort,
+			DstPort:       c.Port,
+			Flags:         flags,
+			SeqNum:        seq,
+			AckNum:        c.IRS.Add(1),
+			RcvWnd:        30000,
+			UrgentPointer: urgentPointer,
+		})
+		b := c.GetPacket()
+		b.Release()
+	}
+	readOOB := func() (byte, tcpip.Error) {
+		t.Helper()
+		var buf bytes.Buffer
+		res, err := c.EP.Read(&buf, tcpip.ReadOptions{OutOfBand: true})
+		if err != nil {
+			return 0, err
+		}
+		if res.Count != 1 || buf.Len() != 1 {
+			t.Fatalf("got c.EP.Read(_, {OutOfBand: true}) = %+v with %d bytes, want 1 byte", res, buf.Len())
+		}
+		return buf.Bytes()[0], nil
+	}
+
+	// The urgent pointer may point beyond the segment that carries it. The
+	// urgent byte is only available once it arrives.
+	send(iss, []byte("ab"), 4)
+	if _, err := readOOB(); !cmp.Equal(&tcpip.ErrWouldBlock{}, err) {
+		t.Fatalf("got readOOB() = %v before the urgent byte arrived, want = %s", err, &tcpip.ErrWouldBlock{})
+	}
+	if got := c.EP.Readiness(waiter.EventPri); got != 0 {
+		t.Errorf("got c.EP.Readiness(EventPri) = %b, want = 0", got)
+	}
+	send(iss.Add(2), []byte("cde"), 0)
+
+	select {
+	case <-ch:
+	default:
+		t.Fatal("expected EventPri notification")
+	}
+	if got := c.EP.Readiness(waiter.EventPri); got != waiter.EventPri {
+		t.Errorf("got c.EP.Readiness(EventPri) = %b, want = %b", got, waiter.EventPri)
+	}
+	if b, err := readOOB(); err != nil || b != 'd' {
+		t.Fatalf("got readOOB() = (%q, %v), want = ('d', nil)", b, err)
+	}
+	if _, err := readOOB(); !cmp.Equal(&tcpip.ErrWouldBlock{}, err) {
+		t.Fatalf("got readOOB() = %v after reading the urgent byte, want = %s", err, &tcpip.ErrWouldBlock{})
+	}
+
+	// Without SO_OOBINLINE the urgent byte is removed from the stream.
+	var buf bytes.Buffer
+	if _, err := c.EP.Read(&buf, tcpip.ReadOptions{}); err != nil {
+		t.Fatalf("c.EP.Read(_, {}): %s", err)
+	}
+	if got, want := buf.String(), "abce"; got != want {
+		t.Errorf("got c.EP.Read(_, {}) = %q, want = %q", got, want)
+	}
+}
+
+func TestUrgentDataReceiveInline(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+	c.CreateConnected(context.TestInitialSequenceNumber, 30000 /* rcvWnd */, -1 /* epRcvBuf */)
+	c.EP.SocketOptions().SetOutOfBandInline(true)
+
+	iss := seqnum.Value(context.TestInitialSequenceNumber).Add(1)
+	c.SendPacket([]byte("abc"), &context.Headers{
+		SrcPort:       context.TestPort,
+		DstPort:       c.Port,
+		Flags:         header.TCPFlagAck | header.TCPFlagUrg,
+		SeqNum:        iss,
+		AckNum:        c.IRS.Add(1),
+		RcvWnd:        30000,
+		UrgentPointer: 2,
+	})
+	b := c.GetPacket()
+	b.Release()
+
+	if got := c.EP.Readiness(waiter.EventPri); got != waiter.EventPri {
+		t.Errorf("got c.EP.Readiness(EventPri) = %b, want = %b", got, waiter.EventPri)
+	}
+
+	// With SO_OOBINLINE the urgent byte can't be read out-of-band.
+	var oob bytes.Buffer
+	if _, err := c.EP.Read(&oob, tcpip.ReadOptions{OutOfBand: true}); !cmp.Equal(&tcpip.ErrInvalidOptionValue{}, err) {
+		t.Fatalf("got c.EP.Read(_, {OutOfBand: true}) = %v, want = %s", err, &tcpip.ErrInvalidOptionValue{})
+	}
+
+	// It is delivered in-band instead.
+	var buf bytes.Buffer
+	if _, err := c.EP.Read(&buf, tcpip.ReadOptions{}); err != nil {
+		t.Fatalf("c.EP.Read(_, {}): %s", err)
+	}
+	if got, want := buf.String(), "abc"; got != want {
+		t.Errorf("got c.EP.Read(_, {}) = %q, want = %q", got, want)
+	}
+}
+
 func TestTimestampSynCookies(t *testing.T) {
 	clock := faketime.NewManualClock()
 	tsNow := func() uint32 {
@@ -9419,6 +11686,75 @@ func TestLateSynCookieAck(t *testing.T) {
 	}
 }
 
+// TestInvalidSynCookieAck tests that an ACK carrying an invalid SYN cookie is
+// answered with a RST and does not create a connection.
+func TestInvalidSynCookieAck(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+	stats := c.Stack().Stats()
+
+	opt := tcpip.TCPAlwaysUseSynCookies(true)
+	if err := c.Stack().SetTransportProtocolOption(tcp.ProtocolNumber, &opt); err != nil {
+		t.Fatalf("SetTransportProtocolOption(%d, &%T(%t)): %s", tcp.ProtocolNumber, opt, opt, err)
+	}
+
+	c.Create(-1)
+	if err := c.EP.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+		t.Fatalf("Bind failed: %s", err)
+	}
+	if err := c.EP.Listen(10); err != nil {
+		t.Fatalf("Listen failed: %s", err)
+	}
+
+	// Send a SYN request.
+	irs := seqnum.Value(context.TestInitialSequenceNumber)
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: context.StackPort,
+		Flags:   header.TCPFlagSyn,
+		SeqNum:  irs,
+	})
+
+	// Receive the SYN-ACK reply carrying the cookie.
+	v := c.GetPacket()
+	defer v.Release()
+	tcpHdr := header.TCP(header.IPv4(v.AsSlice()).Payload())
+	iss := seqnum.Value(tcpHdr.SequenceNumber())
+	if got, want := stats.TCP.ListenOverflowSynCookieSent.Value(), uint64(1); got != want {
+		t.Fatalf("got stats.TCP.ListenOverflowSynCookieSent.Value() = %d, want = %d", got, want)
+	}
+
+	// Acknowledge a different sequence number, as a forged ACK would. The low
+	// bits of a cookie encode a small MSS table index, so flip a bit well
+	// above them to make sure the decoded data is out of range.
+	forgedAck := iss + 1 + 1<<20
+	c.SendPacket(nil, &context.Headers{
+		SrcPort: context.TestPort,
+		DstPort: context.StackPort,
+		Flags:   header.TCPFlagAck,
+		SeqNum:  irs + 1,
+		AckNum:  forgedAck,
+	})
+
+	r := c.GetPacket()
+	defer r.Release()
+	checker.IPv4(t, r, checker.TCP(
+		checker.SrcPort(context.StackPort),
+		checker.DstPort(context.TestPort),
+		checker.TCPFlags(header.TCPFlagRst),
+		checker.TCPSeqNum(uint32(forgedAck)),
+	))
+	if got, want := stats.TCP.ListenOverflowInvalidSynCookieRcvd.Value(), uint64(1); got != want {
+		t.Errorf("got stats.TCP.ListenOverflowInvalidSynCookieRcvd.Value() = %d, want = %d", got, want)
+	}
+	if got, want := stats.TCP.ListenOverflowSynCookieRcvd.Value(), uint64(0); got != want {
+		t.Errorf("got stats.TCP.ListenOverflowSynCookieRcvd.Value() = %d, want = %d", got, want)
+	}
+	if _, _, err := c.EP.Accept(nil); !cmp.Equal(&tcpip.ErrWouldBlock{}, err) {
+		t.Fatalf("got c.EP.Accept(nil) = %s, want = %s", err, &tcpip.ErrWouldBlock{})
+	}
+}
+
 func TestMain(m *testing.M) {
 	refs.SetLeakMode(refs.LeaksPanic)
 	code := m.Run()
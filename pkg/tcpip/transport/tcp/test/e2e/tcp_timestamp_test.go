@@ -26,6 +26,7 @@ import (
 	"gvisor.dev/gvisor/pkg/tcpip"
 	"gvisor.dev/gvisor/pkg/tcpip/checker"
 	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/seqnum"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp/test/e2e"
 	"gvisor.dev/gvisor/pkg/tcpip/transport/tcp/testing/context"
@@ -315,6 +316,110 @@ func TestSegmentNotDroppedWhenTimestampMissing(t *testing.T) {
 	}
 }
 
+// TestPAWSRejectsOldTimestamp tests that a data segment carrying a timestamp
+// older than the most recently received one is acknowledged but dropped, as
+// described in https://tools.ietf.org/html/rfc7323#section-5.3.
+func TestPAWSRejectsOldTimestamp(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	rep := createConnectedWithTimestampOption(c)
+
+	// Register for read.
+	we, ch := waiter.NewChannelEntry(waiter.ReadableEvents)
+	c.WQ.EventRegister(&we)
+	defer c.WQ.EventUnregister(&we)
+
+	tsVal := rep.TSVal + 100
+	rep.SendPacketWithTS([]byte{1, 2, 3}, tsVal)
+	rep.VerifyACKWithTS(tsVal)
+
+	// The next segment carries an older timestamp. It should be dropped and
+	// the ACK should echo the most recent timestamp.
+	pawsRejected := c.Stack().Stats().TCP.PAWSRejected
+	rep.SendPacketWithTS([]byte{4, 5, 6}, tsVal-100)
+	rep.NextSeqNum -= 3
+	rep.VerifyACKWithTS(tsVal)
+	if got := pawsRejected.Value(); got != 1 {
+		t.Errorf("got stats.TCP.PAWSRejected.Value() = %d, want = 1", got)
+	}
+
+	// A retransmission of the dropped data with a newer timestamp is
+	// accepted.
+	tsVal += 100
+	rep.SendPacketWithTS([]byte{4, 5, 6}, tsVal)
+	rep.VerifyACKWithTS(tsVal)
+
+	select {
+	case <-ch:
+	case <-time.After(1 * time.Second):
+		t.Fatalf("Timed out waiting for data to arrive")
+	}
+
+	var buf bytes.Buffer
+	if _, err := c.EP.Read(&buf, tcpip.ReadOptions{}); err != nil {
+		t.Fatalf("Unexpected error from Read: %v", err)
+	}
+	if got, want := buf.Bytes(), []byte{1, 2, 3, 4, 5, 6}; !bytes.Equal(got, want) {
+		t.Fatalf("Data is different: got: %v, want: %v", got, want)
+	}
+}
+
+// TestPAWSOldTimestampAck tests that PAWS only lets ACKs with an older
+// timestamp through if they are reordered duplicates that change no state.
+func TestPAWSOldTimestampAck(t *testing.T) {
+	c := context.New(t, e2e.DefaultMTU)
+	defer c.Cleanup()
+
+	rep := createConnectedWithTimestampOption(c)
+
+	tsVal := rep.TSVal + 100
+	rep.SendPacketWithTS([]byte{1, 2, 3}, tsVal)
+	rep.VerifyACKWithTS(tsVal)
+
+	// Leave data outstanding so that an ACK can acknowledge it.
+	data := []byte{4, 5, 6}
+	var r bytes.Reader
+	r.Reset(data)
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	dataSeq := rep.AckNum
+	checkData := func() {
+		t.Helper()
+		b := c.GetPacket()
+		defer b.Release()
+		checker.IPv4(t, b,
+			checker.PayloadLen(len(data)+header.TCPMinimumSize+12),
+			checker.TCP(
+				checker.TCPSeqNum(uint32(dataSeq)),
+				checker.TCPTimestampChecker(true, 0, tsVal),
+			),
+		)
+	}
+	checkData()
+
+	// A duplicate ACK with an older timestamp is accepted.
+	pawsRejected := c.Stack().Stats().TCP.PAWSRejected
+	rep.SendPacketWithTS(nil, tsVal-100)
+	c.CheckNoPacketTimeout("got a reply to a duplicate ACK", 100*time.Millisecond)
+	if got := pawsRejected.Value(); got != 0 {
+		t.Errorf("got stats.TCP.PAWSRejected.Value() = %d, want = 0", got)
+	}
+
+	// An ACK with an older timestamp that would move snd.una is rejected and
+	// answered with an ACK echoing the most recent timestamp.
+	rep.AckNum = dataSeq.Add(seqnum.Size(len(data)))
+	rep.SendPacketWithTS(nil, tsVal-100)
+	rep.VerifyACKWithTS(tsVal)
+	if got := pawsRejected.Value(); got != 1 {
+		t.Errorf("got stats.TCP.PAWSRejected.Value() = %d, want = 1", got)
+	}
+
+	// The data is still outstanding and gets retransmitted.
+	checkData()
+}
+
 func TestMain(m *testing.M) {
 	refs.SetLeakMode(refs.LeaksPanic)
 	code := m.Run()
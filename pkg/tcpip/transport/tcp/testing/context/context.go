@@ -114,6 +114,10 @@ type Headers struct {
 	// TCPOpts holds the options to be sent in the option field of the TCP
 	// header.
 	TCPOpts []byte
+
+	// UrgentPointer is the value of the urgent pointer field in the TCP
+	// header.
+	UrgentPointer uint16
 }
 
 // Options contains options for creating a new test context.
@@ -129,6 +133,9 @@ type Options struct {
 
 	// Clock that is used by Stack.
 	Clock tcpip.Clock
+
+	// ReceiveMemoryLimit is the stack-wide receive memory limit.
+	ReceiveMemoryLimit int64
 }
 
 // Context provides an initialized Network stack and a link layer endpoint
@@ -190,6 +197,7 @@ func NewWithOpts(t *testing.T, opts Options) *Context {
 	stackOpts := stack.Options{
 		TransportProtocols: []stack.TransportProtocolFactory{tcp.NewProtocol},
 		Clock:              opts.Clock,
+		ReceiveMemoryLimit: opts.ReceiveMemoryLimit,
 	}
 	if opts.EnableV4 {
 		stackOpts.NetworkProtocols = append(stackOpts.NetworkProtocols, ipv4.NewProtocol)
@@ -473,13 +481,14 @@ func (c *Context) BuildSegmentWithAddrs(payload []byte, h *Headers, src, dst tcp
 	// Initialize the TCP header.
 	t := header.TCP(buf[header.IPv4MinimumSize:])
 	t.Encode(&header.TCPFields{
-		SrcPort:    h.SrcPort,
-		DstPort:    h.DstPort,
-		SeqNum:     uint32(h.SeqNum),
-		AckNum:     uint32(h.AckNum),
-		DataOffset: uint8(header.TCPMinimumSize + len(h.TCPOpts)),
-		Flags:      h.Flags,
-		WindowSize: uint16(h.RcvWnd),
+		SrcPort:       h.SrcPort,
+		DstPort:       h.DstPort,
+		SeqNum:        uint32(h.SeqNum),
+		AckNum:        uint32(h.AckNum),
+		DataOffset:    uint8(header.TCPMinimumSize + len(h.TCPOpts)),
+		Flags:         h.Flags,
+		WindowSize:    uint16(h.RcvWnd),
+		UrgentPointer: h.UrgentPointer,
 	})
 
 	// Calculate the TCP pseudo-header checksum.
@@ -677,13 +686,14 @@ func (c *Context) SendV6PacketWithAddrs(payload []byte, h *Headers, src, dst tcp
 	// Initialize the TCP header.
 	t := header.TCP(buf[header.IPv6MinimumSize:])
 	t.Encode(&header.TCPFields{
-		SrcPort:    h.SrcPort,
-		DstPort:    h.DstPort,
-		SeqNum:     uint32(h.SeqNum),
-		AckNum:     uint32(h.AckNum),
-		DataOffset: uint8(header.TCPMinimumSize + len(h.TCPOpts)),
-		Flags:      h.Flags,
-		WindowSize: uint16(h.RcvWnd),
+		SrcPort:       h.SrcPort,
+		DstPort:       h.DstPort,
+		SeqNum:        uint32(h.SeqNum),
+		AckNum:        uint32(h.AckNum),
+		DataOffset:    uint8(header.TCPMinimumSize + len(h.TCPOpts)),
+		Flags:         h.Flags,
+		WindowSize:    uint16(h.RcvWnd),
+		UrgentPointer: h.UrgentPointer,
 	})
 
 	// Calculate the TCP pseudo-header checksum.
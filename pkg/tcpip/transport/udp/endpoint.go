@@ -69,6 +69,9 @@ type endpoint struct {
 	stats       tcpip.TransportEndpointStats
 	ops         tcpip.SocketOptions
 
+	// transProto is either UDP or UDP-Lite.
+	transProto tcpip.TransportProtocolNumber
+
 	// The following fields are used to manage the receive queue, and are
 	// protected by rcvMu.
 	rcvMu      sync.Mutex `state:"nosave"`
@@ -77,6 +80,10 @@ type endpoint struct {
 	rcvBufSize int
 	rcvClosed  bool
 
+	// rcvMemAccount charges rcvBufSize against the stack-wide receive memory
+	// limit.
+	rcvMemAccount stack.ReceiveMemoryAccount
+
 	lastErrorMu sync.Mutex `state:"nosave"`
 	lastError   tcpip.Error
 
@@ -91,6 +98,12 @@ type endpoint struct {
 
 	readShutdown bool
 
+	// sndCoverage and rcvCoverage hold the UDP-Lite checksum coverage of
+	// outgoing datagrams and the minimum partial coverage of incoming ones.
+	// Zero means full coverage and any coverage, respectively.
+	sndCoverage int
+	rcvCoverage int
+
 	// effectiveNetProtos contains the network protocols actually in use. In
 	// most cases it will only contain "netProto", but in cases like IPv6
 	// endpoints with v6only set to false, this could include multiple
@@ -107,17 +120,18 @@ type endpoint struct {
 	remotePort uint16
 }
 
-func newEndpoint(s *stack.Stack, netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) *endpoint {
+func newEndpoint(s *stack.Stack, transProto tcpip.TransportProtocolNumber, netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) *endpoint {
 	e := &endpoint{
 		stack:       s,
 		waiterQueue: waiterQueue,
 		uniqueID:    s.UniqueID(),
+		transProto:  transProto,
 	}
 	e.ops.InitHandler(e, e.stack, tcpip.GetStackSendBufferLimits, tcpip.GetStackReceiveBufferLimits)
 	e.ops.SetMulticastLoop(true)
 	e.ops.SetSendBufferSize(32*1024, false /* notify */)
 	e.ops.SetReceiveBufferSize(32*1024, false /* notify */)
-	e.net.Init(s, netProto, header.UDPProtocolNumber, &e.ops, waiterQueue)
+	e.net.Init(s, netProto, transProto, &e.ops, waiterQueue)
 
 	// Override with stack defaults.
 	var ss tcpip.SendBufferSizeOption
@@ -178,10 +192,10 @@ func (e *endpoint) Close() {
 		id := e.net.Info().ID
 		id.LocalPort = e.localPort
 		id.RemotePort = e.remotePort
-		e.stack.UnregisterTransportEndpoint(e.effectiveNetProtos, ProtocolNumber, id, e, e.boundPortFlags, e.boundBindToDevice)
+		e.stack.UnregisterTransportEndpoint(e.effectiveNetProtos, e.transProto, id, e, e.boundPortFlags, e.boundBindToDevice)
 		portRes := ports.Reservation{
 			Networks:     e.effectiveNetProtos,
-			Transport:    ProtocolNumber,
+			Transport:    e.transProto,
 			Addr:         id.LocalAddress,
 			Port:         id.LocalPort,
 			Flags:        e.boundPortFlags,
@@ -198,6 +212,7 @@ func (e *endpoint) Close() {
 	// Close the receive list and drain it.
 	e.rcvMu.Lock()
 	e.rcvClosed = true
+	e.stack.ReleaseReceiveMemory(&e.rcvMemAccount, e.rcvBufSize)
 	e.rcvBufSize = 0
 	for !e.rcvList.Empty() {
 		p := e.rcvList.Front()
@@ -240,6 +255,7 @@ func (e *endpoint) Read(dst io.Writer, opts tcpip.ReadOptions) (tcpip.ReadResult
 		e.rcvList.Remove(p)
 		defer p.pkt.DecRef()
 		e.rcvBufSize -= p.pkt.Data().Size()
+		e.stack.ReleaseReceiveMemory(&e.rcvMemAccount, p.pkt.Data().Size())
 	}
 	e.rcvMu.Unlock()
 
@@ -446,6 +462,7 @@ func (e *endpoint) prepareForWrite(p tcpip.Payloader, opts tcpip.WriteOptions) (
 	return udpPacketInfo{
 		ctx:        ctx,
 		data:       buf,
+		coverage:   e.sndCoverage,
 		localPort:  e.localPort,
 		remotePort: dst.Port,
 	}, nil
@@ -483,24 +500,37 @@ func (e *endpoint) write(p tcpip.Payloader, opts tcpip.WriteOptions) (int64, tcp
 
 	// Initialize the UDP header.
 	udp := header.UDP(pkt.TransportHeader().Push(header.UDPMinimumSize))
-	pkt.TransportProtocolNumber = ProtocolNumber
+	pkt.TransportProtocolNumber = e.transProto
 
+	// UDP-Lite carries the checksum coverage in the length field, as per RFC
+	// 3828 section 3.1. A partial coverage is only used when it is smaller
+	// than the datagram.
 	length := uint16(pkt.Size())
+	coverage := length
+	if c := udpInfo.coverage; c != 0 && c < int(length) {
+		coverage = uint16(c)
+	}
 	udp.Encode(&header.UDPFields{
 		SrcPort: udpInfo.localPort,
 		DstPort: udpInfo.remotePort,
-		Length:  length,
+		Length:  coverage,
 	})
 
 	// Set the checksum field unless TX checksum offload is enabled.
 	// On IPv4, UDP checksum is optional, and a zero value indicates the
 	// transmitter skipped the checksum generation (RFC768).
 	// On IPv6, UDP checksum is not optional (RFC2460 Section 8.1).
-	if pktInfo.RequiresTXTransportChecksum &&
-		(!e.ops.GetNoChecksum() || pktInfo.NetProto == header.IPv6ProtocolNumber) {
+	// UDP-Lite checksum is never optional (RFC3828 Section 3.1), and it is
+	// always computed here as links can't offload a partial coverage.
+	if e.transProto == header.UDPLiteProtocolNumber || (pktInfo.RequiresTXTransportChecksum &&
+		(!e.ops.GetNoChecksum() || pktInfo.NetProto == header.IPv6ProtocolNumber)) {
+		payloadXsum := pkt.Data().Checksum()
+		if coverage < length {
+			payloadXsum = pkt.Data().AsRange().Capped(int(coverage - header.UDPMinimumSize)).Checksum()
+		}
 		xsum := udp.CalculateChecksum(checksum.Combine(
-			header.PseudoHeaderChecksum(ProtocolNumber, pktInfo.LocalAddress, pktInfo.RemoteAddress, length),
-			pkt.Data().Checksum(),
+			header.PseudoHeaderChecksum(e.transProto, pktInfo.LocalAddress, pktInfo.RemoteAddress, length),
+			payloadXsum,
 		))
 		// As per RFC 768 page 2,
 		//
@@ -552,7 +582,28 @@ func (e *endpoint) OnReusePortSet(v bool) {
 
 // SetSockOptInt implements tcpip.Endpoint.
 func (e *endpoint) SetSockOptInt(opt tcpip.SockOptInt, v int) tcpip.Error {
-	return e.net.SetSockOptInt(opt, v)
+	switch opt {
+	case tcpip.UDPLiteSendCoverageOption, tcpip.UDPLiteRecvCoverageOption:
+		if e.transProto != header.UDPLiteProtocolNumber {
+			return &tcpip.ErrUnknownProtocolOption{}
+		}
+		// As in Linux, a partial coverage covers at least the header and at
+		// most the largest datagram.
+		if v != 0 {
+			v = max(header.UDPMinimumSize, min(v, header.UDPMaximumSize))
+		}
+		e.mu.Lock()
+		if opt == tcpip.UDPLiteSendCoverageOption {
+			e.sndCoverage = v
+		} else {
+			e.rcvCoverage = v
+		}
+		e.mu.Unlock()
+		return nil
+
+	default:
+		return e.net.SetSockOptInt(opt, v)
+	}
 }
 
 var _ tcpip.SocketOptionsHandler = (*endpoint)(nil)
@@ -580,6 +631,17 @@ func (e *endpoint) GetSockOptInt(opt tcpip.SockOptInt) (int, tcpip.Error) {
 		e.rcvMu.Unlock()
 		return v, nil
 
+	case tcpip.UDPLiteSendCoverageOption, tcpip.UDPLiteRecvCoverageOption:
+		if e.transProto != header.UDPLiteProtocolNumber {
+			return -1, &tcpip.ErrUnknownProtocolOption{}
+		}
+		e.mu.RLock()
+		defer e.mu.RUnlock()
+		if opt == tcpip.UDPLiteSendCoverageOption {
+			return e.sndCoverage, nil
+		}
+		return e.rcvCoverage, nil
+
 	default:
 		return e.net.GetSockOptInt(opt)
 	}
@@ -596,6 +658,7 @@ type udpPacketInfo struct {
 	data       buffer.Buffer
 	localPort  uint16
 	remotePort uint16
+	coverage   int
 }
 
 // Disconnect implements tcpip.Endpoint.
@@ -635,7 +698,7 @@ func (e *endpoint) Disconnect() tcpip.Error {
 			// Release the ephemeral port.
 			portRes := ports.Reservation{
 				Networks:     e.effectiveNetProtos,
-				Transport:    ProtocolNumber,
+				Transport:    e.transProto,
 				Addr:         info.ID.LocalAddress,
 				Port:         info.ID.LocalPort,
 				Flags:        boundPortFlags,
@@ -647,7 +710,7 @@ func (e *endpoint) Disconnect() tcpip.Error {
 		}
 	}
 
-	e.stack.UnregisterTransportEndpoint(e.effectiveNetProtos, ProtocolNumber, info.ID, e, boundPortFlags, e.boundBindToDevice)
+	e.stack.UnregisterTransportEndpoint(e.effectiveNetProtos, e.transProto, info.ID, e, boundPortFlags, e.boundBindToDevice)
 	e.boundBindToDevice = btd
 	e.localPort = id.LocalPort
 	e.remotePort = id.RemotePort
@@ -683,7 +746,7 @@ func (e *endpoint) Connect(addr tcpip.FullAddress) tcpip.Error {
 		if e.localPort != 0 {
 			previousID.LocalPort = e.localPort
 			previousID.RemotePort = e.remotePort
-			e.stack.UnregisterTransportEndpoint(e.effectiveNetProtos, ProtocolNumber, previousID, e, oldPortFlags, e.boundBindToDevice)
+			e.stack.UnregisterTransportEndpoint(e.effectiveNetProtos, e.transProto, previousID, e, oldPortFlags, e.boundBindToDevice)
 		}
 
 		nextID, btd, err := e.registerWithStack(netProtos, nextID)
@@ -766,7 +829,7 @@ func (e *endpoint) registerWithStack(netProtos []tcpip.NetworkProtocolNumber, id
 	if e.localPort == 0 {
 		portRes := ports.Reservation{
 			Networks:     netProtos,
-			Transport:    ProtocolNumber,
+			Transport:    e.transProto,
 			Addr:         id.LocalAddress,
 			Port:         id.LocalPort,
 			Flags:        e.portFlags,
@@ -781,11 +844,11 @@ func (e *endpoint) registerWithStack(netProtos []tcpip.NetworkProtocolNumber, id
 	}
 	e.boundPortFlags = e.portFlags
 
-	err := e.stack.RegisterTransportEndpoint(netProtos, ProtocolNumber, id, e, e.boundPortFlags, bindToDevice)
+	err := e.stack.RegisterTransportEndpoint(netProtos, e.transProto, id, e, e.boundPortFlags, bindToDevice)
 	if err != nil {
 		portRes := ports.Reservation{
 			Networks:     netProtos,
-			Transport:    ProtocolNumber,
+			Transport:    e.transProto,
 			Addr:         id.LocalAddress,
 			Port:         id.LocalPort,
 			Flags:        e.boundPortFlags,
@@ -911,15 +974,7 @@ func (e *endpoint) Readiness(mask waiter.EventMask) waiter.EventMask {
 func (e *endpoint) HandlePacket(id stack.TransportEndpointID, pkt *stack.PacketBuffer) {
 	// Get the header then trim it from the view.
 	hdr := header.UDP(pkt.TransportHeader().Slice())
-	netHdr := pkt.Network()
-	lengthValid, csumValid := header.UDPValid(
-		hdr,
-		func() uint16 { return pkt.Data().Checksum() },
-		uint16(pkt.Data().Size()),
-		pkt.NetworkProtocolNumber,
-		netHdr.SourceAddress(),
-		netHdr.DestinationAddress(),
-		pkt.RXChecksumValidated)
+	lengthValid, csumValid := validate(e.transProto, hdr, pkt)
 	if !lengthValid {
 		// Malformed packet.
 		e.stack.Stats().UDP.MalformedPacketsReceived.Increment()
@@ -933,6 +988,20 @@ func (e *endpoint) HandlePacket(id stack.TransportEndpointID, pkt *stack.PacketB
 		return
 	}
 
+	if e.transProto == header.UDPLiteProtocolNumber {
+		e.mu.RLock()
+		minCoverage := e.rcvCoverage
+		e.mu.RUnlock()
+		// Drop datagrams whose partial checksum coverage is smaller than
+		// required.
+		size := uint16(header.UDPMinimumSize + pkt.Data().Size())
+		if coverage := header.UDPLiteCoverage(hdr, size); coverage < size && int(coverage) < minCoverage {
+			e.stack.Stats().UDP.MalformedPacketsReceived.Increment()
+			e.stats.ReceiveErrors.MalformedPacketsReceived.Increment()
+			return
+		}
+	}
+
 	e.stack.Stats().UDP.PacketsReceived.Increment()
 	e.stats.PacketsReceived.Increment()
 
@@ -954,6 +1023,14 @@ func (e *endpoint) HandlePacket(id stack.TransportEndpointID, pkt *stack.PacketB
 		return
 	}
 
+	// Drop the packet if the stack won't let this endpoint hold it.
+	if !e.stack.ReserveReceiveMemory(&e.rcvMemAccount, pkt.Data().Size()) {
+		e.rcvMu.Unlock()
+		e.stack.Stats().UDP.ReceiveBufferErrors.Increment()
+		e.stats.ReceiveErrors.ReceiveBufferOverflow.Increment()
+		return
+	}
+
 	wasEmpty := e.rcvBufSize == 0
 
 	// Push new packet into receive list and increment the buffer size.
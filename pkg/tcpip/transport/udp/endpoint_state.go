@@ -57,6 +57,12 @@ func (e *endpoint) Restore(s *stack.Stack) {
 	e.stack = s
 	e.ops.InitHandler(e, e.stack, tcpip.GetStackSendBufferLimits, tcpip.GetStackReceiveBufferLimits)
 
+	// Packets restored with the endpoint are not yet accounted for by the new
+	// stack.
+	e.rcvMu.Lock()
+	s.ChargeReceiveMemory(&e.rcvMemAccount, e.rcvBufSize)
+	e.rcvMu.Unlock()
+
 	switch state := e.net.State(); state {
 	case transport.DatagramEndpointStateInitial, transport.DatagramEndpointStateClosed:
 	case transport.DatagramEndpointStateBound, transport.DatagramEndpointStateConnected:
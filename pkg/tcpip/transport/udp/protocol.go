@@ -45,22 +45,25 @@ const (
 
 type protocol struct {
 	stack *stack.Stack
+
+	// number is either UDP or UDP-Lite.
+	number tcpip.TransportProtocolNumber
 }
 
 // Number returns the udp protocol number.
-func (*protocol) Number() tcpip.TransportProtocolNumber {
-	return ProtocolNumber
+func (p *protocol) Number() tcpip.TransportProtocolNumber {
+	return p.number
 }
 
 // NewEndpoint creates a new udp endpoint.
 func (p *protocol) NewEndpoint(netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, tcpip.Error) {
-	return newEndpoint(p.stack, netProto, waiterQueue), nil
+	return newEndpoint(p.stack, p.number, netProto, waiterQueue), nil
 }
 
 // NewRawEndpoint creates a new raw UDP endpoint. It implements
 // stack.TransportProtocol.NewRawEndpoint.
 func (p *protocol) NewRawEndpoint(netProto tcpip.NetworkProtocolNumber, waiterQueue *waiter.Queue) (tcpip.Endpoint, tcpip.Error) {
-	return raw.NewEndpoint(p.stack, netProto, header.UDPProtocolNumber, waiterQueue)
+	return raw.NewEndpoint(p.stack, netProto, p.number, waiterQueue)
 }
 
 // MinimumPacketSize returns the minimum valid udp packet size.
@@ -79,15 +82,7 @@ func (*protocol) ParsePorts(v []byte) (src, dst uint16, err tcpip.Error) {
 // protocol but don't match any existing endpoint.
 func (p *protocol) HandleUnknownDestinationPacket(id stack.TransportEndpointID, pkt *stack.PacketBuffer) stack.UnknownDestinationPacketDisposition {
 	hdr := header.UDP(pkt.TransportHeader().Slice())
-	netHdr := pkt.Network()
-	lengthValid, csumValid := header.UDPValid(
-		hdr,
-		func() uint16 { return pkt.Data().Checksum() },
-		uint16(pkt.Data().Size()),
-		pkt.NetworkProtocolNumber,
-		netHdr.SourceAddress(),
-		netHdr.DestinationAddress(),
-		pkt.RXChecksumValidated)
+	lengthValid, csumValid := validate(p.number, hdr, pkt)
 	if !lengthValid {
 		p.stack.Stats().UDP.MalformedPacketsReceived.Increment()
 		return stack.UnknownDestinationPacketMalformed
@@ -124,11 +119,48 @@ func (*protocol) Pause() {}
 func (*protocol) Resume() {}
 
 // Parse implements stack.TransportProtocol.Parse.
-func (*protocol) Parse(pkt *stack.PacketBuffer) bool {
-	return parse.UDP(pkt)
+func (p *protocol) Parse(pkt *stack.PacketBuffer) bool {
+	if !parse.UDP(pkt) {
+		return false
+	}
+	pkt.TransportProtocolNumber = p.number
+	return true
+}
+
+// validate returns whether the length and checksum of the datagram in pkt are
+// valid for the given protocol.
+//
+// UDP-Lite checksums are always validated, even if the link validated the
+// checksum, as links don't know about the partial coverage.
+func validate(number tcpip.TransportProtocolNumber, hdr header.UDP, pkt *stack.PacketBuffer) (lengthValid, csumValid bool) {
+	netHdr := pkt.Network()
+	if number == header.UDPLiteProtocolNumber {
+		return header.UDPLiteValid(
+			hdr,
+			func(coverage int) uint16 { return pkt.Data().AsRange().Capped(coverage).Checksum() },
+			uint16(pkt.Data().Size()),
+			netHdr.SourceAddress(),
+			netHdr.DestinationAddress(),
+			false /* skipChecksumValidation */)
+	}
+	return header.UDPValid(
+		hdr,
+		func() uint16 { return pkt.Data().Checksum() },
+		uint16(pkt.Data().Size()),
+		pkt.NetworkProtocolNumber,
+		netHdr.SourceAddress(),
+		netHdr.DestinationAddress(),
+		pkt.RXChecksumValidated)
 }
 
 // NewProtocol returns a UDP transport protocol.
 func NewProtocol(s *stack.Stack) stack.TransportProtocol {
-	return &protocol{stack: s}
+	return &protocol{stack: s, number: ProtocolNumber}
+}
+
+// NewLiteProtocol returns a UDP-Lite transport protocol, as specified in RFC
+// 3828. It shares the UDP implementation, with the "length" field of the
+// header holding the checksum coverage instead.
+func NewLiteProtocol(s *stack.Stack) stack.TransportProtocol {
+	return &protocol{stack: s, number: header.UDPLiteProtocolNumber}
 }
@@ -869,6 +869,11 @@ func TestWriteOnConnectedInvalidPort(t *testing.T) {
 					c.T.Fatalf("expected c.EP.LastError() == ErrConnectionRefused, got: %+v", err)
 				}
 			}
+
+			// Reading the error clears it.
+			if err := c.EP.LastError(); err != nil {
+				c.T.Fatalf("got second c.EP.LastError() = %s, want = nil", err)
+			}
 		})
 	}
 }
@@ -1005,6 +1010,156 @@ func TestWriteOnBoundToV4MappedBroadcast(t *testing.T) {
 	}
 }
 
+// injectFrom injects a datagram from TestAddr:srcPort to StackAddr:StackPort.
+func injectFrom(c *context.Context, srcPort uint16) {
+	c.T.Helper()
+
+	h := context.UnicastV4.MakeHeader4Tuple(context.Incoming)
+	h.Src.Port = srcPort
+	c.InjectPacket(ipv4.ProtocolNumber, context.BuildV4UDPPacket(newRandomPayload(arbitraryPayloadSize), h, testTOS, testTTL, false))
+}
+
+// checkReadFrom checks that the next datagram read from the endpoint was sent
+// from TestAddr:srcPort and that no other datagram is queued.
+func checkReadFrom(c *context.Context, srcPort uint16) {
+	c.T.Helper()
+
+	var buf bytes.Buffer
+	res, err := c.EP.Read(&buf, tcpip.ReadOptions{NeedRemoteAddr: true})
+	if err != nil {
+		c.T.Fatalf("c.EP.Read(_, _): %s", err)
+	}
+	if want := (tcpip.FullAddress{Addr: context.TestAddr, Port: srcPort}); res.RemoteAddr.Addr != want.Addr || res.RemoteAddr.Port != want.Port {
+		c.T.Errorf("got res.RemoteAddr = %+v, want = %+v", res.RemoteAddr, want)
+	}
+	c.ReadFromEndpointExpectNoPacket()
+}
+
+// checkWriteTo writes a datagram without a destination and checks that it is
+// sent to TestAddr:dstPort.
+func checkWriteTo(c *context.Context, dstPort uint16) {
+	c.T.Helper()
+
+	var r bytes.Reader
+	r.Reset(newRandomPayload(arbitraryPayloadSize))
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		c.T.Fatalf("c.EP.Write(_, {}): %s", err)
+	}
+	p := c.LinkEP.Read()
+	if p == nil {
+		c.T.Fatalf("Packet wasn't written out")
+	}
+	defer p.DecRef()
+	v := p.ToView()
+	defer v.Release()
+	checker.IPv4(c.T, v,
+		checker.DstAddr(context.TestAddr),
+		checker.UDP(checker.DstPort(dstPort)),
+	)
+}
+
+// checkPortUnreachable checks that an ICMP port unreachable error was sent in
+// response to a datagram that did not match any endpoint.
+func checkPortUnreachable(c *context.Context) {
+	c.T.Helper()
+
+	p := c.LinkEP.Read()
+	if p == nil {
+		c.T.Fatalf("ICMP error wasn't written out")
+	}
+	defer p.DecRef()
+	v := p.ToView()
+	defer v.Release()
+	checker.IPv4(c.T, v, checker.ICMPv4(
+		checker.ICMPv4Type(header.ICMPv4DstUnreachable),
+		checker.ICMPv4Code(header.ICMPv4PortUnreachable),
+	))
+}
+
+func TestConnectFiltersPeers(t *testing.T) {
+	const otherPort = context.TestPort + 1
+
+	c := context.New(t, []stack.TransportProtocolFactory{udp.NewProtocol})
+	defer c.Cleanup()
+
+	c.CreateEndpoint(ipv4.ProtocolNumber, udp.ProtocolNumber)
+	if err := c.EP.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+		c.T.Fatalf("c.EP.Bind(_): %s", err)
+	}
+	to := tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}
+	if err := c.EP.Connect(to); err != nil {
+		c.T.Fatalf("c.EP.Connect(%+v): %s", to, err)
+	}
+
+	// Only datagrams from the connected peer are delivered.
+	injectFrom(c, otherPort)
+	checkPortUnreachable(c)
+	injectFrom(c, context.TestPort)
+	checkReadFrom(c, context.TestPort)
+	checkWriteTo(c, context.TestPort)
+
+	// Connecting to a new peer replaces the old one for both directions.
+	to.Port = otherPort
+	if err := c.EP.Connect(to); err != nil {
+		c.T.Fatalf("c.EP.Connect(%+v): %s", to, err)
+	}
+	injectFrom(c, context.TestPort)
+	checkPortUnreachable(c)
+	injectFrom(c, otherPort)
+	checkReadFrom(c, otherPort)
+	checkWriteTo(c, otherPort)
+
+	// Once disconnected, datagrams from any peer are delivered and writes
+	// need a destination.
+	if err := c.EP.Disconnect(); err != nil {
+		c.T.Fatalf("c.EP.Disconnect(): %s", err)
+	}
+	injectFrom(c, context.TestPort)
+	checkReadFrom(c, context.TestPort)
+	injectFrom(c, otherPort)
+	checkReadFrom(c, otherPort)
+	var r bytes.Reader
+	r.Reset(newRandomPayload(arbitraryPayloadSize))
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err == nil {
+		c.T.Errorf("got c.EP.Write(_, {}) = nil after disconnecting, want = %s", &tcpip.ErrDestinationRequired{})
+	} else if _, ok := err.(*tcpip.ErrDestinationRequired); !ok {
+		c.T.Errorf("got c.EP.Write(_, {}) = %v after disconnecting, want = %s", err, &tcpip.ErrDestinationRequired{})
+	}
+}
+
+func TestConnectCachesRoute(t *testing.T) {
+	c := context.New(t, []stack.TransportProtocolFactory{udp.NewProtocol})
+	defer c.Cleanup()
+
+	c.CreateEndpoint(ipv4.ProtocolNumber, udp.ProtocolNumber)
+	to := tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}
+	if err := c.EP.Connect(to); err != nil {
+		c.T.Fatalf("c.EP.Connect(%+v): %s", to, err)
+	}
+
+	// Writes to the connected peer use the route resolved by Connect and so
+	// keep working without a route table.
+	c.Stack.SetRouteTable(nil)
+	checkWriteTo(c, context.TestPort)
+
+	// Writes to an explicit destination still look the route up.
+	var r bytes.Reader
+	r.Reset(newRandomPayload(arbitraryPayloadSize))
+	other := tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort + 1}
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{To: &other}); err == nil {
+		c.T.Errorf("got c.EP.Write(_, {To: %+v}) = nil, want = %s", other, &tcpip.ErrHostUnreachable{})
+	} else if _, ok := err.(*tcpip.ErrHostUnreachable); !ok {
+		c.T.Errorf("got c.EP.Write(_, {To: %+v}) = %v, want = %s", other, err, &tcpip.ErrHostUnreachable{})
+	}
+
+	// A new Connect resolves the route again.
+	if err := c.EP.Connect(to); err == nil {
+		c.T.Errorf("got c.EP.Connect(%+v) = nil without a route, want = %s", to, &tcpip.ErrHostUnreachable{})
+	} else if _, ok := err.(*tcpip.ErrHostUnreachable); !ok {
+		c.T.Errorf("got c.EP.Connect(%+v) = %v without a route, want = %s", to, err, &tcpip.ErrHostUnreachable{})
+	}
+}
+
 func TestReadIncrementsPacketsReceived(t *testing.T) {
 	c := context.New(t, []stack.TransportProtocolFactory{udp.NewProtocol, icmp.NewProtocol6, icmp.NewProtocol4})
 	defer c.Cleanup()
@@ -1126,6 +1281,41 @@ func TestWriteIncrementsPacketsSent(t *testing.T) {
 	}
 }
 
+// TestWriteSlices tests that a payload held in several slices is sent as a
+// single datagram.
+func TestWriteSlices(t *testing.T) {
+	c := context.New(t, []stack.TransportProtocolFactory{udp.NewProtocol, icmp.NewProtocol6, icmp.NewProtocol4})
+	defer c.Cleanup()
+
+	c.CreateEndpoint(ipv4.ProtocolNumber, udp.ProtocolNumber)
+	to := tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}
+	if err := c.EP.Connect(to); err != nil {
+		c.T.Fatalf("c.EP.Connect(%+v): %s", to, err)
+	}
+
+	bufs := [][]byte{[]byte("scatter"), []byte("/"), []byte("gather")}
+	want := bytes.Join(bufs, nil)
+	if n, err := c.EP.Write(tcpip.NewSlicesReader(bufs...), tcpip.WriteOptions{}); err != nil || n != int64(len(want)) {
+		c.T.Fatalf("got c.EP.Write(_, {}) = (%d, %s), want = (%d, nil)", n, err, len(want))
+	}
+
+	p := c.LinkEP.Read()
+	if p == nil {
+		c.T.Fatalf("packet wasn't written out")
+	}
+	defer p.DecRef()
+	v := p.ToView()
+	defer v.Release()
+	checker.IPv4(c.T, v, checker.UDP(
+		checker.DstPort(context.TestPort),
+		checker.Payload(want),
+	))
+	if p := c.LinkEP.Read(); p != nil {
+		p.DecRef()
+		c.T.Fatalf("got more than one packet written out")
+	}
+}
+
 func TestNoChecksum(t *testing.T) {
 	for _, writeOpSequence := range writeOpSequences {
 		for _, flow := range []context.TestFlow{context.UnicastV4, context.UnicastV6} {
@@ -1778,6 +1968,58 @@ func TestShortHeader(t *testing.T) {
 
 // TestBadChecksumErrors verifies if a checksum error is detected,
 // global and endpoint stats are incremented.
+func TestICMPErrorLastError(t *testing.T) {
+	c := context.New(t, []stack.TransportProtocolFactory{udp.NewProtocol, icmp.NewProtocol4})
+	defer c.Cleanup()
+
+	c.CreateEndpoint(ipv4.ProtocolNumber, udp.ProtocolNumber)
+	if err := c.EP.Connect(tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}); err != nil {
+		c.T.Fatalf("Connect failed: %s", err)
+	}
+	var r bytes.Reader
+	payload := newRandomPayload(arbitraryPayloadSize)
+	r.Reset(payload)
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{}); err != nil {
+		c.T.Fatalf("c.EP.Write(...) = %s, want nil", err)
+	}
+	p := c.LinkEP.Read()
+	if p == nil {
+		c.T.Fatalf("packet wasn't written out")
+	}
+	sent := p.ToBuffer()
+	defer sent.Release()
+	p.DecRef()
+
+	// Reply with a port unreachable error quoting the sent packet.
+	quoted := sent.Flatten()[:header.IPv4MinimumSize+header.UDPMinimumSize]
+	buf := make([]byte, header.IPv4MinimumSize+header.ICMPv4MinimumSize+len(quoted))
+	ip := header.IPv4(buf)
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(buf)),
+		TTL:         64,
+		Protocol:    uint8(header.ICMPv4ProtocolNumber),
+		SrcAddr:     context.TestAddr,
+		DstAddr:     context.StackAddr,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+	icmpHdr := header.ICMPv4(ip.Payload())
+	icmpHdr.SetType(header.ICMPv4DstUnreachable)
+	icmpHdr.SetCode(header.ICMPv4PortUnreachable)
+	copy(icmpHdr.Payload(), quoted)
+	icmpHdr.SetChecksum(header.ICMPv4Checksum(icmpHdr[:header.ICMPv4MinimumSize], checksum.Checksum(icmpHdr.Payload(), 0)))
+	c.InjectPacket(ipv4.ProtocolNumber, buf)
+
+	if err := c.EP.LastError(); err == nil {
+		c.T.Fatalf("got c.EP.LastError() = nil, want = %s", &tcpip.ErrConnectionRefused{})
+	} else if _, ok := err.(*tcpip.ErrConnectionRefused); !ok {
+		c.T.Fatalf("got c.EP.LastError() = %s, want = %s", err, &tcpip.ErrConnectionRefused{})
+	}
+	// Reading the error clears it.
+	if err := c.EP.LastError(); err != nil {
+		c.T.Fatalf("got second c.EP.LastError() = %s, want = nil", err)
+	}
+}
+
 func TestBadChecksumErrors(t *testing.T) {
 	for _, flow := range []context.TestFlow{context.UnicastV4, context.UnicastV6} {
 		t.Run(flow.String(), func(t *testing.T) {
@@ -2293,6 +2535,115 @@ func TestWritePayloadSizeTooBig(t *testing.T) {
 	}
 }
 
+func TestStackReceiveMemoryLimit(t *testing.T) {
+	const (
+		nicID       = 1
+		payloadSize = 400
+		// The stack is under memory pressure once three datagrams are
+		// queued.
+		limit = 4 * payloadSize
+	)
+	s := stack.New(stack.Options{
+		NetworkProtocols:   []stack.NetworkProtocolFactory{ipv4.NewProtocol},
+		TransportProtocols: []stack.TransportProtocolFactory{udp.NewProtocol},
+		Clock:              &faketime.NullClock{},
+		ReceiveMemoryLimit: limit,
+	})
+	defer s.Destroy()
+
+	if err := s.CreateNIC(nicID, loopback.New()); err != nil {
+		t.Fatalf("s.CreateNIC(%d, _): %s", nicID, err)
+	}
+	addr := tcpip.AddrFrom4([4]byte{127, 0, 0, 1})
+	protocolAddr := tcpip.ProtocolAddress{
+		Protocol:          ipv4.ProtocolNumber,
+		AddressWithPrefix: addr.WithPrefix(),
+	}
+	if err := s.AddProtocolAddress(nicID, protocolAddr, stack.AddressProperties{}); err != nil {
+		t.Fatalf("s.AddProtocolAddress(%d, %+v, {}): %s", nicID, protocolAddr, err)
+	}
+	s.SetRouteTable([]tcpip.Route{{Destination: header.IPv4EmptySubnet, NIC: nicID}})
+
+	newEndpoint := func(port uint16) tcpip.Endpoint {
+		t.Helper()
+		ep, err := s.NewEndpoint(udp.ProtocolNumber, ipv4.ProtocolNumber, &waiter.Queue{})
+		if err != nil {
+			t.Fatalf("s.NewEndpoint(%d, %d, _): %s", udp.ProtocolNumber, ipv4.ProtocolNumber, err)
+		}
+		if port != 0 {
+			if err := ep.Bind(tcpip.FullAddress{Addr: addr, Port: port}); err != nil {
+				t.Fatalf("ep.Bind(_): %s", err)
+			}
+		}
+		return ep
+	}
+	sender := newEndpoint(0)
+	defer sender.Close()
+	rcv1 := newEndpoint(1001)
+	defer rcv1.Close()
+	rcv2 := newEndpoint(1002)
+	defer rcv2.Close()
+
+	send := func(port uint16) {
+		t.Helper()
+		var r bytes.Reader
+		r.Reset(make([]byte, payloadSize))
+		to := tcpip.FullAddress{Addr: addr, Port: port}
+		if n, err := sender.Write(&r, tcpip.WriteOptions{To: &to}); err != nil || n != payloadSize {
+			t.Fatalf("got sender.Write(_, _) = (%d, %s), want = (%d, nil)", n, err, payloadSize)
+		}
+	}
+	checkUsage := func(wantUsed int64, wantDrops uint64) {
+		t.Helper()
+		if got := s.ReceiveMemoryUsed(); got != wantUsed {
+			t.Errorf("got s.ReceiveMemoryUsed() = %d, want = %d", got, wantUsed)
+		}
+		if got := s.Stats().UDP.ReceiveBufferErrors.Value(); got != wantDrops {
+			t.Errorf("got stats.UDP.ReceiveBufferErrors.Value() = %d, want = %d", got, wantDrops)
+		}
+	}
+
+	read := func(ep tcpip.Endpoint) {
+		t.Helper()
+		if _, err := ep.Read(ioutil.Discard, tcpip.ReadOptions{}); err != nil {
+			t.Fatalf("ep.Read(_, {}): %s", err)
+		}
+	}
+
+	// Each endpoint's own receive buffer is far larger than the stack-wide
+	// limit, so only the latter causes drops. A single endpoint may use
+	// memory up to the pressure threshold.
+	send(1001)
+	send(1001)
+	send(1001)
+	checkUsage(3*payloadSize, 0)
+	send(1001)
+	checkUsage(3*payloadSize, 1)
+
+	// Under pressure, an endpoint holding less than its fair share may still
+	// use the remaining memory, up to the limit.
+	send(1002)
+	checkUsage(4*payloadSize, 1)
+	send(1002)
+	checkUsage(4*payloadSize, 2)
+
+	// Reading frees memory, but the heaviest consumer stays throttled while
+	// it holds more than its fair share.
+	read(rcv1)
+	checkUsage(3*payloadSize, 2)
+	send(1001)
+	checkUsage(3*payloadSize, 3)
+
+	// Closing an endpoint releases everything it still holds.
+	rcv1.Close()
+	checkUsage(payloadSize, 3)
+	send(1002)
+	checkUsage(2*payloadSize, 3)
+	read(rcv2)
+	read(rcv2)
+	checkUsage(0, 3)
+}
+
 func TestMain(m *testing.M) {
 	refs.SetLeakMode(refs.LeaksPanic)
 	code := m.Run()
@@ -0,0 +1,35 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package udplite contains the implementation of the UDP-Lite transport
+// protocol, as specified in RFC 3828.
+//
+// UDP-Lite shares its implementation with UDP, see package udp. It also
+// shares the UDP statistics: UDP-Lite datagrams are counted in
+// tcpip.Stats.UDP and in the UDP endpoint statistics.
+package udplite
+
+import (
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udp"
+)
+
+// ProtocolNumber is the UDP-Lite protocol number.
+const ProtocolNumber = header.UDPLiteProtocolNumber
+
+// NewProtocol returns a UDP-Lite transport protocol.
+func NewProtocol(s *stack.Stack) stack.TransportProtocol {
+	return udp.NewLiteProtocol(s)
+}
@@ -0,0 +1,393 @@
+// Copyright 2024 The gVisor Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package udplite_test
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"gvisor.dev/gvisor/pkg/refs"
+	"gvisor.dev/gvisor/pkg/tcpip"
+	"gvisor.dev/gvisor/pkg/tcpip/checksum"
+	"gvisor.dev/gvisor/pkg/tcpip/header"
+	"gvisor.dev/gvisor/pkg/tcpip/network/ipv4"
+	"gvisor.dev/gvisor/pkg/tcpip/stack"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/testing/context"
+	"gvisor.dev/gvisor/pkg/tcpip/transport/udplite"
+)
+
+var payload = []byte("0123456789abcdefghijklmnopqrstuv")
+
+// newContext returns a context with a UDP-Lite endpoint bound to the stack
+// port.
+func newContext(t *testing.T) *context.Context {
+	t.Helper()
+
+	c := context.New(t, []stack.TransportProtocolFactory{udplite.NewProtocol})
+	c.CreateEndpoint(ipv4.ProtocolNumber, udplite.ProtocolNumber)
+	if err := c.EP.Bind(tcpip.FullAddress{Port: context.StackPort}); err != nil {
+		c.Cleanup()
+		t.Fatalf("Bind failed: %s", err)
+	}
+	return c
+}
+
+// buildPacket returns an IPv4 packet carrying a UDP-Lite datagram from the
+// test address to the stack. The checksum covers the bytes given by the
+// coverage field, clamped to the datagram.
+func buildPacket(payload []byte, coverage uint16) []byte {
+	buf := make([]byte, header.IPv4MinimumSize+header.UDPMinimumSize+len(payload))
+	copy(buf[header.IPv4MinimumSize+header.UDPMinimumSize:], payload)
+
+	ip := header.IPv4(buf)
+	ip.Encode(&header.IPv4Fields{
+		TotalLength: uint16(len(buf)),
+		TTL:         64,
+		Protocol:    uint8(udplite.ProtocolNumber),
+		SrcAddr:     context.TestAddr,
+		DstAddr:     context.StackAddr,
+	})
+	ip.SetChecksum(^ip.CalculateChecksum())
+
+	u := header.UDP(ip.Payload())
+	u.Encode(&header.UDPFields{
+		SrcPort: context.TestPort,
+		DstPort: context.StackPort,
+		Length:  coverage,
+	})
+	covered := int(header.UDPLiteCoverage(u, uint16(len(u))))
+	covered = max(header.UDPMinimumSize, min(covered, len(u)))
+	xsum := header.PseudoHeaderChecksum(udplite.ProtocolNumber, context.TestAddr, context.StackAddr, uint16(len(u)))
+	xsum = checksum.Checksum(u[header.UDPMinimumSize:covered], xsum)
+	u.SetChecksum(^u.CalculateChecksum(xsum))
+	return buf
+}
+
+func TestReceive(t *testing.T) {
+	const datagramSize = header.UDPMinimumSize + 32
+
+	tests := []struct {
+		name        string
+		coverage    uint16
+		minCoverage int
+		// corrupt is the offset of a payload byte to corrupt after computing
+		// the checksum, or -1.
+		corrupt      int
+		zeroChecksum bool
+		wantReceived bool
+	}{
+		{
+			name:         "full coverage",
+			coverage:     0,
+			corrupt:      -1,
+			wantReceived: true,
+		},
+		{
+			name:         "full coverage by length",
+			coverage:     datagramSize,
+			corrupt:      -1,
+			wantReceived: true,
+		},
+		{
+			name:     "full coverage corrupted",
+			coverage: 0,
+			corrupt:  len(payload) - 1,
+		},
+		{
+			name:         "partial coverage",
+			coverage:     header.UDPMinimumSize + 4,
+			corrupt:      -1,
+			wantReceived: true,
+		},
+		{
+			name:         "partial coverage corrupted outside coverage",
+			coverage:     header.UDPMinimumSize + 4,
+			corrupt:      4,
+			wantReceived: true,
+		},
+		{
+			name:     "partial coverage corrupted inside coverage",
+			coverage: header.UDPMinimumSize + 4,
+			corrupt:  3,
+		},
+		{
+			name:         "header only coverage",
+			coverage:     header.UDPMinimumSize,
+			corrupt:      0,
+			wantReceived: true,
+		},
+		{
+			name:     "coverage smaller than header",
+			coverage: header.UDPMinimumSize - 1,
+			corrupt:  -1,
+		},
+		{
+			name:     "coverage larger than datagram",
+			coverage: datagramSize + 1,
+			corrupt:  -1,
+		},
+		{
+			name:         "zero checksum",
+			coverage:     0,
+			corrupt:      -1,
+			zeroChecksum: true,
+		},
+		{
+			name:        "partial coverage below minimum",
+			coverage:    header.UDPMinimumSize + 4,
+			minCoverage: header.UDPMinimumSize + 5,
+			corrupt:     -1,
+		},
+		{
+			name:         "partial coverage at minimum",
+			coverage:     header.UDPMinimumSize + 4,
+			minCoverage:  header.UDPMinimumSize + 4,
+			corrupt:      -1,
+			wantReceived: true,
+		},
+		{
+			name:         "full coverage below minimum",
+			coverage:     0,
+			minCoverage:  datagramSize + 1,
+			corrupt:      -1,
+			wantReceived: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newContext(t)
+			defer c.Cleanup()
+
+			if test.minCoverage != 0 {
+				if err := c.EP.SetSockOptInt(tcpip.UDPLiteRecvCoverageOption, test.minCoverage); err != nil {
+					t.Fatalf("SetSockOptInt(UDPLiteRecvCoverageOption, %d): %s", test.minCoverage, err)
+				}
+			}
+
+			buf := buildPacket(payload, test.coverage)
+			u := header.UDP(header.IPv4(buf).Payload())
+			if test.zeroChecksum {
+				u.SetChecksum(0)
+			}
+			want := append([]byte(nil), payload...)
+			if test.corrupt >= 0 {
+				u.Payload()[test.corrupt] ^= 0xff
+				want[test.corrupt] ^= 0xff
+			}
+			c.InjectPacket(ipv4.ProtocolNumber, buf)
+
+			if test.wantReceived {
+				c.ReadFromEndpointExpectSuccess(want, context.UnicastV4)
+			} else {
+				c.ReadFromEndpointExpectNoPacket()
+			}
+		})
+	}
+}
+
+func TestSend(t *testing.T) {
+	const datagramSize = header.UDPMinimumSize + 32
+
+	tests := []struct {
+		name         string
+		coverage     int
+		wantCoverage uint16
+	}{
+		{
+			name:         "full coverage",
+			coverage:     0,
+			wantCoverage: datagramSize,
+		},
+		{
+			name:         "partial coverage",
+			coverage:     header.UDPMinimumSize + 4,
+			wantCoverage: header.UDPMinimumSize + 4,
+		},
+		{
+			name:         "coverage smaller than header",
+			coverage:     1,
+			wantCoverage: header.UDPMinimumSize,
+		},
+		{
+			name:         "coverage larger than datagram",
+			coverage:     datagramSize + 1,
+			wantCoverage: datagramSize,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newContext(t)
+			defer c.Cleanup()
+
+			if test.coverage != 0 {
+				if err := c.EP.SetSockOptInt(tcpip.UDPLiteSendCoverageOption, test.coverage); err != nil {
+					t.Fatalf("SetSockOptInt(UDPLiteSendCoverageOption, %d): %s", test.coverage, err)
+				}
+			}
+
+			var r bytes.Reader
+			r.Reset(payload)
+			to := tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}
+			if _, err := c.EP.Write(&r, tcpip.WriteOptions{To: &to}); err != nil {
+				t.Fatalf("Write failed: %s", err)
+			}
+
+			p := c.LinkEP.Read()
+			if p == nil {
+				t.Fatalf("Packet wasn't written out")
+			}
+			defer p.DecRef()
+			v := p.ToView()
+			defer v.Release()
+
+			ip := header.IPv4(v.AsSlice())
+			if got, want := tcpip.TransportProtocolNumber(ip.Protocol()), udplite.ProtocolNumber; got != want {
+				t.Fatalf("got ip.Protocol() = %d, want = %d", got, want)
+			}
+			u := header.UDP(ip.Payload())
+			if got := u.Length(); got != test.wantCoverage {
+				t.Errorf("got u.Length() = %d, want = %d", got, test.wantCoverage)
+			}
+			if !bytes.Equal(u.Payload(), payload) {
+				t.Errorf("got u.Payload() = %x, want = %x", u.Payload(), payload)
+			}
+			lengthValid, csumValid := header.UDPLiteValid(
+				u,
+				func(coverage int) uint16 { return checksum.Checksum(u.Payload()[:coverage], 0) },
+				uint16(len(u.Payload())),
+				ip.SourceAddress(),
+				ip.DestinationAddress(),
+				false /* skipChecksumValidation */)
+			if !lengthValid || !csumValid {
+				t.Errorf("got UDPLiteValid(...) = (%t, %t), want = (true, true)", lengthValid, csumValid)
+			}
+
+			// Bytes beyond the coverage are not protected by the checksum.
+			if covered := header.UDPMinimumSize + len(payload); int(test.wantCoverage) < covered {
+				u.Payload()[len(payload)-1] ^= 0xff
+				if _, csumValid := header.UDPLiteValid(
+					u,
+					func(coverage int) uint16 { return checksum.Checksum(u.Payload()[:coverage], 0) },
+					uint16(len(u.Payload())),
+					ip.SourceAddress(),
+					ip.DestinationAddress(),
+					false /* skipChecksumValidation */); !csumValid {
+					t.Errorf("got UDPLiteValid(...) after corrupting an uncovered byte = (_, false), want = (_, true)")
+				}
+			}
+		})
+	}
+}
+
+func TestChecksumOffload(t *testing.T) {
+	c := newContext(t)
+	defer c.Cleanup()
+	c.LinkEP.LinkEPCapabilities |= stack.CapabilityTXChecksumOffload | stack.CapabilityRXChecksumOffload
+
+	// The checksum is computed even though the link offloads checksums, as
+	// links can't offload a partial coverage.
+	const coverage = header.UDPMinimumSize + 4
+	if err := c.EP.SetSockOptInt(tcpip.UDPLiteSendCoverageOption, coverage); err != nil {
+		t.Fatalf("SetSockOptInt(UDPLiteSendCoverageOption, %d): %s", coverage, err)
+	}
+	var r bytes.Reader
+	r.Reset(payload)
+	to := tcpip.FullAddress{Addr: context.TestAddr, Port: context.TestPort}
+	if _, err := c.EP.Write(&r, tcpip.WriteOptions{To: &to}); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	p := c.LinkEP.Read()
+	if p == nil {
+		t.Fatalf("Packet wasn't written out")
+	}
+	defer p.DecRef()
+	v := p.ToView()
+	defer v.Release()
+	ip := header.IPv4(v.AsSlice())
+	u := header.UDP(ip.Payload())
+	if lengthValid, csumValid := header.UDPLiteValid(
+		u,
+		func(coverage int) uint16 { return checksum.Checksum(u.Payload()[:coverage], 0) },
+		uint16(len(u.Payload())),
+		ip.SourceAddress(),
+		ip.DestinationAddress(),
+		false /* skipChecksumValidation */); !lengthValid || !csumValid {
+		t.Errorf("got UDPLiteValid(...) = (%t, %t), want = (true, true)", lengthValid, csumValid)
+	}
+
+	// Received datagrams are validated even though the link validates
+	// checksums.
+	buf := buildPacket(payload, coverage)
+	header.UDP(header.IPv4(buf).Payload()).Payload()[0] ^= 0xff
+	c.InjectPacket(ipv4.ProtocolNumber, buf)
+	c.ReadFromEndpointExpectNoPacket()
+	if got := c.Stack.Stats().UDP.ChecksumErrors.Value(); got != 1 {
+		t.Errorf("got stats.UDP.ChecksumErrors.Value() = %d, want = 1", got)
+	}
+}
+
+func TestUnknownPort(t *testing.T) {
+	c := context.New(t, []stack.TransportProtocolFactory{udplite.NewProtocol})
+	defer c.Cleanup()
+
+	// Datagrams to a port without an endpoint are counted like UDP ones.
+	c.InjectPacket(ipv4.ProtocolNumber, buildPacket(payload, 0 /* coverage */))
+	if got := c.Stack.Stats().UDP.UnknownPortErrors.Value(); got != 1 {
+		t.Errorf("got stats.UDP.UnknownPortErrors.Value() = %d, want = 1", got)
+	}
+}
+
+func TestCoverageOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		v    int
+		want int
+	}{
+		{name: "full coverage", v: 0, want: 0},
+		{name: "smaller than header", v: 1, want: header.UDPMinimumSize},
+		{name: "partial coverage", v: 20, want: 20},
+		{name: "larger than datagram", v: header.UDPMaximumSize + 1, want: header.UDPMaximumSize},
+	}
+
+	for _, opt := range []tcpip.SockOptInt{tcpip.UDPLiteSendCoverageOption, tcpip.UDPLiteRecvCoverageOption} {
+		for _, test := range tests {
+			t.Run(test.name, func(t *testing.T) {
+				c := newContext(t)
+				defer c.Cleanup()
+
+				if err := c.EP.SetSockOptInt(opt, test.v); err != nil {
+					t.Fatalf("SetSockOptInt(%d, %d): %s", opt, test.v, err)
+				}
+				got, err := c.EP.GetSockOptInt(opt)
+				if err != nil {
+					t.Fatalf("GetSockOptInt(%d): %s", opt, err)
+				}
+				if got != test.want {
+					t.Errorf("got GetSockOptInt(%d) = %d, want = %d", opt, got, test.want)
+				}
+			})
+		}
+	}
+}
+
+func TestMain(m *testing.M) {
+	refs.SetLeakMode(refs.LeaksPanic)
+	code := m.Run()
+	refs.DoLeakCheck()
+	os.Exit(code)
+}
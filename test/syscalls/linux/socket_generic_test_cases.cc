@@ -932,14 +932,11 @@ TEST_P(AllSocketPairTest, SetAndGetBooleanSocketOptions) {
 }
 
 TEST_P(AllSocketPairTest, GetSocketOutOfBandInlineOption) {
-  // gVisor does not support this option, unless using Hostinet.
-  SKIP_IF(!IsRunningOnGvisor() || IsRunningWithHostinet());
-
   auto sockets = ASSERT_NO_ERRNO_AND_VALUE(NewSocketPair());
   int enable = -1;
   socklen_t enableLen = sizeof(enable);
 
-  int want = 1;
+  int want = 0;
   ASSERT_THAT(getsockopt(sockets->first_fd(), SOL_SOCKET, SO_OOBINLINE, &enable,
                          &enableLen),
               SyscallSucceeds());